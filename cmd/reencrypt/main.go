@@ -0,0 +1,58 @@
+// Command reencrypt rewrites every encrypted column in envelopes of the
+// primary KEK of the keyring, so an older KEK, or the keys of ENCRYPTION_KEY
+// and ENCRYPTION_KEYS, can be removed. It reads the same environment as the
+// API.
+//
+//	go run ./cmd/reencrypt -dry-run
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+	"github.com/jeanmolossi/verbose-adventure/internal/db"
+	"github.com/jeanmolossi/verbose-adventure/internal/encryption"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+)
+
+func main() {
+	var opts encryption.ReencryptOptions
+	flag.BoolVar(&opts.DryRun, "dry-run", false, "count the secrets to re-encrypt without writing them")
+	flag.IntVar(&opts.BatchSize, "batch", 100, "rows read at once")
+	flag.Parse()
+
+	cfg, err := config.New()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+
+	keys, err := encryption.NewKeyring(cfg)
+	if err != nil {
+		log.Fatalf("keyring: %v", err)
+	}
+
+	conn, err := db.NewMySQL(cfg)
+	if err != nil {
+		log.Fatalf("mysql: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	log.Printf("re-encrypting under key %q (dry run: %t)", keys.PrimaryID(), opts.DryRun)
+
+	stats, err := encryption.Reencrypt(ctx, keys, repo.NewEncryptedColumnRepository(conn), repo.EncryptedColumns, opts)
+	for _, s := range stats {
+		log.Printf("%s: %d scanned, %d re-encrypted, %d already current, %d changed meanwhile, %d failed",
+			s.Column, s.Scanned, s.Rotated, s.Current, s.Changed, s.Failed)
+	}
+
+	if err != nil {
+		log.Fatalf("re-encrypt: %v", err)
+	}
+}
@@ -13,10 +13,13 @@ import (
 	"github.com/jeanmolossi/verbose-adventure/internal/auth"
 	"github.com/jeanmolossi/verbose-adventure/internal/config"
 	"github.com/jeanmolossi/verbose-adventure/internal/db"
+	"github.com/jeanmolossi/verbose-adventure/internal/encryption"
 	"github.com/jeanmolossi/verbose-adventure/internal/http/handlers"
 	"github.com/jeanmolossi/verbose-adventure/internal/http/middleware"
+	"github.com/jeanmolossi/verbose-adventure/internal/http/validation"
 	"github.com/jeanmolossi/verbose-adventure/internal/logger"
 	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+	"github.com/jeanmolossi/verbose-adventure/internal/tenant"
 )
 
 // Register all providers and invokes in one place:
@@ -30,34 +33,74 @@ func New() *fx.App {
 			db.NewMySQL, // *sql.DB (MySQL)
 
 			repo.NewIdentityProviderRepository, // IdentiityProviderRepository
+			repo.NewSessionRepository,          // SessionRepository
+			repo.NewUserRoleRepository,         // UserRoleRepository
+			repo.NewUserRepository,             // UserRepository
+			repo.NewTenantDomainRepository,     // TenantDomainRepository
+			repo.NewTenantRepository,           // TenantRepository
+			repo.NewAPIKeyRepository,           // APIKeyRepository
+			repo.NewOAuthRepository,            // OAuthRepository
+			repo.NewImpersonationRepository,    // ImpersonationRepository
+			repo.NewSCIMTokenRepository,        // SCIMTokenRepository
+			repo.NewSCIMRepository,             // SCIMRepository
+			repo.NewUsedTokenRepository,        // UsedTokenRepository
 
-			auth.LoadIdentityProviders, // LoadIdentityProviders interface
-			echo.New,                   // *echo.Echo
-			handlers.NewAuthHandler,    // *handlers.AuthHandler
-			handlers.NewIDPHandler,     // *handlers.IDPHandler
+			tenant.NewResolver, // *tenant.Resolver
+
+			encryption.NewKeyring, // *encryption.Keyring
+
+			auth.NewRegistry, // *auth.Registry
+			func(r *auth.Registry) auth.ProviderRegistry { return r },
+			func(r *auth.Registry) auth.HealthReporter { return r },
+			auth.NewKeyring,             // *auth.Keyring
+			auth.NewTokenValidator,      // *auth.TokenValidator
+			auth.NewSessions,            // *auth.Sessions
+			auth.NewTXTResolver,         // auth.TXTResolver
+			auth.NewDomainVerifier,      // *auth.DomainVerifier
+			auth.NewAPIKeys,             // *auth.APIKeys
+			auth.NewAuthorizationServer, // *auth.AuthorizationServer
+			auth.NewImpersonations,      // *auth.Impersonations
+			auth.NewSCIMTokens,          // *auth.SCIMTokens
+			auth.NewProvisioning,        // *auth.Provisioning
+			auth.NewReplayStore,         // auth.ReplayStore
+			auth.NewDiagnostics,         // *auth.Diagnostics
+			func(d *auth.Diagnostics) auth.ProviderDiagnostician { return d },
+
+			echo.New,                         // *echo.Echo
+			validation.New,                   // *validation.Validator
+			handlers.NewAuthHandler,          // *handlers.AuthHandler
+			handlers.NewIDPHandler,           // *handlers.IDPHandler
+			handlers.NewRoleHandler,          // *handlers.RoleHandler
+			handlers.NewMeHandler,            // *handlers.MeHandler
+			handlers.NewDomainHandler,        // *handlers.DomainHandler
+			handlers.NewHomeRealmHandler,     // *handlers.HomeRealmHandler
+			handlers.NewAPIKeyHandler,        // *handlers.APIKeyHandler
+			handlers.NewOAuthHandler,         // *handlers.OAuthHandler
+			handlers.NewOAuthClientHandler,   // *handlers.OAuthClientHandler
+			handlers.NewImpersonationHandler, // *handlers.ImpersonationHandler
+			handlers.NewSCIMHandler,          // *handlers.SCIMHandler
+			handlers.NewSCIMTokenHandler,     // *handlers.SCIMTokenHandler
 
 			fx.Annotate(
 				middleware.ZapLogger, // func(*zap.Logger) echo.MiddlewareFunc
 				fx.ResultTags(`name:"zapMw"`),
 			),
 
-			func(cfg *config.Config) middleware.JWTConfig {
-				return middleware.JWTConfig{JWTSecret: cfg.JWTSecret}
+			func(tokens *auth.TokenValidator, sessions *auth.Sessions, keys *auth.APIKeys, impersonations *auth.Impersonations) middleware.JWTConfig {
+				return middleware.JWTConfig{Tokens: tokens, Revocations: sessions, APIKeys: keys, Impersonations: impersonations}
 			},
 
 			fx.Annotate(
-				func(cfg *config.Config) echo.MiddlewareFunc {
-					return middleware.NewJWTMiddleware(middleware.JWTConfig{
-						JWTSecret: cfg.JWTSecret,
-					})
-				}, fx.ResultTags(`name:"jwtMw"`),
+				middleware.NewJWTMiddleware, // func(middleware.JWTConfig) echo.MiddlewareFunc
+				fx.ResultTags(`name:"jwtMw"`),
 			),
 		),
 		// 2) Registrations
 		fx.Invoke(
 			db.RunMigrations,
 			registerMiddlewares(),
-			registerRoutes(),
+			MountRoutes,
+			startRegistry,
 			startServer,
 		),
 	)
@@ -65,15 +108,30 @@ func New() *fx.App {
 
 func registerMiddlewares() any {
 	return fx.Annotate(
-		func(e *echo.Echo, zl echo.MiddlewareFunc) {
+		func(e *echo.Echo, zl echo.MiddlewareFunc, tenants *tenant.Resolver, v *validation.Validator) {
 			e.HideBanner = true
+			e.Validator = v
 			e.Use(emiddleware.Recover())
 			e.Use(zl)
+			e.Use(middleware.ResolveTenant(tenants, "tenantID"))
 		},
-		fx.ParamTags(``, `name:"zapMw"`),
+		fx.ParamTags(``, `name:"zapMw"`, ``, ``),
 	)
 }
 
+func startRegistry(lc fx.Lifecycle, r *auth.Registry) {
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			r.Start()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			r.Stop()
+			return nil
+		},
+	})
+}
+
 func startServer(lc fx.Lifecycle, e *echo.Echo, log *zap.Logger) {
 	lc.Append(fx.Hook{
 		OnStart: func(ctx context.Context) error {
@@ -3,48 +3,217 @@ package app
 import (
 	"database/sql"
 
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
 	"github.com/jeanmolossi/verbose-adventure/internal/http/handlers"
+	"github.com/jeanmolossi/verbose-adventure/internal/http/middleware"
 	"github.com/labstack/echo/v4"
 	"go.uber.org/fx"
 )
 
-func registerRoutes() any {
-	return fx.Annotate(
-		func(
-			e *echo.Echo,
-			hh *handlers.AuthHandler,
-			jwtMw echo.MiddlewareFunc,
-			idph *handlers.IDPHandler,
-			mysqlDB *sql.DB,
-		) {
-			// Health
-			e.GET("/healthz", handlers.Healthz(mysqlDB))
-
-			// Auth SSO
-			e.GET("/:tenantID/:idpType/login", hh.Login)
-			e.GET("/:tenantID/:idpType/callback", hh.Callback)
-
-			// Admin: Identity providers
-			admin := e.Group("/admin/tenants/:tenantID/idps", jwtMw)
-			{
-				admin.GET(":id", idph.Get)
-				admin.GET("", idph.List)
-				admin.POST("", idph.Create)
-				admin.PUT(":id", idph.Update)
-				admin.DELETE(":id", idph.Delete)
-			}
-
-			// Protected API
-			api := e.Group("/api")
-			v1 := api.Group("/v1", jwtMw)
-			v1.GET("/me", handlers.Me)
-		},
-		fx.ParamTags(
-			``,             // echo
-			``,             // AuthHandler
-			`name:"jwtMw"`, // jwt Middleware
-			``,             // IDPHandler
-			``,             // mysqlDB
-		),
-	)
+// routeScopes is the catalogue of the scopes each protected route group
+// requires. Tokens carry the scopes of the user roles, API keys and OAuth
+// clients the ones they were granted.
+var routeScopes = struct {
+	IdentityProviders middleware.RouteScopes
+	UserRoles         middleware.RouteScopes
+	Domains           middleware.RouteScopes
+	APIKeys           middleware.RouteScopes
+	OAuthClients      middleware.RouteScopes
+	Profile           middleware.RouteScopes
+	Impersonations    middleware.RouteScopes
+	SCIMTokens        middleware.RouteScopes
+}{
+	IdentityProviders: middleware.RouteScopes{Read: auth.PermIDPRead, Write: auth.PermIDPWrite},
+	UserRoles:         middleware.RouteScopes{Read: auth.PermRoleRead, Write: auth.PermRoleWrite},
+	Domains:           middleware.RouteScopes{Read: auth.PermDomainRead, Write: auth.PermDomainWrite},
+	APIKeys:           middleware.RouteScopes{Read: auth.PermAPIKeyRead, Write: auth.PermAPIKeyWrite},
+	OAuthClients:      middleware.RouteScopes{Read: auth.PermOAuthClientRead, Write: auth.PermOAuthClientWrite},
+	Profile:           middleware.RouteScopes{Read: auth.ScopeProfile},
+	Impersonations:    middleware.RouteScopes{Read: auth.PermAuditRead, Write: auth.PermImpersonate},
+	SCIMTokens:        middleware.RouteScopes{Read: auth.PermSCIMTokenRead, Write: auth.PermSCIMTokenWrite},
+}
+
+// Routes are the handlers and middlewares MountRoutes puts on the server
+type Routes struct {
+	fx.In
+
+	Echo *echo.Echo
+	JWT  echo.MiddlewareFunc `name:"jwtMw"`
+
+	Auth           *handlers.AuthHandler
+	IDPs           *handlers.IDPHandler
+	Roles          *handlers.RoleHandler
+	Me             *handlers.MeHandler
+	Domains        *handlers.DomainHandler
+	HomeRealm      *handlers.HomeRealmHandler
+	APIKeys        *handlers.APIKeyHandler
+	OAuth          *handlers.OAuthHandler
+	OAuthClients   *handlers.OAuthClientHandler
+	Impersonations *handlers.ImpersonationHandler
+	SCIM           *handlers.SCIMHandler
+	SCIMTokens     *handlers.SCIMTokenHandler
+
+	// SCIMAuth authenticates the SCIM tokens on /scim/v2
+	SCIMAuth *auth.SCIMTokens
+	DB       *sql.DB
+	Health   auth.HealthReporter
+	Config   *config.Config
+	Keys     *auth.Keyring
+}
+
+// MountRoutes registers every route of the API. The e2e tests mount the
+// same routes, so the scopes each one requires are covered there.
+func MountRoutes(r Routes) {
+	e := r.Echo
+
+	// Health
+	e.GET("/healthz", handlers.Healthz(r.DB, r.Health))
+
+	// Token verification keys
+	e.GET("/.well-known/jwks.json", handlers.JWKS(r.Keys))
+	e.GET("/.well-known/openid-configuration", handlers.Discovery(r.Config, r.Keys))
+	e.GET("/.well-known/oauth-authorization-server", handlers.Discovery(r.Config, r.Keys))
+
+	// Auth SSO, the tenant is an id or slug in the path...
+	e.GET("/:tenantID/:idp/login", r.Auth.Login)
+	e.GET("/:tenantID/:idp/callback", r.Auth.Callback)
+	e.POST("/:tenantID/:idp/callback", r.Auth.Callback) // SAML ACS
+	e.GET("/:tenantID/:idp/metadata", r.Auth.Metadata)  // SAML SP metadata
+
+	// ...or the subdomain / custom host of the request
+	e.GET("/:idp/login", r.Auth.Login)
+	e.GET("/:idp/callback", r.Auth.Callback)
+	e.POST("/:idp/callback", r.Auth.Callback)
+	e.GET("/:idp/metadata", r.Auth.Metadata)
+
+	// Home realm discovery and IdP picker
+	e.GET("/auth/discover", r.HomeRealm.Discover)
+	e.GET("/:tenantID/sso", r.HomeRealm.Pick)
+	e.GET("/sso", r.HomeRealm.Pick)
+
+	// Sessions
+	e.POST("/auth/refresh", r.Auth.Refresh)
+	e.POST("/auth/logout", r.Auth.Logout)
+
+	// OAuth 2.0 authorization server for third-party clients
+	e.GET("/oauth/authorize", r.OAuth.Authorize)
+	e.POST("/oauth/token", r.OAuth.Token)
+	e.POST("/oauth/introspect", r.OAuth.Introspect)
+	e.POST("/oauth/revoke", r.OAuth.Revoke)
+
+	// Admin routes are off limits to impersonation tokens: support
+	// staff may look around as the user, not administer the tenant
+
+	// Admin: Identity providers
+	admin := e.Group("/admin/tenants/:tenantID/idps", r.JWT, middleware.RequireTenant(), middleware.DenyImpersonation())
+	{
+		read := routeScopes.IdentityProviders.Reads()
+		write := routeScopes.IdentityProviders.Writes()
+
+		admin.GET("/:id", r.IDPs.Get, read)
+		admin.GET("", r.IDPs.List, read)
+		admin.POST("", r.IDPs.Create, write)
+		admin.PUT("/:id", r.IDPs.Update, write)
+		admin.PATCH("/:id", r.IDPs.Patch, write)
+		admin.DELETE("/:id", r.IDPs.Delete, write)
+		admin.POST("/:id/test", r.IDPs.Test, write)
+	}
+
+	// Admin: User roles
+	roles := e.Group("/admin/tenants/:tenantID/users/:userID/roles", r.JWT, middleware.RequireTenant(), middleware.DenyImpersonation())
+	{
+		roles.GET("", r.Roles.Get, routeScopes.UserRoles.Reads())
+		roles.PUT("", r.Roles.Put, routeScopes.UserRoles.Writes())
+	}
+
+	// Admin: Tenant domains
+	domains := e.Group("/admin/tenants/:tenantID/domains", r.JWT, middleware.RequireTenant(), middleware.DenyImpersonation())
+	{
+		read := routeScopes.Domains.Reads()
+		write := routeScopes.Domains.Writes()
+
+		domains.GET("", r.Domains.List, read)
+		domains.POST("", r.Domains.Create, write)
+		domains.GET("/:id", r.Domains.Get, read)
+		domains.POST("/:id/verify", r.Domains.Verify, write)
+		domains.DELETE("/:id", r.Domains.Delete, write)
+	}
+
+	// Admin: API keys
+	apiKeys := e.Group("/admin/tenants/:tenantID/api-keys", r.JWT, middleware.RequireTenant(), middleware.DenyImpersonation())
+	{
+		read := routeScopes.APIKeys.Reads()
+		write := routeScopes.APIKeys.Writes()
+
+		apiKeys.GET("", r.APIKeys.List, read)
+		apiKeys.POST("", r.APIKeys.Create, write)
+		apiKeys.GET("/:id", r.APIKeys.Get, read)
+		apiKeys.DELETE("/:id", r.APIKeys.Revoke, write)
+	}
+
+	// Admin: OAuth clients
+	oauthClients := e.Group("/admin/tenants/:tenantID/oauth-clients", r.JWT, middleware.RequireTenant(), middleware.DenyImpersonation())
+	{
+		read := routeScopes.OAuthClients.Reads()
+		write := routeScopes.OAuthClients.Writes()
+
+		oauthClients.GET("", r.OAuthClients.List, read)
+		oauthClients.POST("", r.OAuthClients.Create, write)
+		oauthClients.GET("/:id", r.OAuthClients.Get, read)
+		oauthClients.DELETE("/:id", r.OAuthClients.Delete, write)
+	}
+
+	// Admin: Impersonation, super admins act as a tenant user
+	impersonate := e.Group("/admin/tenants/:tenantID/users/:userID/impersonate", r.JWT, middleware.RequireTenant(), middleware.DenyImpersonation())
+	{
+		impersonate.POST("", r.Impersonations.Create, routeScopes.Impersonations.Writes())
+	}
+
+	// Admin: Impersonation audit trail
+	impersonations := e.Group("/admin/tenants/:tenantID/impersonations", r.JWT, middleware.RequireTenant(), middleware.DenyImpersonation())
+	{
+		read := routeScopes.Impersonations.Reads()
+
+		impersonations.GET("", r.Impersonations.List, read)
+		impersonations.GET("/:id", r.Impersonations.Get, read)
+		impersonations.DELETE("/:id", r.Impersonations.End, routeScopes.Impersonations.Writes())
+	}
+
+	// Admin: SCIM tokens
+	scimTokenAdmin := e.Group("/admin/tenants/:tenantID/scim-tokens", r.JWT, middleware.RequireTenant(), middleware.DenyImpersonation())
+	{
+		read := routeScopes.SCIMTokens.Reads()
+		write := routeScopes.SCIMTokens.Writes()
+
+		scimTokenAdmin.GET("", r.SCIMTokens.List, read)
+		scimTokenAdmin.POST("", r.SCIMTokens.Create, write)
+		scimTokenAdmin.GET("/:id", r.SCIMTokens.Get, read)
+		scimTokenAdmin.DELETE("/:id", r.SCIMTokens.Revoke, write)
+	}
+
+	// SCIM 2.0 provisioning, authenticated by the SCIM tokens
+	scimV2 := e.Group("/scim/v2/:tenantID", middleware.SCIMAuth(r.SCIMAuth))
+	{
+		scimV2.GET("/ServiceProviderConfig", r.SCIM.ServiceProviderConfig)
+
+		scimV2.GET("/Users", r.SCIM.ListUsers)
+		scimV2.POST("/Users", r.SCIM.CreateUser)
+		scimV2.GET("/Users/:id", r.SCIM.GetUser)
+		scimV2.PUT("/Users/:id", r.SCIM.ReplaceUser)
+		scimV2.PATCH("/Users/:id", r.SCIM.PatchUser)
+		scimV2.DELETE("/Users/:id", r.SCIM.DeleteUser)
+
+		scimV2.GET("/Groups", r.SCIM.ListGroups)
+		scimV2.POST("/Groups", r.SCIM.CreateGroup)
+		scimV2.GET("/Groups/:id", r.SCIM.GetGroup)
+		scimV2.PUT("/Groups/:id", r.SCIM.ReplaceGroup)
+		scimV2.PATCH("/Groups/:id", r.SCIM.PatchGroup)
+		scimV2.DELETE("/Groups/:id", r.SCIM.DeleteGroup)
+	}
+
+	// Protected API
+	api := e.Group("/api")
+	v1 := api.Group("/v1", r.JWT)
+	v1.GET("/me", r.Me.Get, routeScopes.Profile.Reads())
 }
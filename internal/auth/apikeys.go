@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+)
+
+const (
+	// APIKeyPrefix starts every API key, telling them apart from JWTs
+	APIKeyPrefix = "crm_"
+
+	// apiKeyDisplayLen is how much of the key is kept to identify it
+	apiKeyDisplayLen = len(APIKeyPrefix) + 8
+	// apiKeyLastUsedResolution throttles the last_used_at writes
+	apiKeyLastUsedResolution = time.Minute
+)
+
+var (
+	ErrAPIKeyInvalid = errors.New("api key is invalid")
+	ErrAPIKeyExpired = errors.New("api key has expired")
+	ErrAPIKeyRevoked = errors.New("api key was revoked")
+	ErrUnknownScope  = errors.New("unknown scope")
+)
+
+// APIKeyAuthenticator turns an API key into the claims of its principal
+type APIKeyAuthenticator interface {
+	Authenticate(ctx context.Context, key string) (*Claims, error)
+}
+
+// APIKeys issues and authenticates tenant API keys. Keys are random
+// secrets shown once; like refresh tokens only their SHA-256 hash is
+// stored, and they are looked up by that hash.
+type APIKeys struct {
+	repo repo.APIKeyRepository
+	now  func() time.Time
+}
+
+var _ APIKeyAuthenticator = (*APIKeys)(nil)
+
+func NewAPIKeys(r repo.APIKeyRepository) *APIKeys {
+	return &APIKeys{repo: r, now: time.Now}
+}
+
+// IsAPIKey reports whether a credential is an API key rather than a JWT
+func IsAPIKey(credential string) bool {
+	return strings.HasPrefix(credential, APIKeyPrefix)
+}
+
+// Issue stores a new key for rec.TenantID and returns it. rec gets the
+// generated id, prefix and hash.
+func (k *APIKeys) Issue(ctx context.Context, rec *repo.APIKeyRecord) (string, error) {
+	for _, s := range rec.Scopes {
+		if !IsPermission(s) {
+			return "", fmt.Errorf("%w %q", ErrUnknownScope, s)
+		}
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	key := APIKeyPrefix + secret
+	rec.Prefix = key[:apiKeyDisplayLen]
+	rec.KeyHash = hashToken(key)
+
+	id, err := k.repo.Create(ctx, rec)
+	if err != nil {
+		return "", err
+	}
+
+	rec.ID = id
+	rec.CreatedAt = k.now()
+	return key, nil
+}
+
+// Authenticate resolves a key to claims scoped to its tenant. API key
+// claims carry no user nor roles: the key scopes are their scope.
+func (k *APIKeys) Authenticate(ctx context.Context, key string) (*Claims, error) {
+	if !IsAPIKey(key) {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	rec, err := k.repo.GetByHash(ctx, hashToken(key))
+	if err != nil {
+		return nil, err
+	}
+
+	if rec == nil {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	if rec.RevokedAt != nil {
+		return nil, ErrAPIKeyRevoked
+	}
+
+	now := k.now()
+	if rec.ExpiresAt != nil && !now.Before(*rec.ExpiresAt) {
+		return nil, ErrAPIKeyExpired
+	}
+
+	if rec.LastUsedAt == nil || now.Sub(*rec.LastUsedAt) >= apiKeyLastUsedResolution {
+		// last_used_at is informative, failing to record it does not deny access
+		_ = k.repo.TouchLastUsed(ctx, rec.ID, now)
+	}
+
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:  "apikey:" + strconv.FormatInt(rec.ID, 10),
+			IssuedAt: jwt.NewNumericDate(rec.CreatedAt),
+		},
+		TenantID: rec.TenantID,
+		APIKeyID: rec.ID,
+		Scope:    rec.Scopes,
+	}
+
+	if rec.ExpiresAt != nil {
+		claims.ExpiresAt = jwt.NewNumericDate(*rec.ExpiresAt)
+	}
+
+	return claims, nil
+}
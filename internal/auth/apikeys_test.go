@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+)
+
+// stubAPIKeyRepo keeps keys in memory and counts last-used writes
+type stubAPIKeyRepo struct {
+	keys    []*repo.APIKeyRecord
+	touches int
+}
+
+func (s *stubAPIKeyRepo) ListByTenant(ctx context.Context, tenantID int64) ([]*repo.APIKeyRecord, error) {
+	return nil, nil
+}
+
+func (s *stubAPIKeyRepo) GetByID(ctx context.Context, tenantID, id int64) (*repo.APIKeyRecord, error) {
+	return nil, nil
+}
+
+func (s *stubAPIKeyRepo) GetByHash(ctx context.Context, hash []byte) (*repo.APIKeyRecord, error) {
+	for _, k := range s.keys {
+		if bytes.Equal(k.KeyHash, hash) {
+			cp := *k
+			return &cp, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *stubAPIKeyRepo) Create(ctx context.Context, rec *repo.APIKeyRecord) (int64, error) {
+	cp := *rec
+	cp.ID = int64(len(s.keys) + 1)
+	s.keys = append(s.keys, &cp)
+	return cp.ID, nil
+}
+
+func (s *stubAPIKeyRepo) Revoke(ctx context.Context, tenantID, id int64) error {
+	now := time.Now()
+	s.keys[id-1].RevokedAt = &now
+	return nil
+}
+
+func (s *stubAPIKeyRepo) TouchLastUsed(ctx context.Context, id int64, at time.Time) error {
+	s.touches++
+	s.keys[id-1].LastUsedAt = &at
+	return nil
+}
+
+func TestAPIKeys_IssueAndAuthenticate(t *testing.T) {
+	r := &stubAPIKeyRepo{}
+	k := NewAPIKeys(r)
+
+	rec := &repo.APIKeyRecord{TenantID: 7, Name: "billing sync", Scopes: []string{PermIDPRead}}
+	key, err := k.Issue(context.Background(), rec)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !IsAPIKey(key) || !strings.HasPrefix(key, rec.Prefix) || rec.ID != 1 {
+		t.Fatalf("unexpected key %q for record %+v", key, rec)
+	}
+	if strings.Contains(string(r.keys[0].KeyHash), key) {
+		t.Fatal("the key must not be stored")
+	}
+
+	claims, err := k.Authenticate(context.Background(), key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if claims.TenantID != 7 || claims.APIKeyID != 1 || claims.UserID != 0 || claims.Subject != "apikey:1" {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if !claims.Can(PermIDPRead) || claims.Can(PermIDPWrite) {
+		t.Errorf("api key permissions must follow its scopes")
+	}
+
+	// last_used_at is written at most once per resolution window
+	_, _ = k.Authenticate(context.Background(), key)
+	if r.touches != 1 {
+		t.Errorf("expected 1 last-used write, got %d", r.touches)
+	}
+
+	if _, err := k.Authenticate(context.Background(), key+"x"); !errors.Is(err, ErrAPIKeyInvalid) {
+		t.Errorf("expected ErrAPIKeyInvalid, got %v", err)
+	}
+}
+
+func TestAPIKeys_RejectsUnknownScope(t *testing.T) {
+	r := &stubAPIKeyRepo{}
+	k := NewAPIKeys(r)
+
+	_, err := k.Issue(context.Background(), &repo.APIKeyRecord{TenantID: 7, Name: "x", Scopes: []string{"everything"}})
+	if !errors.Is(err, ErrUnknownScope) {
+		t.Fatalf("expected ErrUnknownScope, got %v", err)
+	}
+	if len(r.keys) != 0 {
+		t.Error("no key should be stored")
+	}
+}
+
+func TestAPIKeys_ExpiredAndRevoked(t *testing.T) {
+	r := &stubAPIKeyRepo{}
+	k := NewAPIKeys(r)
+
+	expires := time.Now().Add(time.Hour)
+	key, err := k.Issue(context.Background(), &repo.APIKeyRecord{TenantID: 7, Name: "x", Scopes: []string{PermIDPRead}, ExpiresAt: &expires})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	k.now = func() time.Time { return expires }
+	if _, err := k.Authenticate(context.Background(), key); !errors.Is(err, ErrAPIKeyExpired) {
+		t.Errorf("expected ErrAPIKeyExpired, got %v", err)
+	}
+
+	k.now = time.Now
+	_ = r.Revoke(context.Background(), 7, 1)
+	if _, err := k.Authenticate(context.Background(), key); !errors.Is(err, ErrAPIKeyRevoked) {
+		t.Errorf("expected ErrAPIKeyRevoked, got %v", err)
+	}
+}
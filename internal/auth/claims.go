@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+)
+
+// ClaimMapping names the claims (or SAML attributes) that feed the user
+// profile. Empty fields fall back to the standard OIDC claim names.
+type ClaimMapping = repo.ClaimMapping
+
+// claimsWithDefaults fills the unset claims with the standard OIDC names
+func claimsWithDefaults(m ClaimMapping) ClaimMapping {
+	if m.UserID == "" {
+		m.UserID = "sub"
+	}
+	if m.Email == "" {
+		m.Email = "email"
+	}
+	if m.Name == "" {
+		m.Name = "name"
+	}
+	if m.Groups == "" {
+		m.Groups = "groups"
+	}
+	return m
+}
+
+// ReservedAuthParams are authorization request parameters owned by the
+// login flow, they can not be overridden per identity provider.
+var ReservedAuthParams = []string{
+	"client_id",
+	"code_challenge",
+	"code_challenge_method",
+	"nonce",
+	"redirect_uri",
+	"response_type",
+	"scope",
+	"state",
+}
+
+func isReservedAuthParam(name string) bool {
+	for _, r := range ReservedAuthParams {
+		if strings.EqualFold(r, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupClaim resolves a claim by its exact name first, then as a dotted
+// path into nested objects (e.g. "realm_access.roles" on Keycloak).
+func lookupClaim(claims map[string]any, name string) (any, bool) {
+	if v, ok := claims[name]; ok {
+		return v, true
+	}
+
+	var cur any = claims
+	for _, part := range strings.Split(name, ".") {
+		obj, ok := cur.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+
+		if cur, ok = obj[part]; !ok {
+			return nil, false
+		}
+	}
+
+	return cur, true
+}
+
+// claimString returns a claim as a string, or "" when it is missing
+func claimString(claims map[string]any, name string) string {
+	v, ok := lookupClaim(claims, name)
+	if !ok || v == nil {
+		return ""
+	}
+
+	switch t := v.(type) {
+	case string:
+		return t
+	case []any:
+		if len(t) > 0 {
+			return claimString(map[string]any{"v": t[0]}, "v")
+		}
+		return ""
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+// claimStrings returns a claim holding one or many values as a slice
+func claimStrings(claims map[string]any, name string) []string {
+	v, ok := lookupClaim(claims, name)
+	if !ok || v == nil {
+		return nil
+	}
+
+	switch t := v.(type) {
+	case string:
+		return []string{t}
+	case []any:
+		out := make([]string, 0, len(t))
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			} else if item != nil {
+				out = append(out, fmt.Sprint(item))
+			}
+		}
+		return out
+	default:
+		return []string{fmt.Sprint(t)}
+	}
+}
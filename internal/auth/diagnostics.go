@@ -0,0 +1,404 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/samlsp"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+)
+
+// diagnosticTimeout bounds each request a diagnostic makes to the IdP
+const diagnosticTimeout = 10 * time.Second
+
+// maxDiagnosticBody caps the documents read from the IdP
+const maxDiagnosticBody = 1 << 20
+
+// Names of the diagnostic checks
+const (
+	// CheckDiscovery fetches the OIDC discovery document or SAML metadata
+	CheckDiscovery = "discovery"
+	// CheckIssuer compares the discovered issuer with the metadata URL
+	CheckIssuer = "issuer"
+	// CheckSigningKeys looks for the keys that sign ID tokens or assertions
+	CheckSigningKeys = "signing_keys"
+	// CheckClientCredentials authenticates the client at the token endpoint
+	CheckClientCredentials = "client_credentials"
+)
+
+// Outcomes of a diagnostic check
+const (
+	CheckOK      = "ok"
+	CheckFailed  = "failed"
+	CheckSkipped = "skipped"
+)
+
+// DiagnosticCheck is the outcome of one step of a diagnosis
+type DiagnosticCheck struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Detail     string `json:"detail,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// Diagnosis is the report of a connection test. OK is true when no check
+// failed; Discovery is set once the IdP metadata could be read.
+type Diagnosis struct {
+	OK        bool                    `json:"ok"`
+	Checks    []DiagnosticCheck       `json:"checks"`
+	Discovery *repo.ProviderDiscovery `json:"discovery,omitempty"`
+}
+
+// Check returns the check of the given name
+func (d *Diagnosis) Check(name string) (DiagnosticCheck, bool) {
+	i := slices.IndexFunc(d.Checks, func(c DiagnosticCheck) bool { return c.Name == name })
+	if i < 0 {
+		return DiagnosticCheck{}, false
+	}
+	return d.Checks[i], true
+}
+
+// DiagnosticRequest is the configuration of an identity provider to test
+type DiagnosticRequest struct {
+	Type         string
+	MetadataURL  string
+	ClientID     string
+	ClientSecret string
+	// CheckCredentials asks the token endpoint to authenticate the client.
+	// It is opt-in: some IdPs lock a client out after failed attempts.
+	CheckCredentials bool
+}
+
+// ProviderDiagnostician tests the configuration of an identity provider
+// against the IdP itself.
+type ProviderDiagnostician interface {
+	Diagnose(ctx context.Context, req DiagnosticRequest) *Diagnosis
+}
+
+// Diagnostics runs the same discovery the registry does when it builds a
+// provider, step by step, so an admin sees which one is wrong before users
+// try to log in.
+type Diagnostics struct {
+	client *http.Client
+	now    func() time.Time
+}
+
+var _ ProviderDiagnostician = (*Diagnostics)(nil)
+
+func NewDiagnostics() *Diagnostics {
+	return &Diagnostics{
+		client: &http.Client{Timeout: diagnosticTimeout},
+		now:    time.Now,
+	}
+}
+
+// diagnosis accumulates the checks of a run
+type diagnosis struct {
+	*Diagnosis
+	now   func() time.Time
+	start time.Time
+}
+
+func (d *diagnosis) begin() { d.start = d.now() }
+
+func (d *diagnosis) record(name, status, format string, args ...any) {
+	d.Checks = append(d.Checks, DiagnosticCheck{
+		Name:       name,
+		Status:     status,
+		Detail:     fmt.Sprintf(format, args...),
+		DurationMS: d.now().Sub(d.start).Milliseconds(),
+	})
+	if status == CheckFailed {
+		d.OK = false
+	}
+}
+
+// skip records the checks that depend on a failed one
+func (d *diagnosis) skip(reason string, names ...string) {
+	for _, name := range names {
+		d.Checks = append(d.Checks, DiagnosticCheck{Name: name, Status: CheckSkipped, Detail: reason})
+	}
+}
+
+func (d *Diagnostics) Diagnose(ctx context.Context, req DiagnosticRequest) *Diagnosis {
+	run := &diagnosis{Diagnosis: &Diagnosis{OK: true}, now: d.now}
+
+	switch req.Type {
+	case "oidc":
+		d.diagnoseOIDC(ctx, run, req)
+	case "saml":
+		d.diagnoseSAML(ctx, run, req)
+	default:
+		run.record(CheckDiscovery, CheckFailed, "unsupported provider type %q", req.Type)
+	}
+
+	return run.Diagnosis
+}
+
+// oidcDiscovery is the part of the discovery document the CRM relies on
+type oidcDiscovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint"`
+	EndSessionEndpoint    string   `json:"end_session_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+func (d *Diagnostics) diagnoseOIDC(ctx context.Context, run *diagnosis, req DiagnosticRequest) {
+	run.begin()
+	wellKnown := strings.TrimSuffix(req.MetadataURL, "/") + "/.well-known/openid-configuration"
+
+	var doc oidcDiscovery
+	if err := d.getJSON(ctx, wellKnown, &doc); err != nil {
+		run.record(CheckDiscovery, CheckFailed, "%v", err)
+		run.skip("discovery failed", CheckIssuer, CheckSigningKeys, CheckClientCredentials)
+		return
+	}
+
+	var missing []string
+	for _, f := range []struct{ name, value string }{
+		{"issuer", doc.Issuer},
+		{"authorization_endpoint", doc.AuthorizationEndpoint},
+		{"token_endpoint", doc.TokenEndpoint},
+		{"jwks_uri", doc.JWKSURI},
+	} {
+		if f.value == "" {
+			missing = append(missing, f.name)
+		}
+	}
+	if len(missing) > 0 {
+		run.record(CheckDiscovery, CheckFailed, "%s does not define %s", wellKnown, strings.Join(missing, ", "))
+		run.skip("discovery failed", CheckIssuer, CheckSigningKeys, CheckClientCredentials)
+		return
+	}
+
+	run.record(CheckDiscovery, CheckOK, "read %s", wellKnown)
+	run.Discovery = &repo.ProviderDiscovery{
+		Issuer:                doc.Issuer,
+		AuthorizationEndpoint: doc.AuthorizationEndpoint,
+		TokenEndpoint:         doc.TokenEndpoint,
+		UserinfoEndpoint:      doc.UserinfoEndpoint,
+		EndSessionEndpoint:    doc.EndSessionEndpoint,
+		JWKSURI:               doc.JWKSURI,
+		DiscoveredAt:          d.now().UTC(),
+	}
+
+	// the provider is built with the metadata URL as issuer and go-oidc
+	// refuses any difference, even a trailing slash
+	run.begin()
+	switch {
+	case doc.Issuer == req.MetadataURL:
+		run.record(CheckIssuer, CheckOK, "issuer %s", doc.Issuer)
+	case strings.TrimSuffix(doc.Issuer, "/") == strings.TrimSuffix(req.MetadataURL, "/"):
+		run.record(CheckIssuer, CheckFailed, "issuer %q differs from the metadata url only by a trailing slash; use the issuer as metadata_url", doc.Issuer)
+	default:
+		run.record(CheckIssuer, CheckFailed, "issuer %q does not match the metadata url %q", doc.Issuer, req.MetadataURL)
+	}
+
+	run.begin()
+	d.checkJWKS(ctx, run, doc.JWKSURI)
+
+	if !req.CheckCredentials {
+		run.skip("not requested", CheckClientCredentials)
+		return
+	}
+
+	run.begin()
+	d.checkClientCredentials(ctx, run, doc, req)
+}
+
+// checkJWKS requires at least one key that can verify ID tokens
+func (d *Diagnostics) checkJWKS(ctx context.Context, run *diagnosis, jwksURI string) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			Alg string `json:"alg"`
+		} `json:"keys"`
+	}
+	if err := d.getJSON(ctx, jwksURI, &set); err != nil {
+		run.record(CheckSigningKeys, CheckFailed, "%v", err)
+		return
+	}
+
+	var (
+		count int
+		algs  []string
+	)
+	for _, k := range set.Keys {
+		if k.Kty == "" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		count++
+		if k.Alg != "" && !slices.Contains(algs, k.Alg) {
+			algs = append(algs, k.Alg)
+		}
+	}
+
+	if count == 0 {
+		run.record(CheckSigningKeys, CheckFailed, "%s has no signing key", jwksURI)
+		return
+	}
+
+	detail := fmt.Sprintf("%d signing key(s) at %s", count, jwksURI)
+	if len(algs) > 0 {
+		detail += " (" + strings.Join(algs, ", ") + ")"
+	}
+	run.record(CheckSigningKeys, CheckOK, "%s", detail)
+}
+
+// checkClientCredentials authenticates the client with a client_credentials
+// grant. The grant itself does not have to be allowed: an IdP that answers
+// unauthorized_client has authenticated the client before refusing it.
+func (d *Diagnostics) checkClientCredentials(ctx context.Context, run *diagnosis, doc oidcDiscovery, req DiagnosticRequest) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+
+	// client_secret_basic is the default of RFC 8414
+	post := len(doc.TokenAuthMethods) > 0 &&
+		!slices.Contains(doc.TokenAuthMethods, "client_secret_basic") &&
+		slices.Contains(doc.TokenAuthMethods, "client_secret_post")
+	if post {
+		form.Set("client_id", req.ClientID)
+		form.Set("client_secret", req.ClientSecret)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		run.record(CheckClientCredentials, CheckFailed, "%v", err)
+		return
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.Header.Set("Accept", "application/json")
+	if !post {
+		httpReq.SetBasicAuth(url.QueryEscape(req.ClientID), url.QueryEscape(req.ClientSecret))
+	}
+
+	resp, err := d.client.Do(httpReq)
+	if err != nil {
+		run.record(CheckClientCredentials, CheckFailed, "%v", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	_ = json.NewDecoder(io.LimitReader(resp.Body, maxDiagnosticBody)).Decode(&body)
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		run.record(CheckClientCredentials, CheckOK, "the client authenticated")
+	case body.Error == "invalid_client" || resp.StatusCode == http.StatusUnauthorized:
+		run.record(CheckClientCredentials, CheckFailed, "the identity provider rejected the client credentials%s", describeOAuthError(body.Error, body.Description))
+	case body.Error == "unauthorized_client" || body.Error == "unsupported_grant_type" || body.Error == "invalid_scope":
+		run.record(CheckClientCredentials, CheckOK, "the client authenticated; the client_credentials grant is not enabled%s", describeOAuthError(body.Error, body.Description))
+	default:
+		run.record(CheckClientCredentials, CheckFailed, "token endpoint answered %s%s", resp.Status, describeOAuthError(body.Error, body.Description))
+	}
+}
+
+func describeOAuthError(code, description string) string {
+	switch {
+	case code == "":
+		return ""
+	case description == "":
+		return " (" + code + ")"
+	default:
+		return " (" + code + ": " + description + ")"
+	}
+}
+
+func (d *Diagnostics) diagnoseSAML(ctx context.Context, run *diagnosis, req DiagnosticRequest) {
+	run.begin()
+	metadataURL, err := url.Parse(req.MetadataURL)
+	if err != nil {
+		run.record(CheckDiscovery, CheckFailed, "invalid saml metadata url: %v", err)
+		run.skip("discovery failed", CheckIssuer, CheckSigningKeys)
+		return
+	}
+
+	md, err := samlsp.FetchMetadata(ctx, d.client, *metadataURL)
+	if err != nil {
+		run.record(CheckDiscovery, CheckFailed, "fetch saml metadata: %v", err)
+		run.skip("discovery failed", CheckIssuer, CheckSigningKeys)
+		return
+	}
+
+	sp := saml.ServiceProvider{IDPMetadata: md}
+	ssoURL := sp.GetSSOBindingLocation(saml.HTTPRedirectBinding)
+	if ssoURL == "" {
+		run.record(CheckDiscovery, CheckFailed, "saml metadata has no HTTP-Redirect single sign-on service")
+		run.skip("discovery failed", CheckIssuer, CheckSigningKeys)
+		return
+	}
+
+	run.record(CheckDiscovery, CheckOK, "read %s", req.MetadataURL)
+	run.Discovery = &repo.ProviderDiscovery{
+		Issuer:       md.EntityID,
+		SSOURL:       ssoURL,
+		DiscoveredAt: d.now().UTC(),
+	}
+
+	run.begin()
+	if md.EntityID == "" {
+		run.record(CheckIssuer, CheckFailed, "saml metadata has no entityID")
+	} else {
+		run.record(CheckIssuer, CheckOK, "entity %s", md.EntityID)
+	}
+
+	run.begin()
+	var certs int
+	for _, idp := range md.IDPSSODescriptors {
+		for _, kd := range idp.KeyDescriptors {
+			if kd.Use == "" || kd.Use == "signing" {
+				certs += len(kd.KeyInfo.X509Data.X509Certificates)
+			}
+		}
+	}
+	if certs == 0 {
+		run.record(CheckSigningKeys, CheckFailed, "saml metadata has no signing certificate")
+	} else {
+		run.record(CheckSigningKeys, CheckOK, "%d signing certificate(s)", certs)
+	}
+}
+
+// getJSON fetches a JSON document of the IdP
+func (d *Diagnostics) getJSON(ctx context.Context, rawURL string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxDiagnosticBody)).Decode(v); err != nil {
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			return fmt.Errorf("GET %s: the response is not JSON", rawURL)
+		}
+		return fmt.Errorf("GET %s: %w", rawURL, err)
+	}
+
+	return nil
+}
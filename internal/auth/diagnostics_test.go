@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeOIDCServer serves a discovery document, a JWKS and a token endpoint
+// that accepts the client "cid" with the secret "secret"
+func fakeOIDCServer(t *testing.T, issuerSuffix string) *httptest.Server {
+	t.Helper()
+
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                 srv.URL + issuerSuffix,
+			"authorization_endpoint": srv.URL + "/authorize",
+			"token_endpoint":         srv.URL + "/token",
+			"jwks_uri":               srv.URL + "/keys",
+			"end_session_endpoint":   srv.URL + "/logout",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"keys":[{"kty":"RSA","use":"sig","alg":"RS256","kid":"1"},{"kty":"RSA","use":"enc","kid":"2"}]}`)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if id, secret, ok := r.BasicAuth(); !ok || id != "cid" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"invalid_client","error_description":"bad secret"}`)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":"unauthorized_client"}`)
+	})
+
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func checkStatuses(t *testing.T, d *Diagnosis, want map[string]string) {
+	t.Helper()
+
+	for name, status := range want {
+		got, ok := d.Check(name)
+		if !ok || got.Status != status {
+			t.Errorf("check %s = %+v; want %s", name, got, status)
+		}
+	}
+}
+
+func TestDiagnostics_OIDC(t *testing.T) {
+	srv := fakeOIDCServer(t, "")
+	d := NewDiagnostics()
+
+	report := d.Diagnose(context.Background(), DiagnosticRequest{Type: "oidc", MetadataURL: srv.URL, ClientID: "cid"})
+	if !report.OK {
+		t.Fatalf("expected a healthy provider, got %+v", report.Checks)
+	}
+	checkStatuses(t, report, map[string]string{
+		CheckDiscovery:         CheckOK,
+		CheckIssuer:            CheckOK,
+		CheckSigningKeys:       CheckOK,
+		CheckClientCredentials: CheckSkipped,
+	})
+
+	if ds := report.Discovery; ds == nil || ds.Issuer != srv.URL || ds.TokenEndpoint != srv.URL+"/token" || ds.JWKSURI != srv.URL+"/keys" || ds.DiscoveredAt.IsZero() {
+		t.Errorf("unexpected discovery %+v", report.Discovery)
+	}
+	if c, _ := report.Check(CheckSigningKeys); c.Detail != fmt.Sprintf("1 signing key(s) at %s/keys (RS256)", srv.URL) {
+		t.Errorf("unexpected detail %q", c.Detail)
+	}
+
+	// the grant is refused, but only after the client authenticated
+	report = d.Diagnose(context.Background(), DiagnosticRequest{Type: "oidc", MetadataURL: srv.URL, ClientID: "cid", ClientSecret: "secret", CheckCredentials: true})
+	checkStatuses(t, report, map[string]string{CheckClientCredentials: CheckOK})
+
+	report = d.Diagnose(context.Background(), DiagnosticRequest{Type: "oidc", MetadataURL: srv.URL, ClientID: "cid", ClientSecret: "wrong", CheckCredentials: true})
+	if report.OK {
+		t.Error("wrong credentials must fail the diagnosis")
+	}
+	if c, _ := report.Check(CheckClientCredentials); c.Status != CheckFailed || c.Detail != "the identity provider rejected the client credentials (invalid_client: bad secret)" {
+		t.Errorf("unexpected check %+v", c)
+	}
+}
+
+func TestDiagnostics_OIDCFailures(t *testing.T) {
+	srv := fakeOIDCServer(t, "/")
+	d := NewDiagnostics()
+
+	report := d.Diagnose(context.Background(), DiagnosticRequest{Type: "oidc", MetadataURL: srv.URL})
+	if report.OK || report.Discovery == nil {
+		t.Fatalf("an issuer mismatch must fail after discovery, got %+v", report)
+	}
+	checkStatuses(t, report, map[string]string{CheckDiscovery: CheckOK, CheckIssuer: CheckFailed, CheckSigningKeys: CheckOK})
+
+	report = d.Diagnose(context.Background(), DiagnosticRequest{Type: "oidc", MetadataURL: srv.URL + "/tenant", CheckCredentials: true})
+	if report.OK || report.Discovery != nil {
+		t.Fatalf("a missing discovery document must fail, got %+v", report)
+	}
+	checkStatuses(t, report, map[string]string{
+		CheckDiscovery:         CheckFailed,
+		CheckIssuer:            CheckSkipped,
+		CheckSigningKeys:       CheckSkipped,
+		CheckClientCredentials: CheckSkipped,
+	})
+}
+
+func TestDiagnostics_SAML(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<EntityDescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata" entityID="%[1]s/idp">
+  <IDPSSODescriptor protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+    <SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect" Location="%[1]s/sso"/>
+  </IDPSSODescriptor>
+</EntityDescriptor>`, srv.URL)
+	}))
+	defer srv.Close()
+
+	report := NewDiagnostics().Diagnose(context.Background(), DiagnosticRequest{Type: "saml", MetadataURL: srv.URL})
+	checkStatuses(t, report, map[string]string{CheckDiscovery: CheckOK, CheckIssuer: CheckOK, CheckSigningKeys: CheckFailed})
+
+	if ds := report.Discovery; ds == nil || ds.Issuer != srv.URL+"/idp" || ds.SSOURL != srv.URL+"/sso" {
+		t.Errorf("unexpected discovery %+v", report.Discovery)
+	}
+}
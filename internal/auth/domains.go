@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	// domainVerificationLabel prefixes the TXT record proving ownership
+	domainVerificationLabel = "_crm-verification"
+	// domainVerificationValue prefixes the token in the TXT record value
+	domainVerificationValue = "crm-verification="
+)
+
+var (
+	ErrInvalidDomain      = errors.New("invalid domain")
+	ErrDomainNotVerified  = errors.New("domain ownership record not found")
+	ErrDomainLookupFailed = errors.New("domain ownership record could not be resolved")
+)
+
+// TXTResolver resolves DNS TXT records. *net.Resolver satisfies it.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// NewTXTResolver returns the system resolver
+func NewTXTResolver() TXTResolver {
+	return net.DefaultResolver
+}
+
+// DomainVerifier proves that a tenant owns a domain: the tenant publishes
+// its verification token in a TXT record under the domain.
+type DomainVerifier struct {
+	resolver TXTResolver
+}
+
+func NewDomainVerifier(resolver TXTResolver) *DomainVerifier {
+	return &DomainVerifier{resolver: resolver}
+}
+
+// NewDomainVerificationToken returns a random token for a claimed domain
+func NewDomainVerificationToken() (string, error) {
+	return randomToken(24)
+}
+
+// DomainVerificationRecord is the TXT record name and value to publish
+func DomainVerificationRecord(domain, token string) (name, value string) {
+	return domainVerificationLabel + "." + domain, domainVerificationValue + token
+}
+
+// Verify looks the verification record of the domain up
+func (v *DomainVerifier) Verify(ctx context.Context, domain, token string) error {
+	name, want := DomainVerificationRecord(domain, token)
+
+	records, err := v.resolver.LookupTXT(ctx, name)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return ErrDomainNotVerified
+		}
+		return fmt.Errorf("%w: %v", ErrDomainLookupFailed, err)
+	}
+
+	for _, r := range records {
+		if strings.TrimSpace(r) == want {
+			return nil
+		}
+	}
+
+	return ErrDomainNotVerified
+}
+
+// NormalizeDomain extracts the domain of an email address or a domain name,
+// lowercased and without the trailing dot.
+func NormalizeDomain(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		s = s[i+1:]
+	}
+
+	s = strings.TrimSuffix(strings.ToLower(s), ".")
+	if len(s) == 0 || len(s) > 253 || !strings.Contains(s, ".") {
+		return "", ErrInvalidDomain
+	}
+
+	for _, label := range strings.Split(s, ".") {
+		if !validDomainLabel(label) {
+			return "", ErrInvalidDomain
+		}
+	}
+
+	return s, nil
+}
+
+// validDomainLabel follows the LDH rule: letters, digits and inner hyphens
+func validDomainLabel(label string) bool {
+	if len(label) == 0 || len(label) > 63 {
+		return false
+	}
+
+	if label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
+
+	for _, r := range label {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+// stubResolver answers TXT lookups from a map
+type stubResolver struct {
+	records map[string][]string
+	err     error
+}
+
+func (s *stubResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	if r, ok := s.records[name]; ok {
+		return r, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	cases := map[string]string{
+		"jane@Contoso.COM":      "contoso.com",
+		" contoso.com. ":        "contoso.com",
+		"a@b@mail.contoso.com":  "mail.contoso.com",
+		"xn--bcher-kva.example": "xn--bcher-kva.example",
+	}
+	for in, want := range cases {
+		if got, err := NormalizeDomain(in); err != nil || got != want {
+			t.Errorf("NormalizeDomain(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+
+	for _, in := range []string{"", "jane@", "localhost", "-bad.com", "bad_.com", "a..com", "contoso.com/path"} {
+		if _, err := NormalizeDomain(in); !errors.Is(err, ErrInvalidDomain) {
+			t.Errorf("NormalizeDomain(%q) should fail, got %v", in, err)
+		}
+	}
+}
+
+func TestDomainVerifier(t *testing.T) {
+	r := &stubResolver{records: map[string][]string{
+		"_crm-verification.contoso.com": {"v=spf1 -all", "crm-verification=tok-1"},
+	}}
+	v := NewDomainVerifier(r)
+
+	if err := v.Verify(context.Background(), "contoso.com", "tok-1"); err != nil {
+		t.Errorf("expected verified domain, got %v", err)
+	}
+
+	if err := v.Verify(context.Background(), "contoso.com", "tok-2"); !errors.Is(err, ErrDomainNotVerified) {
+		t.Errorf("expected ErrDomainNotVerified for another token, got %v", err)
+	}
+
+	if err := v.Verify(context.Background(), "fabrikam.com", "tok-1"); !errors.Is(err, ErrDomainNotVerified) {
+		t.Errorf("expected ErrDomainNotVerified without record, got %v", err)
+	}
+
+	r.err = &net.DNSError{Err: "timeout", IsTimeout: true}
+	if err := v.Verify(context.Background(), "contoso.com", "tok-1"); !errors.Is(err, ErrDomainLookupFailed) {
+		t.Errorf("expected ErrDomainLookupFailed, got %v", err)
+	}
+}
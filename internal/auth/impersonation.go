@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+)
+
+const (
+	defaultImpersonationTTL = 15 * time.Minute
+
+	// maxImpersonationReason is the size of the reason column
+	maxImpersonationReason = 500
+)
+
+var (
+	ErrImpersonationForbidden = errors.New("only super admins may impersonate users")
+	ErrImpersonationReason    = errors.New("a reason is required to impersonate a user")
+	ErrImpersonationUnknown   = errors.New("impersonation token was not issued by this server")
+	ErrUserNotFound           = errors.New("user not found")
+)
+
+// Actor is the "act" claim of RFC 8693, section 4.1: the super admin
+// acting as the subject of an impersonation token.
+type Actor struct {
+	Subject  string `json:"sub"`
+	TenantID int64  `json:"tenant_id"`
+	UserID   int64  `json:"user_id"`
+	Email    string `json:"email,omitempty"`
+}
+
+// ImpersonationAuditor records the requests made with impersonation tokens
+type ImpersonationAuditor interface {
+	Record(ctx context.Context, claims *Claims, req *repo.ImpersonationRequestRecord) error
+}
+
+// Impersonation is a token minted to act as a tenant user
+type Impersonation struct {
+	Record    *repo.ImpersonationRecord
+	Token     string
+	ExpiresIn int64
+}
+
+// Impersonations lets super admins act as a tenant user, for support.
+// The tokens are short-lived access tokens with no refresh token, they
+// carry the actor in the "act" claim and every request made with them is
+// written to the audit trail before it is served. Each one is bound to a
+// session of its own, so it is revoked like any other access token.
+type Impersonations struct {
+	repo     repo.ImpersonationRepository
+	users    repo.UserRepository
+	roles    repo.UserRoleRepository
+	sessions *Sessions
+	keys     *Keyring
+	cfg      *config.Config
+	now      func() time.Time
+}
+
+var _ ImpersonationAuditor = (*Impersonations)(nil)
+
+func NewImpersonations(cfg *config.Config, r repo.ImpersonationRepository, users repo.UserRepository, roles repo.UserRoleRepository, sessions *Sessions, keys *Keyring) *Impersonations {
+	return &Impersonations{
+		repo:     r,
+		users:    users,
+		roles:    roles,
+		sessions: sessions,
+		keys:     keys,
+		cfg:      cfg,
+		now:      time.Now,
+	}
+}
+
+// Start mints a token acting as the user on behalf of actor. The token
+// lives for ttl, capped by IMPERSONATION_TTL; zero takes the maximum.
+func (i *Impersonations) Start(ctx context.Context, actor *Claims, tenantID, userID int64, reason string, ttl time.Duration) (*Impersonation, error) {
+	// the super admin list is read again, a token minted before an entry
+	// was removed does not keep the power to impersonate
+	if actor == nil || actor.IsAPIKey() || actor.ClientID != "" || actor.IsImpersonation() ||
+		!i.cfg.IsSuperAdmin(actor.TenantID, actor.UserID) {
+		return nil, ErrImpersonationForbidden
+	}
+
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, ErrImpersonationReason
+	}
+	if len(reason) > maxImpersonationReason {
+		return nil, fmt.Errorf("%w: at most %d characters", ErrImpersonationReason, maxImpersonationReason)
+	}
+
+	if maxTTL := i.maxTTL(); ttl <= 0 || ttl > maxTTL {
+		ttl = maxTTL
+	}
+
+	user, err := i.users.GetByID(ctx, tenantID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("load user: %w", err)
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	roles, err := i.roles.ListRoles(ctx, tenantID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("load roles: %w", err)
+	}
+
+	// the session is named after the token, so the audit trail knows
+	// which session to revoke
+	sess := &repo.SessionRecord{TenantID: tenantID, UserID: userID, Email: user.Email}
+	if err := i.sessions.create(ctx, sess); err != nil {
+		return nil, err
+	}
+
+	now := i.now()
+	rec := &repo.ImpersonationRecord{
+		TokenID:       sess.ID,
+		TenantID:      tenantID,
+		UserID:        userID,
+		ActorTenantID: actor.TenantID,
+		ActorUserID:   actor.UserID,
+		ActorEmail:    actor.Email,
+		Reason:        reason,
+		ExpiresAt:     now.Add(ttl),
+		CreatedAt:     now,
+	}
+
+	// the token is only usable once it is in the audit trail
+	id, err := i.repo.Create(ctx, rec)
+	if err != nil {
+		return nil, fmt.Errorf("store impersonation: %w", err)
+	}
+	rec.ID = id
+
+	// the user gets exactly its own scope, never the super admin one
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        sess.ID,
+			Issuer:    i.cfg.Issuer(),
+			Subject:   strconv.FormatInt(userID, 10),
+			Audience:  jwt.ClaimStrings{audience(i.cfg)},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(rec.ExpiresAt),
+		},
+		TenantID:  tenantID,
+		UserID:    userID,
+		Email:     user.Email,
+		SessionID: sess.ID,
+		Roles:     roles,
+		Scope:     GrantedScope(roles, false),
+		Act: &Actor{
+			Subject:  actor.Subject,
+			TenantID: actor.TenantID,
+			UserID:   actor.UserID,
+			Email:    actor.Email,
+		},
+	}
+
+	signed, err := i.keys.Sign(claims)
+	if err != nil {
+		return nil, fmt.Errorf("sign access token: %w", err)
+	}
+
+	return &Impersonation{
+		Record:    rec,
+		Token:     signed,
+		ExpiresIn: int64(ttl.Seconds()),
+	}, nil
+}
+
+// Record writes a request made with an impersonation token to the audit
+// trail. Tokens missing from the trail are refused.
+func (i *Impersonations) Record(ctx context.Context, claims *Claims, req *repo.ImpersonationRequestRecord) error {
+	if !claims.IsImpersonation() || claims.ID == "" {
+		return ErrImpersonationUnknown
+	}
+
+	err := i.repo.RecordRequest(ctx, claims.ID, req)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrImpersonationUnknown
+	}
+
+	return err
+}
+
+// End revokes the token of an impersonation of the tenant before it
+// expires. It returns ErrImpersonationUnknown when there is none.
+func (i *Impersonations) End(ctx context.Context, tenantID, id int64) error {
+	rec, err := i.repo.GetByID(ctx, tenantID, id)
+	if err != nil {
+		return fmt.Errorf("load impersonation: %w", err)
+	}
+	if rec == nil {
+		return ErrImpersonationUnknown
+	}
+
+	return i.sessions.Revoke(ctx, rec.TokenID, RevokedByAdmin)
+}
+
+// maxTTL is the longest lifetime of an impersonation token
+func (i *Impersonations) maxTTL() time.Duration {
+	if i.cfg.ImpersonationTTL <= 0 {
+		return defaultImpersonationTTL
+	}
+	return i.cfg.ImpersonationTTL
+}
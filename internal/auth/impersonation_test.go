@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+)
+
+// stubImpersonationRepo keeps the audit trail in memory
+type stubImpersonationRepo struct {
+	recs     []*repo.ImpersonationRecord
+	requests []*repo.ImpersonationRequestRecord
+}
+
+func (s *stubImpersonationRepo) Create(ctx context.Context, rec *repo.ImpersonationRecord) (int64, error) {
+	cp := *rec
+	cp.ID = int64(len(s.recs) + 1)
+	s.recs = append(s.recs, &cp)
+	return cp.ID, nil
+}
+
+func (s *stubImpersonationRepo) ListByTenant(ctx context.Context, tenantID int64) ([]*repo.ImpersonationRecord, error) {
+	return nil, nil
+}
+
+func (s *stubImpersonationRepo) GetByID(ctx context.Context, tenantID, id int64) (*repo.ImpersonationRecord, error) {
+	return nil, nil
+}
+
+func (s *stubImpersonationRepo) RecordRequest(ctx context.Context, tokenID string, rec *repo.ImpersonationRequestRecord) error {
+	for _, r := range s.recs {
+		if r.TokenID == tokenID {
+			s.requests = append(s.requests, rec)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (s *stubImpersonationRepo) ListRequests(ctx context.Context, impersonationID int64) ([]*repo.ImpersonationRequestRecord, error) {
+	return nil, nil
+}
+
+func TestImpersonations_Start(t *testing.T) {
+	s, _, now := newTestSessions(t)
+	ctx := context.Background()
+	s.cfg.SuperAdmins = []string{"1:1"}
+
+	userID, err := s.Provision(ctx, &AuthResult{TenantID: 7, UserID: "u1", Email: "u1@example.com", Roles: []string{RoleReadOnly}})
+	if err != nil {
+		t.Fatalf("provision: %v", err)
+	}
+
+	audit := &stubImpersonationRepo{}
+	imps := NewImpersonations(s.cfg, audit, s.users, s.roles, s, s.keys)
+	imps.now = func() time.Time { return *now }
+
+	superAdmin := &Claims{TenantID: 1, UserID: 1, SuperAdmin: true}
+	forbidden := []*Claims{
+		nil,
+		{TenantID: 7, UserID: 2, Roles: []string{RoleOwner}},
+		// the claim alone is not enough, the user must still be listed
+		{TenantID: 1, UserID: 3, SuperAdmin: true},
+		{TenantID: 1, UserID: 1, ClientID: "c1"},
+		{TenantID: 1, UserID: 1, Act: &Actor{UserID: 9}},
+	}
+	for _, actor := range forbidden {
+		if _, err := imps.Start(ctx, actor, 7, userID, "support", 0); !errors.Is(err, ErrImpersonationForbidden) {
+			t.Errorf("expected ErrImpersonationForbidden for %+v, got %v", actor, err)
+		}
+	}
+
+	if _, err := imps.Start(ctx, superAdmin, 7, userID, "  ", 0); !errors.Is(err, ErrImpersonationReason) {
+		t.Errorf("expected ErrImpersonationReason, got %v", err)
+	}
+
+	if _, err := imps.Start(ctx, superAdmin, 7, 99, "support", 0); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+
+	imp, err := imps.Start(ctx, superAdmin, 7, userID, "support", time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if imp.ExpiresIn != int64(defaultImpersonationTTL.Seconds()) {
+		t.Errorf("expected the lifetime to be capped, got %d", imp.ExpiresIn)
+	}
+
+	if len(audit.recs) != 1 || audit.recs[0].TokenID == "" || audit.recs[0].ActorUserID != 1 {
+		t.Fatalf("expected the impersonation in the audit trail, got %+v", audit.recs)
+	}
+
+	// the test clock is in the past, only the signature is checked
+	claims := &Claims{}
+	if _, err := jwt.NewParser(jwt.WithoutClaimsValidation()).ParseWithClaims(imp.Token, claims, s.keys.Keyfunc); err != nil {
+		t.Fatalf("parse token: %v", err)
+	}
+
+	if claims.Act == nil || claims.Act.UserID != 1 || claims.SuperAdmin || claims.SessionID != claims.ID {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+
+	if !claims.Can(PermIDPRead) || claims.Can(PermIDPWrite) {
+		t.Errorf("expected the scope of the user, got %v", claims.Scope)
+	}
+
+	if err := imps.Record(ctx, claims, &repo.ImpersonationRequestRecord{Method: "GET", Path: "/api/v1/me"}); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+
+	claims.ID = "unknown"
+	if err := imps.Record(ctx, claims, &repo.ImpersonationRequestRecord{}); !errors.Is(err, ErrImpersonationUnknown) {
+		t.Errorf("expected ErrImpersonationUnknown, got %v", err)
+	}
+}
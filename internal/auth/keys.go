@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+	"go.uber.org/zap"
+)
+
+const minRSAKeyBits = 2048
+
+var (
+	ErrUnknownKeyID       = errors.New("unknown signing key id")
+	ErrUnsupportedKeyType = errors.New("unsupported signing key type, use RSA or Ed25519")
+)
+
+// SigningKey is one asymmetric key of the keyring. Its ID is the RFC 7638
+// thumbprint of the public key, so every replica derives the same kid.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	private   crypto.Signer
+	method    jwt.SigningMethod
+}
+
+// Public returns the verification key
+func (k *SigningKey) Public() crypto.PublicKey {
+	return k.private.Public()
+}
+
+// JSONWebKey is the public part of a signing key (RFC 7517).
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JSONWebKeySet is served at /.well-known/jwks.json.
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// Keyring signs access tokens with its active key and verifies them with
+// any of its keys. Rotation overlaps by configuration: a new key is first
+// published after the active one, then moved to the front to become
+// active, and the old key is dropped once its tokens have expired.
+type Keyring struct {
+	active *SigningKey
+	keys   []*SigningKey
+}
+
+// ErrNoSigningKeys is returned at startup when no signing key is configured
+var ErrNoSigningKeys = errors.New("JWT_SIGNING_KEY_FILES is empty; set JWT_EPHEMERAL_KEY=true only for development")
+
+// NewKeyring loads JWT_SIGNING_KEY_FILES; the first file is the active key.
+// Without files it fails, unless JWT_EPHEMERAL_KEY asks for an ephemeral
+// Ed25519 key, which is only fit for development: tokens do not survive
+// restarts nor cross replicas.
+func NewKeyring(cfg *config.Config, log *zap.Logger) (*Keyring, error) {
+	if len(cfg.JWTSigningKeyFiles) == 0 {
+		if !cfg.JWTEphemeralKey {
+			return nil, ErrNoSigningKeys
+		}
+
+		log.Warn("JWT_SIGNING_KEY_FILES is empty, signing tokens with an ephemeral key")
+
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return NewKeyringFromSigners(priv)
+	}
+
+	signers := make([]crypto.Signer, 0, len(cfg.JWTSigningKeyFiles))
+	for _, path := range cfg.JWTSigningKeyFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read signing key: %w", err)
+		}
+
+		signer, err := parsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %s: %w", path, err)
+		}
+		signers = append(signers, signer)
+	}
+
+	return NewKeyringFromSigners(signers...)
+}
+
+// NewKeyringFromSigners builds a keyring whose first signer is active
+func NewKeyringFromSigners(signers ...crypto.Signer) (*Keyring, error) {
+	if len(signers) == 0 {
+		return nil, errors.New("keyring needs at least one key")
+	}
+
+	k := &Keyring{}
+	seen := make(map[string]bool, len(signers))
+	for _, s := range signers {
+		key, err := newSigningKey(s)
+		if err != nil {
+			return nil, err
+		}
+
+		if seen[key.ID] {
+			continue
+		}
+		seen[key.ID] = true
+
+		k.keys = append(k.keys, key)
+	}
+
+	k.active = k.keys[0]
+	return k, nil
+}
+
+// Active returns the key that signs new tokens
+func (k *Keyring) Active() *SigningKey {
+	return k.active
+}
+
+// Sign signs the claims with the active key, setting the kid header
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.active.method, claims)
+	token.Header["kid"] = k.active.ID
+	return token.SignedString(k.active.private)
+}
+
+// Keyfunc resolves the verification key of a token by its kid header
+func (k *Keyring) Keyfunc(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	key := k.key(kid)
+	if key == nil {
+		return nil, ErrUnknownKeyID
+	}
+
+	if t.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method %s for key %s", t.Method.Alg(), kid)
+	}
+
+	return key.Public(), nil
+}
+
+// Algorithms lists the signing algorithms of the keyring
+func (k *Keyring) Algorithms() []string {
+	var out []string
+	for _, key := range k.keys {
+		if !slices.Contains(out, key.Algorithm) {
+			out = append(out, key.Algorithm)
+		}
+	}
+	return out
+}
+
+// JWKS returns the public keys, the active one first
+func (k *Keyring) JWKS() JSONWebKeySet {
+	set := JSONWebKeySet{Keys: make([]JSONWebKey, 0, len(k.keys))}
+	for _, key := range k.keys {
+		set.Keys = append(set.Keys, key.jwk())
+	}
+	return set
+}
+
+func (k *Keyring) key(kid string) *SigningKey {
+	for _, key := range k.keys {
+		if key.ID == kid {
+			return key
+		}
+	}
+	return nil
+}
+
+func newSigningKey(s crypto.Signer) (*SigningKey, error) {
+	key := &SigningKey{private: s}
+
+	switch pub := s.Public().(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("rsa key must have at least %d bits", minRSAKeyBits)
+		}
+		key.Algorithm = jwt.SigningMethodRS256.Alg()
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.Algorithm = jwt.SigningMethodEdDSA.Alg()
+		key.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, ErrUnsupportedKeyType
+	}
+
+	thumbprint, err := key.thumbprint()
+	if err != nil {
+		return nil, err
+	}
+
+	key.ID = thumbprint
+	return key, nil
+}
+
+func (k *SigningKey) jwk() JSONWebKey {
+	jwk := JSONWebKey{KeyID: k.ID, Use: "sig", Algorithm: k.Algorithm}
+
+	switch pub := k.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+
+	return jwk
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint
+func (k *SigningKey) thumbprint() (string, error) {
+	jwk := k.jwk()
+
+	// required members only, in lexicographic order
+	var members any
+	switch jwk.KeyType {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X}
+	}
+
+	b, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// parsePrivateKey reads a PKCS#8 or PKCS#1 PEM private key
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	default:
+		return nil, ErrUnsupportedKeyType
+	}
+}
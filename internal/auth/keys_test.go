@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"io"
+	"math/big"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+	"go.uber.org/zap"
+)
+
+// publicOnly exposes a public key as a signer, enough to derive its kid
+type publicOnly struct{ pub crypto.PublicKey }
+
+func (p publicOnly) Public() crypto.PublicKey { return p.pub }
+func (p publicOnly) Sign(io.Reader, []byte, crypto.SignerOpts) ([]byte, error) {
+	return nil, errors.New("public key only")
+}
+
+func TestSigningKey_Thumbprint(t *testing.T) {
+	// RFC 7638 section 3.1
+	n, _ := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+
+	key, err := newSigningKey(publicOnly{pub})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if key.ID != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Errorf("unexpected kid %q", key.ID)
+	}
+
+	if key.Algorithm != "RS256" {
+		t.Errorf("unexpected alg %q", key.Algorithm)
+	}
+}
+
+func TestKeyring_OverlappingRotation(t *testing.T) {
+	_, oldKey, _ := ed25519.GenerateKey(rand.Reader)
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := NewKeyringFromSigners(oldKey, newKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	signed, err := before.Sign(jwt.MapClaims{"sub": "u1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the new key becomes active, the old one still verifies
+	during, _ := NewKeyringFromSigners(newKey, oldKey)
+	if _, err := jwt.Parse(signed, during.Keyfunc, jwt.WithValidMethods(during.Algorithms())); err != nil {
+		t.Fatalf("token of the previous key should verify: %v", err)
+	}
+
+	fresh, _ := during.Sign(jwt.MapClaims{"sub": "u1"})
+	tok, err := jwt.Parse(fresh, before.Keyfunc)
+	if err != nil || tok.Method.Alg() != "RS256" {
+		t.Fatalf("new key was published before rotation, got %v", err)
+	}
+
+	// the old key is retired
+	after, _ := NewKeyringFromSigners(newKey)
+	if _, err := jwt.Parse(signed, after.Keyfunc); !errors.Is(err, ErrUnknownKeyID) {
+		t.Fatalf("expected ErrUnknownKeyID, got %v", err)
+	}
+
+	if set := during.JWKS(); len(set.Keys) != 2 || set.Keys[0].KeyType != "RSA" || set.Keys[1].Curve != "Ed25519" {
+		t.Errorf("unexpected jwks %+v", set)
+	}
+}
+
+func TestKeyring_RejectsAlgorithmConfusion(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	keys, _ := NewKeyringFromSigners(priv)
+
+	// HS256 token claiming the kid of an asymmetric key
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "u1"})
+	forged.Header["kid"] = keys.Active().ID
+	signed, _ := forged.SignedString([]byte("secret"))
+
+	if _, err := jwt.Parse(signed, keys.Keyfunc, jwt.WithValidMethods(keys.Algorithms())); err == nil {
+		t.Fatal("expected HS256 token to be rejected")
+	}
+}
+
+func TestNewSigningKey_RejectsWeakRSA(t *testing.T) {
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := newSigningKey(weak); err == nil {
+		t.Fatal("expected error for a 1024 bits key")
+	}
+}
+
+func TestNewKeyring_RequiresKeysOutsideDevelopment(t *testing.T) {
+	if _, err := NewKeyring(&config.Config{}, zap.NewNop()); !errors.Is(err, ErrNoSigningKeys) {
+		t.Fatalf("expected ErrNoSigningKeys, got %v", err)
+	}
+
+	k, err := NewKeyring(&config.Config{JWTEphemeralKey: true}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if k.Active().Algorithm != "EdDSA" {
+		t.Errorf("unexpected alg %q", k.Active().Algorithm)
+	}
+}
@@ -1,31 +1,45 @@
 package auth
 
 import (
+	"bytes"
 	"context"
-	"crypto/aes"
-	"crypto/cipher"
+	"crypto/subtle"
 	"database/sql"
-	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/coreos/go-oidc"
 	"github.com/jeanmolossi/verbose-adventure/internal/config"
+	"github.com/jeanmolossi/verbose-adventure/internal/encryption"
 	"golang.org/x/oauth2"
 )
 
 type IdentityProvider interface {
 	TenantID() int64
 	Type() string
-	AuthURL(state string) string
-	Callback(ctx context.Context, req *http.Request) (*AuthResult, error)
+	// Slug identifies the provider among the tenant providers, in URLs
+	Slug() string
+	AuthURL(params AuthParams) string
+	Callback(ctx context.Context, req *http.Request, params AuthParams) (*AuthResult, error)
 }
 
 type AuthResult struct {
-	TenantID   int64
-	UserID     string
-	Email      string
+	TenantID int64
+	// IdPID is the identity provider that authenticated the user
+	IdPID int64
+	// UserID is the subject asserted by the IdP, not the CRM user id
+	UserID string
+	Email  string
+	Name   string
+	Groups []string
+	// Roles are the tenant roles mapped from Groups
+	Roles      []string
+	Attributes map[string][]string
 	RawIDToken *oidc.IDToken
 }
 
@@ -33,148 +47,206 @@ type idpRecord struct {
 	ID              int64
 	TenantID        int64
 	ProviderType    string
+	Slug            string
 	MetadataURL     string
 	ClientID        string
 	ClientSecretEnc []byte
+	Scopes          []string
+	ClaimMapping    ClaimMapping
+	RoleMapping     map[string]string
+	AuthParams      map[string]string
+	LoginPolicy     LoginPolicy
+	RedirectURL     string
 	Enabled         bool
 }
 
-func LoadIdentityProviders(cfg *config.Config, db *sql.DB) ([]IdentityProvider, error) {
-	ctx := context.Background()
-
+// queryTenantRecords returns the enabled identity providers of a tenant
+func queryTenantRecords(ctx context.Context, db *sql.DB, tenantID int64) ([]idpRecord, error) {
 	query := `
-    SELECT id, tenant_id, type, metadata_url, client_id, client_secret_enc, enabled
+    SELECT id, tenant_id, type, slug, metadata_url, client_id, client_secret_enc,
+           scopes, claim_mapping, role_mapping, auth_params, login_policy, redirect_url, enabled
     FROM identity_providers
-    WHERE enabled = ?
+    WHERE tenant_id = ? AND enabled = ?
     `
 
-	rows, err := db.QueryContext(ctx, query, 1)
+	rows, err := db.QueryContext(ctx, query, tenantID, 1)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	providers := make([]IdentityProvider, 0, 2) // pre-alloc 2 providers space
+	var records []idpRecord
 	for rows.Next() {
-		var rec idpRecord
+		var (
+			rec                                                        idpRecord
+			scopes, claimMapping, roleMapping, authParams, loginPolicy []byte
+			redirectURL                                                sql.NullString
+		)
+
 		if err := rows.Scan(
 			&rec.ID,
 			&rec.TenantID,
 			&rec.ProviderType,
+			&rec.Slug,
 			&rec.MetadataURL,
 			&rec.ClientID,
 			&rec.ClientSecretEnc,
+			&scopes,
+			&claimMapping,
+			&roleMapping,
+			&authParams,
+			&loginPolicy,
+			&redirectURL,
 			&rec.Enabled,
 		); err != nil {
 			return nil, err
 		}
 
-		secret, err := decryptSecret(rec.ClientSecretEnc, cfg.EncryptionKey)
-		if err != nil {
-			return nil, err
-		}
-
-		switch rec.ProviderType {
-		case "oidc":
-			p, err := newOIDCProvider(ctx, rec, secret, cfg)
-			if err != nil {
-				return nil, err
+		for _, col := range []struct {
+			data []byte
+			dst  any
+		}{
+			{scopes, &rec.Scopes},
+			{claimMapping, &rec.ClaimMapping},
+			{roleMapping, &rec.RoleMapping},
+			{authParams, &rec.AuthParams},
+			{loginPolicy, &rec.LoginPolicy},
+		} {
+			if len(col.data) == 0 {
+				continue
+			}
+			if err := json.Unmarshal(col.data, col.dst); err != nil {
+				return nil, fmt.Errorf("identity provider %d: %w", rec.ID, err)
 			}
-
-			providers = append(providers, p)
-		case "saml":
-			// TODO: implement newSAMLProvider
-		default:
-			// ignore
 		}
-	}
 
-	if err := rows.Err(); err != nil {
-		return nil, err
+		rec.RedirectURL = redirectURL.String
+		records = append(records, rec)
 	}
 
-	return providers, nil
+	return records, rows.Err()
 }
 
-// decryptSecret decipher the client_secret using AES-GCM
-func decryptSecret(ciphertext []byte, keyBase64 string) (string, error) {
-	key, err := base64.StdEncoding.DecodeString(keyBase64)
-	if err != nil {
-		return "", err
-	}
-
-	if len(key) != 32 {
-		return "", errors.New("invalid encryption key length")
-	}
-
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return "", err
-	}
-
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return "", err
-	}
-
-	nonceSize := gcm.NonceSize()
-	if len(ciphertext) < nonceSize {
-		return "", errors.New("ciphertext too short")
-	}
-
-	nonce, ct := ciphertext[:nonceSize], ciphertext[nonceSize:]
-	plaintext, err := gcm.Open(nil, nonce, ct, nil)
-	if err != nil {
-		return "", err
+// buildProvider builds the provider of the record, decrypting the client
+// secret of OIDC ones; SAML providers have none. The IdP is fetched with
+// client, ctx bounds the whole build. SAML providers consume their
+// assertions in replay.
+func buildProvider(ctx context.Context, rec idpRecord, cfg *config.Config, secrets *encryption.Keyring, client *http.Client, replay ReplayStore) (IdentityProvider, error) {
+	switch rec.ProviderType {
+	case "oidc":
+		secret, err := secrets.Decrypt(ctx, rec.ClientSecretEnc)
+		if err != nil {
+			return nil, fmt.Errorf("decrypt client secret: %w", err)
+		}
+		return newOIDCProvider(ctx, rec, string(secret), cfg, client)
+	case "saml":
+		return newSAMLProvider(ctx, rec, cfg, client, replay)
+	default:
+		return nil, fmt.Errorf("unsupported provider type %q", rec.ProviderType)
 	}
-
-	return string(plaintext), nil
 }
 
-func newOIDCProvider(ctx context.Context, rec idpRecord, secret string, cfg *config.Config) (IdentityProvider, error) {
-	provider, err := oidc.NewProvider(ctx, rec.MetadataURL)
+func newOIDCProvider(ctx context.Context, rec idpRecord, secret string, cfg *config.Config, client *http.Client) (IdentityProvider, error) {
+	// the provider keeps this context to fetch the signing keys on later
+	// logins, so it can not carry the build deadline: the client timeout
+	// bounds discovery and every key fetch instead
+	provider, err := oidc.NewProvider(oidc.ClientContext(context.WithoutCancel(ctx), client), rec.MetadataURL)
 	if err != nil {
 		return nil, err
 	}
 
+	redirectURL := rec.RedirectURL
+	if redirectURL == "" {
+		redirectURL = providerBaseURL(cfg, rec) + "/callback"
+	}
+
 	oauth2Cfg := &oauth2.Config{
 		ClientID:     rec.ClientID,
 		ClientSecret: secret,
 		Endpoint:     provider.Endpoint(),
-		RedirectURL:  cfg.BaseURL + "/" + strconv.FormatInt(rec.TenantID, 10) + "/oidc/callback",
-		Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+		RedirectURL:  redirectURL,
+		Scopes:       oidcScopes(rec.Scopes),
+	}
+
+	var authOpts []oauth2.AuthCodeOption
+	for k, v := range rec.AuthParams {
+		if isReservedAuthParam(k) {
+			continue
+		}
+		authOpts = append(authOpts, oauth2.SetAuthURLParam(k, v))
+	}
+
+	// asked after auth_params, the policy wins over a custom acr_values
+	if len(rec.LoginPolicy.ACRValues) > 0 {
+		authOpts = append(authOpts, oauth2.SetAuthURLParam("acr_values", strings.Join(rec.LoginPolicy.ACRValues, " ")))
 	}
 
 	verifier := provider.Verifier(&oidc.Config{ClientID: rec.ClientID})
 	return &oidcProvider{
 		id:       rec.ID,
 		tenantID: rec.TenantID,
+		slug:     rec.Slug,
 		oauth2:   oauth2Cfg,
 		verifier: verifier,
+		claims:   claimsWithDefaults(rec.ClaimMapping),
+		roles:    rec.RoleMapping,
+		policy:   rec.LoginPolicy,
+		authOpts: authOpts,
 	}, nil
 }
 
+// providerBaseURL is the root of the provider routes, /<tenant>/<slug>
+func providerBaseURL(cfg *config.Config, rec idpRecord) string {
+	return strings.TrimSuffix(cfg.BaseURL, "/") + "/" + strconv.FormatInt(rec.TenantID, 10) + "/" + rec.Slug
+}
+
+// oidcScopes returns the default scopes plus the provider extra scopes
+func oidcScopes(extra []string) []string {
+	scopes := []string{oidc.ScopeOpenID, "profile", "email"}
+	for _, s := range extra {
+		if s != "" && !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
 type oidcProvider struct {
 	id       int64
 	tenantID int64
+	slug     string
 	oauth2   *oauth2.Config
 	verifier *oidc.IDTokenVerifier
+	claims   ClaimMapping
+	roles    map[string]string
+	policy   LoginPolicy
+	authOpts []oauth2.AuthCodeOption
 }
 
 func (o *oidcProvider) TenantID() int64 { return o.tenantID }
 func (o *oidcProvider) Type() string    { return "oidc" }
-func (o *oidcProvider) AuthURL(state string) string {
-	return o.oauth2.AuthCodeURL(state)
+func (o *oidcProvider) Slug() string    { return o.slug }
+func (o *oidcProvider) AuthURL(params AuthParams) string {
+	opts := append([]oauth2.AuthCodeOption{
+		oidc.Nonce(params.Nonce),
+		oauth2.S256ChallengeOption(params.CodeVerifier),
+	}, o.authOpts...)
+
+	return o.oauth2.AuthCodeURL(params.State, opts...)
 }
 
-func (o *oidcProvider) Callback(ctx context.Context, req *http.Request) (*AuthResult, error) {
+func (o *oidcProvider) Callback(ctx context.Context, req *http.Request, params AuthParams) (*AuthResult, error) {
 	q := req.URL.Query()
+	if e := q.Get("error"); e != "" {
+		return nil, fmt.Errorf("identity provider returned error: %s", e)
+	}
+
 	code := q.Get("code")
 	if code == "" {
 		return nil, errors.New("authorization code not found")
 	}
 
-	tok, err := o.oauth2.Exchange(ctx, code)
+	tok, err := o.oauth2.Exchange(ctx, code, oauth2.VerifierOption(params.CodeVerifier))
 	if err != nil {
 		return nil, err
 	}
@@ -189,19 +261,42 @@ func (o *oidcProvider) Callback(ctx context.Context, req *http.Request) (*AuthRe
 		return nil, err
 	}
 
-	var claims struct {
-		Email   string `json:"email"`
-		Subject string `json:"sub"`
+	if params.Nonce == "" || subtle.ConstantTimeCompare([]byte(idTok.Nonce), []byte(params.Nonce)) != 1 {
+		return nil, ErrNonceMismatch
+	}
+
+	var raw json.RawMessage
+	if err := idTok.Claims(&raw); err != nil {
+		return nil, err
+	}
+
+	// keep numeric ids as written by the IdP instead of float64
+	claims := make(map[string]any)
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&claims); err != nil {
+		return nil, err
+	}
+
+	groups := claimStrings(claims, o.claims.Groups)
+	userID := claimString(claims, o.claims.UserID)
+	if userID == "" {
+		return nil, fmt.Errorf("claim %q not found in id_token", o.claims.UserID)
 	}
 
-	if err := idTok.Claims(&claims); err != nil {
+	email := claimString(claims, o.claims.Email)
+	if err := checkPolicy(o.policy, claims, email); err != nil {
 		return nil, err
 	}
 
 	return &AuthResult{
 		TenantID:   o.tenantID,
-		UserID:     claims.Subject,
-		Email:      claims.Email,
+		IdPID:      o.id,
+		UserID:     userID,
+		Email:      email,
+		Name:       claimString(claims, o.claims.Name),
+		Groups:     groups,
+		Roles:      mapRoles(o.roles, groups),
 		RawIDToken: idTok,
 	}, nil
 }
@@ -5,16 +5,30 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"io"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/jeanmolossi/verbose-adventure/internal/config"
+	"github.com/jeanmolossi/verbose-adventure/internal/encryption"
 )
 
+var idpColumns = []string{
+	"id", "tenant_id", "type", "slug", "metadata_url", "client_id", "client_secret_enc",
+	"scopes", "claim_mapping", "role_mapping", "auth_params", "login_policy", "redirect_url", "enabled",
+}
+
 func encryptAESGCM(t *testing.T, key []byte, plaintext string) []byte {
 	t.Helper()
 
@@ -36,71 +50,19 @@ func encryptAESGCM(t *testing.T, key []byte, plaintext string) []byte {
 	return append(nonce, gcm.Seal(nil, nonce, []byte(plaintext), nil)...)
 }
 
-func TestDecryptSecret_Success(t *testing.T) {
-	rawKey := make([]byte, 32)
-	if _, err := rand.Read(rawKey); err != nil {
-		t.Fatal(err)
-	}
-
-	keyB64 := base64.StdEncoding.EncodeToString(rawKey)
-
-	ciphertext := encryptAESGCM(t, rawKey, "super-secret")
+// testSecrets returns a keyring whose legacy key is rawKey, like a
+// deployment that only sets ENCRYPTION_KEY
+func testSecrets(t *testing.T, rawKey []byte) *encryption.Keyring {
+	t.Helper()
 
-	got, err := decryptSecret(ciphertext, keyB64)
+	k, err := encryption.NewKeyringFromKeys(rawKey)
 	if err != nil {
-		t.Fatal(err)
-	}
-
-	if got != "super-secret" {
-		t.Fatalf("want %q, got %q", "super-secret", got)
-	}
-}
-
-func TestDecryptSecret_InvalidBase64(t *testing.T) {
-	ciphertext := []byte("data")
-
-	if _, err := decryptSecret(ciphertext, "bad-base64"); err == nil {
-		t.Fatal("expected error on base64")
+		t.Fatalf("failed to build keyring: %v", err)
 	}
+	return k
 }
 
-func TestDecryptSecret_InvalidKeyLength(t *testing.T) {
-	// valid key length to encrypt
-	rawKey := make([]byte, 32)
-	if _, err := rand.Read(rawKey); err != nil {
-		t.Fatalf("failed to randomize key: %v", err)
-	}
-	ciphertext := encryptAESGCM(t, rawKey, "x")
-
-	// invalid key length
-	shortKey := make([]byte, 10)
-	if _, err := rand.Read(shortKey); err != nil {
-		t.Fatalf("failed to randomize short key: %v", err)
-	}
-
-	keyB64 := base64.StdEncoding.EncodeToString(shortKey)
-	_, err := decryptSecret(ciphertext, keyB64)
-	if err == nil {
-		t.Error("expected error for invalid key length, got nil")
-	}
-}
-
-func TestDecryptSecret_CipherTooShort(t *testing.T) {
-	rawKey := make([]byte, 32)
-	if _, err := rand.Read(rawKey); err != nil {
-		t.Fatalf("failed to randomize key: %v", err)
-	}
-
-	keyB64 := base64.StdEncoding.EncodeToString(rawKey)
-
-	short := make([]byte, 0)
-	_, err := decryptSecret(short, keyB64)
-	if err == nil {
-		t.Error("expected error for invalid key length, got nil")
-	}
-}
-
-func TestLoadIdentityProviders_Success(t *testing.T) {
+func TestLoadTenantProviders_Success(t *testing.T) {
 	var ts *httptest.Server
 	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
@@ -133,22 +95,23 @@ func TestLoadIdentityProviders_Success(t *testing.T) {
 	secretPlain := "secret"
 	ciphertext := encryptAESGCM(t, rawKey, secretPlain)
 
-	rows := sqlmock.NewRows([]string{"id", "tenant_id", "type", "metadata_url", "client_id", "client_secret_enc", "enabled"}).
-		AddRow(1, 42, "oidc", ts.URL, "client-id", ciphertext, true)
+	rows := sqlmock.NewRows(idpColumns).
+		AddRow(1, 42, "oidc", "oidc", ts.URL, "client-id", ciphertext, nil, nil, nil, nil, nil, nil, true)
 
-	mock.ExpectQuery("SELECT id, tenant_id, type, metadata_url, client_id, client_secret_enc, enabled FROM identity_providers WHERE enabled = ?").
+	mock.ExpectQuery("SELECT id, tenant_id, type, slug, metadata_url, client_id, client_secret_enc, scopes, claim_mapping, role_mapping, auth_params, login_policy, redirect_url, enabled FROM identity_providers WHERE tenant_id = \\? AND enabled = \\?").
+		WithArgs(42, 1).
 		WillReturnRows(rows)
 
-	providers, err := LoadIdentityProviders(cfg, db)
+	registry := NewRegistry(cfg, db, testSecrets(t, rawKey), newReplayCache())
+	p, err := registry.Get(context.Background(), 42, "oidc")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	if len(providers) != 1 {
-		t.Errorf("expected 1 providers, got %d", len(providers))
+	if h := registry.Health(42); len(h) != 1 || !h[0].Healthy {
+		t.Errorf("expected 1 healthy provider, got %+v", h)
 	}
 
-	p := providers[0]
 	if p.TenantID() != 42 {
 		t.Errorf("expected tenantID 42, got %d", p.TenantID())
 	}
@@ -158,7 +121,7 @@ func TestLoadIdentityProviders_Success(t *testing.T) {
 	}
 }
 
-func TestLoadIdentityProviders_NoRows(t *testing.T) {
+func TestLoadTenantProviders_NoRows(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("failed to open sqlmock: %v", err)
@@ -168,16 +131,66 @@ func TestLoadIdentityProviders_NoRows(t *testing.T) {
 
 	cfg := &config.Config{EncryptionKey: "", BaseURL: ""}
 
-	mock.ExpectQuery("SELECT id, tenant_id, type, metadata_url, client_id, client_secret_enc, enabled FROM identity_providers WHERE enabled = ?").
-		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "type", "metadata_url", "client_id", "client_secret_enc", "enabled"}))
+	mock.ExpectQuery("SELECT id, tenant_id, type, slug, metadata_url, client_id, client_secret_enc, scopes, claim_mapping, role_mapping, auth_params, login_policy, redirect_url, enabled FROM identity_providers WHERE tenant_id = \\? AND enabled = \\?").
+		WillReturnRows(sqlmock.NewRows(idpColumns))
+
+	registry := NewRegistry(cfg, db, nil, newReplayCache())
+	if _, err := registry.Get(context.Background(), 42, "oidc"); !errors.Is(err, ErrProviderNotFound) {
+		t.Fatalf("expected ErrProviderNotFound, got %v", err)
+	}
+
+	if h := registry.Health(42); len(h) != 0 {
+		t.Errorf("expected no providers, got %+v", h)
+	}
+}
+
+func TestLoadTenantProviders_IsolatesBrokenProvider(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"issuer":"` + ts.URL + `","jwks_uri":"` + ts.URL + `/jwks"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
 
-	providers, err := LoadIdentityProviders(cfg, db)
+	db, mock, err := sqlmock.New()
 	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
+		t.Fatalf("failed to open sqlmock: %v", err)
 	}
 
-	if len(providers) != 0 {
-		t.Errorf("expected 0 providers, got %d", len(providers))
+	defer db.Close()
+
+	rawKey := make([]byte, 32)
+	if _, err := rand.Read(rawKey); err != nil {
+		t.Fatalf("failed to randomize key: %v", err)
+	}
+	cfg := &config.Config{EncryptionKey: base64.StdEncoding.EncodeToString(rawKey), BaseURL: ts.URL}
+
+	// the saml metadata url answers 404
+	rows := sqlmock.NewRows(idpColumns).
+		AddRow(1, 42, "oidc", "oidc", ts.URL, "client-id", encryptAESGCM(t, rawKey, "secret"), nil, nil, nil, nil, nil, nil, true).
+		AddRow(2, 42, "saml", "saml", ts.URL+"/missing", "sp-id", encryptAESGCM(t, rawKey, "secret"), nil, nil, nil, nil, nil, nil, true)
+
+	mock.ExpectQuery("SELECT id, tenant_id, type, slug, metadata_url, client_id, client_secret_enc, scopes, claim_mapping, role_mapping, auth_params, login_policy, redirect_url, enabled FROM identity_providers WHERE tenant_id = \\? AND enabled = \\?").
+		WithArgs(42, 1).
+		WillReturnRows(rows)
+
+	registry := NewRegistry(cfg, db, testSecrets(t, rawKey), newReplayCache())
+	if _, err := registry.Get(context.Background(), 42, "oidc"); err != nil {
+		t.Fatalf("healthy provider should be served: %v", err)
+	}
+
+	if _, err := registry.Get(context.Background(), 42, "saml"); !errors.Is(err, ErrProviderUnavailable) {
+		t.Fatalf("expected ErrProviderUnavailable, got %v", err)
+	}
+
+	unhealthy := registry.Unhealthy()
+	if len(unhealthy) != 1 || unhealthy[0].ID != 2 || unhealthy[0].LastError == "" || unhealthy[0].NextRetry == nil {
+		t.Errorf("unexpected unhealthy providers %+v", unhealthy)
 	}
 }
 
@@ -214,14 +227,14 @@ func TestOIDCProviderCallback_NoCode(t *testing.T) {
 	}
 
 	cfg := &config.Config{EncryptionKey: base64.StdEncoding.EncodeToString(rawKey), BaseURL: ts.URL}
-	pIface, err := newOIDCProvider(context.Background(), rec, "secret", cfg)
+	pIface, err := newOIDCProvider(context.Background(), rec, "secret", cfg, http.DefaultClient)
 	if err != nil {
 		t.Fatalf("failed to create provider: %v", err)
 	}
 	op := pIface.(*oidcProvider)
 
 	req := httptest.NewRequest(http.MethodGet, "/callback", nil)
-	if _, err := op.Callback(context.Background(), req); err == nil {
+	if _, err := op.Callback(context.Background(), req, AuthParams{State: "s", Nonce: "n", CodeVerifier: "v"}); err == nil {
 		t.Error("expected error for missing code, got nil")
 	}
 }
@@ -261,14 +274,263 @@ func TestOIDCProviderCallback_InvalidCode(t *testing.T) {
 	}
 
 	cfg := &config.Config{EncryptionKey: base64.StdEncoding.EncodeToString(rawKey), BaseURL: ts.URL}
-	pIface, err := newOIDCProvider(context.Background(), rec, "secret", cfg)
+	pIface, err := newOIDCProvider(context.Background(), rec, "secret", cfg, http.DefaultClient)
 	if err != nil {
 		t.Fatalf("failed to create provider: %v", err)
 	}
 	op := pIface.(*oidcProvider)
 
 	req := httptest.NewRequest(http.MethodGet, "/callback?code=foo", nil)
-	if _, err := op.Callback(context.Background(), req); err == nil {
+	if _, err := op.Callback(context.Background(), req, AuthParams{State: "s", Nonce: "n", CodeVerifier: "v"}); err == nil {
 		t.Error("expected error for invalid code exchange, got nil")
 	}
 }
+
+// testOIDCServer is a minimal OpenID provider that signs id_tokens with
+// the claims set by the test and enforces PKCE on the token endpoint.
+type testOIDCServer struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	claims    map[string]any
+	challenge string
+}
+
+func newTestOIDCServer(t *testing.T) *testOIDCServer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	s := &testOIDCServer{key: key, claims: map[string]any{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]any{
+				"issuer":                 s.URL,
+				"jwks_uri":               s.URL + "/jwks",
+				"authorization_endpoint": s.URL + "/authorize",
+				"token_endpoint":         s.URL + "/token",
+			})
+		case "/jwks":
+			json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]any{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "test",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}}})
+		case "/token":
+			sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+			if base64.RawURLEncoding.EncodeToString(sum[:]) != s.challenge {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"invalid_grant"}`))
+				return
+			}
+
+			claims := jwt.MapClaims{
+				"iss": s.URL,
+				"aud": "client-id",
+				"iat": time.Now().Unix(),
+				"exp": time.Now().Add(time.Minute).Unix(),
+			}
+			for k, v := range s.claims {
+				claims[k] = v
+			}
+
+			tok := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+			tok.Header["kid"] = "test"
+			signed, _ := tok.SignedString(key)
+
+			json.NewEncoder(w).Encode(map[string]any{
+				"access_token": "at",
+				"token_type":   "Bearer",
+				"id_token":     signed,
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	return s
+}
+
+// login runs AuthURL and the callback against the test server
+func (s *testOIDCServer) login(t *testing.T, p IdentityProvider, params AuthParams) (*AuthResult, error) {
+	t.Helper()
+
+	u, err := url.Parse(p.AuthURL(params))
+	if err != nil {
+		t.Fatalf("invalid auth url: %v", err)
+	}
+	s.challenge = u.Query().Get("code_challenge")
+
+	req := httptest.NewRequest(http.MethodGet, "/callback?code=abc&state="+params.State, nil)
+	return p.Callback(context.Background(), req, params)
+}
+
+func TestOIDCProviderCallback_ClaimMapping(t *testing.T) {
+	ts := newTestOIDCServer(t)
+	defer ts.Close()
+
+	rec := idpRecord{
+		ID:           1,
+		TenantID:     7,
+		ProviderType: "oidc",
+		MetadataURL:  ts.URL,
+		ClientID:     "client-id",
+		Scopes:       []string{"offline_access", "email"},
+		ClaimMapping: ClaimMapping{UserID: "oid", Email: "preferred_username", Groups: "realm_access.roles"},
+		RoleMapping:  map[string]string{"admins": RoleAdmin, "sales": RoleMember, "ops": RoleOwner},
+		AuthParams:   map[string]string{"domain_hint": "contoso.com", "state": "ignored"},
+		RedirectURL:  "https://crm.example.com/7/oidc/callback",
+	}
+
+	p, err := newOIDCProvider(context.Background(), rec, "secret", &config.Config{BaseURL: "http://localhost"}, http.DefaultClient)
+	if err != nil {
+		t.Fatalf("failed to create provider: %v", err)
+	}
+
+	params := AuthParams{State: "st", Nonce: "nc", CodeVerifier: "verifier-verifier-verifier-verifier-verifier"}
+	u, _ := url.Parse(p.AuthURL(params))
+	q := u.Query()
+	if q.Get("scope") != "openid profile email offline_access" {
+		t.Errorf("unexpected scope %q", q.Get("scope"))
+	}
+	if q.Get("domain_hint") != "contoso.com" || q.Get("state") != "st" {
+		t.Errorf("unexpected auth params %v", q)
+	}
+	if q.Get("redirect_uri") != rec.RedirectURL {
+		t.Errorf("unexpected redirect_uri %q", q.Get("redirect_uri"))
+	}
+
+	ts.claims = map[string]any{
+		"sub":                "pairwise-sub",
+		"oid":                "00000000-0000-0000-0000-000000000001",
+		"nonce":              "nc",
+		"name":               "Jane Doe",
+		"preferred_username": "jane@contoso.com",
+		"realm_access":       map[string]any{"roles": []string{"sales", "admins"}},
+	}
+
+	res, err := ts.login(t, p, params)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if res.IdPID != rec.ID || res.UserID != "00000000-0000-0000-0000-000000000001" || res.Email != "jane@contoso.com" || res.Name != "Jane Doe" {
+		t.Errorf("unexpected auth result %+v", res)
+	}
+	if len(res.Groups) != 2 || res.Groups[0] != "sales" || res.Groups[1] != "admins" {
+		t.Errorf("unexpected groups %v", res.Groups)
+	}
+	if len(res.Roles) != 2 || res.Roles[0] != RoleAdmin || res.Roles[1] != RoleMember {
+		t.Errorf("unexpected roles %v", res.Roles)
+	}
+}
+
+func TestOIDCProviderCallback_NonceMismatch(t *testing.T) {
+	ts := newTestOIDCServer(t)
+	defer ts.Close()
+
+	rec := idpRecord{ID: 1, TenantID: 7, ProviderType: "oidc", MetadataURL: ts.URL, ClientID: "client-id"}
+	p, err := newOIDCProvider(context.Background(), rec, "secret", &config.Config{BaseURL: "http://localhost"}, http.DefaultClient)
+	if err != nil {
+		t.Fatalf("failed to create provider: %v", err)
+	}
+
+	ts.claims = map[string]any{"sub": "u1", "nonce": "other"}
+	params := AuthParams{State: "st", Nonce: "nc", CodeVerifier: "verifier-verifier-verifier-verifier-verifier"}
+	if _, err := ts.login(t, p, params); !errors.Is(err, ErrNonceMismatch) {
+		t.Errorf("expected ErrNonceMismatch, got %v", err)
+	}
+}
+
+func TestOIDCProviderCallback_LoginPolicy(t *testing.T) {
+	ts := newTestOIDCServer(t)
+	defer ts.Close()
+
+	rec := idpRecord{
+		ID: 1, TenantID: 7, ProviderType: "oidc", MetadataURL: ts.URL, ClientID: "client-id",
+		LoginPolicy: LoginPolicy{
+			ACRValues:            []string{"urn:okta:loa:2fa:any"},
+			AMRValues:            []string{"mfa", "hwk"},
+			RequireEmailVerified: true,
+			AllowedEmailDomains:  []string{"contoso.com"},
+		},
+	}
+
+	p, err := newOIDCProvider(context.Background(), rec, "secret", &config.Config{BaseURL: "http://localhost"}, http.DefaultClient)
+	if err != nil {
+		t.Fatalf("failed to create provider: %v", err)
+	}
+
+	params := AuthParams{State: "st", Nonce: "nc", CodeVerifier: "verifier-verifier-verifier-verifier-verifier"}
+	u, _ := url.Parse(p.AuthURL(params))
+	if got := u.Query().Get("acr_values"); got != "urn:okta:loa:2fa:any" {
+		t.Errorf("expected the acr values to be requested, got %q", got)
+	}
+
+	valid := map[string]any{
+		"sub":            "u1",
+		"nonce":          "nc",
+		"email":          "jane@Contoso.com",
+		"email_verified": true,
+		"acr":            "urn:okta:loa:2fa:any",
+		"amr":            []string{"pwd", "mfa"},
+	}
+
+	cases := []struct {
+		name   string
+		mutate func(claims map[string]any)
+		ok     bool
+	}{
+		{"meets the policy", func(map[string]any) {}, true},
+		{"email_verified as a string", func(c map[string]any) { c["email_verified"] = "true" }, true},
+		{"missing acr", func(c map[string]any) { delete(c, "acr") }, false},
+		{"weaker acr", func(c map[string]any) { c["acr"] = "urn:okta:loa:1fa:any" }, false},
+		{"password only", func(c map[string]any) { c["amr"] = []string{"pwd"} }, false},
+		{"missing amr", func(c map[string]any) { delete(c, "amr") }, false},
+		{"unverified email", func(c map[string]any) { c["email_verified"] = false }, false},
+		{"missing email", func(c map[string]any) { delete(c, "email") }, false},
+		{"other domain", func(c map[string]any) { c["email"] = "jane@contoso.com.evil.io" }, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts.claims = map[string]any{}
+			for k, v := range valid {
+				ts.claims[k] = v
+			}
+			tc.mutate(ts.claims)
+
+			_, err := ts.login(t, p, params)
+			if tc.ok && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tc.ok && !errors.Is(err, ErrLoginPolicy) {
+				t.Fatalf("expected ErrLoginPolicy, got %v", err)
+			}
+		})
+	}
+}
+
+func TestOIDCProvider_DefaultRedirectURLUsesSlug(t *testing.T) {
+	ts := newTestOIDCServer(t)
+	defer ts.Close()
+
+	rec := idpRecord{ID: 3, TenantID: 7, ProviderType: "oidc", Slug: "okta", MetadataURL: ts.URL, ClientID: "client-id"}
+	p, err := newOIDCProvider(context.Background(), rec, "secret", &config.Config{BaseURL: "https://crm.example.com/"}, http.DefaultClient)
+	if err != nil {
+		t.Fatalf("failed to create provider: %v", err)
+	}
+
+	u, _ := url.Parse(p.AuthURL(AuthParams{State: "st", Nonce: "nc", CodeVerifier: "verifier-verifier-verifier-verifier-verifier"}))
+	if got := u.Query().Get("redirect_uri"); got != "https://crm.example.com/7/okta/callback" {
+		t.Errorf("unexpected redirect_uri %q", got)
+	}
+}
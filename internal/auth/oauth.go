@@ -0,0 +1,633 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+	"golang.org/x/oauth2"
+)
+
+// OAuth grant types
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantClientCredentials = "client_credentials"
+	GrantRefreshToken      = "refresh_token"
+)
+
+const (
+	authorizationCodeTTL = 5 * time.Minute
+
+	// RevokedByClient is the reason of sessions revoked through RFC 7009
+	RevokedByClient = "oauth_revocation"
+)
+
+// GrantTypes are the grants a client may be registered for
+var GrantTypes = []string{GrantAuthorizationCode, GrantClientCredentials, GrantRefreshToken}
+
+// OAuthError is an OAuth 2.0 error response (RFC 6749, section 5.2)
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+func oauthError(code, description string) *OAuthError {
+	return &OAuthError{Code: code, Description: description}
+}
+
+var (
+	ErrInvalidClient        = oauthError("invalid_client", "client authentication failed")
+	ErrInvalidGrant         = oauthError("invalid_grant", "the grant is invalid, expired or was issued to another client")
+	ErrUnauthorizedClient   = oauthError("unauthorized_client", "the client is not allowed to use this grant type")
+	ErrUnsupportedGrantType = oauthError("unsupported_grant_type", "")
+	ErrInvalidRedirectURI   = oauthError("invalid_request", "redirect_uri is not registered for this client")
+)
+
+// AuthorizeParams are the parameters of an authorization request
+type AuthorizeParams struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// AuthorizationRequest is a validated authorization request waiting for
+// the user to sign in. It is kept in a signed cookie across the SSO login.
+// RedirectURIGiven tells whether the client sent redirect_uri, in which
+// case the token request must repeat it (RFC 6749, 4.1.3).
+type AuthorizationRequest struct {
+	ClientID         string   `json:"cid"`
+	TenantID         int64    `json:"tid"`
+	RedirectURI      string   `json:"ru"`
+	RedirectURIGiven bool     `json:"rg,omitempty"`
+	Scopes           []string `json:"sc"`
+	State            string   `json:"st,omitempty"`
+	CodeChallenge    string   `json:"cc"`
+	ExpiresAt        int64    `json:"exp"`
+}
+
+// TokenRequest are the parameters of a token request
+type TokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	RefreshToken string
+	Scope        string
+}
+
+// TokenResponse is a successful token response (RFC 6749, section 5.1)
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// Introspection is a token introspection response (RFC 7662)
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	TenantID  int64  `json:"tenant_id,omitempty"`
+	UserID    int64  `json:"user_id,omitempty"`
+}
+
+// AuthorizationServer lets third-party clients registered per tenant get
+// delegated access: the authorization code grant with PKCE, where the
+// user signs in with the tenant SSO, and the client credentials grant.
+type AuthorizationServer struct {
+	repo     repo.OAuthRepository
+	sessions *Sessions
+	tokens   *TokenValidator
+	cfg      *config.Config
+	key      []byte
+	ttl      time.Duration
+	now      func() time.Time
+}
+
+func NewAuthorizationServer(cfg *config.Config, r repo.OAuthRepository, sessions *Sessions, tokens *TokenValidator) *AuthorizationServer {
+	ttl := cfg.LoginStateTTL
+	if ttl <= 0 {
+		ttl = defaultLoginStateTTL
+	}
+
+	return &AuthorizationServer{
+		repo:     r,
+		sessions: sessions,
+		tokens:   tokens,
+		cfg:      cfg,
+		key:      deriveKey(cfg.JWTSecret, "oauth-authorization-request"),
+		ttl:      ttl,
+		now:      time.Now,
+	}
+}
+
+// RequestTTL returns how long the user has to sign in before a pending
+// authorization request expires. It matches the login state TTL.
+func (s *AuthorizationServer) RequestTTL() time.Duration { return s.ttl }
+
+// RegisterClient validates and stores a client. Confidential clients get
+// a secret, returned once; only its hash is stored.
+func (s *AuthorizationServer) RegisterClient(ctx context.Context, rec *repo.OAuthClientRecord, confidential bool) (string, error) {
+	if len(rec.GrantTypes) == 0 {
+		rec.GrantTypes = []string{GrantAuthorizationCode, GrantRefreshToken}
+	}
+
+	for _, g := range rec.GrantTypes {
+		if !slices.Contains(GrantTypes, g) {
+			return "", oauthError("invalid_client_metadata", "unsupported grant type "+g)
+		}
+	}
+
+	if slices.Contains(rec.GrantTypes, GrantClientCredentials) && !confidential {
+		return "", oauthError("invalid_client_metadata", "client_credentials requires a confidential client")
+	}
+
+	if slices.Contains(rec.GrantTypes, GrantAuthorizationCode) && len(rec.RedirectURIs) == 0 {
+		return "", oauthError("invalid_redirect_uri", "authorization_code requires a redirect uri")
+	}
+
+	for _, u := range rec.RedirectURIs {
+		if !ValidRedirectURI(u) {
+			return "", oauthError("invalid_redirect_uri", "invalid redirect uri "+u)
+		}
+	}
+
+	for _, sc := range rec.Scopes {
+		if !IsPermission(sc) {
+			return "", oauthError("invalid_client_metadata", "unknown scope "+sc)
+		}
+	}
+
+	clientID, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	rec.ClientID = clientID
+
+	var secret string
+	if confidential {
+		if secret, err = randomToken(32); err != nil {
+			return "", err
+		}
+		rec.SecretHash = hashToken(secret)
+	}
+
+	id, err := s.repo.CreateClient(ctx, rec)
+	if err != nil {
+		return "", err
+	}
+
+	rec.ID = id
+	rec.CreatedAt = s.now()
+	rec.UpdatedAt = rec.CreatedAt
+	return secret, nil
+}
+
+// ValidRedirectURI accepts absolute https URIs without fragment, and
+// http on the loopback interface for native apps (RFC 8252).
+func ValidRedirectURI(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || u.Fragment != "" {
+		return false
+	}
+
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	default:
+		return false
+	}
+}
+
+// AuthenticateClient checks the client credentials. Public clients have
+// no secret and are identified by their client_id only.
+func (s *AuthorizationServer) AuthenticateClient(ctx context.Context, clientID, secret string) (*repo.OAuthClientRecord, error) {
+	if clientID == "" {
+		return nil, ErrInvalidClient
+	}
+
+	client, err := s.repo.GetClientByClientID(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	if client == nil {
+		return nil, ErrInvalidClient
+	}
+
+	if client.Confidential() {
+		if subtle.ConstantTimeCompare(hashToken(secret), client.SecretHash) != 1 {
+			return nil, ErrInvalidClient
+		}
+	} else if secret != "" {
+		return nil, ErrInvalidClient
+	}
+
+	return client, nil
+}
+
+// Authorize validates an authorization request. When the client or the
+// redirect uri can not be trusted it returns only an error, which must be
+// shown to the user; otherwise errors are sent back to the client with
+// ErrorRedirect, so the request is returned along with them.
+func (s *AuthorizationServer) Authorize(ctx context.Context, p AuthorizeParams) (*AuthorizationRequest, error) {
+	client, err := s.repo.GetClientByClientID(ctx, p.ClientID)
+	if err != nil {
+		return nil, err
+	}
+
+	if client == nil {
+		return nil, oauthError("invalid_request", "unknown client_id")
+	}
+
+	redirectURI := p.RedirectURI
+	if redirectURI == "" && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+
+	if !slices.Contains(client.RedirectURIs, redirectURI) {
+		return nil, ErrInvalidRedirectURI
+	}
+
+	req := &AuthorizationRequest{
+		ClientID:         client.ClientID,
+		TenantID:         client.TenantID,
+		RedirectURI:      redirectURI,
+		RedirectURIGiven: p.RedirectURI != "",
+		State:            p.State,
+		CodeChallenge:    p.CodeChallenge,
+		ExpiresAt:        s.now().Add(s.ttl).Unix(),
+	}
+
+	if p.ResponseType != "code" {
+		return req, oauthError("unsupported_response_type", "only the code response type is supported")
+	}
+
+	if !slices.Contains(client.GrantTypes, GrantAuthorizationCode) {
+		return req, ErrUnauthorizedClient
+	}
+
+	// PKCE is required from every client, with S256 only
+	if p.CodeChallenge == "" || p.CodeChallengeMethod != "S256" {
+		return req, oauthError("invalid_request", "code_challenge with code_challenge_method S256 is required")
+	}
+
+	scopes, err := grantScopes(client, p.Scope)
+	if err != nil {
+		return req, err
+	}
+	req.Scopes = scopes
+
+	return req, nil
+}
+
+// EncodeRequest signs the authorization request for the login cookie
+func (s *AuthorizationServer) EncodeRequest(req *AuthorizationRequest) (string, error) {
+	return seal(s.key, req)
+}
+
+// DecodeRequest verifies the login cookie. It returns nil when the value
+// is not a valid, unexpired authorization request.
+func (s *AuthorizationServer) DecodeRequest(value string) *AuthorizationRequest {
+	var req AuthorizationRequest
+	if value == "" || !unseal(s.key, value, &req) || s.now().Unix() >= req.ExpiresAt {
+		return nil
+	}
+	return &req
+}
+
+// IssueCode stores an authorization code for the signed in user and
+// returns the redirect back to the client.
+func (s *AuthorizationServer) IssueCode(ctx context.Context, req *AuthorizationRequest, userID int64) (string, error) {
+	code, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	// the code keeps the redirect uri only when the client sent it, since
+	// only then the token request has to match it
+	var redirectURI string
+	if req.RedirectURIGiven {
+		redirectURI = req.RedirectURI
+	}
+
+	_, err = s.repo.CreateCode(ctx, &repo.AuthorizationCodeRecord{
+		CodeHash:      hashToken(code),
+		ClientID:      req.ClientID,
+		TenantID:      req.TenantID,
+		UserID:        userID,
+		RedirectURI:   redirectURI,
+		Scopes:        req.Scopes,
+		CodeChallenge: req.CodeChallenge,
+		ExpiresAt:     s.now().Add(authorizationCodeTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return s.redirect(req, url.Values{"code": {code}}), nil
+}
+
+// ErrorRedirect returns the redirect that reports err to the client
+func (s *AuthorizationServer) ErrorRedirect(req *AuthorizationRequest, err error) string {
+	var oe *OAuthError
+	switch {
+	case errors.As(err, &oe):
+	case errors.Is(err, ErrUserInactive):
+		oe = oauthError("access_denied", err.Error())
+	default:
+		oe = oauthError("server_error", "")
+	}
+
+	q := url.Values{"error": {oe.Code}}
+	if oe.Description != "" {
+		q.Set("error_description", oe.Description)
+	}
+	return s.redirect(req, q)
+}
+
+// redirect adds the state and the issuer (RFC 9207) to the client redirect
+func (s *AuthorizationServer) redirect(req *AuthorizationRequest, q url.Values) string {
+	if req.State != "" {
+		q.Set("state", req.State)
+	}
+	q.Set("iss", s.cfg.Issuer())
+
+	u, _ := url.Parse(req.RedirectURI)
+	query := u.Query()
+	for k, v := range q {
+		query[k] = v
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// Token serves the token endpoint for an authenticated client
+func (s *AuthorizationServer) Token(ctx context.Context, client *repo.OAuthClientRecord, req TokenRequest) (*TokenResponse, error) {
+	if !slices.Contains(GrantTypes, req.GrantType) {
+		return nil, ErrUnsupportedGrantType
+	}
+
+	if !slices.Contains(client.GrantTypes, req.GrantType) {
+		return nil, ErrUnauthorizedClient
+	}
+
+	switch req.GrantType {
+	case GrantAuthorizationCode:
+		return s.exchangeCode(ctx, client, req)
+	case GrantRefreshToken:
+		return s.refresh(ctx, client, req)
+	default:
+		return s.clientCredentials(client, req)
+	}
+}
+
+func (s *AuthorizationServer) exchangeCode(ctx context.Context, client *repo.OAuthClientRecord, req TokenRequest) (*TokenResponse, error) {
+	if req.Code == "" || req.CodeVerifier == "" {
+		return nil, oauthError("invalid_request", "code and code_verifier are required")
+	}
+
+	rec, err := s.repo.GetCodeByHash(ctx, hashToken(req.Code))
+	if err != nil {
+		return nil, err
+	}
+
+	if rec == nil || rec.ClientID != client.ClientID {
+		return nil, ErrInvalidGrant
+	}
+
+	// a replayed code may have leaked: end the session it opened (RFC 6749, 4.1.2)
+	if rec.UsedAt != nil {
+		return nil, s.revokeReplayed(ctx, rec)
+	}
+
+	if !s.now().Before(rec.ExpiresAt) || (rec.RedirectURI != "" && rec.RedirectURI != req.RedirectURI) {
+		return nil, ErrInvalidGrant
+	}
+
+	challenge := oauth2.S256ChallengeFromVerifier(req.CodeVerifier)
+	if subtle.ConstantTimeCompare([]byte(challenge), []byte(rec.CodeChallenge)) != 1 {
+		return nil, oauthError("invalid_grant", "code_verifier does not match the code_challenge")
+	}
+
+	ok, err := s.repo.ConsumeCode(ctx, rec.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, s.revokeReplayed(ctx, rec)
+	}
+
+	pair, err := s.sessions.StartClient(ctx, rec.TenantID, rec.UserID, client.ClientID, rec.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.AttachSession(ctx, rec.ID, pair.SessionID); err != nil {
+		return nil, err
+	}
+
+	return newTokenResponse(pair, slices.Contains(client.GrantTypes, GrantRefreshToken)), nil
+}
+
+func (s *AuthorizationServer) revokeReplayed(ctx context.Context, rec *repo.AuthorizationCodeRecord) error {
+	if rec.SessionID != "" {
+		if err := s.sessions.Revoke(ctx, rec.SessionID, RevokedByReuse); err != nil {
+			return err
+		}
+	}
+	return ErrInvalidGrant
+}
+
+func (s *AuthorizationServer) refresh(ctx context.Context, client *repo.OAuthClientRecord, req TokenRequest) (*TokenResponse, error) {
+	// the scopes of a session are fixed when it is opened
+	if req.Scope != "" {
+		return nil, oauthError("invalid_scope", "the scope of a refresh token can not change")
+	}
+
+	pair, err := s.sessions.RefreshClient(ctx, req.RefreshToken, client.ClientID)
+	if err != nil {
+		return nil, grantError(err)
+	}
+
+	return newTokenResponse(pair, true), nil
+}
+
+func (s *AuthorizationServer) clientCredentials(client *repo.OAuthClientRecord, req TokenRequest) (*TokenResponse, error) {
+	scopes, err := grantScopes(client, req.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	pair, err := s.sessions.ClientToken(client.TenantID, client.ClientID, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	return newTokenResponse(pair, false), nil
+}
+
+// Introspect describes a token to a confidential client of the same tenant.
+// Tokens of other tenants, invalid and revoked tokens are all inactive.
+func (s *AuthorizationServer) Introspect(ctx context.Context, client *repo.OAuthClientRecord, token string) (*Introspection, error) {
+	inactive := &Introspection{Active: false}
+
+	if claims, err := s.tokens.Validate(token); err == nil {
+		if claims.TenantID != client.TenantID {
+			return inactive, nil
+		}
+
+		if claims.SessionID != "" {
+			revoked, err := s.sessions.IsRevoked(ctx, claims.SessionID)
+			if err != nil {
+				return nil, err
+			}
+			if revoked {
+				return inactive, nil
+			}
+		}
+
+		out := &Introspection{
+			Active:    true,
+			Scope:     claims.Scope.String(),
+			ClientID:  claims.ClientID,
+			Subject:   claims.Subject,
+			TokenType: "Bearer",
+			Issuer:    claims.Issuer,
+			TenantID:  claims.TenantID,
+			UserID:    claims.UserID,
+		}
+		if claims.ExpiresAt != nil {
+			out.ExpiresAt = claims.ExpiresAt.Unix()
+		}
+		if claims.IssuedAt != nil {
+			out.IssuedAt = claims.IssuedAt.Unix()
+		}
+		return out, nil
+	}
+
+	rec, sess, err := s.sessions.Inspect(ctx, token)
+	if errors.Is(err, ErrRefreshTokenInvalid) {
+		return inactive, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if sess.TenantID != client.TenantID || sess.RevokedAt != nil || rec.UsedAt != nil || !s.now().Before(rec.ExpiresAt) {
+		return inactive, nil
+	}
+
+	return &Introspection{
+		Active:    true,
+		Scope:     strings.Join(sess.Scopes, " "),
+		ClientID:  sess.ClientID,
+		Subject:   strconv.FormatInt(sess.UserID, 10),
+		TokenType: "refresh_token",
+		ExpiresAt: rec.ExpiresAt.Unix(),
+		IssuedAt:  rec.CreatedAt.Unix(),
+		Issuer:    s.cfg.Issuer(),
+		TenantID:  sess.TenantID,
+		UserID:    sess.UserID,
+	}, nil
+}
+
+// Revoke ends the session of an access or refresh token issued to the
+// client. Unknown tokens and tokens of other clients are ignored, as
+// RFC 7009 requires; access tokens without a session expire on their own.
+func (s *AuthorizationServer) Revoke(ctx context.Context, client *repo.OAuthClientRecord, token string) error {
+	if claims, err := s.tokens.Validate(token); err == nil {
+		if claims.ClientID != client.ClientID || claims.SessionID == "" {
+			return nil
+		}
+		return s.sessions.Revoke(ctx, claims.SessionID, RevokedByClient)
+	}
+
+	_, sess, err := s.sessions.Inspect(ctx, token)
+	if errors.Is(err, ErrRefreshTokenInvalid) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if sess.ClientID != client.ClientID {
+		return nil
+	}
+	return s.sessions.Revoke(ctx, sess.ID, RevokedByClient)
+}
+
+// grantScopes resolves the requested scopes, which default to all the
+// scopes of the client and can not exceed them.
+func grantScopes(client *repo.OAuthClientRecord, scope string) ([]string, error) {
+	requested := strings.Fields(scope)
+	if len(requested) == 0 {
+		return client.Scopes, nil
+	}
+
+	for _, sc := range requested {
+		if !slices.Contains(client.Scopes, sc) {
+			return nil, oauthError("invalid_scope", "scope "+sc+" is not allowed for this client")
+		}
+	}
+
+	return requested, nil
+}
+
+// grantError reports refresh token failures as invalid_grant
+func grantError(err error) error {
+	switch {
+	case errors.Is(err, ErrRefreshTokenInvalid),
+		errors.Is(err, ErrRefreshTokenExpired),
+		errors.Is(err, ErrRefreshTokenReused),
+		errors.Is(err, ErrSessionRevoked):
+		return ErrInvalidGrant
+	default:
+		return err
+	}
+}
+
+// newTokenResponse reports the scope actually granted, which may be
+// narrower than the requested one when the user lacks some of it
+func newTokenResponse(pair *TokenPair, withRefresh bool) *TokenResponse {
+	resp := &TokenResponse{
+		AccessToken: pair.AccessToken,
+		TokenType:   pair.TokenType,
+		ExpiresIn:   pair.ExpiresIn,
+		Scope:       pair.Scope.String(),
+	}
+
+	if withRefresh {
+		resp.RefreshToken = pair.RefreshToken
+	}
+	return resp
+}
@@ -0,0 +1,339 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+	"golang.org/x/oauth2"
+)
+
+// stubOAuthRepo keeps clients and authorization codes in memory
+type stubOAuthRepo struct {
+	clients []*repo.OAuthClientRecord
+	codes   []*repo.AuthorizationCodeRecord
+}
+
+func (s *stubOAuthRepo) ListClients(ctx context.Context, tenantID int64) ([]*repo.OAuthClientRecord, error) {
+	return nil, nil
+}
+
+func (s *stubOAuthRepo) GetClient(ctx context.Context, tenantID, id int64) (*repo.OAuthClientRecord, error) {
+	return nil, nil
+}
+
+func (s *stubOAuthRepo) GetClientByClientID(ctx context.Context, clientID string) (*repo.OAuthClientRecord, error) {
+	for _, c := range s.clients {
+		if c.ClientID == clientID {
+			cp := *c
+			return &cp, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *stubOAuthRepo) CreateClient(ctx context.Context, rec *repo.OAuthClientRecord) (int64, error) {
+	cp := *rec
+	cp.ID = int64(len(s.clients) + 1)
+	s.clients = append(s.clients, &cp)
+	return cp.ID, nil
+}
+
+func (s *stubOAuthRepo) DeleteClient(ctx context.Context, tenantID, id int64) error {
+	return nil
+}
+
+func (s *stubOAuthRepo) CreateCode(ctx context.Context, rec *repo.AuthorizationCodeRecord) (int64, error) {
+	cp := *rec
+	cp.ID = int64(len(s.codes) + 1)
+	s.codes = append(s.codes, &cp)
+	return cp.ID, nil
+}
+
+func (s *stubOAuthRepo) GetCodeByHash(ctx context.Context, hash []byte) (*repo.AuthorizationCodeRecord, error) {
+	for _, c := range s.codes {
+		if bytes.Equal(c.CodeHash, hash) {
+			cp := *c
+			return &cp, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *stubOAuthRepo) ConsumeCode(ctx context.Context, id int64) (bool, error) {
+	c := s.codes[id-1]
+	if c.UsedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	c.UsedAt = &now
+	return true, nil
+}
+
+func (s *stubOAuthRepo) AttachSession(ctx context.Context, id int64, sessionID string) error {
+	s.codes[id-1].SessionID = sessionID
+	return nil
+}
+
+func newTestAuthorizationServer(t *testing.T) (*AuthorizationServer, *Sessions, *stubSessionRepo) {
+	t.Helper()
+
+	s, sessions, now := newTestSessions(t)
+	srv := NewAuthorizationServer(s.cfg, &stubOAuthRepo{}, s, NewTokenValidator(s.cfg, s.keys))
+	srv.now = func() time.Time { return *now }
+	return srv, s, sessions
+}
+
+// authorize registers a public client and returns the code issued to user 1
+func authorize(t *testing.T, srv *AuthorizationServer, s *Sessions, verifier string) (*repo.OAuthClientRecord, string) {
+	t.Helper()
+	ctx := context.Background()
+
+	client := &repo.OAuthClientRecord{
+		TenantID:     7,
+		RedirectURIs: []string{"https://app.example.com/cb"},
+		Scopes:       []string{PermIDPRead, PermRoleRead},
+	}
+	if _, err := srv.RegisterClient(ctx, client, false); err != nil {
+		t.Fatalf("register client: %v", err)
+	}
+
+	userID, err := s.Provision(ctx, &AuthResult{TenantID: 7, UserID: "u1", Email: "u1@example.com", Roles: []string{RoleAdmin}})
+	if err != nil {
+		t.Fatalf("provision: %v", err)
+	}
+
+	req, err := srv.Authorize(ctx, AuthorizeParams{
+		ResponseType:        "code",
+		ClientID:            client.ClientID,
+		Scope:               PermIDPRead,
+		State:               "xyz",
+		CodeChallenge:       oauth2.S256ChallengeFromVerifier(verifier),
+		CodeChallengeMethod: "S256",
+	})
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+
+	location, err := srv.IssueCode(ctx, req, userID)
+	if err != nil {
+		t.Fatalf("issue code: %v", err)
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Query().Get("state") != "xyz" || u.Host != "app.example.com" {
+		t.Fatalf("unexpected redirect %s", location)
+	}
+
+	return client, u.Query().Get("code")
+}
+
+func TestAuthorizationServer_CodeFlowRequiresVerifier(t *testing.T) {
+	srv, s, sessions := newTestAuthorizationServer(t)
+	ctx := context.Background()
+	verifier := oauth2.GenerateVerifier()
+
+	client, code := authorize(t, srv, s, verifier)
+	req := TokenRequest{
+		GrantType:    GrantAuthorizationCode,
+		Code:         code,
+		RedirectURI:  "https://app.example.com/cb",
+		CodeVerifier: oauth2.GenerateVerifier(),
+	}
+
+	var oe *OAuthError
+	if _, err := srv.Token(ctx, client, req); !errors.As(err, &oe) || oe.Code != "invalid_grant" {
+		t.Fatalf("expected invalid_grant for a wrong verifier, got %v", err)
+	}
+
+	req.CodeVerifier = verifier
+	resp, err := srv.Token(ctx, client, req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if resp.AccessToken == "" || resp.RefreshToken == "" || resp.Scope != PermIDPRead {
+		t.Errorf("unexpected token response %+v", resp)
+	}
+
+	// the session is delegated to the client with the granted scopes only
+	if len(sessions.sessions) != 1 {
+		t.Fatalf("expected one session, got %d", len(sessions.sessions))
+	}
+	for _, sess := range sessions.sessions {
+		if sess.ClientID != client.ClientID || !slices.Equal(sess.Scopes, []string{PermIDPRead}) {
+			t.Errorf("unexpected session %+v", sess)
+		}
+	}
+}
+
+func TestAuthorizationServer_CodeReplayRevokesSession(t *testing.T) {
+	srv, s, sessions := newTestAuthorizationServer(t)
+	ctx := context.Background()
+	verifier := oauth2.GenerateVerifier()
+
+	client, code := authorize(t, srv, s, verifier)
+	req := TokenRequest{
+		GrantType:    GrantAuthorizationCode,
+		Code:         code,
+		RedirectURI:  "https://app.example.com/cb",
+		CodeVerifier: verifier,
+	}
+
+	if _, err := srv.Token(ctx, client, req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := srv.Token(ctx, client, req); !errors.Is(err, ErrInvalidGrant) {
+		t.Fatalf("expected ErrInvalidGrant on replay, got %v", err)
+	}
+
+	for _, sess := range sessions.sessions {
+		if sess.RevokedAt == nil || sess.RevokedReason != RevokedByReuse {
+			t.Errorf("expected the session to be revoked, got %+v", sess)
+		}
+	}
+}
+
+func TestAuthorizationServer_RedirectURIMatchedOnlyWhenSent(t *testing.T) {
+	srv, s, _ := newTestAuthorizationServer(t)
+	ctx := context.Background()
+	verifier := oauth2.GenerateVerifier()
+
+	// the client omitted redirect_uri, so the token request may omit it too
+	client, code := authorize(t, srv, s, verifier)
+	req := TokenRequest{GrantType: GrantAuthorizationCode, Code: code, CodeVerifier: verifier}
+	if _, err := srv.Token(ctx, client, req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	authz, err := srv.Authorize(ctx, AuthorizeParams{
+		ResponseType:        "code",
+		ClientID:            client.ClientID,
+		RedirectURI:         "https://app.example.com/cb",
+		Scope:               PermIDPRead,
+		CodeChallenge:       oauth2.S256ChallengeFromVerifier(verifier),
+		CodeChallengeMethod: "S256",
+	})
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+
+	location, err := srv.IssueCode(ctx, authz, 1)
+	if err != nil {
+		t.Fatalf("issue code: %v", err)
+	}
+	u, _ := url.Parse(location)
+
+	// once sent, it must be repeated
+	req = TokenRequest{GrantType: GrantAuthorizationCode, Code: u.Query().Get("code"), CodeVerifier: verifier}
+	if _, err := srv.Token(ctx, client, req); !errors.Is(err, ErrInvalidGrant) {
+		t.Fatalf("expected ErrInvalidGrant without redirect_uri, got %v", err)
+	}
+}
+
+func TestAuthorizationServer_AuthorizeValidation(t *testing.T) {
+	srv, _, _ := newTestAuthorizationServer(t)
+	ctx := context.Background()
+
+	client := &repo.OAuthClientRecord{
+		TenantID:     7,
+		RedirectURIs: []string{"https://app.example.com/cb"},
+		Scopes:       []string{PermIDPRead},
+	}
+	if _, err := srv.RegisterClient(ctx, client, false); err != nil {
+		t.Fatalf("register client: %v", err)
+	}
+
+	valid := AuthorizeParams{
+		ResponseType:        "code",
+		ClientID:            client.ClientID,
+		RedirectURI:         "https://app.example.com/cb",
+		CodeChallenge:       oauth2.S256ChallengeFromVerifier(oauth2.GenerateVerifier()),
+		CodeChallengeMethod: "S256",
+	}
+
+	cases := []struct {
+		name       string
+		mutate     func(p *AuthorizeParams)
+		redirected bool
+		code       string
+	}{
+		{"unknown client", func(p *AuthorizeParams) { p.ClientID = "nope" }, false, "invalid_request"},
+		{"unregistered redirect", func(p *AuthorizeParams) { p.RedirectURI = "https://evil.example.com/cb" }, false, "invalid_request"},
+		{"token response type", func(p *AuthorizeParams) { p.ResponseType = "token" }, true, "unsupported_response_type"},
+		{"missing pkce", func(p *AuthorizeParams) { p.CodeChallenge = "" }, true, "invalid_request"},
+		{"plain pkce", func(p *AuthorizeParams) { p.CodeChallengeMethod = "plain" }, true, "invalid_request"},
+		{"scope beyond client", func(p *AuthorizeParams) { p.Scope = PermIDPWrite }, true, "invalid_scope"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := valid
+			tc.mutate(&p)
+
+			req, err := srv.Authorize(ctx, p)
+			var oe *OAuthError
+			if !errors.As(err, &oe) || oe.Code != tc.code {
+				t.Fatalf("expected %s, got %v", tc.code, err)
+			}
+
+			if (req != nil) != tc.redirected {
+				t.Errorf("redirected = %v, want %v", req != nil, tc.redirected)
+			}
+		})
+	}
+}
+
+func TestAuthorizationServer_RegisterClient(t *testing.T) {
+	srv, _, _ := newTestAuthorizationServer(t)
+	ctx := context.Background()
+
+	public := &repo.OAuthClientRecord{TenantID: 7, GrantTypes: []string{GrantClientCredentials}, Scopes: []string{PermIDPRead}}
+	if _, err := srv.RegisterClient(ctx, public, false); err == nil {
+		t.Error("expected client_credentials to require a confidential client")
+	}
+
+	insecure := &repo.OAuthClientRecord{TenantID: 7, RedirectURIs: []string{"http://app.example.com/cb"}, Scopes: []string{PermIDPRead}}
+	if _, err := srv.RegisterClient(ctx, insecure, false); err == nil {
+		t.Error("expected http redirect uris outside loopback to be rejected")
+	}
+
+	unknown := &repo.OAuthClientRecord{TenantID: 7, GrantTypes: []string{GrantClientCredentials}, Scopes: []string{"crm:everything"}}
+	if _, err := srv.RegisterClient(ctx, unknown, true); err == nil {
+		t.Error("expected unknown scopes to be rejected")
+	}
+
+	service := &repo.OAuthClientRecord{TenantID: 7, GrantTypes: []string{GrantClientCredentials}, Scopes: []string{PermIDPRead}}
+	secret, err := srv.RegisterClient(ctx, service, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := srv.AuthenticateClient(ctx, service.ClientID, "wrong"); !errors.Is(err, ErrInvalidClient) {
+		t.Errorf("expected ErrInvalidClient for a wrong secret, got %v", err)
+	}
+
+	client, err := srv.AuthenticateClient(ctx, service.ClientID, secret)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	resp, err := srv.Token(ctx, client, TokenRequest{GrantType: GrantClientCredentials})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if resp.RefreshToken != "" || resp.Scope != PermIDPRead {
+		t.Errorf("client credentials get a scoped access token only, got %+v", resp)
+	}
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+)
+
+// ErrLoginPolicy is wrapped by the errors of logins that do not meet the
+// policy of their identity provider
+var ErrLoginPolicy = errors.New("login does not meet the identity provider policy")
+
+// LoginPolicy holds what a login through an identity provider must meet on
+// top of a valid ID token. The zero value requires nothing.
+type LoginPolicy = repo.LoginPolicy
+
+// checkPolicy enforces the policy on the claims of an ID token and the
+// email mapped from them
+func checkPolicy(p LoginPolicy, claims map[string]any, email string) error {
+	if len(p.ACRValues) > 0 {
+		acr := claimString(claims, "acr")
+		if acr == "" {
+			return policyError("the id_token has no acr claim, one of %s is required", quoteList(p.ACRValues))
+		}
+
+		if !slices.Contains(p.ACRValues, acr) {
+			return policyError("authentication context %q is not one of %s", acr, quoteList(p.ACRValues))
+		}
+	}
+
+	if len(p.AMRValues) > 0 {
+		amr := claimStrings(claims, "amr")
+		if len(amr) == 0 {
+			return policyError("the id_token has no amr claim, one of %s is required", quoteList(p.AMRValues))
+		}
+
+		if !slices.ContainsFunc(amr, func(m string) bool { return slices.Contains(p.AMRValues, m) }) {
+			return policyError("authentication methods %s do not include any of %s", quoteList(amr), quoteList(p.AMRValues))
+		}
+	}
+
+	if p.RequireEmailVerified || len(p.AllowedEmailDomains) > 0 {
+		if email == "" {
+			return policyError("the identity provider did not return an email")
+		}
+	}
+
+	// some IdPs (e.g. Cognito) send email_verified as a string
+	if p.RequireEmailVerified && claimString(claims, "email_verified") != "true" {
+		return policyError("email %q was not verified by the identity provider", email)
+	}
+
+	if len(p.AllowedEmailDomains) > 0 {
+		domain, err := NormalizeDomain(email)
+		if err != nil || !slices.Contains(p.AllowedEmailDomains, domain) {
+			return policyError("email domain of %q is not one of %s", email, quoteList(p.AllowedEmailDomains))
+		}
+	}
+
+	return nil
+}
+
+func policyError(format string, args ...any) error {
+	return fmt.Errorf("%w: "+format, append([]any{ErrLoginPolicy}, args...)...)
+}
+
+// quoteList formats values for the policy errors
+func quoteList(values []string) string {
+	return `"` + strings.Join(values, `", "`) + `"`
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"slices"
+	"sort"
+)
+
+// Tenant roles
+const (
+	RoleOwner    = "owner"
+	RoleAdmin    = "admin"
+	RoleMember   = "member"
+	RoleReadOnly = "read-only"
+)
+
+// Permissions checked by the API
+const (
+	PermIDPRead   = "idp:read"
+	PermIDPWrite  = "idp:write"
+	PermRoleRead  = "roles:read"
+	PermRoleWrite = "roles:write"
+
+	PermDomainRead  = "domains:read"
+	PermDomainWrite = "domains:write"
+
+	PermAPIKeyRead  = "apikeys:read"
+	PermAPIKeyWrite = "apikeys:write"
+
+	PermOAuthClientRead  = "oauth_clients:read"
+	PermOAuthClientWrite = "oauth_clients:write"
+
+	PermSCIMTokenRead  = "scim_tokens:read"
+	PermSCIMTokenWrite = "scim_tokens:write"
+
+	PermAuditRead = "audit:read"
+
+	// PermImpersonate is granted by no role, only super admins hold it
+	PermImpersonate = "users:impersonate"
+)
+
+// permissions is the catalogue of permissions, and so of the scopes of
+// tokens, API keys and OAuth clients
+var permissions = []string{
+	ScopeProfile,
+	PermIDPRead, PermIDPWrite,
+	PermRoleRead, PermRoleWrite,
+	PermDomainRead, PermDomainWrite,
+	PermAPIKeyRead, PermAPIKeyWrite,
+	PermOAuthClientRead, PermOAuthClientWrite,
+	PermSCIMTokenRead, PermSCIMTokenWrite,
+	PermAuditRead,
+	PermImpersonate,
+}
+
+// rolePermissions is the permission set granted by each role
+var rolePermissions = map[string][]string{
+	RoleOwner:    {PermIDPRead, PermIDPWrite, PermRoleRead, PermRoleWrite, PermDomainRead, PermDomainWrite, PermAPIKeyRead, PermAPIKeyWrite, PermOAuthClientRead, PermOAuthClientWrite, PermSCIMTokenRead, PermSCIMTokenWrite, PermAuditRead},
+	RoleAdmin:    {PermIDPRead, PermIDPWrite, PermRoleRead, PermDomainRead, PermDomainWrite, PermAPIKeyRead, PermAPIKeyWrite, PermOAuthClientRead, PermOAuthClientWrite, PermSCIMTokenRead, PermSCIMTokenWrite, PermAuditRead},
+	RoleMember:   {},
+	RoleReadOnly: {PermIDPRead, PermRoleRead, PermDomainRead, PermAPIKeyRead, PermOAuthClientRead, PermSCIMTokenRead},
+}
+
+// IsPermission reports whether name is a known permission
+func IsPermission(name string) bool {
+	return slices.Contains(permissions, name)
+}
+
+// Permissions returns the permission catalogue
+func Permissions() []string {
+	return slices.Clone(permissions)
+}
+
+// IsRole reports whether name is a known role
+func IsRole(name string) bool {
+	_, ok := rolePermissions[name]
+	return ok
+}
+
+// HasPermission reports whether any of the roles grants the permission
+func HasPermission(roles []string, perm string) bool {
+	for _, r := range roles {
+		if slices.Contains(rolePermissions[r], perm) {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports whether the claims grant the permission, that is whether
+// their scope includes it. Tokens issued before scopes were written into
+// them are granted the scope of their roles.
+func (c *Claims) Can(perm string) bool {
+	if c.Scope == nil && !c.IsAPIKey() && c.ClientID == "" {
+		return GrantedScope(c.Roles, c.SuperAdmin).Has(perm)
+	}
+
+	return c.Scope.Has(perm)
+}
+
+// mapRoles translates IdP groups into roles, ignoring unmapped groups
+// and mappings to unknown roles.
+func mapRoles(mapping map[string]string, groups []string) []string {
+	var roles []string
+	for _, g := range groups {
+		role, ok := mapping[g]
+		if !ok || !IsRole(role) || slices.Contains(roles, role) {
+			continue
+		}
+		roles = append(roles, role)
+	}
+
+	sort.Strings(roles)
+	return roles
+}
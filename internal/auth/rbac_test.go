@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMapRoles(t *testing.T) {
+	mapping := map[string]string{
+		"crm-admins":  RoleAdmin,
+		"crm-owners":  RoleOwner,
+		"crm-admins2": RoleAdmin,
+		"legacy":      "superuser",
+	}
+
+	got := mapRoles(mapping, []string{"crm-admins", "crm-admins2", "crm-owners", "legacy", "unmapped"})
+	if !slices.Equal(got, []string{RoleAdmin, RoleOwner}) {
+		t.Errorf("unexpected roles %v", got)
+	}
+
+	if got := mapRoles(nil, []string{"crm-admins"}); len(got) != 0 {
+		t.Errorf("expected no roles without mapping, got %v", got)
+	}
+}
+
+func TestHasPermission(t *testing.T) {
+	cases := []struct {
+		roles []string
+		perm  string
+		want  bool
+	}{
+		{[]string{RoleOwner}, PermRoleWrite, true},
+		{[]string{RoleAdmin}, PermIDPWrite, true},
+		{[]string{RoleAdmin}, PermRoleWrite, false},
+		{[]string{RoleReadOnly}, PermIDPRead, true},
+		{[]string{RoleReadOnly}, PermIDPWrite, false},
+		{[]string{RoleReadOnly}, PermDomainRead, true},
+		{[]string{RoleReadOnly}, PermDomainWrite, false},
+		{[]string{RoleAdmin}, PermAPIKeyWrite, true},
+		{[]string{RoleReadOnly}, PermAPIKeyRead, true},
+		{[]string{RoleReadOnly}, PermAPIKeyWrite, false},
+		{[]string{RoleAdmin}, PermOAuthClientWrite, true},
+		{[]string{RoleReadOnly}, PermOAuthClientRead, true},
+		{[]string{RoleReadOnly}, PermOAuthClientWrite, false},
+		{[]string{RoleAdmin}, PermAuditRead, true},
+		{[]string{RoleReadOnly}, PermAuditRead, false},
+		{[]string{RoleAdmin}, PermSCIMTokenWrite, true},
+		{[]string{RoleReadOnly}, PermSCIMTokenRead, true},
+		{[]string{RoleReadOnly}, PermSCIMTokenWrite, false},
+		{[]string{RoleMember}, PermIDPRead, false},
+		{[]string{RoleMember, RoleAdmin}, PermIDPWrite, true},
+		{nil, PermIDPRead, false},
+		{[]string{"unknown"}, PermIDPRead, false},
+	}
+
+	for _, tc := range cases {
+		if got := HasPermission(tc.roles, tc.perm); got != tc.want {
+			t.Errorf("HasPermission(%v, %q) = %v, want %v", tc.roles, tc.perm, got, tc.want)
+		}
+	}
+}
+
+func TestGrantedScope(t *testing.T) {
+	got := GrantedScope([]string{RoleReadOnly}, false)
+	want := Scope{ScopeProfile, PermIDPRead, PermRoleRead, PermDomainRead, PermAPIKeyRead, PermOAuthClientRead, PermSCIMTokenRead}
+	if !slices.Equal(got, want) {
+		t.Errorf("unexpected scope %v", got)
+	}
+
+	if got := GrantedScope(nil, false); !slices.Equal(got, Scope{ScopeProfile}) {
+		t.Errorf("a user without roles only gets the profile, got %v", got)
+	}
+
+	if got := GrantedScope(nil, true); !slices.Equal(got, Scope(Permissions())) {
+		t.Errorf("super admins get the whole catalogue, got %v", got)
+	}
+}
+
+func TestClaimsCan(t *testing.T) {
+	cases := []struct {
+		name   string
+		claims Claims
+		perm   string
+		want   bool
+	}{
+		{"scope grants", Claims{UserID: 1, Scope: Scope{PermIDPRead}}, PermIDPRead, true},
+		{"scope wins over roles", Claims{UserID: 1, Roles: []string{RoleOwner}, Scope: Scope{PermIDPRead}}, PermIDPWrite, false},
+		{"token without scope uses roles", Claims{UserID: 1, Roles: []string{RoleAdmin}}, PermIDPWrite, true},
+		{"token without scope reads profile", Claims{UserID: 1}, ScopeProfile, true},
+		{"api key without scope", Claims{APIKeyID: 3}, PermIDPRead, false},
+		{"client without scope", Claims{ClientID: "c1", SuperAdmin: true}, PermIDPRead, false},
+		{"client scope", Claims{ClientID: "c1", Scope: Scope{PermDomainRead}}, PermDomainRead, true},
+	}
+
+	for _, tc := range cases {
+		if got := tc.claims.Can(tc.perm); got != tc.want {
+			t.Errorf("%s: Can(%q) = %v, want %v", tc.name, tc.perm, got, tc.want)
+		}
+	}
+}
+
+func TestScope_JSONIsSpaceDelimited(t *testing.T) {
+	b, err := json.Marshal(&Claims{Scope: Scope{ScopeProfile, PermIDPRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(b), `"scope":"profile idp:read"`) {
+		t.Errorf("unexpected claims %s", b)
+	}
+
+	var c Claims
+	if err := json.Unmarshal(b, &c); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(c.Scope, Scope{ScopeProfile, PermIDPRead}) {
+		t.Errorf("unexpected scope %v", c.Scope)
+	}
+}
@@ -0,0 +1,518 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+	"github.com/jeanmolossi/verbose-adventure/internal/encryption"
+)
+
+const (
+	defaultPollInterval = 30 * time.Second
+	retryCheckInterval  = time.Second
+	retryBaseDelay      = 5 * time.Second
+	retryMaxDelay       = 5 * time.Minute
+
+	// buildTimeout bounds the build of one provider, like diagnosticTimeout
+	// bounds a diagnostic: an IdP that never answers must not hold the
+	// tenant logins nor the background work
+	buildTimeout = 10 * time.Second
+)
+
+var (
+	ErrProviderNotFound    = errors.New("identity provider not found")
+	ErrProviderUnavailable = errors.New("identity provider unavailable")
+)
+
+// ProviderRegistry resolves identity providers on demand.
+type ProviderRegistry interface {
+	// Get returns the enabled provider of a tenant by its slug
+	Get(ctx context.Context, tenantID int64, slug string) (IdentityProvider, error)
+	// Invalidate drops the cached providers of a tenant
+	Invalidate(tenantID int64)
+	// Health returns the initialization status of the tenant providers
+	Health(tenantID int64) []ProviderHealth
+}
+
+// HealthReporter summarizes provider health for /healthz.
+type HealthReporter interface {
+	Unhealthy() []ProviderHealth
+}
+
+// ProviderHealth is the initialization status of one identity provider.
+type ProviderHealth struct {
+	ID                  int64      `json:"id"`
+	TenantID            int64      `json:"tenant_id"`
+	Type                string     `json:"type"`
+	Slug                string     `json:"slug"`
+	Healthy             bool       `json:"healthy"`
+	LastError           string     `json:"last_error,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastAttempt         time.Time  `json:"last_attempt"`
+	NextRetry           *time.Time `json:"next_retry,omitempty"`
+}
+
+// providerEntry holds one provider and its health, guarded by Registry.mu.
+// provider is nil while the IdP is unhealthy.
+type providerEntry struct {
+	rec      idpRecord
+	provider IdentityProvider
+	health   ProviderHealth
+	retrying bool
+}
+
+// tenantEntry holds the providers of one tenant, keyed by slug. done is closed once
+// loading finished; providers and err must only be read after that.
+type tenantEntry struct {
+	done      chan struct{}
+	providers map[string]*providerEntry
+	err       error
+}
+
+// Registry lazily builds providers per tenant and keeps them cached until
+// the tenant is invalidated, either locally by the admin API or by another
+// replica, detected through polling identity_providers versions.
+//
+// A provider that fails to build does not affect the others: it is kept
+// as unhealthy and rebuilt in the background with exponential backoff.
+type Registry struct {
+	db      *sql.DB
+	cfg     *config.Config
+	secrets *encryption.Keyring
+	replay  ReplayStore
+	client  *http.Client
+	timeout time.Duration
+	now     func() time.Time
+
+	query func(ctx context.Context, tenantID int64) ([]idpRecord, error)
+	build func(ctx context.Context, rec idpRecord) (IdentityProvider, error)
+
+	mu       sync.Mutex
+	tenants  map[int64]*tenantEntry
+	versions map[int64]string
+
+	// ctx is canceled by Stop and bounds every background load
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+var (
+	_ ProviderRegistry = (*Registry)(nil)
+	_ HealthReporter   = (*Registry)(nil)
+)
+
+func NewRegistry(cfg *config.Config, db *sql.DB, secrets *encryption.Keyring, replay ReplayStore) *Registry {
+	r := &Registry{
+		db:      db,
+		cfg:     cfg,
+		secrets: secrets,
+		replay:  replay,
+		client:  &http.Client{Timeout: buildTimeout},
+		timeout: buildTimeout,
+		now:     time.Now,
+		tenants: make(map[int64]*tenantEntry),
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+
+	r.query = func(ctx context.Context, tenantID int64) ([]idpRecord, error) {
+		return queryTenantRecords(ctx, r.db, tenantID)
+	}
+	r.build = func(ctx context.Context, rec idpRecord) (IdentityProvider, error) {
+		return buildProvider(ctx, rec, r.cfg, r.secrets, r.client, r.replay)
+	}
+
+	return r
+}
+
+func (r *Registry) Get(ctx context.Context, tenantID int64, slug string) (IdentityProvider, error) {
+	e, err := r.tenant(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pe, ok := e.providers[slug]
+	if !ok {
+		return nil, ErrProviderNotFound
+	}
+
+	if pe.provider == nil {
+		return nil, fmt.Errorf("%w: %s", ErrProviderUnavailable, pe.health.LastError)
+	}
+
+	return pe.provider, nil
+}
+
+func (r *Registry) Invalidate(tenantID int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.tenants, tenantID)
+	if r.ctx.Err() != nil {
+		return
+	}
+
+	// rebuild right away so health reflects the new configuration
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		_, _ = r.tenant(r.ctx, tenantID)
+	}()
+}
+
+func (r *Registry) Health(tenantID int64) []ProviderHealth {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.tenants[tenantID]
+	if !ok || !isDone(e) {
+		return nil
+	}
+
+	out := make([]ProviderHealth, 0, len(e.providers))
+	for _, pe := range e.providers {
+		out = append(out, pe.health)
+	}
+
+	sortHealth(out)
+	return out
+}
+
+func (r *Registry) Unhealthy() []ProviderHealth {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var out []ProviderHealth
+	for _, e := range r.tenants {
+		if !isDone(e) {
+			continue
+		}
+
+		for _, pe := range e.providers {
+			if !pe.health.Healthy {
+				out = append(out, pe.health)
+			}
+		}
+	}
+
+	sortHealth(out)
+	return out
+}
+
+// tenant returns the loaded entry of a tenant, loading it if needed
+func (r *Registry) tenant(ctx context.Context, tenantID int64) (*tenantEntry, error) {
+	r.mu.Lock()
+	e, ok := r.tenants[tenantID]
+	if !ok {
+		e = &tenantEntry{done: make(chan struct{})}
+		r.tenants[tenantID] = e
+	}
+	r.mu.Unlock()
+
+	if !ok {
+		// discovery must not be tied to the request that triggered it
+		e.providers, e.err = r.load(context.WithoutCancel(ctx), tenantID)
+		close(e.done)
+
+		if e.err != nil {
+			r.drop(tenantID, e)
+		}
+	}
+
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if e.err != nil {
+		return nil, fmt.Errorf("load identity providers: %w", e.err)
+	}
+
+	return e, nil
+}
+
+func (r *Registry) load(ctx context.Context, tenantID int64) (map[string]*providerEntry, error) {
+	records, err := r.query(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	providers := make(map[string]*providerEntry, len(records))
+	entries := make([]*providerEntry, 0, len(records))
+	for _, rec := range records {
+		pe := &providerEntry{
+			rec: rec,
+			health: ProviderHealth{
+				ID:       rec.ID,
+				TenantID: rec.TenantID,
+				Type:     rec.ProviderType,
+				Slug:     rec.Slug,
+			},
+		}
+
+		providers[rec.Slug] = pe
+		entries = append(entries, pe)
+	}
+
+	r.attemptAll(ctx, entries)
+	return providers, nil
+}
+
+// attemptAll builds the providers concurrently, so a slow IdP does not
+// delay the others
+func (r *Registry) attemptAll(ctx context.Context, entries []*providerEntry) {
+	var wg sync.WaitGroup
+	for _, pe := range entries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.attempt(ctx, pe)
+		}()
+	}
+	wg.Wait()
+}
+
+// attempt builds the provider and records the outcome
+func (r *Registry) attempt(ctx context.Context, pe *providerEntry) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	p, err := r.build(ctx, pe.rec)
+	now := r.now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pe.retrying = false
+	pe.health.LastAttempt = now
+
+	if err != nil {
+		pe.health.Healthy = false
+		pe.health.LastError = err.Error()
+		pe.health.ConsecutiveFailures++
+
+		next := now.Add(backoff(pe.health.ConsecutiveFailures))
+		pe.health.NextRetry = &next
+		return
+	}
+
+	pe.provider = p
+	pe.health.Healthy = true
+	pe.health.LastError = ""
+	pe.health.ConsecutiveFailures = 0
+	pe.health.NextRetry = nil
+}
+
+// retryDue rebuilds every unhealthy provider whose backoff has elapsed
+func (r *Registry) retryDue(ctx context.Context) {
+	now := r.now()
+
+	r.mu.Lock()
+	var due []*providerEntry
+	for _, e := range r.tenants {
+		if !isDone(e) {
+			continue
+		}
+
+		for _, pe := range e.providers {
+			if pe.health.Healthy || pe.retrying || pe.health.NextRetry == nil || now.Before(*pe.health.NextRetry) {
+				continue
+			}
+
+			pe.retrying = true
+			due = append(due, pe)
+		}
+	}
+	r.mu.Unlock()
+
+	r.attemptAll(ctx, due)
+}
+
+// drop removes e only if it is still the current entry of the tenant
+func (r *Registry) drop(tenantID int64, e *tenantEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.tenants[tenantID] == e {
+		delete(r.tenants, tenantID)
+	}
+}
+
+// Start warms up every tenant with enabled providers in the background,
+// while it keeps retrying unhealthy providers and polling for changes made
+// by other replicas. It never blocks nor fails application startup.
+func (r *Registry) Start() {
+	interval := r.cfg.IDPPollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+
+	ctx := r.ctx
+	r.wg.Add(2)
+
+	// warming up runs on its own so it never delays polling nor retries
+	go func() {
+		defer r.wg.Done()
+		r.warm(ctx)
+	}()
+
+	go func() {
+		defer r.wg.Done()
+
+		poll := time.NewTicker(interval)
+		defer poll.Stop()
+
+		retry := time.NewTicker(retryCheckInterval)
+		defer retry.Stop()
+
+		for {
+			select {
+			case <-poll.C:
+				// a failed poll keeps the previous versions and retries later
+				_ = r.Poll(ctx)
+			case <-retry.C:
+				r.retryDue(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the background work and waits for pending rebuilds.
+func (r *Registry) Stop() {
+	// under mu so Invalidate never adds to wg while it is being waited on
+	r.mu.Lock()
+	r.cancel()
+	r.mu.Unlock()
+
+	r.wg.Wait()
+}
+
+// warm loads every tenant that has enabled providers
+func (r *Registry) warm(ctx context.Context) {
+	_ = r.Poll(ctx)
+
+	rows, err := r.db.QueryContext(ctx, `SELECT DISTINCT tenant_id FROM identity_providers WHERE enabled = ?`, 1)
+	if err != nil {
+		return
+	}
+
+	var tenants []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			break
+		}
+		tenants = append(tenants, id)
+	}
+	rows.Close()
+
+	for _, id := range tenants {
+		if ctx.Err() != nil {
+			return
+		}
+
+		_, _ = r.tenant(ctx, id)
+	}
+}
+
+// Poll compares the per-tenant version of identity_providers with the
+// previous poll and reloads every tenant that changed.
+func (r *Registry) Poll(ctx context.Context) error {
+	query := `
+    SELECT tenant_id, COUNT(*), COALESCE(SUM(id), 0), COALESCE(SUM(revision), 0)
+    FROM identity_providers
+    GROUP BY tenant_id
+    `
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	current := make(map[int64]string)
+	for rows.Next() {
+		var (
+			tenantID    int64
+			count       int64
+			sumID       int64
+			sumRevision int64
+		)
+
+		if err := rows.Scan(&tenantID, &count, &sumID, &sumRevision); err != nil {
+			return err
+		}
+
+		// revision is bumped on every update, so any write changes the sum
+		current[tenantID] = fmt.Sprintf("%d:%d:%d", count, sumID, sumRevision)
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	previous := r.versions
+	r.versions = current
+	r.mu.Unlock()
+
+	if previous == nil {
+		return nil
+	}
+
+	for tenantID, v := range current {
+		if previous[tenantID] != v {
+			r.Invalidate(tenantID)
+		}
+	}
+
+	r.mu.Lock()
+	for tenantID := range previous {
+		if _, ok := current[tenantID]; !ok {
+			delete(r.tenants, tenantID)
+		}
+	}
+	r.mu.Unlock()
+
+	return nil
+}
+
+func backoff(failures int) time.Duration {
+	d := retryBaseDelay
+	for i := 1; i < failures && d < retryMaxDelay; i++ {
+		d *= 2
+	}
+
+	return min(d, retryMaxDelay)
+}
+
+func isDone(e *tenantEntry) bool {
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func sortHealth(h []ProviderHealth) {
+	sort.Slice(h, func(i, j int) bool {
+		if h[i].TenantID != h[j].TenantID {
+			return h[i].TenantID < h[j].TenantID
+		}
+		return h[i].ID < h[j].ID
+	})
+}
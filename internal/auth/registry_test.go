@@ -0,0 +1,298 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+)
+
+type stubProvider struct {
+	tenant int64
+	typ    string
+	slug   string
+}
+
+func (s *stubProvider) TenantID() int64                  { return s.tenant }
+func (s *stubProvider) Type() string                     { return s.typ }
+func (s *stubProvider) Slug() string                     { return s.slug }
+func (s *stubProvider) AuthURL(params AuthParams) string { return "" }
+func (s *stubProvider) Callback(ctx context.Context, req *http.Request, params AuthParams) (*AuthResult, error) {
+	return nil, nil
+}
+
+func newStubRegistry(t *testing.T) (*Registry, sqlmock.Sqlmock, *int32) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open sqlmock: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	var loads int32
+	r := NewRegistry(&config.Config{}, db, nil, newReplayCache())
+	r.query = func(ctx context.Context, tenantID int64) ([]idpRecord, error) {
+		atomic.AddInt32(&loads, 1)
+		return []idpRecord{{ID: tenantID * 10, TenantID: tenantID, ProviderType: "oidc", Slug: "oidc"}}, nil
+	}
+	r.build = func(ctx context.Context, rec idpRecord) (IdentityProvider, error) {
+		return &stubProvider{tenant: rec.TenantID, typ: rec.ProviderType, slug: rec.Slug}, nil
+	}
+
+	return r, mock, &loads
+}
+
+func TestRegistry_LazyLoadAndCache(t *testing.T) {
+	r, _, loads := newStubRegistry(t)
+
+	p, err := r.Get(context.Background(), 7, "oidc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if p.TenantID() != 7 {
+		t.Errorf("expected tenant 7, got %d", p.TenantID())
+	}
+
+	if _, err := r.Get(context.Background(), 7, "oidc"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if atomic.LoadInt32(loads) != 1 {
+		t.Errorf("expected 1 load, got %d", *loads)
+	}
+
+	if _, err := r.Get(context.Background(), 7, "saml"); !errors.Is(err, ErrProviderNotFound) {
+		t.Errorf("expected ErrProviderNotFound, got %v", err)
+	}
+}
+
+func TestRegistry_Invalidate(t *testing.T) {
+	r, _, loads := newStubRegistry(t)
+
+	_, _ = r.Get(context.Background(), 7, "oidc")
+	r.Invalidate(7)
+	_, _ = r.Get(context.Background(), 7, "oidc")
+
+	if atomic.LoadInt32(loads) != 2 {
+		t.Errorf("expected 2 loads, got %d", *loads)
+	}
+}
+
+func TestRegistry_StopWaitsForInvalidate(t *testing.T) {
+	r, _, loads := newStubRegistry(t)
+
+	release := make(chan struct{})
+	query := r.query
+	r.query = func(ctx context.Context, tenantID int64) ([]idpRecord, error) {
+		<-release
+		return query(ctx, tenantID)
+	}
+
+	r.Invalidate(7)
+
+	stopped := make(chan struct{})
+	go func() {
+		r.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("Stop returned before the rebuild finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	<-stopped
+
+	if atomic.LoadInt32(loads) != 1 {
+		t.Errorf("expected 1 load, got %d", *loads)
+	}
+
+	// once stopped, invalidations no longer start background work
+	r.Invalidate(7)
+	r.Stop()
+
+	if atomic.LoadInt32(loads) != 1 {
+		t.Errorf("expected 1 load after Stop, got %d", *loads)
+	}
+}
+
+func TestRegistry_SlowProviderDoesNotBlockTenant(t *testing.T) {
+	r, _, _ := newStubRegistry(t)
+	r.timeout = 50 * time.Millisecond
+
+	r.query = func(ctx context.Context, tenantID int64) ([]idpRecord, error) {
+		return []idpRecord{
+			{ID: 1, TenantID: tenantID, ProviderType: "oidc", Slug: "hanging"},
+			{ID: 2, TenantID: tenantID, ProviderType: "oidc", Slug: "okta"},
+		}, nil
+	}
+	r.build = func(ctx context.Context, rec idpRecord) (IdentityProvider, error) {
+		if rec.Slug == "hanging" {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return &stubProvider{tenant: rec.TenantID, typ: rec.ProviderType, slug: rec.Slug}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if _, err := r.Get(ctx, 7, "okta"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := r.Get(ctx, 7, "hanging"); !errors.Is(err, ErrProviderUnavailable) {
+		t.Fatalf("expected ErrProviderUnavailable, got %v", err)
+	}
+}
+
+func TestRegistry_LoadErrorIsNotCached(t *testing.T) {
+	r, _, _ := newStubRegistry(t)
+
+	query := r.query
+	fail := true
+	r.query = func(ctx context.Context, tenantID int64) ([]idpRecord, error) {
+		if fail {
+			return nil, errors.New("database unavailable")
+		}
+		return query(ctx, tenantID)
+	}
+
+	if _, err := r.Get(context.Background(), 7, "oidc"); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	fail = false
+	if _, err := r.Get(context.Background(), 7, "oidc"); err != nil {
+		t.Fatalf("unexpected error after recovery: %v", err)
+	}
+}
+
+func TestRegistry_PollInvalidatesChangedTenants(t *testing.T) {
+	r, mock, loads := newStubRegistry(t)
+
+	cols := []string{"tenant_id", "count", "sum", "revision"}
+
+	mock.ExpectQuery("SELECT tenant_id, COUNT").
+		WillReturnRows(sqlmock.NewRows(cols).AddRow(7, 1, 1, 0).AddRow(8, 1, 2, 0))
+	mock.ExpectQuery("SELECT tenant_id, COUNT").
+		WillReturnRows(sqlmock.NewRows(cols).AddRow(7, 1, 1, 1).AddRow(8, 1, 2, 0))
+
+	if err := r.Poll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, _ = r.Get(context.Background(), 7, "oidc")
+	_, _ = r.Get(context.Background(), 8, "oidc")
+
+	// tenant 7 was updated by another replica
+	if err := r.Poll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, _ = r.Get(context.Background(), 7, "oidc")
+	_, _ = r.Get(context.Background(), 8, "oidc")
+
+	if atomic.LoadInt32(loads) != 3 {
+		t.Errorf("expected 3 loads, got %d", *loads)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRegistry_ProvidersOfSameTypeBySlug(t *testing.T) {
+	r, _, _ := newStubRegistry(t)
+	r.query = func(ctx context.Context, tenantID int64) ([]idpRecord, error) {
+		return []idpRecord{
+			{ID: 1, TenantID: tenantID, ProviderType: "oidc", Slug: "azure"},
+			{ID: 2, TenantID: tenantID, ProviderType: "oidc", Slug: "okta"},
+		}, nil
+	}
+
+	for _, slug := range []string{"azure", "okta"} {
+		p, err := r.Get(context.Background(), 7, slug)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", slug, err)
+		}
+		if p.Slug() != slug {
+			t.Errorf("expected provider %s, got %s", slug, p.Slug())
+		}
+	}
+
+	if _, err := r.Get(context.Background(), 7, "oidc"); !errors.Is(err, ErrProviderNotFound) {
+		t.Errorf("expected ErrProviderNotFound, got %v", err)
+	}
+
+	if h := r.Health(7); len(h) != 2 {
+		t.Errorf("expected 2 providers, got %+v", h)
+	}
+}
+
+func TestRegistry_RetryWithBackoff(t *testing.T) {
+	r, _, _ := newStubRegistry(t)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+
+	builds := 0
+	r.build = func(ctx context.Context, rec idpRecord) (IdentityProvider, error) {
+		builds++
+		if builds < 3 {
+			return nil, errors.New("metadata unreachable")
+		}
+		return &stubProvider{tenant: rec.TenantID, typ: rec.ProviderType, slug: rec.Slug}, nil
+	}
+
+	if _, err := r.Get(context.Background(), 7, "oidc"); !errors.Is(err, ErrProviderUnavailable) {
+		t.Fatalf("expected ErrProviderUnavailable, got %v", err)
+	}
+
+	h := r.Health(7)
+	if len(h) != 1 || h[0].Healthy || h[0].ConsecutiveFailures != 1 || !h[0].NextRetry.Equal(now.Add(retryBaseDelay)) {
+		t.Fatalf("unexpected health %+v", h)
+	}
+
+	// not due yet
+	r.retryDue(context.Background())
+	if builds != 1 {
+		t.Fatalf("expected no retry before backoff, got %d builds", builds)
+	}
+
+	now = now.Add(retryBaseDelay)
+	r.retryDue(context.Background())
+	if h := r.Health(7); h[0].ConsecutiveFailures != 2 || !h[0].NextRetry.Equal(now.Add(2*retryBaseDelay)) {
+		t.Fatalf("expected doubled backoff, got %+v", h)
+	}
+
+	now = now.Add(2 * retryBaseDelay)
+	r.retryDue(context.Background())
+
+	if _, err := r.Get(context.Background(), 7, "oidc"); err != nil {
+		t.Fatalf("provider should recover: %v", err)
+	}
+
+	if u := r.Unhealthy(); len(u) != 0 {
+		t.Errorf("expected no unhealthy providers, got %+v", u)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	if backoff(1) != retryBaseDelay {
+		t.Errorf("expected %s, got %s", retryBaseDelay, backoff(1))
+	}
+
+	if backoff(100) != retryMaxDelay {
+		t.Errorf("expected %s, got %s", retryMaxDelay, backoff(100))
+	}
+}
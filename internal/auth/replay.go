@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+)
+
+// replaySweepInterval is how often the expired values are deleted
+const replaySweepInterval = time.Minute
+
+// ErrReplayUnavailable is wrapped by the errors of a store that can not
+// tell whether a value was already used
+var ErrReplayUnavailable = errors.New("one-time values can not be checked")
+
+// ReplayStore consumes one-time values: login states and SAML assertions.
+// Every replica must share the store, or a value consumed on one of them
+// is accepted again by the others.
+type ReplayStore interface {
+	// Use marks key as consumed until expiresAt. It returns false when
+	// the key was already consumed and has not expired yet.
+	Use(ctx context.Context, key string, expiresAt time.Time) (bool, error)
+}
+
+// dbReplayStore keeps the consumed values in MySQL, hashed
+type dbReplayStore struct {
+	repo repo.UsedTokenRepository
+	now  func() time.Time
+
+	mu    sync.Mutex
+	swept time.Time
+}
+
+// NewReplayStore returns the ReplayStore shared by the replicas
+func NewReplayStore(r repo.UsedTokenRepository) ReplayStore {
+	return &dbReplayStore{repo: r, now: time.Now}
+}
+
+func (s *dbReplayStore) Use(ctx context.Context, key string, expiresAt time.Time) (bool, error) {
+	now := s.now()
+	s.sweep(ctx, now)
+
+	ok, err := s.repo.Consume(ctx, hashToken(key), expiresAt, now)
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrReplayUnavailable, err)
+	}
+	return ok, nil
+}
+
+// sweep deletes the expired values now and then. A failed sweep is not
+// an error, the next one deletes them.
+func (s *dbReplayStore) sweep(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	due := now.Sub(s.swept) >= replaySweepInterval
+	if due {
+		s.swept = now
+	}
+	s.mu.Unlock()
+
+	if due {
+		_ = s.repo.DeleteExpired(ctx, now)
+	}
+}
+
+// replayCache remembers one-time values in memory. Only a single replica
+// may use it; tests use it in place of the shared store.
+type replayCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+	now  func() time.Time
+}
+
+// NewMemoryReplayStore returns a ReplayStore local to the process
+func NewMemoryReplayStore() ReplayStore {
+	return newReplayCache()
+}
+
+func newReplayCache() *replayCache {
+	return &replayCache{seen: make(map[string]time.Time), now: time.Now}
+}
+
+func (r *replayCache) Use(ctx context.Context, key string, expiresAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	for k, exp := range r.seen {
+		if !now.Before(exp) {
+			delete(r.seen, k)
+		}
+	}
+
+	if _, ok := r.seen[key]; ok {
+		return false, nil
+	}
+
+	r.seen[key] = expiresAt
+	return true, nil
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// stubUsedTokens is an in-memory repo.UsedTokenRepository
+type stubUsedTokens struct {
+	used  map[string]time.Time
+	err   error
+	swept int
+}
+
+func newStubUsedTokens() *stubUsedTokens {
+	return &stubUsedTokens{used: make(map[string]time.Time)}
+}
+
+func (s *stubUsedTokens) Consume(ctx context.Context, hash []byte, expiresAt, now time.Time) (bool, error) {
+	if s.err != nil {
+		return false, s.err
+	}
+	if exp, ok := s.used[string(hash)]; ok && now.Before(exp) {
+		return false, nil
+	}
+	s.used[string(hash)] = expiresAt
+	return true, nil
+}
+
+func (s *stubUsedTokens) DeleteExpired(ctx context.Context, now time.Time) error {
+	s.swept++
+	for k, exp := range s.used {
+		if !now.Before(exp) {
+			delete(s.used, k)
+		}
+	}
+	return nil
+}
+
+func TestReplayStore_Use(t *testing.T) {
+	tokens := newStubUsedTokens()
+	store := NewReplayStore(tokens).(*dbReplayStore)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if ok, err := store.Use(ctx, "k", now.Add(time.Minute)); !ok || err != nil {
+		t.Fatalf("expected the first use to pass, got %v, %v", ok, err)
+	}
+	if ok, _ := store.Use(ctx, "k", now.Add(time.Minute)); ok {
+		t.Error("expected the second use to be refused")
+	}
+	if _, ok := tokens.used["k"]; ok {
+		t.Error("expected the key to be stored hashed")
+	}
+
+	// expired values are swept at most once per interval
+	now = now.Add(2 * time.Minute)
+	if ok, _ := store.Use(ctx, "k", now.Add(time.Minute)); !ok {
+		t.Error("expected an expired key to be usable again")
+	}
+	if tokens.swept != 2 {
+		t.Errorf("expected 2 sweeps, got %d", tokens.swept)
+	}
+
+	tokens.err = errors.New("connection refused")
+	if _, err := store.Use(ctx, "other", now.Add(time.Minute)); !errors.Is(err, ErrReplayUnavailable) {
+		t.Errorf("expected ErrReplayUnavailable, got %v", err)
+	}
+}
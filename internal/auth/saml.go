@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/samlsp"
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+)
+
+var ErrAssertionReplayed = errors.New("saml assertion was already used")
+
+// MetadataProvider is implemented by providers that publish SP metadata.
+type MetadataProvider interface {
+	Metadata() ([]byte, error)
+}
+
+// well known attribute names used to find the user e-mail and display name
+var (
+	samlEmailAttributes = []string{
+		"email",
+		"mail",
+		"emailaddress",
+		"urn:oid:0.9.2342.19200300.100.1.3",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress",
+	}
+	samlNameAttributes = []string{
+		"name",
+		"displayname",
+		"urn:oid:2.16.840.1.113730.3.1.241",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/name",
+	}
+	samlGroupAttributes = []string{
+		"groups",
+		"memberof",
+		"http://schemas.microsoft.com/ws/2008/06/identity/claims/groups",
+		"http://schemas.xmlsoap.org/claims/group",
+	}
+)
+
+type samlProvider struct {
+	id       int64
+	tenantID int64
+	slug     string
+	sp       *saml.ServiceProvider
+	claims   ClaimMapping
+	roles    map[string]string
+	replay   ReplayStore
+	now      func() time.Time
+}
+
+func newSAMLProvider(ctx context.Context, rec idpRecord, cfg *config.Config, client *http.Client, replay ReplayStore) (IdentityProvider, error) {
+	metadataURL, err := url.Parse(rec.MetadataURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid saml metadata url: %w", err)
+	}
+
+	idpMetadata, err := samlsp.FetchMetadata(ctx, client, *metadataURL)
+	if err != nil {
+		return nil, fmt.Errorf("fetch saml metadata: %w", err)
+	}
+
+	base := providerBaseURL(cfg, rec)
+	acsURL, err := url.Parse(base + "/callback")
+	if err != nil {
+		return nil, err
+	}
+
+	spMetadataURL, err := url.Parse(base + "/metadata")
+	if err != nil {
+		return nil, err
+	}
+
+	sp := &saml.ServiceProvider{
+		EntityID:          rec.ClientID,
+		MetadataURL:       *spMetadataURL,
+		AcsURL:            *acsURL,
+		IDPMetadata:       idpMetadata,
+		AuthnNameIDFormat: saml.UnspecifiedNameIDFormat,
+	}
+
+	if sp.GetSSOBindingLocation(saml.HTTPRedirectBinding) == "" {
+		return nil, errors.New("saml metadata has no HTTP-Redirect single sign-on service")
+	}
+
+	if cfg.SAMLCertFile != "" && cfg.SAMLKeyFile != "" {
+		key, cert, err := loadSAMLKeyPair(cfg.SAMLCertFile, cfg.SAMLKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		sp.Key = key
+		sp.Certificate = cert
+	}
+
+	return &samlProvider{
+		id:       rec.ID,
+		tenantID: rec.TenantID,
+		slug:     rec.Slug,
+		sp:       sp,
+		claims:   rec.ClaimMapping,
+		roles:    rec.RoleMapping,
+		replay:   replay,
+		now:      time.Now,
+	}, nil
+}
+
+// loadSAMLKeyPair loads the SP key used to decrypt assertions
+func loadSAMLKeyPair(certFile, keyFile string) (crypto.Signer, *x509.Certificate, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load saml key pair: %w", err)
+	}
+
+	signer, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, errors.New("saml private key can not sign")
+	}
+
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return signer, cert, nil
+}
+
+func (s *samlProvider) TenantID() int64 { return s.tenantID }
+func (s *samlProvider) Type() string    { return "saml" }
+func (s *samlProvider) Slug() string    { return s.slug }
+
+// AuthURL builds an AuthnRequest for the redirect binding. The request ID
+// is derived from the login nonce so the response can be correlated.
+func (s *samlProvider) AuthURL(params AuthParams) string {
+	req, err := s.sp.MakeAuthenticationRequest(
+		s.sp.GetSSOBindingLocation(saml.HTTPRedirectBinding),
+		saml.HTTPRedirectBinding,
+		saml.HTTPPostBinding,
+	)
+	if err != nil {
+		return ""
+	}
+
+	req.ID = samlRequestID(params.Nonce)
+
+	// the state is base64url, it needs no escaping in the query
+	u, err := req.Redirect(params.State, s.sp)
+	if err != nil {
+		return ""
+	}
+
+	return u.String()
+}
+
+// Callback consumes the SAMLResponse posted to the ACS endpoint.
+func (s *samlProvider) Callback(ctx context.Context, req *http.Request, params AuthParams) (*AuthResult, error) {
+	if params.Nonce == "" {
+		return nil, ErrNonceMismatch
+	}
+
+	if err := req.ParseForm(); err != nil {
+		return nil, err
+	}
+
+	// ParseResponse checks signature, issuer, recipient, audience,
+	// conditions and InResponseTo
+	assertion, err := s.sp.ParseResponse(req, []string{samlRequestID(params.Nonce)})
+	if err != nil {
+		var ire *saml.InvalidResponseError
+		if errors.As(err, &ire) {
+			return nil, fmt.Errorf("invalid saml response: %w", ire.PrivateErr)
+		}
+		return nil, err
+	}
+
+	now := s.now()
+	expiresAt := now.Add(saml.MaxIssueDelay)
+	if assertion.Conditions != nil && !assertion.Conditions.NotOnOrAfter.IsZero() {
+		expiresAt = assertion.Conditions.NotOnOrAfter.Add(saml.MaxClockSkew)
+	}
+
+	// the store outlives this provider and is shared by the replicas, so
+	// an assertion is refused after a rebuild or on another replica
+	ok, err := s.replay.Use(ctx, fmt.Sprintf("saml-assertion:%d:%d:%s", s.tenantID, s.id, assertion.ID), expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrAssertionReplayed
+	}
+
+	return s.mapAssertion(assertion)
+}
+
+// Metadata returns the SP metadata XML for this tenant.
+func (s *samlProvider) Metadata() ([]byte, error) {
+	return xml.MarshalIndent(s.sp.Metadata(), "", "  ")
+}
+
+func (s *samlProvider) mapAssertion(assertion *saml.Assertion) (*AuthResult, error) {
+	if assertion.Subject == nil || assertion.Subject.NameID == nil || assertion.Subject.NameID.Value == "" {
+		return nil, errors.New("saml assertion has no NameID")
+	}
+
+	nameID := assertion.Subject.NameID
+
+	attrs := make(map[string][]string)
+	for _, stmt := range assertion.AttributeStatements {
+		for _, attr := range stmt.Attributes {
+			for _, v := range attr.Values {
+				attrs[attr.Name] = append(attrs[attr.Name], v.Value)
+				if attr.FriendlyName != "" && attr.FriendlyName != attr.Name {
+					attrs[attr.FriendlyName] = append(attrs[attr.FriendlyName], v.Value)
+				}
+			}
+		}
+	}
+
+	userID := nameID.Value
+	if s.claims.UserID != "" {
+		if userID = firstAttribute(attrs, []string{s.claims.UserID}); userID == "" {
+			return nil, fmt.Errorf("attribute %q not found in saml assertion", s.claims.UserID)
+		}
+	}
+
+	email := firstAttribute(attrs, mappedOr(s.claims.Email, samlEmailAttributes))
+	if email == "" && s.claims.Email == "" && nameID.Format == string(saml.EmailAddressNameIDFormat) {
+		email = nameID.Value
+	}
+
+	groups := allAttributes(attrs, mappedOr(s.claims.Groups, samlGroupAttributes))
+
+	return &AuthResult{
+		TenantID:   s.tenantID,
+		IdPID:      s.id,
+		UserID:     userID,
+		Email:      email,
+		Name:       firstAttribute(attrs, mappedOr(s.claims.Name, samlNameAttributes)),
+		Groups:     groups,
+		Roles:      mapRoles(s.roles, groups),
+		Attributes: attrs,
+	}, nil
+}
+
+// mappedOr returns the configured attribute or the well known fallbacks
+func mappedOr(mapped string, fallback []string) []string {
+	if mapped != "" {
+		return []string{mapped}
+	}
+	return fallback
+}
+
+func firstAttribute(attrs map[string][]string, names []string) string {
+	for _, name := range names {
+		for k, v := range attrs {
+			if strings.EqualFold(k, name) && len(v) > 0 && v[0] != "" {
+				return v[0]
+			}
+		}
+	}
+
+	return ""
+}
+
+func allAttributes(attrs map[string][]string, names []string) []string {
+	for _, name := range names {
+		for k, v := range attrs {
+			if strings.EqualFold(k, name) && len(v) > 0 {
+				return v
+			}
+		}
+	}
+
+	return nil
+}
+
+// samlRequestID turns a nonce into a valid xs:ID (must not start with a digit)
+func samlRequestID(nonce string) string {
+	return "_" + nonce
+}
@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+)
+
+type testSPProvider struct {
+	sp *saml.ServiceProvider
+}
+
+func (p *testSPProvider) GetServiceProvider(r *http.Request, id string) (*saml.EntityDescriptor, error) {
+	if p.sp == nil || id != p.sp.EntityID {
+		return nil, os.ErrNotExist
+	}
+	return p.sp.Metadata(), nil
+}
+
+func newTestIDP(t *testing.T) (*saml.IdentityProvider, *testSPProvider, *httptest.Server) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test-idp"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	spp := &testSPProvider{}
+	idp := &saml.IdentityProvider{
+		Key:                     key,
+		Certificate:             cert,
+		ServiceProviderProvider: spp,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metadata", idp.ServeMetadata)
+	ts := httptest.NewServer(mux)
+
+	metadataURL, _ := url.Parse(ts.URL + "/metadata")
+	ssoURL, _ := url.Parse(ts.URL + "/sso")
+	idp.MetadataURL = *metadataURL
+	idp.SSOURL = *ssoURL
+
+	return idp, spp, ts
+}
+
+// postResponse plays the IdP side: it answers the AuthnRequest in authURL
+// and returns the ACS POST the browser would send back.
+func postResponse(t *testing.T, idp *saml.IdentityProvider, authURL string, session *saml.Session) *http.Request {
+	t.Helper()
+
+	req, err := saml.NewIdpAuthnRequest(idp, httptest.NewRequest(http.MethodGet, authURL, nil))
+	if err != nil {
+		t.Fatalf("failed to parse authn request: %v", err)
+	}
+
+	if err := req.Validate(); err != nil {
+		t.Fatalf("invalid authn request: %v", err)
+	}
+
+	if err := (saml.DefaultAssertionMaker{}).MakeAssertion(req, session); err != nil {
+		t.Fatalf("failed to make assertion: %v", err)
+	}
+
+	form, err := req.PostBinding()
+	if err != nil {
+		t.Fatalf("failed to build response: %v", err)
+	}
+
+	body := url.Values{"SAMLResponse": {form.SAMLResponse}, "RelayState": {form.RelayState}}
+	post := httptest.NewRequest(http.MethodPost, form.URL, strings.NewReader(body.Encode()))
+	post.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return post
+}
+
+func TestSAMLProvider_LoginAndCallback(t *testing.T) {
+	idp, spp, ts := newTestIDP(t)
+	defer ts.Close()
+
+	rec := idpRecord{ID: 1, TenantID: 7, ProviderType: "saml", Slug: "saml", MetadataURL: ts.URL + "/metadata", ClientID: "https://crm.test/sp"}
+	cfg := &config.Config{BaseURL: "https://crm.test"}
+
+	pIface, err := newSAMLProvider(context.Background(), rec, cfg, http.DefaultClient, newReplayCache())
+	if err != nil {
+		t.Fatalf("failed to create provider: %v", err)
+	}
+	sp := pIface.(*samlProvider)
+	spp.sp = sp.sp
+
+	params := AuthParams{State: "state-123", Nonce: "nonce-abc"}
+	authURL := sp.AuthURL(params)
+	if !strings.HasPrefix(authURL, ts.URL+"/sso?SAMLRequest=") || !strings.Contains(authURL, "RelayState=state-123") {
+		t.Fatalf("unexpected auth url %q", authURL)
+	}
+
+	session := &saml.Session{
+		ID:           "session-1",
+		NameID:       "jdoe",
+		NameIDFormat: string(saml.PersistentNameIDFormat),
+		UserEmail:    "jdoe@example.com",
+		UserName:     "jdoe",
+	}
+
+	post := postResponse(t, idp, authURL, session)
+	res, err := sp.Callback(context.Background(), post, params)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if res.TenantID != 7 || res.IdPID != rec.ID || res.UserID != "jdoe" || res.Email != "jdoe@example.com" {
+		t.Errorf("unexpected auth result %+v", res)
+	}
+}
+
+func TestBuildProvider_SAMLHasNoSecret(t *testing.T) {
+	_, _, ts := newTestIDP(t)
+	defer ts.Close()
+
+	// no keyring: the secret column of a SAML provider is never decrypted
+	rec := idpRecord{ID: 1, TenantID: 7, ProviderType: "saml", Slug: "saml", MetadataURL: ts.URL + "/metadata", ClientID: "https://crm.test/sp", ClientSecretEnc: []byte("not encrypted")}
+	if _, err := buildProvider(context.Background(), rec, &config.Config{BaseURL: "https://crm.test"}, nil, http.DefaultClient, newReplayCache()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSAMLProvider_Replay(t *testing.T) {
+	idp, spp, ts := newTestIDP(t)
+	defer ts.Close()
+
+	rec := idpRecord{ID: 1, TenantID: 7, ProviderType: "saml", Slug: "saml", MetadataURL: ts.URL + "/metadata", ClientID: "https://crm.test/sp"}
+	replayStore := newReplayCache()
+	build := func() *samlProvider {
+		pIface, err := newSAMLProvider(context.Background(), rec, &config.Config{BaseURL: "https://crm.test"}, http.DefaultClient, replayStore)
+		if err != nil {
+			t.Fatalf("failed to create provider: %v", err)
+		}
+		return pIface.(*samlProvider)
+	}
+	sp := build()
+	spp.sp = sp.sp
+
+	params := AuthParams{State: "s", Nonce: "n"}
+	post := postResponse(t, idp, sp.AuthURL(params), &saml.Session{ID: "x", NameID: "jdoe"})
+	_ = post.ParseForm()
+	body := post.PostForm.Encode()
+
+	replay := func() *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "https://crm.test/7/saml/callback", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+
+	if _, err := sp.Callback(context.Background(), replay(), params); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := sp.Callback(context.Background(), replay(), params); !errors.Is(err, ErrAssertionReplayed) {
+		t.Errorf("expected ErrAssertionReplayed, got %v", err)
+	}
+
+	// the store outlives the provider: a rebuild does not forget the assertion
+	if _, err := build().Callback(context.Background(), replay(), params); !errors.Is(err, ErrAssertionReplayed) {
+		t.Errorf("expected ErrAssertionReplayed after a rebuild, got %v", err)
+	}
+}
+
+func TestSAMLProvider_WrongRequestID(t *testing.T) {
+	idp, spp, ts := newTestIDP(t)
+	defer ts.Close()
+
+	rec := idpRecord{ID: 1, TenantID: 7, ProviderType: "saml", Slug: "saml", MetadataURL: ts.URL + "/metadata", ClientID: "https://crm.test/sp"}
+	pIface, err := newSAMLProvider(context.Background(), rec, &config.Config{BaseURL: "https://crm.test"}, http.DefaultClient, newReplayCache())
+	if err != nil {
+		t.Fatalf("failed to create provider: %v", err)
+	}
+	sp := pIface.(*samlProvider)
+	spp.sp = sp.sp
+
+	post := postResponse(t, idp, sp.AuthURL(AuthParams{State: "s", Nonce: "n1"}), &saml.Session{ID: "x", NameID: "jdoe"})
+	if _, err := sp.Callback(context.Background(), post, AuthParams{State: "s", Nonce: "n2"}); err == nil {
+		t.Error("expected error for response to another request, got nil")
+	}
+}
+
+func TestSAMLProvider_Metadata(t *testing.T) {
+	_, _, ts := newTestIDP(t)
+	defer ts.Close()
+
+	rec := idpRecord{ID: 1, TenantID: 7, ProviderType: "saml", Slug: "saml", MetadataURL: ts.URL + "/metadata", ClientID: "https://crm.test/sp"}
+	pIface, err := newSAMLProvider(context.Background(), rec, &config.Config{BaseURL: "https://crm.test"}, http.DefaultClient, newReplayCache())
+	if err != nil {
+		t.Fatalf("failed to create provider: %v", err)
+	}
+
+	body, err := pIface.(MetadataProvider).Metadata()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.Contains(string(body), `Location="https://crm.test/7/saml/callback"`) {
+		t.Errorf("metadata does not advertise the ACS url: %s", body)
+	}
+}
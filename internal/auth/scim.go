@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+)
+
+const (
+	// SCIMTokenPrefix starts every SCIM bearer token
+	SCIMTokenPrefix = "scim_"
+
+	// scimTokenDisplayLen is how much of the token is kept to identify it
+	scimTokenDisplayLen = len(SCIMTokenPrefix) + 8
+)
+
+var (
+	ErrSCIMTokenInvalid = errors.New("scim token is invalid")
+	ErrSCIMTokenRevoked = errors.New("scim token was revoked")
+	ErrSCIMUnknownIdP   = errors.New("identity provider not found")
+)
+
+// SCIMAuthenticator resolves the bearer token of a SCIM client
+type SCIMAuthenticator interface {
+	Authenticate(ctx context.Context, token string) (*repo.SCIMTokenRecord, error)
+}
+
+// SCIMTokens issues and authenticates the bearer tokens SCIM clients (Okta,
+// Azure AD...) use to provision users. A token belongs to one identity
+// provider of the tenant: the users it pushes log in through that IdP.
+// Like API keys only the SHA-256 hash of the token is stored.
+type SCIMTokens struct {
+	repo repo.SCIMTokenRepository
+	idps repo.IdentityProviderRepository
+	now  func() time.Time
+}
+
+var _ SCIMAuthenticator = (*SCIMTokens)(nil)
+
+func NewSCIMTokens(r repo.SCIMTokenRepository, idps repo.IdentityProviderRepository) *SCIMTokens {
+	return &SCIMTokens{repo: r, idps: idps, now: time.Now}
+}
+
+// Issue stores a new token for rec.TenantID and rec.IdPID and returns it.
+// rec gets the generated id, prefix and hash.
+func (t *SCIMTokens) Issue(ctx context.Context, rec *repo.SCIMTokenRecord) (string, error) {
+	idp, err := t.idps.GetByID(ctx, rec.TenantID, rec.IdPID)
+	if err != nil {
+		return "", fmt.Errorf("load identity provider: %w", err)
+	}
+
+	if idp == nil {
+		return "", ErrSCIMUnknownIdP
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	token := SCIMTokenPrefix + secret
+	rec.Prefix = token[:scimTokenDisplayLen]
+	rec.TokenHash = hashToken(token)
+
+	id, err := t.repo.Create(ctx, rec)
+	if err != nil {
+		return "", err
+	}
+
+	rec.ID = id
+	rec.CreatedAt = t.now()
+	return token, nil
+}
+
+// Authenticate returns the stored token, refusing unknown and revoked ones
+func (t *SCIMTokens) Authenticate(ctx context.Context, token string) (*repo.SCIMTokenRecord, error) {
+	if !strings.HasPrefix(token, SCIMTokenPrefix) {
+		return nil, ErrSCIMTokenInvalid
+	}
+
+	rec, err := t.repo.GetByHash(ctx, hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+
+	if rec == nil {
+		return nil, ErrSCIMTokenInvalid
+	}
+
+	if rec.RevokedAt != nil {
+		return nil, ErrSCIMTokenRevoked
+	}
+
+	now := t.now()
+	if rec.LastUsedAt == nil || now.Sub(*rec.LastUsedAt) >= apiKeyLastUsedResolution {
+		// last_used_at is informative, failing to record it does not deny access
+		_ = t.repo.TouchLastUsed(ctx, rec.ID, now)
+	}
+
+	return rec, nil
+}
+
+// Provisioning applies what SCIM clients push to the access of the users:
+// group memberships become roles through the role mapping of the IdP, and
+// deprovisioned users lose their sessions.
+type Provisioning struct {
+	scim     repo.SCIMRepository
+	idps     repo.IdentityProviderRepository
+	roles    repo.UserRoleRepository
+	sessions *Sessions
+}
+
+func NewProvisioning(scim repo.SCIMRepository, idps repo.IdentityProviderRepository, roles repo.UserRoleRepository, sessions *Sessions) *Provisioning {
+	return &Provisioning{scim: scim, idps: idps, roles: roles, sessions: sessions}
+}
+
+// SyncRoles maps the SCIM groups of each user into its "scim" roles. They
+// are kept apart from the roles of the login, so a login whose token has
+// no groups claim does not drop them.
+func (p *Provisioning) SyncRoles(ctx context.Context, tenantID, idpID int64, userIDs ...int64) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	idp, err := p.idps.GetByID(ctx, tenantID, idpID)
+	if err != nil {
+		return fmt.Errorf("load identity provider: %w", err)
+	}
+
+	var mapping map[string]string
+	if idp != nil {
+		mapping = idp.RoleMapping
+	}
+
+	for _, userID := range userIDs {
+		groups, err := p.scim.ListUserGroups(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("load scim groups: %w", err)
+		}
+
+		names := make([]string, 0, len(groups))
+		for _, g := range groups {
+			names = append(names, g.DisplayName)
+		}
+
+		if err := p.roles.ReplaceRoles(ctx, tenantID, userID, repo.RoleSourceSCIM, mapRoles(mapping, names)); err != nil {
+			return fmt.Errorf("sync scim roles: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Deprovision ends the sessions of a user the SCIM client deactivated or
+// deleted; its access tokens are rejected from now on.
+func (p *Provisioning) Deprovision(ctx context.Context, tenantID, userID int64) error {
+	return p.sessions.RevokeUser(ctx, tenantID, userID, RevokedByDeprovision)
+}
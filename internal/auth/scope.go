@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+)
+
+// ScopeProfile lets a token read the profile of its user. Every user
+// token has it, on top of the scopes granted by the user roles.
+const ScopeProfile = "profile"
+
+// Scope is a set of scopes. In tokens it is the space-delimited "scope"
+// claim of RFC 9068; the catalogue of scopes is the permission catalogue.
+type Scope []string
+
+// ParseScope splits a space-delimited scope string
+func ParseScope(s string) Scope {
+	return Scope(strings.Fields(s))
+}
+
+// String returns the space-delimited form of the scope
+func (s Scope) String() string {
+	return strings.Join(s, " ")
+}
+
+// Has reports whether the scope includes name
+func (s Scope) Has(name string) bool {
+	return slices.Contains(s, name)
+}
+
+// Intersect returns the scopes of s that other includes too
+func (s Scope) Intersect(other []string) Scope {
+	out := Scope{}
+	for _, name := range s {
+		if slices.Contains(other, name) {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+func (s Scope) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s *Scope) UnmarshalJSON(b []byte) error {
+	var v string
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*s = ParseScope(v)
+	return nil
+}
+
+// GrantedScope returns the scope of a user token: the profile plus the
+// permissions of the roles, in catalogue order. Super admins get them all.
+func GrantedScope(roles []string, superAdmin bool) Scope {
+	scope := Scope{ScopeProfile}
+	for _, p := range permissions {
+		if p == ScopeProfile {
+			continue
+		}
+		if superAdmin || HasPermission(roles, p) {
+			scope = append(scope, p)
+		}
+	}
+	return scope
+}
@@ -0,0 +1,497 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+)
+
+const (
+	defaultAccessTokenTTL     = 15 * time.Minute
+	defaultRefreshTokenTTL    = 30 * 24 * time.Hour
+	defaultRevocationCacheTTL = 30 * time.Second
+
+	// revocation cache size that triggers a sweep of expired entries
+	revocationCacheSweep = 10000
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid")
+	ErrRefreshTokenExpired = errors.New("refresh token has expired")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used, session revoked")
+	ErrSessionRevoked      = errors.New("session was revoked")
+	ErrUserInactive        = errors.New("user was deprovisioned")
+)
+
+// Session revocation reasons
+const (
+	RevokedByLogout = "logout"
+	RevokedByReuse  = "refresh_token_reuse"
+	// RevokedByDeprovision ends the sessions of users a SCIM client
+	// deactivated or deleted
+	RevokedByDeprovision = "deprovisioned"
+	// RevokedByAdmin ends an impersonation before it expires
+	RevokedByAdmin = "admin"
+)
+
+// TokenPair is returned by a successful login or refresh. The access
+// token keeps the "token" field name used before refresh tokens existed.
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        Scope  `json:"scope,omitempty"`
+
+	// SessionID is the session the tokens belong to
+	SessionID string `json:"-"`
+}
+
+// RevocationChecker reports whether the session behind an access token
+// was revoked.
+type RevocationChecker interface {
+	IsRevoked(ctx context.Context, sessionID string) (bool, error)
+}
+
+type revocationEntry struct {
+	revoked bool
+	until   time.Time
+}
+
+// Sessions issues short-lived access tokens bound to a server side
+// session, and rotating refresh tokens stored hashed in MySQL.
+//
+// Every refresh token is single use: presenting one that was already
+// rotated means it leaked, so the whole session is revoked.
+type Sessions struct {
+	repo  repo.SessionRepository
+	users repo.UserRepository
+	roles repo.UserRoleRepository
+	keys  *Keyring
+	cfg   *config.Config
+	now   func() time.Time
+
+	mu      sync.Mutex
+	revoked map[string]revocationEntry
+}
+
+var _ RevocationChecker = (*Sessions)(nil)
+
+func NewSessions(cfg *config.Config, r repo.SessionRepository, users repo.UserRepository, roles repo.UserRoleRepository, keys *Keyring) *Sessions {
+	return &Sessions{
+		repo:    r,
+		users:   users,
+		roles:   roles,
+		keys:    keys,
+		cfg:     cfg,
+		now:     time.Now,
+		revoked: make(map[string]revocationEntry),
+	}
+}
+
+// Start provisions the authenticated user and opens a session for it.
+func (s *Sessions) Start(ctx context.Context, res *AuthResult) (*TokenPair, error) {
+	userID, err := s.Provision(ctx, res)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.open(ctx, &repo.SessionRecord{
+		TenantID: res.TenantID,
+		UserID:   userID,
+		Email:    res.Email,
+	})
+}
+
+// Provision creates or updates the authenticated user and returns its id.
+// The profile is refreshed from the IdP on every login (JIT provisioning).
+// Users a SCIM client deactivated can not log in.
+func (s *Sessions) Provision(ctx context.Context, res *AuthResult) (int64, error) {
+	userID, err := s.users.UpsertLogin(ctx, &repo.UserRecord{
+		TenantID: res.TenantID,
+		IdPID:    res.IdPID,
+		Subject:  res.UserID,
+		Email:    res.Email,
+		Name:     res.Name,
+		Groups:   res.Groups,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("provision user: %w", err)
+	}
+
+	user, err := s.users.GetByID(ctx, res.TenantID, userID)
+	if err != nil {
+		return 0, fmt.Errorf("load user: %w", err)
+	}
+
+	if user == nil || !user.Active {
+		return 0, ErrUserInactive
+	}
+
+	// the IdP is the authority on the roles it maps, refresh them on login
+	if err := s.roles.ReplaceRoles(ctx, res.TenantID, userID, repo.RoleSourceIdP, res.Roles); err != nil {
+		return 0, fmt.Errorf("sync idp roles: %w", err)
+	}
+
+	return userID, nil
+}
+
+// StartClient opens a session delegated to an OAuth client. Its tokens
+// carry the client and are limited to the granted scopes.
+func (s *Sessions) StartClient(ctx context.Context, tenantID, userID int64, clientID string, scopes []string) (*TokenPair, error) {
+	user, err := s.users.GetByID(ctx, tenantID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("load user: %w", err)
+	}
+
+	if user == nil {
+		return nil, ErrRefreshTokenInvalid
+	}
+
+	return s.open(ctx, &repo.SessionRecord{
+		TenantID: tenantID,
+		UserID:   userID,
+		Email:    user.Email,
+		ClientID: clientID,
+		Scopes:   scopes,
+	})
+}
+
+// ClientToken mints an access token for an OAuth client acting on its
+// own behalf. There is no user, no session and no refresh token.
+func (s *Sessions) ClientToken(tenantID int64, clientID string, scopes []string) (*TokenPair, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	ttl := s.accessTTL()
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    s.cfg.Issuer(),
+			Subject:   clientID,
+			Audience:  jwt.ClaimStrings{audience(s.cfg)},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+		TenantID: tenantID,
+		ClientID: clientID,
+		Scope:    scopes,
+	}
+
+	signed, err := s.keys.Sign(claims)
+	if err != nil {
+		return nil, fmt.Errorf("sign access token: %w", err)
+	}
+
+	return &TokenPair{
+		AccessToken: signed,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(ttl.Seconds()),
+		Scope:       scopes,
+	}, nil
+}
+
+// open stores a new session and issues its first tokens
+func (s *Sessions) open(ctx context.Context, sess *repo.SessionRecord) (*TokenPair, error) {
+	if err := s.create(ctx, sess); err != nil {
+		return nil, err
+	}
+
+	return s.issue(ctx, sess, nil)
+}
+
+// create stores the session under a new random id
+func (s *Sessions) create(ctx context.Context, sess *repo.SessionRecord) error {
+	id, err := randomToken(24)
+	if err != nil {
+		return err
+	}
+	sess.ID = id
+
+	if err := s.repo.CreateSession(ctx, sess); err != nil {
+		return fmt.Errorf("create session: %w", err)
+	}
+
+	return nil
+}
+
+// Refresh rotates a refresh token and mints a new access token. Tokens
+// of OAuth client sessions are refreshed through RefreshClient only.
+func (s *Sessions) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	return s.refresh(ctx, refreshToken, "")
+}
+
+// RefreshClient rotates a refresh token issued to the OAuth client
+func (s *Sessions) RefreshClient(ctx context.Context, refreshToken, clientID string) (*TokenPair, error) {
+	return s.refresh(ctx, refreshToken, clientID)
+}
+
+func (s *Sessions) refresh(ctx context.Context, refreshToken, clientID string) (*TokenPair, error) {
+	rec, sess, err := s.lookup(ctx, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if sess.ClientID != clientID {
+		return nil, ErrRefreshTokenInvalid
+	}
+
+	if sess.RevokedAt != nil {
+		return nil, ErrSessionRevoked
+	}
+
+	if rec.UsedAt != nil {
+		return nil, s.revokeReused(ctx, sess.ID)
+	}
+
+	if !s.now().Before(rec.ExpiresAt) {
+		return nil, ErrRefreshTokenExpired
+	}
+
+	ok, err := s.repo.MarkRefreshTokenUsed(ctx, rec.ID)
+	if err != nil {
+		return nil, fmt.Errorf("rotate refresh token: %w", err)
+	}
+
+	// another request consumed the token first
+	if !ok {
+		return nil, s.revokeReused(ctx, sess.ID)
+	}
+
+	return s.issue(ctx, sess, &rec.ID)
+}
+
+// Logout revokes the session of a refresh token
+func (s *Sessions) Logout(ctx context.Context, refreshToken string) error {
+	_, sess, err := s.lookup(ctx, refreshToken)
+	if err != nil {
+		return err
+	}
+
+	return s.Revoke(ctx, sess.ID, RevokedByLogout)
+}
+
+// Revoke ends a session; its access tokens are rejected from now on
+func (s *Sessions) Revoke(ctx context.Context, sessionID, reason string) error {
+	if err := s.repo.RevokeSession(ctx, sessionID, reason); err != nil {
+		return fmt.Errorf("revoke session: %w", err)
+	}
+
+	s.remember(sessionID, true)
+	return nil
+}
+
+// RevokeUser ends every open session of the user
+func (s *Sessions) RevokeUser(ctx context.Context, tenantID, userID int64, reason string) error {
+	ids, err := s.repo.ListActiveSessions(ctx, tenantID, userID)
+	if err != nil {
+		return fmt.Errorf("list sessions: %w", err)
+	}
+
+	for _, id := range ids {
+		if err := s.Revoke(ctx, id, reason); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// IsRevoked answers from a short-lived cache, so a revocation made by
+// another replica takes effect within RevocationCacheTTL.
+func (s *Sessions) IsRevoked(ctx context.Context, sessionID string) (bool, error) {
+	s.mu.Lock()
+	e, ok := s.revoked[sessionID]
+	s.mu.Unlock()
+
+	if ok && s.now().Before(e.until) {
+		return e.revoked, nil
+	}
+
+	sess, err := s.repo.GetSession(ctx, sessionID)
+	if err != nil {
+		return false, err
+	}
+
+	// an unknown session was deleted along with its tenant
+	revoked := sess == nil || sess.RevokedAt != nil
+	s.remember(sessionID, revoked)
+
+	return revoked, nil
+}
+
+// Inspect returns the refresh token and its session, without consuming it
+func (s *Sessions) Inspect(ctx context.Context, refreshToken string) (*repo.RefreshTokenRecord, *repo.SessionRecord, error) {
+	return s.lookup(ctx, refreshToken)
+}
+
+func (s *Sessions) lookup(ctx context.Context, refreshToken string) (*repo.RefreshTokenRecord, *repo.SessionRecord, error) {
+	if refreshToken == "" {
+		return nil, nil, ErrRefreshTokenInvalid
+	}
+
+	rec, err := s.repo.GetRefreshTokenByHash(ctx, hashToken(refreshToken))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if rec == nil {
+		return nil, nil, ErrRefreshTokenInvalid
+	}
+
+	sess, err := s.repo.GetSession(ctx, rec.SessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if sess == nil {
+		return nil, nil, ErrRefreshTokenInvalid
+	}
+
+	return rec, sess, nil
+}
+
+func (s *Sessions) revokeReused(ctx context.Context, sessionID string) error {
+	if err := s.Revoke(ctx, sessionID, RevokedByReuse); err != nil {
+		return err
+	}
+
+	return ErrRefreshTokenReused
+}
+
+// issue stores a new refresh token for the session and signs an access token
+func (s *Sessions) issue(ctx context.Context, sess *repo.SessionRecord, parentID *int64) (*TokenPair, error) {
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	_, err = s.repo.CreateRefreshToken(ctx, &repo.RefreshTokenRecord{
+		SessionID: sess.ID,
+		TokenHash: hashToken(refreshToken),
+		ParentID:  parentID,
+		ExpiresAt: now.Add(s.refreshTTL()),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("store refresh token: %w", err)
+	}
+
+	jti, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	// read on every issue so role changes apply on the next refresh
+	roles, err := s.roles.ListRoles(ctx, sess.TenantID, sess.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("load roles: %w", err)
+	}
+
+	// re-evaluated on every refresh, so removing an entry takes effect. A
+	// client never inherits it: its tokens stay bound to the user's tenant.
+	superAdmin := sess.ClientID == "" && s.cfg.IsSuperAdmin(sess.TenantID, sess.UserID)
+
+	// a client acting for the user gets what both the user and the grant allow
+	scope := GrantedScope(roles, superAdmin)
+	if sess.ClientID != "" {
+		scope = scope.Intersect(sess.Scopes)
+	}
+
+	ttl := s.accessTTL()
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    s.cfg.Issuer(),
+			Subject:   strconv.FormatInt(sess.UserID, 10),
+			Audience:  jwt.ClaimStrings{audience(s.cfg)},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+		TenantID:   sess.TenantID,
+		UserID:     sess.UserID,
+		Email:      sess.Email,
+		SessionID:  sess.ID,
+		ClientID:   sess.ClientID,
+		Scope:      scope,
+		Roles:      roles,
+		SuperAdmin: superAdmin,
+	}
+
+	signed, err := s.keys.Sign(claims)
+	if err != nil {
+		return nil, fmt.Errorf("sign access token: %w", err)
+	}
+
+	return &TokenPair{
+		AccessToken:  signed,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(ttl.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        scope,
+		SessionID:    sess.ID,
+	}, nil
+}
+
+// remember caches a revocation status. A revoked session never comes
+// back, so it is kept as long as its access tokens may still be valid.
+func (s *Sessions) remember(sessionID string, revoked bool) {
+	now := s.now()
+	ttl := s.cacheTTL()
+	if revoked {
+		ttl = max(ttl, s.accessTTL())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.revoked) >= revocationCacheSweep {
+		for id, e := range s.revoked {
+			if !now.Before(e.until) {
+				delete(s.revoked, id)
+			}
+		}
+	}
+
+	s.revoked[sessionID] = revocationEntry{revoked: revoked, until: now.Add(ttl)}
+}
+
+// accessTTL is the lifetime of the access tokens
+func (s *Sessions) accessTTL() time.Duration {
+	if s.cfg.AccessTokenTTL <= 0 {
+		return defaultAccessTokenTTL
+	}
+	return s.cfg.AccessTokenTTL
+}
+
+func (s *Sessions) refreshTTL() time.Duration {
+	if s.cfg.RefreshTokenTTL <= 0 {
+		return defaultRefreshTokenTTL
+	}
+	return s.cfg.RefreshTokenTTL
+}
+
+func (s *Sessions) cacheTTL() time.Duration {
+	if s.cfg.RevocationCacheTTL <= 0 {
+		return defaultRevocationCacheTTL
+	}
+	return s.cfg.RevocationCacheTTL
+}
+
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
@@ -0,0 +1,350 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+)
+
+// stubSessionRepo keeps sessions in memory and counts session lookups
+type stubSessionRepo struct {
+	sessions map[string]*repo.SessionRecord
+	tokens   []*repo.RefreshTokenRecord
+	lookups  int
+	// stolen makes MarkRefreshTokenUsed lose the race
+	stolen bool
+}
+
+func (s *stubSessionRepo) CreateSession(ctx context.Context, rec *repo.SessionRecord) error {
+	s.sessions[rec.ID] = rec
+	return nil
+}
+
+func (s *stubSessionRepo) GetSession(ctx context.Context, id string) (*repo.SessionRecord, error) {
+	s.lookups++
+	if sess, ok := s.sessions[id]; ok {
+		cp := *sess
+		return &cp, nil
+	}
+	return nil, nil
+}
+
+func (s *stubSessionRepo) RevokeSession(ctx context.Context, id, reason string) error {
+	if sess, ok := s.sessions[id]; ok && sess.RevokedAt == nil {
+		now := time.Now()
+		sess.RevokedAt = &now
+		sess.RevokedReason = reason
+	}
+	return nil
+}
+
+func (s *stubSessionRepo) ListActiveSessions(ctx context.Context, tenantID, userID int64) ([]string, error) {
+	var ids []string
+	for id, sess := range s.sessions {
+		if sess.TenantID == tenantID && sess.UserID == userID && sess.RevokedAt == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (s *stubSessionRepo) CreateRefreshToken(ctx context.Context, rec *repo.RefreshTokenRecord) (int64, error) {
+	rec.ID = int64(len(s.tokens) + 1)
+	s.tokens = append(s.tokens, rec)
+	return rec.ID, nil
+}
+
+func (s *stubSessionRepo) GetRefreshTokenByHash(ctx context.Context, hash []byte) (*repo.RefreshTokenRecord, error) {
+	for _, t := range s.tokens {
+		if bytes.Equal(t.TokenHash, hash) {
+			cp := *t
+			return &cp, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *stubSessionRepo) MarkRefreshTokenUsed(ctx context.Context, id int64) (bool, error) {
+	t := s.tokens[id-1]
+	if t.UsedAt != nil || s.stolen {
+		return false, nil
+	}
+	now := time.Now()
+	t.UsedAt = &now
+	return true, nil
+}
+
+// stubRoleRepo keeps user roles in memory, keyed by tenant/user/source
+type stubRoleRepo struct {
+	roles map[string][]string
+}
+
+func (s *stubRoleRepo) key(tenantID, userID int64, source string) string {
+	return fmt.Sprintf("%d/%d/%s", tenantID, userID, source)
+}
+
+func (s *stubRoleRepo) ListRoles(ctx context.Context, tenantID, userID int64) ([]string, error) {
+	var out []string
+	for _, source := range []string{repo.RoleSourceIdP, repo.RoleSourceManual, repo.RoleSourceSCIM} {
+		for _, r := range s.roles[s.key(tenantID, userID, source)] {
+			if !slices.Contains(out, r) {
+				out = append(out, r)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func (s *stubRoleRepo) ListRolesBySource(ctx context.Context, tenantID, userID int64, source string) ([]string, error) {
+	return s.roles[s.key(tenantID, userID, source)], nil
+}
+
+func (s *stubRoleRepo) ReplaceRoles(ctx context.Context, tenantID, userID int64, source string, roles []string) error {
+	s.roles[s.key(tenantID, userID, source)] = roles
+	return nil
+}
+
+// stubUserRepo provisions users in memory, keyed by tenant/idp/subject
+type stubUserRepo struct {
+	users []*repo.UserRecord
+}
+
+func (s *stubUserRepo) UpsertLogin(ctx context.Context, rec *repo.UserRecord) (int64, error) {
+	now := time.Now()
+	for _, u := range s.users {
+		if u.TenantID == rec.TenantID && u.IdPID == rec.IdPID && u.Subject == rec.Subject {
+			u.Email, u.Name, u.Groups, u.LastLoginAt = rec.Email, rec.Name, rec.Groups, &now
+			return u.ID, nil
+		}
+	}
+
+	cp := *rec
+	cp.ID = int64(len(s.users) + 1)
+	cp.Active = true
+	cp.LastLoginAt = &now
+	s.users = append(s.users, &cp)
+	return cp.ID, nil
+}
+
+func (s *stubUserRepo) GetByID(ctx context.Context, tenantID, id int64) (*repo.UserRecord, error) {
+	for _, u := range s.users {
+		if u.TenantID == tenantID && u.ID == id {
+			cp := *u
+			return &cp, nil
+		}
+	}
+	return nil, nil
+}
+
+func newTestSessions(t *testing.T) (*Sessions, *stubSessionRepo, *time.Time) {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := NewKeyringFromSigners(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &stubSessionRepo{sessions: map[string]*repo.SessionRecord{}}
+	s := NewSessions(&config.Config{
+		JWTSecret:          "test-secret",
+		AccessTokenTTL:     time.Minute,
+		RefreshTokenTTL:    time.Hour,
+		RevocationCacheTTL: 10 * time.Second,
+	}, r, &stubUserRepo{}, &stubRoleRepo{roles: map[string][]string{}}, keys)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	return s, r, &now
+}
+
+func TestSessions_StoresOnlyTokenHash(t *testing.T) {
+	s, r, _ := newTestSessions(t)
+
+	pair, err := s.Start(context.Background(), &AuthResult{TenantID: 7, UserID: "u1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if pair.ExpiresIn != 60 || pair.TokenType != "Bearer" {
+		t.Errorf("unexpected token pair %+v", pair)
+	}
+
+	if len(r.tokens) != 1 || bytes.Equal(r.tokens[0].TokenHash, []byte(pair.RefreshToken)) {
+		t.Fatalf("refresh token must be stored hashed")
+	}
+}
+
+func TestSessions_RefreshExpired(t *testing.T) {
+	s, _, now := newTestSessions(t)
+
+	pair, _ := s.Start(context.Background(), &AuthResult{TenantID: 7, UserID: "u1"})
+	*now = now.Add(time.Hour)
+
+	if _, err := s.Refresh(context.Background(), pair.RefreshToken); !errors.Is(err, ErrRefreshTokenExpired) {
+		t.Fatalf("expected ErrRefreshTokenExpired, got %v", err)
+	}
+}
+
+func TestSessions_ConcurrentRefreshRevokes(t *testing.T) {
+	s, r, _ := newTestSessions(t)
+
+	pair, _ := s.Start(context.Background(), &AuthResult{TenantID: 7, UserID: "u1"})
+	r.stolen = true
+
+	if _, err := s.Refresh(context.Background(), pair.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("expected ErrRefreshTokenReused, got %v", err)
+	}
+
+	sess := r.sessions[r.tokens[0].SessionID]
+	if sess.RevokedAt == nil || sess.RevokedReason != RevokedByReuse {
+		t.Errorf("session should be revoked for reuse, got %+v", sess)
+	}
+}
+
+func TestSessions_IsRevokedIsCached(t *testing.T) {
+	s, r, now := newTestSessions(t)
+
+	_, _ = s.Start(context.Background(), &AuthResult{TenantID: 7, UserID: "u1"})
+	sid := r.tokens[0].SessionID
+
+	for range 3 {
+		if revoked, err := s.IsRevoked(context.Background(), sid); err != nil || revoked {
+			t.Fatalf("expected active session, got %v %v", revoked, err)
+		}
+	}
+
+	if r.lookups != 1 {
+		t.Errorf("expected 1 lookup, got %d", r.lookups)
+	}
+
+	// revoked by another replica, seen once the cache entry expires
+	_ = r.RevokeSession(context.Background(), sid, RevokedByLogout)
+	if revoked, _ := s.IsRevoked(context.Background(), sid); revoked {
+		t.Fatal("expected cached status before the cache TTL")
+	}
+
+	*now = now.Add(10 * time.Second)
+	if revoked, _ := s.IsRevoked(context.Background(), sid); !revoked {
+		t.Fatal("expected revoked session after the cache TTL")
+	}
+
+	if revoked, _ := s.IsRevoked(context.Background(), "unknown"); !revoked {
+		t.Error("unknown sessions must be treated as revoked")
+	}
+}
+
+func TestSessions_RolesInToken(t *testing.T) {
+	s, _, _ := newTestSessions(t)
+	s.now = time.Now
+	roles := s.roles.(*stubRoleRepo)
+	_ = roles.ReplaceRoles(context.Background(), 7, 1, repo.RoleSourceManual, []string{RoleOwner})
+	_ = roles.ReplaceRoles(context.Background(), 7, 1, repo.RoleSourceIdP, []string{RoleReadOnly})
+
+	// the IdP roles of the login replace the previous ones
+	pair, err := s.Start(context.Background(), &AuthResult{TenantID: 7, UserID: "u1", Roles: []string{RoleAdmin}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	v := NewTokenValidator(s.cfg, s.keys)
+	claims, err := v.Validate(pair.AccessToken)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !slices.Equal(claims.Roles, []string{RoleAdmin, RoleOwner}) {
+		t.Errorf("unexpected roles %v", claims.Roles)
+	}
+}
+
+func TestSessions_ProvisionsUserOnLogin(t *testing.T) {
+	s, r, _ := newTestSessions(t)
+	s.now = time.Now
+	users := s.users.(*stubUserRepo)
+
+	login := &AuthResult{TenantID: 7, IdPID: 3, UserID: "sub-1", Email: "old@acme.io"}
+	_, _ = s.Start(context.Background(), &AuthResult{TenantID: 7, IdPID: 3, UserID: "sub-0"})
+	_, _ = s.Start(context.Background(), login)
+
+	login.Email = "new@acme.io"
+	pair, err := s.Start(context.Background(), login)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the same subject of another IdP is another user
+	_, _ = s.Start(context.Background(), &AuthResult{TenantID: 7, IdPID: 4, UserID: "sub-1"})
+
+	if len(users.users) != 3 {
+		t.Fatalf("expected 3 users, got %d", len(users.users))
+	}
+
+	if u := users.users[1]; u.Email != "new@acme.io" || u.Subject != "sub-1" {
+		t.Errorf("profile was not updated on login: %+v", u)
+	}
+
+	claims, err := NewTokenValidator(s.cfg, s.keys).Validate(pair.AccessToken)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if claims.UserID != 2 || claims.Subject != "2" {
+		t.Errorf("token must carry the internal user id, got %d %q", claims.UserID, claims.Subject)
+	}
+
+	if sess := r.sessions[claims.SessionID]; sess == nil || sess.UserID != 2 {
+		t.Errorf("session must reference the internal user id, got %+v", sess)
+	}
+}
+
+func TestSessions_RevokeUserAndInactiveUsers(t *testing.T) {
+	s, r, _ := newTestSessions(t)
+	s.now = time.Now
+	users := s.users.(*stubUserRepo)
+
+	login := &AuthResult{TenantID: 7, IdPID: 3, UserID: "sub-1"}
+	first, _ := s.Start(context.Background(), login)
+	second, _ := s.Start(context.Background(), login)
+	other, _ := s.Start(context.Background(), &AuthResult{TenantID: 7, IdPID: 3, UserID: "sub-2"})
+
+	// cache the active status before the user is deprovisioned
+	_, _ = s.IsRevoked(context.Background(), r.tokens[0].SessionID)
+
+	if err := s.RevokeUser(context.Background(), 7, 1, RevokedByDeprovision); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, pair := range []*TokenPair{first, second} {
+		if _, err := s.Refresh(context.Background(), pair.RefreshToken); !errors.Is(err, ErrSessionRevoked) {
+			t.Errorf("expected ErrSessionRevoked, got %v", err)
+		}
+	}
+	if revoked, _ := s.IsRevoked(context.Background(), r.tokens[0].SessionID); !revoked {
+		t.Error("the revocation cache must be updated")
+	}
+	if _, err := s.Refresh(context.Background(), other.RefreshToken); err != nil {
+		t.Errorf("sessions of other users must survive, got %v", err)
+	}
+
+	users.users[0].Active = false
+	if _, err := s.Start(context.Background(), login); !errors.Is(err, ErrUserInactive) {
+		t.Fatalf("expected ErrUserInactive, got %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const defaultLoginStateTTL = 10 * time.Minute
+
+var (
+	ErrStateMissing  = errors.New("login state is missing")
+	ErrStateInvalid  = errors.New("login state is invalid")
+	ErrStateExpired  = errors.New("login state has expired")
+	ErrStateMismatch = errors.New("login state does not match this request")
+	ErrStateReplayed = errors.New("login state was already used")
+	ErrNonceMismatch = errors.New("id_token nonce does not match login state")
+)
+
+// AuthParams carries the per-login values that must round-trip
+// between the authorization request and the callback.
+type AuthParams struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+}
+
+// LoginState is the payload stored in the signed login cookie.
+type LoginState struct {
+	State        string `json:"st"`
+	Nonce        string `json:"nc"`
+	CodeVerifier string `json:"cv"`
+	TenantID     int64  `json:"tid"`
+	// IdP is the slug of the identity provider
+	IdP       string `json:"idp"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Params returns the values the identity provider needs.
+func (s *LoginState) Params() AuthParams {
+	return AuthParams{State: s.State, Nonce: s.Nonce, CodeVerifier: s.CodeVerifier}
+}
+
+// StateManager issues and verifies signed login states. Consumed states
+// are remembered in the shared store until they expire, so a callback can
+// not be replayed, on this replica or another one.
+type StateManager struct {
+	key []byte
+	ttl time.Duration
+	now func() time.Time
+
+	used ReplayStore
+}
+
+func NewStateManager(secret string, ttl time.Duration, used ReplayStore) *StateManager {
+	if ttl <= 0 {
+		ttl = defaultLoginStateTTL
+	}
+
+	return &StateManager{
+		// a dedicated key so the state MAC never equals a JWT signature
+		key:  deriveKey(secret, "login-state"),
+		ttl:  ttl,
+		now:  time.Now,
+		used: used,
+	}
+}
+
+// TTL returns how long a login state stays valid.
+func (m *StateManager) TTL() time.Duration { return m.ttl }
+
+// New creates a fresh login state for the given tenant and provider slug.
+func (m *StateManager) New(tenantID int64, idp string) (*LoginState, error) {
+	state, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginState{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: oauth2.GenerateVerifier(),
+		TenantID:     tenantID,
+		IdP:          idp,
+		ExpiresAt:    m.now().Add(m.ttl).Unix(),
+	}, nil
+}
+
+// Encode serializes and signs the state as payload.signature.
+func (m *StateManager) Encode(s *LoginState) (string, error) {
+	return seal(m.key, s)
+}
+
+// Verify checks the cookie value against the state returned by the IdP
+// and consumes it. A state can only be verified successfully once.
+func (m *StateManager) Verify(ctx context.Context, cookieValue, state string, tenantID int64, idp string) (*LoginState, error) {
+	if cookieValue == "" || state == "" {
+		return nil, ErrStateMissing
+	}
+
+	var ls LoginState
+	if !unseal(m.key, cookieValue, &ls) {
+		return nil, ErrStateInvalid
+	}
+
+	now := m.now().Unix()
+	if now >= ls.ExpiresAt {
+		return nil, ErrStateExpired
+	}
+
+	if subtle.ConstantTimeCompare([]byte(ls.State), []byte(state)) != 1 ||
+		ls.TenantID != tenantID || ls.IdP != idp {
+		return nil, ErrStateMismatch
+	}
+
+	ok, err := m.used.Use(ctx, "login-state:"+ls.State, time.Unix(ls.ExpiresAt, 0))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrStateReplayed
+	}
+
+	return &ls, nil
+}
+
+// deriveKey derives a MAC key dedicated to one purpose from the secret
+func deriveKey(secret, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// seal serializes v and signs it as payload.signature
+func seal(key []byte, v any) (string, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	p := base64.RawURLEncoding.EncodeToString(payload)
+	return p + "." + base64.RawURLEncoding.EncodeToString(sign(key, p)), nil
+}
+
+// unseal verifies the signature of a sealed value and decodes it into v
+func unseal(key []byte, value string, v any) bool {
+	p, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return false
+	}
+
+	gotSig, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(gotSig, sign(key, p)) {
+		return false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(p)
+	if err != nil {
+		return false
+	}
+
+	return json.Unmarshal(payload, v) == nil
+}
+
+func sign(key []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
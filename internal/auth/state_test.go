@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStateManager_RoundTrip(t *testing.T) {
+	m := NewStateManager("secret", time.Minute, newReplayCache())
+
+	ls, err := m.New(7, "oidc")
+	if err != nil {
+		t.Fatalf("failed to create state: %v", err)
+	}
+
+	value, err := m.Encode(ls)
+	if err != nil {
+		t.Fatalf("failed to encode state: %v", err)
+	}
+
+	got, err := m.Verify(context.Background(), value, ls.State, 7, "oidc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got.Nonce != ls.Nonce || got.CodeVerifier != ls.CodeVerifier {
+		t.Errorf("decoded state differs from issued state")
+	}
+
+	if _, err := m.Verify(context.Background(), value, ls.State, 7, "oidc"); !errors.Is(err, ErrStateReplayed) {
+		t.Errorf("expected ErrStateReplayed, got %v", err)
+	}
+}
+
+func TestStateManager_Tampered(t *testing.T) {
+	m := NewStateManager("secret", time.Minute, newReplayCache())
+	ls, _ := m.New(7, "oidc")
+	value, _ := m.Encode(ls)
+
+	other := NewStateManager("other-secret", time.Minute, newReplayCache())
+	if _, err := other.Verify(context.Background(), value, ls.State, 7, "oidc"); !errors.Is(err, ErrStateInvalid) {
+		t.Errorf("expected ErrStateInvalid, got %v", err)
+	}
+
+	if _, err := m.Verify(context.Background(), value, ls.State, 8, "oidc"); !errors.Is(err, ErrStateMismatch) {
+		t.Errorf("expected ErrStateMismatch for another tenant, got %v", err)
+	}
+}
+
+func TestStateManager_Expired(t *testing.T) {
+	m := NewStateManager("secret", time.Minute, newReplayCache())
+	ls, _ := m.New(7, "oidc")
+	value, _ := m.Encode(ls)
+
+	m.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+
+	if _, err := m.Verify(context.Background(), value, ls.State, 7, "oidc"); !errors.Is(err, ErrStateExpired) {
+		t.Errorf("expected ErrStateExpired, got %v", err)
+	}
+}
+
+func TestStateManager_Missing(t *testing.T) {
+	m := NewStateManager("secret", time.Minute, newReplayCache())
+
+	if _, err := m.Verify(context.Background(), "", "state", 7, "oidc"); !errors.Is(err, ErrStateMissing) {
+		t.Errorf("expected ErrStateMissing, got %v", err)
+	}
+}
+
+func TestStateManager_ReplayedOnAnotherReplica(t *testing.T) {
+	used := NewReplayStore(newStubUsedTokens())
+	a := NewStateManager("secret", time.Minute, used)
+	b := NewStateManager("secret", time.Minute, used)
+
+	ls, _ := a.New(7, "oidc")
+	value, _ := a.Encode(ls)
+
+	if _, err := a.Verify(context.Background(), value, ls.State, 7, "oidc"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := b.Verify(context.Background(), value, ls.State, 7, "oidc"); !errors.Is(err, ErrStateReplayed) {
+		t.Errorf("expected ErrStateReplayed, got %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultAudience  = "crm-api"
+	defaultClockSkew = 30 * time.Second
+
+	claimsContextKey = "auth.claims"
+)
+
+var (
+	ErrMissingTenant = errors.New("token has no tenant_id")
+	ErrMissingUser   = errors.New("token has no user_id")
+)
+
+// Claims are the claims of a CRM access token.
+type Claims struct {
+	jwt.RegisteredClaims
+	TenantID  int64  `json:"tenant_id"`
+	UserID    int64  `json:"user_id"`
+	Email     string `json:"email,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+	// Roles are the tenant roles of the user
+	Roles []string `json:"roles,omitempty"`
+	// SuperAdmin may administer every tenant
+	SuperAdmin bool `json:"super_admin,omitempty"`
+
+	// APIKeyID is set when the request was authenticated by an API key
+	APIKeyID int64 `json:"api_key_id,omitempty"`
+	// ClientID is the OAuth client the token was issued to
+	ClientID string `json:"client_id,omitempty"`
+	// Scope is what the token may do. For a user it follows the roles, for
+	// an API key or OAuth client it is limited to what they were granted.
+	Scope Scope `json:"scope,omitempty"`
+
+	// Act is the super admin impersonating the user, if any
+	Act *Actor `json:"act,omitempty"`
+}
+
+// IsAPIKey reports whether the claims belong to an API key
+func (c *Claims) IsAPIKey() bool {
+	return c.APIKeyID != 0
+}
+
+// IsImpersonation reports whether a super admin is acting as the user
+func (c *Claims) IsImpersonation() bool {
+	return c.Act != nil
+}
+
+// Validate enforces the application claims, it runs after the registered
+// claims were validated by the parser.
+func (c *Claims) Validate() error {
+	if c.TenantID <= 0 {
+		return ErrMissingTenant
+	}
+
+	// only the tokens of an OAuth client acting on its own have no user
+	if c.UserID <= 0 && c.ClientID == "" {
+		return ErrMissingUser
+	}
+
+	return nil
+}
+
+// TokenValidator verifies the signature and the claims of access tokens.
+type TokenValidator struct {
+	keys   *Keyring
+	parser *jwt.Parser
+}
+
+func NewTokenValidator(cfg *config.Config, keys *Keyring) *TokenValidator {
+	return &TokenValidator{
+		keys: keys,
+		parser: jwt.NewParser(
+			jwt.WithValidMethods(keys.Algorithms()),
+			jwt.WithIssuer(cfg.Issuer()),
+			jwt.WithAudience(audience(cfg)),
+			jwt.WithLeeway(clockSkew(cfg)),
+			jwt.WithExpirationRequired(),
+			jwt.WithIssuedAt(),
+		),
+	}
+}
+
+// Validate parses a signed access token and returns its claims
+func (v *TokenValidator) Validate(token string) (*Claims, error) {
+	claims := &Claims{}
+	if _, err := v.parser.ParseWithClaims(token, claims, v.keys.Keyfunc); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// NewContext stores the claims of the authenticated request
+func NewContext(c echo.Context, claims *Claims) {
+	c.Set(claimsContextKey, claims)
+}
+
+// FromContext returns the claims stored by the JWT middleware
+func FromContext(c echo.Context) (*Claims, bool) {
+	claims, ok := c.Get(claimsContextKey).(*Claims)
+	return claims, ok && claims != nil
+}
+
+// audience returns the "aud" of the tokens issued by the CRM
+func audience(cfg *config.Config) string {
+	if cfg.JWTAudience == "" {
+		return defaultAudience
+	}
+	return cfg.JWTAudience
+}
+
+// clockSkew returns the leeway allowed on exp, nbf and iat
+func clockSkew(cfg *config.Config) time.Duration {
+	if cfg.JWTClockSkew <= 0 {
+		return defaultClockSkew
+	}
+	return cfg.JWTClockSkew
+}
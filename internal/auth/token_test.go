@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+)
+
+func newTestValidator(t *testing.T) (*TokenValidator, *Keyring) {
+	t.Helper()
+
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	keys, err := NewKeyringFromSigners(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{BaseURL: "https://crm.example.com", JWTClockSkew: 30 * time.Second}
+	return NewTokenValidator(cfg, keys), keys
+}
+
+func validClaims() *Claims {
+	now := time.Now()
+	return &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "https://crm.example.com",
+			Audience:  jwt.ClaimStrings{"crm-api"},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+		TenantID: 7,
+		UserID:   1,
+	}
+}
+
+func TestTokenValidator_Claims(t *testing.T) {
+	v, keys := newTestValidator(t)
+
+	cases := []struct {
+		name   string
+		modify func(c *Claims)
+		want   error
+	}{
+		{"valid", func(c *Claims) {}, nil},
+		{"wrong issuer", func(c *Claims) { c.Issuer = "https://evil.example.com" }, jwt.ErrTokenInvalidIssuer},
+		{"wrong audience", func(c *Claims) { c.Audience = jwt.ClaimStrings{"billing"} }, jwt.ErrTokenInvalidAudience},
+		{"missing audience", func(c *Claims) { c.Audience = nil }, jwt.ErrTokenRequiredClaimMissing},
+		{"missing exp", func(c *Claims) { c.ExpiresAt = nil }, jwt.ErrTokenRequiredClaimMissing},
+		{"expired beyond skew", func(c *Claims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute)) }, jwt.ErrTokenExpired},
+		{"expired within skew", func(c *Claims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-10 * time.Second)) }, nil},
+		{"not yet valid", func(c *Claims) { c.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Minute)) }, jwt.ErrTokenNotValidYet},
+		{"not yet valid within skew", func(c *Claims) { c.NotBefore = jwt.NewNumericDate(time.Now().Add(10 * time.Second)) }, nil},
+		{"missing tenant", func(c *Claims) { c.TenantID = 0 }, ErrMissingTenant},
+		{"missing user", func(c *Claims) { c.UserID = 0 }, ErrMissingUser},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			claims := validClaims()
+			tc.modify(claims)
+
+			signed, err := keys.Sign(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := v.Validate(signed)
+			if tc.want == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if got.TenantID != 7 {
+					t.Errorf("expected tenant 7, got %d", got.TenantID)
+				}
+				return
+			}
+
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
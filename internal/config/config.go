@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 )
@@ -19,6 +21,8 @@ type MySQLConfig struct {
 func (c MySQLConfig) dsn(host string) string {
 	query := url.Values{
 		"parseTime": []string{"true"},
+		// RowsAffected reports matched rows, not only the changed ones
+		"clientFoundRows": []string{"true"},
 	}
 
 	return fmt.Sprintf("%s:%s@tcp(%s:%s)/crmcore?%s",
@@ -61,14 +65,88 @@ func (c PGConfig) ReadDSN() string {
 	return c.dsn(c.ReadHost)
 }
 
+// VaultConfig points to the Vault transit key that wraps the data keys of
+// the secrets
+type VaultConfig struct {
+	Addr         string `envconfig:"VAULT_ADDR"`
+	Token        string `envconfig:"VAULT_TOKEN"`
+	Namespace    string `envconfig:"VAULT_NAMESPACE"`
+	TransitMount string `envconfig:"VAULT_TRANSIT_MOUNT" default:"transit"`
+	TransitKey   string `envconfig:"VAULT_TRANSIT_KEY"`
+}
+
 type Config struct {
 	MySQLConfig MySQLConfig
 	PGConfig    PGConfig
 	LogLevel    string `envconfig:"LOG_LEVEL" default:"info"`
 
-	BaseURL       string `envconfig:"BASE_URL" default:"localhost:8080"`
-	EncryptionKey string `envconfig:"ENCRYPTION_KEY" required:"true"`
-	JWTSecret     string `envconfig:"JWT_SECRET" required:"true"`
+	BaseURL   string `envconfig:"BASE_URL" default:"localhost:8080"`
+	JWTSecret string `envconfig:"JWT_SECRET" required:"true"`
+
+	// Secrets are encrypted with a data key of their own, wrapped by a key
+	// encryption key (KEK): the Vault transit key, when VAULT_ADDR is set,
+	// or local KEKs read from files as "<id>:<path>".
+	Vault              VaultConfig
+	EncryptionKEKFiles []string `envconfig:"ENCRYPTION_KEK_FILES"`
+	// Id of the KEK that wraps new data keys. Defaults to Vault, then to
+	// the first KEK file, ENCRYPTION_KEYS and ENCRYPTION_KEY.
+	EncryptionPrimaryKey string `envconfig:"ENCRYPTION_PRIMARY_KEY"`
+
+	// Deprecated: keys kept in the environment. They still read the secrets
+	// they encrypted and act as KEKs until those are re-encrypted.
+	// ENCRYPTION_KEY is the "default" key, which reads the secrets encrypted
+	// before key ids existed; ENCRYPTION_KEYS are "<id>:<base64 key>".
+	EncryptionKey  string   `envconfig:"ENCRYPTION_KEY"`
+	EncryptionKeys []string `envconfig:"ENCRYPTION_KEYS"`
+
+	LoginStateTTL   time.Duration `envconfig:"LOGIN_STATE_TTL" default:"10m"`
+	IDPPollInterval time.Duration `envconfig:"IDP_POLL_INTERVAL" default:"30s"`
+
+	// PEM private keys (RSA or Ed25519) that sign the access tokens. All
+	// of them are published in the JWKS, the first one signs.
+	JWTSigningKeyFiles []string `envconfig:"JWT_SIGNING_KEY_FILES"`
+	// Development only: sign with a key generated at startup when there are
+	// no key files. Each replica and each restart gets a different key.
+	JWTEphemeralKey bool `envconfig:"JWT_EPHEMERAL_KEY" default:"false"`
+	// Issuer of the access tokens, defaults to BASE_URL
+	JWTIssuer string `envconfig:"JWT_ISSUER"`
+	// Audience of the access tokens and leeway allowed on their timestamps
+	JWTAudience  string        `envconfig:"JWT_AUDIENCE" default:"crm-api"`
+	JWTClockSkew time.Duration `envconfig:"JWT_CLOCK_SKEW" default:"30s"`
+
+	// Users allowed to administer every tenant, as "<tenant_id>:<user_id>"
+	SuperAdmins []string `envconfig:"SUPER_ADMINS"`
+
+	AccessTokenTTL     time.Duration `envconfig:"ACCESS_TOKEN_TTL" default:"15m"`
+	RefreshTokenTTL    time.Duration `envconfig:"REFRESH_TOKEN_TTL" default:"720h"`
+	RevocationCacheTTL time.Duration `envconfig:"REVOCATION_CACHE_TTL" default:"30s"`
+
+	// Longest lifetime of the tokens super admins mint to act as a user
+	ImpersonationTTL time.Duration `envconfig:"IMPERSONATION_TTL" default:"15m"`
+
+	// Optional SP key pair used to decrypt SAML assertions
+	SAMLCertFile string `envconfig:"SAML_SP_CERT_FILE"`
+	SAMLKeyFile  string `envconfig:"SAML_SP_KEY_FILE"`
+}
+
+// Issuer returns the "iss" of the tokens issued by the CRM
+func (c *Config) Issuer() string {
+	if c.JWTIssuer != "" {
+		return c.JWTIssuer
+	}
+	return strings.TrimSuffix(c.BaseURL, "/")
+}
+
+// IsSuperAdmin reports whether the user is listed in SUPER_ADMINS, as
+// "tenant_id:user_id" pairs of CRM ids.
+func (c *Config) IsSuperAdmin(tenantID, userID int64) bool {
+	want := fmt.Sprintf("%d:%d", tenantID, userID)
+	for _, s := range c.SuperAdmins {
+		if strings.TrimSpace(s) == want {
+			return true
+		}
+	}
+	return false
 }
 
 func New() (*Config, error) {
@@ -0,0 +1,6 @@
+-- migrations/mysql/00005_add_identity_provider_oidc_settings.down.sql
+ALTER TABLE `identity_providers`
+  DROP COLUMN `redirect_url`,
+  DROP COLUMN `auth_params`,
+  DROP COLUMN `claim_mapping`,
+  DROP COLUMN `scopes`;
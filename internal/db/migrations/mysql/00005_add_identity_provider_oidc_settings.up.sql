@@ -0,0 +1,6 @@
+-- migrations/mysql/00005_add_identity_provider_oidc_settings.up.sql
+ALTER TABLE `identity_providers`
+  ADD COLUMN `scopes`        JSON NULL AFTER `client_secret_enc`,
+  ADD COLUMN `claim_mapping` JSON NULL AFTER `scopes`,
+  ADD COLUMN `auth_params`   JSON NULL AFTER `claim_mapping`,
+  ADD COLUMN `redirect_url`  VARCHAR(512) NULL AFTER `auth_params`;
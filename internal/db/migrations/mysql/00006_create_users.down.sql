@@ -0,0 +1,2 @@
+-- migrations/mysql/00006_create_users.down.sql
+DROP TABLE IF EXISTS users;
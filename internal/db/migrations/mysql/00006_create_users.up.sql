@@ -0,0 +1,17 @@
+-- migrations/mysql/00006_create_users.up.sql
+CREATE TABLE IF NOT EXISTS users (
+  id             BIGINT AUTO_INCREMENT PRIMARY KEY,
+  tenant_id      BIGINT NOT NULL,
+  idp_id         BIGINT NOT NULL,
+  subject        VARCHAR(255) NOT NULL,
+  email          VARCHAR(255) NOT NULL DEFAULT '',
+  name           VARCHAR(255) NOT NULL DEFAULT '',
+  `groups`       JSON NULL,
+  last_login_at  TIMESTAMP NULL,
+  created_at     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  UNIQUE INDEX idx_users_tenant_idp_subject (tenant_id, idp_id, subject),
+  INDEX idx_users_tenant_email (tenant_id, email),
+  CONSTRAINT fk_users_tenants FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,3 @@
+-- migrations/mysql/00007_create_auth_sessions.down.sql
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS auth_sessions;
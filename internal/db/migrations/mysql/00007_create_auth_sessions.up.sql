@@ -0,0 +1,27 @@
+-- migrations/mysql/00007_create_auth_sessions.up.sql
+CREATE TABLE IF NOT EXISTS auth_sessions (
+  id              VARCHAR(64) NOT NULL PRIMARY KEY,
+  tenant_id       BIGINT NOT NULL,
+  user_id         BIGINT NOT NULL,
+  email           VARCHAR(255) NOT NULL DEFAULT '',
+  created_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  revoked_at      TIMESTAMP NULL,
+  revoked_reason  VARCHAR(64) NULL,
+
+  INDEX idx_auth_sessions_tenant_user (tenant_id, user_id),
+  CONSTRAINT fk_auth_sessions_tenants FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE,
+  CONSTRAINT fk_auth_sessions_users FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+  id          BIGINT AUTO_INCREMENT PRIMARY KEY,
+  session_id  VARCHAR(64) NOT NULL,
+  token_hash  BINARY(32) NOT NULL,
+  parent_id   BIGINT NULL,
+  expires_at  TIMESTAMP NOT NULL,
+  used_at     TIMESTAMP NULL,
+  created_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  UNIQUE INDEX idx_refresh_tokens_hash (token_hash),
+  CONSTRAINT fk_refresh_tokens_sessions FOREIGN KEY (session_id) REFERENCES auth_sessions(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
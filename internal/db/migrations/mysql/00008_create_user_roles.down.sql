@@ -0,0 +1,5 @@
+-- migrations/mysql/00008_create_user_roles.down.sql
+DROP TABLE IF EXISTS user_roles;
+
+ALTER TABLE `identity_providers`
+  DROP COLUMN `role_mapping`;
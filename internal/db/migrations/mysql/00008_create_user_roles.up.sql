@@ -0,0 +1,15 @@
+-- migrations/mysql/00008_create_user_roles.up.sql
+ALTER TABLE `identity_providers`
+  ADD COLUMN `role_mapping` JSON NULL AFTER `claim_mapping`;
+
+CREATE TABLE IF NOT EXISTS user_roles (
+  tenant_id   BIGINT NOT NULL,
+  user_id     BIGINT NOT NULL,
+  role        VARCHAR(32) NOT NULL,
+  source      ENUM('idp', 'manual') NOT NULL,
+  created_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (tenant_id, user_id, role, source),
+  CONSTRAINT fk_user_roles_tenants FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE,
+  CONSTRAINT fk_user_roles_users FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
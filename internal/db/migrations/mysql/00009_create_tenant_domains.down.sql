@@ -0,0 +1,2 @@
+-- migrations/mysql/00009_create_tenant_domains.down.sql
+DROP TABLE IF EXISTS tenant_domains;
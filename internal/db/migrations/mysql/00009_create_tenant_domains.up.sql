@@ -0,0 +1,16 @@
+-- migrations/mysql/00009_create_tenant_domains.up.sql
+CREATE TABLE IF NOT EXISTS tenant_domains (
+  id                  BIGINT AUTO_INCREMENT PRIMARY KEY,
+  tenant_id           BIGINT NOT NULL,
+  domain              VARCHAR(255) NOT NULL,
+  verification_token  VARCHAR(64) NOT NULL,
+  verified_at         TIMESTAMP NULL,
+  created_at          TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  -- a domain may be claimed by several tenants, but verified by only one
+  verified_domain     VARCHAR(255) AS (IF(verified_at IS NULL, NULL, domain)) STORED,
+
+  UNIQUE INDEX idx_tenant_domains_tenant_domain (tenant_id, domain),
+  UNIQUE INDEX idx_tenant_domains_verified (verified_domain),
+  CONSTRAINT fk_tenant_domains_tenants FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,4 @@
+-- migrations/mysql/00010_add_tenant_custom_host.down.sql
+ALTER TABLE `tenants`
+  DROP INDEX `idx_tenants_custom_host`,
+  DROP COLUMN `custom_host`;
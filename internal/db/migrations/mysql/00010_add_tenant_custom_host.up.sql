@@ -0,0 +1,4 @@
+-- migrations/mysql/00010_add_tenant_custom_host.up.sql
+ALTER TABLE `tenants`
+  ADD COLUMN `custom_host` VARCHAR(255) NULL AFTER `domain`,
+  ADD UNIQUE INDEX `idx_tenants_custom_host` (`custom_host`);
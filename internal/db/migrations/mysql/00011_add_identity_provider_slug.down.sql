@@ -0,0 +1,4 @@
+-- migrations/mysql/00011_add_identity_provider_slug.down.sql
+ALTER TABLE `identity_providers`
+  DROP INDEX `idx_identity_providers_tenant_slug`,
+  DROP COLUMN `slug`;
@@ -0,0 +1,15 @@
+-- migrations/mysql/00011_add_identity_provider_slug.up.sql
+ALTER TABLE `identity_providers`
+  ADD COLUMN `slug` VARCHAR(64) NULL AFTER `type`;
+
+-- the first provider of each type keeps the URLs it had, /<tenant>/<type>/...
+UPDATE `identity_providers` SET `slug` = `type`;
+
+UPDATE `identity_providers` p
+  JOIN `identity_providers` earlier
+    ON earlier.tenant_id = p.tenant_id AND earlier.type = p.type AND earlier.id < p.id
+  SET p.slug = CONCAT(p.type, '-', p.id);
+
+ALTER TABLE `identity_providers`
+  MODIFY COLUMN `slug` VARCHAR(64) NOT NULL,
+  ADD UNIQUE INDEX `idx_identity_providers_tenant_slug` (`tenant_id`, `slug`);
@@ -0,0 +1,2 @@
+-- migrations/mysql/00012_create_api_keys.down.sql
+DROP TABLE IF EXISTS api_keys;
@@ -0,0 +1,19 @@
+-- migrations/mysql/00012_create_api_keys.up.sql
+CREATE TABLE IF NOT EXISTS api_keys (
+  id            BIGINT AUTO_INCREMENT PRIMARY KEY,
+  tenant_id     BIGINT NOT NULL,
+  name          VARCHAR(255) NOT NULL,
+  prefix        VARCHAR(16) NOT NULL,
+  key_hash      BINARY(32) NOT NULL,
+  scopes        JSON NOT NULL,
+  created_by    BIGINT NULL,
+  expires_at    TIMESTAMP NULL,
+  last_used_at  TIMESTAMP NULL,
+  revoked_at    TIMESTAMP NULL,
+  created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  UNIQUE INDEX idx_api_keys_hash (key_hash),
+  INDEX idx_api_keys_tenant (tenant_id),
+  CONSTRAINT fk_api_keys_tenants FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE,
+  CONSTRAINT fk_api_keys_users FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
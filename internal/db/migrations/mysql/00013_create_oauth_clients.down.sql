@@ -0,0 +1,10 @@
+-- migrations/mysql/00013_create_oauth_clients.down.sql
+DELETE FROM auth_sessions WHERE client_id IS NOT NULL;
+
+ALTER TABLE auth_sessions
+  DROP FOREIGN KEY fk_auth_sessions_oauth_clients,
+  DROP COLUMN scopes,
+  DROP COLUMN client_id;
+
+DROP TABLE IF EXISTS oauth_authorization_codes;
+DROP TABLE IF EXISTS oauth_clients;
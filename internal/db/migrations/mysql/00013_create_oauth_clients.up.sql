@@ -0,0 +1,47 @@
+-- migrations/mysql/00013_create_oauth_clients.up.sql
+CREATE TABLE IF NOT EXISTS oauth_clients (
+  id             BIGINT AUTO_INCREMENT PRIMARY KEY,
+  tenant_id      BIGINT NOT NULL,
+  client_id      VARCHAR(64) NOT NULL,
+  -- NULL for public clients, which authenticate with PKCE only
+  secret_hash    BINARY(32) NULL,
+  name           VARCHAR(255) NOT NULL,
+  redirect_uris  JSON NOT NULL,
+  grant_types    JSON NOT NULL,
+  scopes         JSON NOT NULL,
+  created_by     BIGINT NULL,
+  created_at     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  UNIQUE INDEX idx_oauth_clients_client_id (client_id),
+  INDEX idx_oauth_clients_tenant (tenant_id),
+  CONSTRAINT fk_oauth_clients_tenants FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE,
+  CONSTRAINT fk_oauth_clients_users FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS oauth_authorization_codes (
+  id              BIGINT AUTO_INCREMENT PRIMARY KEY,
+  code_hash       BINARY(32) NOT NULL,
+  client_id       VARCHAR(64) NOT NULL,
+  tenant_id       BIGINT NOT NULL,
+  user_id         BIGINT NOT NULL,
+  redirect_uri    VARCHAR(2048) NOT NULL,
+  scopes          JSON NOT NULL,
+  code_challenge  VARCHAR(128) NOT NULL,
+  -- session opened by the exchange, revoked if the code is replayed
+  session_id      VARCHAR(64) NULL,
+  expires_at      TIMESTAMP NOT NULL,
+  used_at         TIMESTAMP NULL,
+  created_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  UNIQUE INDEX idx_oauth_codes_hash (code_hash),
+  CONSTRAINT fk_oauth_codes_clients FOREIGN KEY (client_id) REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+  CONSTRAINT fk_oauth_codes_users FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- sessions opened for an OAuth client carry the client and the granted scopes;
+-- deleting the client ends them
+ALTER TABLE auth_sessions
+  ADD COLUMN client_id VARCHAR(64) NULL AFTER email,
+  ADD COLUMN scopes JSON NULL AFTER client_id,
+  ADD CONSTRAINT fk_auth_sessions_oauth_clients FOREIGN KEY (client_id) REFERENCES oauth_clients(client_id) ON DELETE CASCADE;
@@ -0,0 +1,3 @@
+-- migrations/mysql/00014_create_impersonations.down.sql
+DROP TABLE IF EXISTS impersonation_requests;
+DROP TABLE IF EXISTS impersonations;
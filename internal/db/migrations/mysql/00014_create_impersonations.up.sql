@@ -0,0 +1,30 @@
+-- migrations/mysql/00014_create_impersonations.up.sql
+CREATE TABLE IF NOT EXISTS impersonations (
+  id               BIGINT AUTO_INCREMENT PRIMARY KEY,
+  token_id         VARCHAR(64) NOT NULL,
+  tenant_id        BIGINT NOT NULL,
+  user_id          BIGINT NOT NULL,
+  actor_tenant_id  BIGINT NOT NULL,
+  actor_user_id    BIGINT NOT NULL,
+  actor_email      VARCHAR(255) NOT NULL DEFAULT '',
+  reason           VARCHAR(500) NOT NULL,
+  expires_at       TIMESTAMP NOT NULL,
+  created_at       TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  UNIQUE INDEX idx_impersonations_token (token_id),
+  INDEX idx_impersonations_tenant (tenant_id, created_at),
+  CONSTRAINT fk_impersonations_tenants FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS impersonation_requests (
+  id                BIGINT AUTO_INCREMENT PRIMARY KEY,
+  impersonation_id  BIGINT NOT NULL,
+  method            VARCHAR(16) NOT NULL,
+  path              VARCHAR(2048) NOT NULL,
+  remote_ip         VARCHAR(64) NOT NULL DEFAULT '',
+  user_agent        VARCHAR(512) NOT NULL DEFAULT '',
+  created_at        TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  INDEX idx_impersonation_requests_impersonation (impersonation_id, id),
+  CONSTRAINT fk_impersonation_requests_impersonations FOREIGN KEY (impersonation_id) REFERENCES impersonations(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,3 @@
+-- migrations/mysql/00015_add_identity_provider_login_policy.down.sql
+ALTER TABLE `identity_providers`
+  DROP COLUMN `login_policy`;
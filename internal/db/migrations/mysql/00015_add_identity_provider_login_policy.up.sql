@@ -0,0 +1,3 @@
+-- migrations/mysql/00015_add_identity_provider_login_policy.up.sql
+ALTER TABLE `identity_providers`
+  ADD COLUMN `login_policy` JSON NULL AFTER `auth_params`;
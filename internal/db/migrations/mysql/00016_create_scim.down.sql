@@ -0,0 +1,14 @@
+-- migrations/mysql/00016_create_scim.down.sql
+DROP TABLE IF EXISTS scim_group_members;
+DROP TABLE IF EXISTS scim_groups;
+DROP TABLE IF EXISTS scim_tokens;
+
+DELETE FROM user_roles WHERE source = 'scim';
+ALTER TABLE user_roles
+  MODIFY COLUMN source ENUM('idp', 'manual') NOT NULL;
+
+ALTER TABLE users
+  DROP INDEX idx_users_tenant_idp_user_name,
+  DROP COLUMN active,
+  DROP COLUMN external_id,
+  DROP COLUMN user_name;
@@ -0,0 +1,54 @@
+-- migrations/mysql/00016_create_scim.up.sql
+ALTER TABLE users
+  ADD COLUMN user_name    VARCHAR(255) NULL AFTER subject,
+  ADD COLUMN external_id  VARCHAR(255) NULL AFTER user_name,
+  ADD COLUMN active       TINYINT(1) NOT NULL DEFAULT 1 AFTER `groups`,
+  ADD UNIQUE INDEX idx_users_tenant_idp_user_name (tenant_id, idp_id, user_name);
+
+-- roles mapped from the SCIM groups, kept apart from those of the login
+ALTER TABLE user_roles
+  MODIFY COLUMN source ENUM('idp', 'manual', 'scim') NOT NULL;
+
+-- bearer tokens of the SCIM clients, each one provisions the users of an IdP
+CREATE TABLE IF NOT EXISTS scim_tokens (
+  id            BIGINT AUTO_INCREMENT PRIMARY KEY,
+  tenant_id     BIGINT NOT NULL,
+  idp_id        BIGINT NOT NULL,
+  name          VARCHAR(255) NOT NULL,
+  prefix        VARCHAR(16) NOT NULL,
+  token_hash    BINARY(32) NOT NULL,
+  created_by    BIGINT NULL,
+  last_used_at  TIMESTAMP NULL,
+  revoked_at    TIMESTAMP NULL,
+  created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  UNIQUE INDEX idx_scim_tokens_hash (token_hash),
+  INDEX idx_scim_tokens_tenant (tenant_id),
+  CONSTRAINT fk_scim_tokens_tenants FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE,
+  CONSTRAINT fk_scim_tokens_identity_providers FOREIGN KEY (idp_id) REFERENCES identity_providers(id) ON DELETE CASCADE,
+  CONSTRAINT fk_scim_tokens_users FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS scim_groups (
+  id            BIGINT AUTO_INCREMENT PRIMARY KEY,
+  tenant_id     BIGINT NOT NULL,
+  idp_id        BIGINT NOT NULL,
+  display_name  VARCHAR(255) NOT NULL,
+  external_id   VARCHAR(255) NULL,
+  created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  UNIQUE INDEX idx_scim_groups_display_name (tenant_id, idp_id, display_name),
+  CONSTRAINT fk_scim_groups_tenants FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE,
+  CONSTRAINT fk_scim_groups_identity_providers FOREIGN KEY (idp_id) REFERENCES identity_providers(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS scim_group_members (
+  group_id  BIGINT NOT NULL,
+  user_id   BIGINT NOT NULL,
+
+  PRIMARY KEY (group_id, user_id),
+  INDEX idx_scim_group_members_user (user_id),
+  CONSTRAINT fk_scim_group_members_groups FOREIGN KEY (group_id) REFERENCES scim_groups(id) ON DELETE CASCADE,
+  CONSTRAINT fk_scim_group_members_users FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
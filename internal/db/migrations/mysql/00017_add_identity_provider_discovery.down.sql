@@ -0,0 +1,3 @@
+-- migrations/mysql/00017_add_identity_provider_discovery.down.sql
+ALTER TABLE `identity_providers`
+  DROP COLUMN `discovery`;
@@ -0,0 +1,3 @@
+-- migrations/mysql/00017_add_identity_provider_discovery.up.sql
+ALTER TABLE `identity_providers`
+  ADD COLUMN `discovery` JSON NULL AFTER `login_policy`;
@@ -0,0 +1,3 @@
+-- migrations/mysql/00018_widen_identity_provider_secret.down.sql
+ALTER TABLE `identity_providers`
+  MODIFY COLUMN `client_secret_enc` VARBINARY(512) NOT NULL;
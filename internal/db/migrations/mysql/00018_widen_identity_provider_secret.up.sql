@@ -0,0 +1,3 @@
+-- migrations/mysql/00018_widen_identity_provider_secret.up.sql
+ALTER TABLE `identity_providers`
+  MODIFY COLUMN `client_secret_enc` VARBINARY(1024) NOT NULL;
@@ -0,0 +1,4 @@
+-- migrations/mysql/00019_add_identity_provider_secret_hint.down.sql
+ALTER TABLE `identity_providers`
+  DROP COLUMN `secret_updated_at`,
+  DROP COLUMN `client_secret_hint`;
@@ -0,0 +1,6 @@
+-- migrations/mysql/00019_add_identity_provider_secret_hint.up.sql
+ALTER TABLE `identity_providers`
+  ADD COLUMN `client_secret_hint` VARCHAR(16) NULL AFTER `client_secret_enc`,
+  ADD COLUMN `secret_updated_at`  TIMESTAMP NULL AFTER `client_secret_hint`;
+
+UPDATE `identity_providers` SET `secret_updated_at` = `updated_at`;
@@ -0,0 +1,3 @@
+-- migrations/mysql/00020_add_identity_provider_revision.down.sql
+ALTER TABLE `identity_providers`
+  DROP COLUMN `revision`;
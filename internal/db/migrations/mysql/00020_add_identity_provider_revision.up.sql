@@ -0,0 +1,3 @@
+-- migrations/mysql/00020_add_identity_provider_revision.up.sql
+ALTER TABLE `identity_providers`
+  ADD COLUMN `revision` BIGINT UNSIGNED NOT NULL DEFAULT 0 AFTER `enabled`;
@@ -0,0 +1,2 @@
+-- migrations/mysql/00021_create_used_tokens.down.sql
+DROP TABLE IF EXISTS used_tokens;
@@ -0,0 +1,7 @@
+-- migrations/mysql/00021_create_used_tokens.up.sql
+CREATE TABLE IF NOT EXISTS used_tokens (
+  token_hash  BINARY(32) NOT NULL PRIMARY KEY,
+  expires_at  TIMESTAMP NOT NULL,
+
+  INDEX idx_used_tokens_expires (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,394 @@
+// Package encryption encrypts the application secrets stored in the
+// database, such as the client secrets of the identity providers.
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+)
+
+// DefaultKeyID is the id of ENCRYPTION_KEY in the keyring
+const DefaultKeyID = "default"
+
+// keySize is the size of the AES-256 keys
+const keySize = 32
+
+var (
+	// magic starts the envelope ciphertexts
+	magic = []byte("enc2:")
+	// directMagic starts the ciphertexts sealed directly with a key of the
+	// environment. Secrets encrypted before key ids existed are a bare
+	// nonce|sealed and are read with ENCRYPTION_KEY.
+	directMagic = []byte("enc1:")
+)
+
+// keyIDPattern keeps key ids free of the ":" that ends them in ciphertexts
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,32}$`)
+
+var (
+	ErrNoKey      = errors.New("encryption keyring has no key")
+	ErrUnknownKey = errors.New("ciphertext key is not in the keyring")
+	ErrDecrypt    = errors.New("unable to decrypt secret")
+)
+
+// Key is an AES-256 key and its id
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// Keyring encrypts every secret with a fresh AES-256-GCM data key wrapped
+// by its primary key manager, and decrypts with any of its key managers.
+// A ciphertext is "enc2:<KEK id>:" followed by the length of the wrapped
+// data key in two bytes, the wrapped data key, the nonce and the sealed
+// secret. Everything before the nonce is authenticated as additional data
+// so neither the KEK id nor the data key can be swapped.
+//
+// Ciphertexts sealed directly with the local keys of the environment,
+// "enc1:<key id>:" and the unversioned ones, are still decrypted so the
+// re-encrypt command can move them to envelopes.
+type Keyring struct {
+	primary  KeyManager
+	managers map[string]KeyManager
+	// legacy opens the ciphertexts written before key ids, if set
+	legacy *LocalKeyManager
+}
+
+// NewKeyring builds the keyring from the configured key managers: the
+// Vault transit key, the KEK files and the keys of the environment.
+// ENCRYPTION_PRIMARY_KEY picks the one that wraps new data keys, defaulting
+// to the first one in that order.
+func NewKeyring(cfg *config.Config) (*Keyring, error) {
+	var managers []KeyManager
+
+	if cfg.Vault.Addr != "" {
+		vault, err := NewVaultTransit(cfg.Vault)
+		if err != nil {
+			return nil, err
+		}
+		managers = append(managers, vault)
+	}
+
+	for _, entry := range cfg.EncryptionKEKFiles {
+		id, path, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			return nil, errors.New("ENCRYPTION_KEK_FILES: entries must be <id>:<path>")
+		}
+
+		m, err := LoadLocalKeyManager(id, path)
+		if err != nil {
+			return nil, fmt.Errorf("ENCRYPTION_KEK_FILES: %w", err)
+		}
+		managers = append(managers, m)
+	}
+
+	var keys []Key
+	for _, entry := range cfg.EncryptionKeys {
+		id, b64, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			return nil, errors.New("ENCRYPTION_KEYS: entries must be <id>:<base64 key>")
+		}
+
+		secret, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil {
+			return nil, fmt.Errorf("ENCRYPTION_KEYS: key %q: %w", id, err)
+		}
+		keys = append(keys, Key{ID: id, Secret: secret})
+	}
+
+	var legacy []byte
+	if cfg.EncryptionKey != "" {
+		var err error
+		if legacy, err = base64.StdEncoding.DecodeString(cfg.EncryptionKey); err != nil {
+			return nil, fmt.Errorf("ENCRYPTION_KEY: %w", err)
+		}
+	}
+
+	local, err := localManagers(legacy, keys)
+	if err != nil {
+		return nil, err
+	}
+	managers = append(managers, local...)
+
+	if id := cfg.EncryptionPrimaryKey; id != "" {
+		i := indexOf(managers, id)
+		if i < 0 {
+			return nil, fmt.Errorf("ENCRYPTION_PRIMARY_KEY: %w: %q", ErrUnknownKey, id)
+		}
+		managers[0], managers[i] = managers[i], managers[0]
+	}
+
+	return NewKeyringFromManagers(managers...)
+}
+
+// NewKeyringFromKeys builds a keyring of local keys whose first key is
+// primary. The legacy key, which may be nil, is registered as DefaultKeyID
+// and is the primary one when no other key is given.
+func NewKeyringFromKeys(legacy []byte, keys ...Key) (*Keyring, error) {
+	managers, err := localManagers(legacy, keys)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyringFromManagers(managers...)
+}
+
+// NewKeyringFromManagers builds a keyring whose first key manager wraps the
+// data keys. A LocalKeyManager with DefaultKeyID also opens the ciphertexts
+// written before key ids.
+func NewKeyringFromManagers(managers ...KeyManager) (*Keyring, error) {
+	if len(managers) == 0 {
+		return nil, ErrNoKey
+	}
+
+	k := &Keyring{primary: managers[0], managers: make(map[string]KeyManager, len(managers))}
+	for _, m := range managers {
+		if _, dup := k.managers[m.ID()]; dup {
+			return nil, fmt.Errorf("key id %q is used by two key managers", m.ID())
+		}
+		k.managers[m.ID()] = m
+
+		if local, ok := m.(*LocalKeyManager); ok && m.ID() == DefaultKeyID {
+			k.legacy = local
+		}
+	}
+	return k, nil
+}
+
+// localManagers turns the keys of the environment into key managers, the
+// legacy one last
+func localManagers(legacy []byte, keys []Key) ([]KeyManager, error) {
+	if legacy != nil {
+		keys = append(keys, Key{ID: DefaultKeyID, Secret: legacy})
+	}
+
+	seen := make(map[string][]byte, len(keys))
+	managers := make([]KeyManager, 0, len(keys))
+	for _, key := range keys {
+		if prev, ok := seen[key.ID]; ok {
+			if !bytes.Equal(prev, key.Secret) {
+				return nil, fmt.Errorf("key id %q is used by two different keys", key.ID)
+			}
+			continue
+		}
+		seen[key.ID] = key.Secret
+
+		m, err := NewLocalKeyManager(key.ID, key.Secret)
+		if err != nil {
+			return nil, err
+		}
+		managers = append(managers, m)
+	}
+	return managers, nil
+}
+
+func indexOf(managers []KeyManager, id string) int {
+	for i, m := range managers {
+		if m.ID() == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func newAEAD(secret []byte) (cipher.AEAD, error) {
+	if len(secret) != keySize {
+		return nil, fmt.Errorf("key must have %d bytes, got %d", keySize, len(secret))
+	}
+
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// PrimaryID returns the id of the KEK that wraps new data keys
+func (k *Keyring) PrimaryID() string {
+	return k.primary.ID()
+}
+
+// Encrypt seals the plaintext with a new data key wrapped by the primary
+// key manager
+func (k *Keyring) Encrypt(ctx context.Context, plaintext []byte) ([]byte, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := k.primary.WrapKey(ctx, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("wrap data key with %q: %w", k.primary.ID(), err)
+	}
+	if len(wrapped) > 0xffff {
+		return nil, fmt.Errorf("wrapped data key of %q is too long", k.primary.ID())
+	}
+
+	header := append(append(append([]byte{}, magic...), k.primary.ID()...), ':')
+	header = binary.BigEndian.AppendUint16(header, uint16(len(wrapped)))
+	header = append(header, wrapped...)
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append(header, nonce...)
+	return aead.Seal(out, nonce, plaintext, header), nil
+}
+
+// Decrypt opens a ciphertext of any key of the keyring
+func (k *Keyring) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	if id, header, wrapped, body, ok := parseEnvelope(ciphertext); ok {
+		m, known := k.managers[id]
+		if !known {
+			if plaintext, err := k.decryptLegacy(ciphertext); err == nil {
+				return plaintext, nil
+			}
+			return nil, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+		}
+
+		dataKey, err := m.UnwrapKey(ctx, wrapped)
+		if err != nil {
+			return nil, fmt.Errorf("%w: unwrap data key with %q: %v", ErrDecrypt, id, err)
+		}
+
+		aead, err := newAEAD(dataKey)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+		}
+		return open(aead, body, header)
+	}
+
+	id, header, body, ok := parseDirect(ciphertext)
+	if !ok {
+		return k.decryptLegacy(ciphertext)
+	}
+
+	local, known := k.managers[id].(*LocalKeyManager)
+	if !known {
+		// a bare ciphertext may start with the magic by chance
+		if plaintext, err := k.decryptLegacy(ciphertext); err == nil {
+			return plaintext, nil
+		}
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+
+	plaintext, err := open(local.aead, body, header)
+	if err != nil {
+		if plaintext, lerr := k.decryptLegacy(ciphertext); lerr == nil {
+			return plaintext, nil
+		}
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+// KeyID returns the id of the key of a ciphertext, DefaultKeyID for the
+// ones written before key ids
+func (k *Keyring) KeyID(ciphertext []byte) string {
+	if id, _, _, _, ok := parseEnvelope(ciphertext); ok {
+		return id
+	}
+	if id, _, _, ok := parseDirect(ciphertext); ok {
+		if _, known := k.managers[id]; known {
+			return id
+		}
+	}
+	return DefaultKeyID
+}
+
+// Rotate re-encrypts a ciphertext in an envelope of the primary key
+// manager. It reports false, returning the ciphertext as is, when it
+// already is one.
+func (k *Keyring) Rotate(ctx context.Context, ciphertext []byte) ([]byte, bool, error) {
+	plaintext, err := k.Decrypt(ctx, ciphertext)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if id, _, _, _, ok := parseEnvelope(ciphertext); ok && id == k.primary.ID() {
+		return ciphertext, false, nil
+	}
+
+	out, err := k.Encrypt(ctx, plaintext)
+	if err != nil {
+		return nil, false, err
+	}
+	return out, true, nil
+}
+
+func (k *Keyring) decryptLegacy(ciphertext []byte) ([]byte, error) {
+	if k.legacy == nil {
+		return nil, fmt.Errorf("%w: the secret has no key id and ENCRYPTION_KEY is not set", ErrUnknownKey)
+	}
+	return open(k.legacy.aead, ciphertext, nil)
+}
+
+// parseEnvelope splits an envelope ciphertext into its KEK id, header,
+// wrapped data key and body
+func parseEnvelope(ciphertext []byte) (id string, header, wrapped, body []byte, ok bool) {
+	id, prefix, rest, ok := cutKeyID(ciphertext, magic)
+	if !ok || len(rest) < 2 {
+		return "", nil, nil, nil, false
+	}
+
+	n := int(binary.BigEndian.Uint16(rest))
+	if len(rest) < 2+n {
+		return "", nil, nil, nil, false
+	}
+
+	end := len(prefix) + 2 + n
+	return id, ciphertext[:end], rest[2 : 2+n], ciphertext[end:], true
+}
+
+// parseDirect splits a ciphertext sealed directly with a key into its key
+// id, header and body
+func parseDirect(ciphertext []byte) (id string, header, body []byte, ok bool) {
+	id, header, body, ok = cutKeyID(ciphertext, directMagic)
+	return
+}
+
+// cutKeyID splits "<magic><key id>:" from the rest of the ciphertext
+func cutKeyID(ciphertext, prefix []byte) (id string, header, rest []byte, ok bool) {
+	after, found := bytes.CutPrefix(ciphertext, prefix)
+	if !found {
+		return "", nil, nil, false
+	}
+
+	i := bytes.IndexByte(after, ':')
+	if i < 0 || !keyIDPattern.Match(after[:i]) {
+		return "", nil, nil, false
+	}
+
+	n := len(prefix) + i + 1
+	return string(after[:i]), ciphertext[:n], ciphertext[n:], true
+}
+
+func open(aead cipher.AEAD, body, additional []byte) ([]byte, error) {
+	if len(body) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: ciphertext too short", ErrDecrypt)
+	}
+
+	nonce, sealed := body[:aead.NonceSize()], body[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, additional)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,259 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+)
+
+func newKey(t *testing.T) []byte {
+	t.Helper()
+
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// sealLegacy encrypts the way secrets were stored before key ids
+func sealLegacy(t *testing.T, key []byte, plaintext string) []byte {
+	t.Helper()
+	return sealWith(t, key, nil, plaintext)
+}
+
+// sealDirect encrypts the way secrets were stored before data keys
+func sealDirect(t *testing.T, id string, key []byte, plaintext string) []byte {
+	t.Helper()
+	return sealWith(t, key, []byte("enc1:"+id+":"), plaintext)
+}
+
+func sealWith(t *testing.T, key, header []byte, plaintext string) []byte {
+	t.Helper()
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatal(err)
+	}
+	out := append(append([]byte{}, header...), nonce...)
+	return gcm.Seal(out, nonce, []byte(plaintext), header)
+}
+
+func TestKeyring_EncryptDecrypt(t *testing.T) {
+	k, err := NewKeyringFromKeys(nil, Key{ID: "2024-06", Secret: newKey(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	ct, err := k.Encrypt(ctx, []byte("super-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(ct, []byte("enc2:2024-06:")) || bytes.Contains(ct, []byte("super-secret")) {
+		t.Fatalf("unexpected ciphertext %q", ct)
+	}
+
+	// every secret has a data key of its own
+	again, _ := k.Encrypt(ctx, []byte("super-secret"))
+	_, _, wrapped, body, _ := parseEnvelope(ct)
+	_, otherHeader, wrappedAgain, _, _ := parseEnvelope(again)
+	if len(wrapped) == 0 || bytes.Equal(wrapped, wrappedAgain) {
+		t.Errorf("expected distinct wrapped data keys, got %x and %x", wrapped, wrappedAgain)
+	}
+
+	got, err := k.Decrypt(ctx, ct)
+	if err != nil || string(got) != "super-secret" {
+		t.Fatalf("Decrypt = %q, %v", got, err)
+	}
+	if id := k.KeyID(ct); id != "2024-06" {
+		t.Errorf("KeyID = %q", id)
+	}
+
+	// the key id is authenticated
+	relabeled := bytes.Replace(ct, []byte("2024-06"), []byte("2024-07"), 1)
+	other, _ := NewKeyringFromKeys(nil, Key{ID: "2024-06", Secret: newKey(t)}, Key{ID: "2024-07", Secret: newKey(t)})
+	if _, err := other.Decrypt(ctx, relabeled); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("expected ErrDecrypt, got %v", err)
+	}
+
+	// so is the wrapped data key
+	swapped := append(append([]byte{}, otherHeader...), body...)
+	if _, err := k.Decrypt(ctx, swapped); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("expected ErrDecrypt for a swapped data key, got %v", err)
+	}
+
+	if _, err := k.Decrypt(ctx, ct[:len(ct)-1]); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("expected ErrDecrypt for a truncated ciphertext, got %v", err)
+	}
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	legacyKey, oldKey, newKeyBytes := newKey(t), newKey(t), newKey(t)
+	ctx := context.Background()
+
+	before, err := NewKeyringFromKeys(legacyKey, Key{ID: "old", Secret: oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldCT, _ := before.Encrypt(ctx, []byte("old"))
+	directCT := sealDirect(t, "old", oldKey, "direct")
+	legacyCT := sealLegacy(t, legacyKey, "legacy")
+
+	after, err := NewKeyringFromKeys(legacyKey, Key{ID: "new", Secret: newKeyBytes}, Key{ID: "old", Secret: oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.PrimaryID() != "new" {
+		t.Fatalf("PrimaryID = %q", after.PrimaryID())
+	}
+
+	for want, ct := range map[string][]byte{"old": oldCT, "direct": directCT, "legacy": legacyCT} {
+		got, err := after.Decrypt(ctx, ct)
+		if err != nil || string(got) != want {
+			t.Errorf("Decrypt = %q, %v; want %q", got, err, want)
+		}
+
+		rotated, ok, err := after.Rotate(ctx, ct)
+		if err != nil || !ok || after.KeyID(rotated) != "new" || !bytes.HasPrefix(rotated, magic) {
+			t.Errorf("Rotate(%s) = %v, %v; key %q", want, ok, err, after.KeyID(rotated))
+		}
+
+		if _, ok, _ := after.Rotate(ctx, rotated); ok {
+			t.Errorf("a ciphertext of the primary key must not be rotated")
+		}
+	}
+
+	// secrets sealed directly with a key move to envelopes of that key too
+	if _, ok, _ := before.Rotate(ctx, directCT); !ok {
+		t.Error("a direct ciphertext must be rotated even under its own key")
+	}
+
+	// once the old key is retired, its ciphertexts can not be read
+	retired, _ := NewKeyringFromKeys(nil, Key{ID: "new", Secret: newKeyBytes})
+	for name, ct := range map[string][]byte{"envelope": oldCT, "direct": directCT, "legacy": legacyCT} {
+		if _, err := retired.Decrypt(ctx, ct); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("%s: expected ErrUnknownKey, got %v", name, err)
+		}
+	}
+}
+
+func TestLocalKeyManager(t *testing.T) {
+	dir := t.TempDir()
+	kek := newKey(t)
+	ctx := context.Background()
+
+	b64File := filepath.Join(dir, "kek.b64")
+	rawFile := filepath.Join(dir, "kek.raw")
+	_ = os.WriteFile(b64File, []byte(base64.StdEncoding.EncodeToString(kek)+"\n"), 0o600)
+	_ = os.WriteFile(rawFile, kek, 0o600)
+
+	fromB64, err := LoadLocalKeyManager("k1", b64File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromRaw, err := LoadLocalKeyManager("k1", rawFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataKey := newKey(t)
+	wrapped, err := fromB64.WrapKey(ctx, dataKey)
+	if err != nil || bytes.Contains(wrapped, dataKey) {
+		t.Fatalf("WrapKey = %x, %v", wrapped, err)
+	}
+	if got, err := fromRaw.UnwrapKey(ctx, wrapped); err != nil || !bytes.Equal(got, dataKey) {
+		t.Errorf("UnwrapKey = %x, %v", got, err)
+	}
+
+	// the wrapped key is bound to the KEK id
+	other, _ := NewLocalKeyManager("k2", kek)
+	if _, err := other.UnwrapKey(ctx, wrapped); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("expected ErrDecrypt, got %v", err)
+	}
+
+	short := filepath.Join(dir, "short")
+	_ = os.WriteFile(short, []byte("c2hvcnQ="), 0o600)
+	for _, path := range []string{short, filepath.Join(dir, "missing")} {
+		if _, err := LoadLocalKeyManager("k1", path); err == nil {
+			t.Errorf("%s: expected an error", path)
+		}
+	}
+}
+
+func TestNewKeyring(t *testing.T) {
+	legacy, next, kek := newKey(t), newKey(t), newKey(t)
+	b64 := base64.StdEncoding.EncodeToString
+	ctx := context.Background()
+
+	kekFile := filepath.Join(t.TempDir(), "kek")
+	if err := os.WriteFile(kekFile, []byte(b64(kek)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// ENCRYPTION_KEY alone keeps working, and now wraps data keys
+	k, err := NewKeyring(&config.Config{EncryptionKey: b64(legacy)})
+	if err != nil || k.PrimaryID() != DefaultKeyID {
+		t.Fatalf("NewKeyring = %v, %v", k, err)
+	}
+	if got, err := k.Decrypt(ctx, sealLegacy(t, legacy, "x")); err != nil || string(got) != "x" {
+		t.Errorf("legacy Decrypt = %q, %v", got, err)
+	}
+
+	k, err = NewKeyring(&config.Config{EncryptionKey: b64(legacy), EncryptionKeys: []string{"k2:" + b64(next)}})
+	if err != nil || k.PrimaryID() != "k2" {
+		t.Fatalf("NewKeyring = %v, %v", k, err)
+	}
+
+	// a KEK file takes over the keys of the environment, which still decrypt
+	cfg := &config.Config{EncryptionKey: b64(legacy), EncryptionKEKFiles: []string{"file1:" + kekFile}}
+	k, err = NewKeyring(cfg)
+	if err != nil || k.PrimaryID() != "file1" {
+		t.Fatalf("NewKeyring = %v, %v", k, err)
+	}
+	if got, err := k.Decrypt(ctx, sealLegacy(t, legacy, "x")); err != nil || string(got) != "x" {
+		t.Errorf("legacy Decrypt = %q, %v", got, err)
+	}
+
+	cfg.EncryptionPrimaryKey = DefaultKeyID
+	if k, err = NewKeyring(cfg); err != nil || k.PrimaryID() != DefaultKeyID {
+		t.Fatalf("NewKeyring = %v, %v", k, err)
+	}
+
+	for name, cfg := range map[string]*config.Config{
+		"no key":          {},
+		"bad base64":      {EncryptionKey: "bad-base64"},
+		"short key":       {EncryptionKey: b64(legacy[:10])},
+		"no id":           {EncryptionKeys: []string{b64(next)}},
+		"bad id":          {EncryptionKeys: []string{"k 2:" + b64(next)}},
+		"id reused":       {EncryptionKeys: []string{"k2:" + b64(next), "k2:" + b64(legacy)}},
+		"default reused":  {EncryptionKey: b64(legacy), EncryptionKeys: []string{"default:" + b64(next)}},
+		"kek file no id":  {EncryptionKEKFiles: []string{kekFile}},
+		"kek id reused":   {EncryptionKEKFiles: []string{"k2:" + kekFile}, EncryptionKeys: []string{"k2:" + b64(next)}},
+		"unknown primary": {EncryptionKEKFiles: []string{"file1:" + kekFile}, EncryptionPrimaryKey: "file2"},
+		"vault no key":    {Vault: config.VaultConfig{Addr: "http://127.0.0.1:8200"}},
+	} {
+		if _, err := NewKeyring(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
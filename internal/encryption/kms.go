@@ -0,0 +1,78 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+)
+
+// KeyManager wraps the data keys of the secrets with a key encryption key
+// (KEK) the application never needs to hold, such as a KMS key.
+type KeyManager interface {
+	// ID identifies the KEK. It is written in the ciphertexts, so it must
+	// stay stable while they exist.
+	ID() string
+	// WrapKey encrypts a data key
+	WrapKey(ctx context.Context, dataKey []byte) ([]byte, error)
+	// UnwrapKey decrypts a data key wrapped by WrapKey
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// LocalKeyManager wraps data keys with an AES-256 KEK held in memory,
+// loaded from a file or, for older deployments, from the environment.
+type LocalKeyManager struct {
+	id   string
+	aead cipher.AEAD
+}
+
+var _ KeyManager = (*LocalKeyManager)(nil)
+
+// NewLocalKeyManager builds a key manager from a raw AES-256 key
+func NewLocalKeyManager(id string, kek []byte) (*LocalKeyManager, error) {
+	if !keyIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("invalid key id %q", id)
+	}
+
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, fmt.Errorf("key %q: %w", id, err)
+	}
+	return &LocalKeyManager{id: id, aead: aead}, nil
+}
+
+// LoadLocalKeyManager reads the KEK from a file holding the key in base64,
+// as written by `openssl rand -base64 32`, or its 32 raw bytes
+func LoadLocalKeyManager(id, path string) (*LocalKeyManager, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key %q: %w", id, err)
+	}
+
+	kek := data
+	if len(data) != keySize {
+		if kek, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data))); err != nil {
+			return nil, fmt.Errorf("key %q: %s holds neither a base64 nor a raw %d-byte key", id, path, keySize)
+		}
+	}
+
+	return NewLocalKeyManager(id, kek)
+}
+
+func (m *LocalKeyManager) ID() string { return m.id }
+
+// WrapKey seals the data key, binding it to the KEK id
+func (m *LocalKeyManager) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	nonce := make([]byte, m.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return m.aead.Seal(nonce, nonce, dataKey, []byte(m.id)), nil
+}
+
+func (m *LocalKeyManager) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	return open(m.aead, wrapped, []byte(m.id))
+}
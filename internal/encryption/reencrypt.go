@@ -0,0 +1,97 @@
+package encryption
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+)
+
+// defaultBatchSize is the number of rows read at once by Reencrypt
+const defaultBatchSize = 100
+
+// ReencryptStats counts what Reencrypt did on one column
+type ReencryptStats struct {
+	Column repo.EncryptedColumn
+	// Scanned rows, Rotated under the primary key, Current ones already
+	// under it and Changed ones updated by someone else meanwhile
+	Scanned, Rotated, Current, Changed int
+	// Failed rows could not be decrypted with any key of the keyring
+	Failed int
+}
+
+// ReencryptOptions tunes Reencrypt
+type ReencryptOptions struct {
+	BatchSize int
+	// DryRun counts the rows to rotate without writing them
+	DryRun bool
+}
+
+// Reencrypt rewrites the values of the columns under the primary key of
+// the keyring, so the older keys can be retired. Rows are processed in
+// batches and each one is only replaced if it did not change since it was
+// read. Rows that fail to decrypt are counted and reported at the end
+// without stopping the others.
+func Reencrypt(ctx context.Context, k *Keyring, store repo.EncryptedColumnRepository, cols []repo.EncryptedColumn, opts ReencryptOptions) ([]ReencryptStats, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+
+	var (
+		all  []ReencryptStats
+		errs []error
+	)
+	for _, col := range cols {
+		stats := ReencryptStats{Column: col}
+
+		var afterID int64
+		for {
+			recs, err := store.List(ctx, col, afterID, opts.BatchSize)
+			if err != nil {
+				return append(all, stats), fmt.Errorf("list %s: %w", col, err)
+			}
+
+			for _, rec := range recs {
+				afterID = rec.ID
+				stats.Scanned++
+
+				value, rotated, err := k.Rotate(ctx, rec.Value)
+				if err != nil {
+					stats.Failed++
+					errs = append(errs, fmt.Errorf("%s id %d: %w", col, rec.ID, err))
+					continue
+				}
+
+				if !rotated {
+					stats.Current++
+					continue
+				}
+
+				if opts.DryRun {
+					stats.Rotated++
+					continue
+				}
+
+				ok, err := store.Replace(ctx, col, rec.ID, rec.Value, value)
+				if err != nil {
+					return append(all, stats), fmt.Errorf("replace %s id %d: %w", col, rec.ID, err)
+				}
+
+				if ok {
+					stats.Rotated++
+				} else {
+					stats.Changed++
+				}
+			}
+
+			if len(recs) < opts.BatchSize {
+				break
+			}
+		}
+
+		all = append(all, stats)
+	}
+
+	return all, errors.Join(errs...)
+}
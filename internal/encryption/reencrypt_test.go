@@ -0,0 +1,85 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+)
+
+// memColumns keeps the values of one encrypted column, keyed by id
+type memColumns struct {
+	values map[int64][]byte
+	// concurrent is written by "another writer" when its row is replaced
+	concurrent map[int64][]byte
+}
+
+func (m *memColumns) List(ctx context.Context, col repo.EncryptedColumn, afterID int64, limit int) ([]*repo.EncryptedValueRecord, error) {
+	var out []*repo.EncryptedValueRecord
+	for id := afterID + 1; id <= int64(len(m.values)) && len(out) < limit; id++ {
+		out = append(out, &repo.EncryptedValueRecord{ID: id, Value: m.values[id]})
+	}
+	return out, nil
+}
+
+func (m *memColumns) Replace(ctx context.Context, col repo.EncryptedColumn, id int64, old, value []byte) (bool, error) {
+	if v, ok := m.concurrent[id]; ok {
+		m.values[id] = v
+	}
+	if !bytes.Equal(m.values[id], old) {
+		return false, nil
+	}
+	m.values[id] = value
+	return true, nil
+}
+
+func TestReencrypt(t *testing.T) {
+	legacyKey, oldKey, newKeyBytes := newKey(t), newKey(t), newKey(t)
+
+	old, _ := NewKeyringFromKeys(legacyKey, Key{ID: "old", Secret: oldKey})
+	k, _ := NewKeyringFromKeys(legacyKey, Key{ID: "new", Secret: newKeyBytes}, Key{ID: "old", Secret: oldKey})
+
+	enc := func(k *Keyring, s string) []byte {
+		ct, err := k.Encrypt(context.Background(), []byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ct
+	}
+
+	store := &memColumns{values: map[int64][]byte{
+		1: sealLegacy(t, legacyKey, "one"),
+		2: enc(old, "two"),
+		3: enc(k, "three"),
+		4: sealLegacy(t, newKey(t), "lost"),
+		5: enc(old, "five"),
+		6: sealDirect(t, "new", newKeyBytes, "six"),
+	}}
+	store.concurrent = map[int64][]byte{5: enc(k, "changed")}
+	cols := []repo.EncryptedColumn{{Table: "identity_providers", Column: "client_secret_enc"}}
+
+	stats, err := Reencrypt(context.Background(), k, store, cols, ReencryptOptions{BatchSize: 2, DryRun: true})
+	if s := stats[0]; s.Scanned != 6 || s.Rotated != 4 || s.Current != 1 || s.Failed != 1 {
+		t.Errorf("unexpected dry run stats %+v", s)
+	}
+	if !errors.Is(err, ErrUnknownKey) && !errors.Is(err, ErrDecrypt) {
+		t.Errorf("expected the undecryptable row to be reported, got %v", err)
+	}
+	if k.KeyID(store.values[1]) != DefaultKeyID {
+		t.Fatal("a dry run must not write")
+	}
+
+	stats, _ = Reencrypt(context.Background(), k, store, cols, ReencryptOptions{BatchSize: 2})
+	if s := stats[0]; s.Rotated != 3 || s.Changed != 1 || s.Current != 1 || s.Failed != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+
+	for id, want := range map[int64]string{1: "one", 2: "two", 3: "three", 5: "changed", 6: "six"} {
+		got, err := k.Decrypt(context.Background(), store.values[id])
+		if err != nil || string(got) != want || k.KeyID(store.values[id]) != "new" {
+			t.Errorf("row %d = %q, %v under %q", id, got, err, k.KeyID(store.values[id]))
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+)
+
+// vaultIDPrefix prefixes the transit key name in the KEK id
+const vaultIDPrefix = "vault."
+
+// maxVaultResponse bounds the responses read from Vault
+const maxVaultResponse = 1 << 20
+
+// VaultTransit wraps data keys with a key of the Vault transit secrets
+// engine, or of any server speaking its API such as OpenBao. The KEK never
+// leaves Vault: data keys are sent to its encrypt and decrypt endpoints.
+type VaultTransit struct {
+	id        string
+	endpoint  string
+	token     string
+	namespace string
+	client    *http.Client
+}
+
+var _ KeyManager = (*VaultTransit)(nil)
+
+// NewVaultTransit builds the key manager of a transit key. Its KEK id is
+// "vault.<key name>", so a new transit key name is a new KEK.
+func NewVaultTransit(cfg config.VaultConfig) (*VaultTransit, error) {
+	if cfg.Addr == "" || cfg.TransitKey == "" {
+		return nil, errors.New("vault: VAULT_ADDR and VAULT_TRANSIT_KEY are required")
+	}
+
+	id := vaultIDPrefix + cfg.TransitKey
+	if !keyIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("vault: invalid transit key name %q", cfg.TransitKey)
+	}
+
+	mount := strings.Trim(cfg.TransitMount, "/")
+	if mount == "" {
+		mount = "transit"
+	}
+
+	return &VaultTransit{
+		id:        id,
+		endpoint:  strings.TrimSuffix(cfg.Addr, "/") + "/v1/" + mount,
+		token:     cfg.Token,
+		namespace: cfg.Namespace,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (v *VaultTransit) ID() string { return v.id }
+
+func (v *VaultTransit) keyName() string {
+	return strings.TrimPrefix(v.id, vaultIDPrefix)
+}
+
+// WrapKey encrypts the data key with the transit key; the result is the
+// "vault:v<version>:..." ciphertext of Vault
+func (v *VaultTransit) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	var out struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	in := map[string]string{"plaintext": base64.StdEncoding.EncodeToString(dataKey)}
+	if err := v.call(ctx, "encrypt", in, &out); err != nil {
+		return nil, err
+	}
+
+	if out.Ciphertext == "" {
+		return nil, errors.New("vault encrypt: empty ciphertext")
+	}
+	return []byte(out.Ciphertext), nil
+}
+
+func (v *VaultTransit) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	var out struct {
+		Plaintext string `json:"plaintext"`
+	}
+	if err := v.call(ctx, "decrypt", map[string]string{"ciphertext": string(wrapped)}, &out); err != nil {
+		return nil, err
+	}
+
+	dataKey, err := base64.StdEncoding.DecodeString(out.Plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("vault decrypt: %w", err)
+	}
+	return dataKey, nil
+}
+
+// call posts to {mount}/{op}/{key} and decodes the "data" of the response
+func (v *VaultTransit) call(ctx context.Context, op string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	u := v.endpoint + "/" + op + "/" + url.PathEscape(v.keyName())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if v.token != "" {
+		req.Header.Set("X-Vault-Token", v.token)
+	}
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault %s: %w", op, err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxVaultResponse))
+	if err != nil {
+		return fmt.Errorf("vault %s: %w", op, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(raw, &e) == nil && len(e.Errors) > 0 {
+			return fmt.Errorf("vault %s: status %d: %s", op, resp.StatusCode, strings.Join(e.Errors, "; "))
+		}
+		return fmt.Errorf("vault %s: status %d", op, resp.StatusCode)
+	}
+
+	envelope := struct {
+		Data any `json:"data"`
+	}{Data: out}
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return fmt.Errorf("vault %s: %w", op, err)
+	}
+	return nil
+}
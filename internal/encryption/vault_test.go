@@ -0,0 +1,128 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+)
+
+// fakeTransit serves the encrypt and decrypt endpoints of the transit key
+// "crm" mounted at "secrets", for the token "root" in the namespace "team"
+func fakeTransit(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	kek, err := NewLocalKeyManager("transit", newKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fail := func(status int, msg string) {
+			w.WriteHeader(status)
+			fmt.Fprintf(w, `{"errors":[%q]}`, msg)
+		}
+
+		if r.Header.Get("X-Vault-Token") != "root" || r.Header.Get("X-Vault-Namespace") != "team" {
+			fail(http.StatusForbidden, "permission denied")
+			return
+		}
+
+		var in struct{ Plaintext, Ciphertext string }
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&in) != nil {
+			fail(http.StatusBadRequest, "bad request")
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/secrets/encrypt/crm":
+			plaintext, _ := base64.StdEncoding.DecodeString(in.Plaintext)
+			wrapped, _ := kek.WrapKey(r.Context(), plaintext)
+			fmt.Fprintf(w, `{"data":{"ciphertext":"vault:v1:%s","key_version":1}}`, base64.StdEncoding.EncodeToString(wrapped))
+		case "/v1/secrets/decrypt/crm":
+			wrapped, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(in.Ciphertext, "vault:v1:"))
+			plaintext, err := kek.UnwrapKey(r.Context(), wrapped)
+			if err != nil {
+				fail(http.StatusBadRequest, "invalid ciphertext: unable to decrypt")
+				return
+			}
+			fmt.Fprintf(w, `{"data":{"plaintext":%q}}`, base64.StdEncoding.EncodeToString(plaintext))
+		default:
+			fail(http.StatusNotFound, "no handler for route")
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestVaultTransit(t *testing.T) {
+	srv := fakeTransit(t)
+	ctx := context.Background()
+	cfg := config.VaultConfig{Addr: srv.URL + "/", Token: "root", Namespace: "team", TransitMount: "/secrets/", TransitKey: "crm"}
+
+	v, err := NewVaultTransit(cfg)
+	if err != nil || v.ID() != "vault.crm" {
+		t.Fatalf("NewVaultTransit = %v, %v", v, err)
+	}
+
+	dataKey := newKey(t)
+	wrapped, err := v.WrapKey(ctx, dataKey)
+	if err != nil || !bytes.HasPrefix(wrapped, []byte("vault:v1:")) {
+		t.Fatalf("WrapKey = %q, %v", wrapped, err)
+	}
+	if got, err := v.UnwrapKey(ctx, wrapped); err != nil || !bytes.Equal(got, dataKey) {
+		t.Errorf("UnwrapKey = %x, %v", got, err)
+	}
+
+	if _, err := v.UnwrapKey(ctx, []byte("vault:v1:AAAA")); err == nil || !strings.Contains(err.Error(), "status 400: invalid ciphertext") {
+		t.Errorf("expected the error of Vault, got %v", err)
+	}
+
+	cfg.Token = "wrong"
+	denied, _ := NewVaultTransit(cfg)
+	if _, err := denied.WrapKey(ctx, dataKey); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("expected permission denied, got %v", err)
+	}
+}
+
+func TestKeyring_Vault(t *testing.T) {
+	srv := fakeTransit(t)
+	ctx := context.Background()
+	legacy := newKey(t)
+
+	k, err := NewKeyring(&config.Config{
+		Vault:         config.VaultConfig{Addr: srv.URL, Token: "root", Namespace: "team", TransitMount: "secrets", TransitKey: "crm"},
+		EncryptionKey: base64.StdEncoding.EncodeToString(legacy),
+	})
+	if err != nil || k.PrimaryID() != "vault.crm" {
+		t.Fatalf("NewKeyring = %v, %v", k, err)
+	}
+
+	ct, err := k.Encrypt(ctx, []byte("client-secret"))
+	if err != nil || !bytes.HasPrefix(ct, []byte("enc2:vault.crm:")) {
+		t.Fatalf("Encrypt = %q, %v", ct, err)
+	}
+	if got, err := k.Decrypt(ctx, ct); err != nil || string(got) != "client-secret" {
+		t.Errorf("Decrypt = %q, %v", got, err)
+	}
+
+	// the secrets of ENCRYPTION_KEY move under Vault
+	rotated, ok, err := k.Rotate(ctx, sealLegacy(t, legacy, "old"))
+	if err != nil || !ok || k.KeyID(rotated) != "vault.crm" {
+		t.Errorf("Rotate = %v, %v; key %q", ok, err, k.KeyID(rotated))
+	}
+
+	// without Vault the data keys can not be unwrapped
+	srv.Close()
+	if _, err := k.Decrypt(ctx, ct); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("expected ErrDecrypt, got %v", err)
+	}
+}
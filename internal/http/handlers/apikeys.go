@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+	"github.com/labstack/echo/v4"
+)
+
+// APIKeyHandler gerencia as chaves de API de um tenant
+type APIKeyHandler struct {
+	repo repo.APIKeyRepository
+	keys *auth.APIKeys
+}
+
+// NewAPIKeyHandler cria um novo handler, injetando o repo e o emissor de chaves
+func NewAPIKeyHandler(r repo.APIKeyRepository, keys *auth.APIKeys) *APIKeyHandler {
+	return &APIKeyHandler{repo: r, keys: keys}
+}
+
+// apiKeyRequest representa o payload de criação de chave
+type apiKeyRequest struct {
+	Name      string     `json:"name" validate:"notblank"`
+	Scopes    []string   `json:"scopes" validate:"min=1"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// APIKeyResponse representa uma chave de API, sem o segredo
+type APIKeyResponse struct {
+	ID         int64      `json:"id"`
+	TenantID   int64      `json:"tenant_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  *int64     `json:"created_by,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+
+	// Key só é exibida na criação
+	Key string `json:"key,omitempty"`
+}
+
+// Register associa as rotas de administração de chaves de API
+func (h *APIKeyHandler) Register(e *echo.Echo) {
+	g := e.Group("/admin/tenants/:tenantID/api-keys")
+	g.GET("", h.List)
+	g.POST("", h.Create)
+	g.GET("/:id", h.Get)
+	g.DELETE("/:id", h.Revoke)
+}
+
+// List retorna as chaves do tenant
+func (h *APIKeyHandler) List(c echo.Context) error {
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
+	recs, err := h.repo.ListByTenant(c.Request().Context(), tenant)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	out := []APIKeyResponse{}
+	for _, r := range recs {
+		out = append(out, newAPIKeyResponse(r))
+	}
+
+	return c.JSON(http.StatusOK, out)
+}
+
+// Get retorna uma chave do tenant
+func (h *APIKeyHandler) Get(c echo.Context) error {
+	id, err := parseID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+	}
+
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
+	rec, err := h.repo.GetByID(c.Request().Context(), tenant, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if rec == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "api key not found")
+	}
+
+	return c.JSON(http.StatusOK, newAPIKeyResponse(rec))
+}
+
+// Create emite uma chave. O segredo é devolvido uma única vez; apenas o
+// hash é armazenado.
+func (h *APIKeyHandler) Create(c echo.Context) error {
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
+	var req apiKeyRequest
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return invalidField("expires_at", "future", "must be in the future")
+	}
+
+	rec := &repo.APIKeyRecord{
+		TenantID:  tenant,
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+	}
+
+	// uma chave não recebe permissões que quem a cria não tem
+	if claims, ok := auth.FromContext(c); ok {
+		for _, s := range req.Scopes {
+			if auth.IsPermission(s) && !claims.Can(s) {
+				return echo.NewHTTPError(http.StatusForbidden, "cannot grant scope "+s)
+			}
+		}
+
+		if claims.UserID > 0 {
+			rec.CreatedBy = &claims.UserID
+		}
+	}
+
+	key, err := h.keys.Issue(c.Request().Context(), rec)
+	if err != nil {
+		if errors.Is(err, auth.ErrUnknownScope) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	resp := newAPIKeyResponse(rec)
+	resp.Key = key
+	return c.JSON(http.StatusCreated, resp)
+}
+
+// Revoke revoga uma chave do tenant; a chave continua listada
+func (h *APIKeyHandler) Revoke(c echo.Context) error {
+	id, err := parseID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+	}
+
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
+	if err := h.repo.Revoke(c.Request().Context(), tenant, id); err != nil {
+		if err == sql.ErrNoRows {
+			return c.NoContent(http.StatusNotFound)
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// newAPIKeyResponse monta a resposta a partir do registro
+func newAPIKeyResponse(rec *repo.APIKeyRecord) APIKeyResponse {
+	scopes := rec.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	return APIKeyResponse{
+		ID:         rec.ID,
+		TenantID:   rec.TenantID,
+		Name:       rec.Name,
+		Prefix:     rec.Prefix,
+		Scopes:     scopes,
+		CreatedBy:  rec.CreatedBy,
+		ExpiresAt:  rec.ExpiresAt,
+		LastUsedAt: rec.LastUsedAt,
+		RevokedAt:  rec.RevokedAt,
+		CreatedAt:  rec.CreatedAt,
+	}
+}
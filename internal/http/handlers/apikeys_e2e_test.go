@@ -0,0 +1,168 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+	h "github.com/jeanmolossi/verbose-adventure/internal/http/handlers"
+	"github.com/jeanmolossi/verbose-adventure/internal/http/middleware"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+)
+
+// memAPIKeys is an in-memory APIKeyRepository
+type memAPIKeys struct {
+	mu   sync.Mutex
+	keys []*repo.APIKeyRecord
+}
+
+func (m *memAPIKeys) ListByTenant(ctx context.Context, tenantID int64) ([]*repo.APIKeyRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []*repo.APIKeyRecord
+	for _, k := range m.keys {
+		if k.TenantID == tenantID {
+			cp := *k
+			out = append(out, &cp)
+		}
+	}
+	return out, nil
+}
+
+func (m *memAPIKeys) GetByID(ctx context.Context, tenantID, id int64) (*repo.APIKeyRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range m.keys {
+		if k.TenantID == tenantID && k.ID == id {
+			cp := *k
+			return &cp, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memAPIKeys) GetByHash(ctx context.Context, hash []byte) (*repo.APIKeyRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range m.keys {
+		if bytes.Equal(k.KeyHash, hash) {
+			cp := *k
+			return &cp, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memAPIKeys) Create(ctx context.Context, rec *repo.APIKeyRecord) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cp := *rec
+	cp.ID = int64(len(m.keys) + 1)
+	cp.CreatedAt = time.Now()
+	m.keys = append(m.keys, &cp)
+	return cp.ID, nil
+}
+
+func (m *memAPIKeys) Revoke(ctx context.Context, tenantID, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range m.keys {
+		if k.TenantID == tenantID && k.ID == id {
+			if k.RevokedAt == nil {
+				now := time.Now()
+				k.RevokedAt = &now
+			}
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *memAPIKeys) TouchLastUsed(ctx context.Context, id int64, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[id-1].LastUsedAt = &at
+	return nil
+}
+
+func TestAPIKeys_CreateShownOnceAndAuthenticates(t *testing.T) {
+	srv := newTestServer(t, &config.Config{BaseURL: "https://crm.example.com"})
+	admin := signToken(t, srv.cfg, srv.keys, 1, false, auth.RoleAdmin)
+
+	rec := srv.call(http.MethodPost, "/admin/tenants/1/api-keys", admin, `{"name":"billing sync","scopes":["apikeys:read"]}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	var created h.APIKeyResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	require.True(t, auth.IsAPIKey(created.Key))
+	require.Equal(t, created.Key[:len(created.Prefix)], created.Prefix)
+	require.Equal(t, int64(1), *created.CreatedBy)
+
+	// the key is never returned again
+	rec = srv.call(http.MethodGet, "/admin/tenants/1/api-keys/1", admin, ``)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NotContains(t, rec.Body.String(), created.Key)
+
+	// the key authenticates as its tenant, within its scopes
+	rec = srv.call(http.MethodGet, "/admin/tenants/1/api-keys", created.Key, ``)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var listed []h.APIKeyResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &listed))
+	require.Len(t, listed, 1)
+	require.NotNil(t, listed[0].LastUsedAt)
+
+	require.Equal(t, http.StatusForbidden, srv.call(http.MethodPost, "/admin/tenants/1/api-keys", created.Key, `{"name":"x","scopes":["apikeys:read"]}`).Code)
+	require.Equal(t, http.StatusForbidden, srv.call(http.MethodGet, "/admin/tenants/2/api-keys", created.Key, ``).Code)
+
+	// or in the X-API-Key header
+	req := srv.request(http.MethodGet, "/admin/tenants/1/api-keys/1", "", ``)
+	req.Header.Set(middleware.APIKeyHeader, created.Key)
+	require.Equal(t, http.StatusOK, srv.serve(req).Code)
+
+	// revoked keys are listed but no longer authenticate
+	require.Equal(t, http.StatusNoContent, srv.call(http.MethodDelete, "/admin/tenants/1/api-keys/1", admin, ``).Code)
+	require.Equal(t, http.StatusUnauthorized, srv.call(http.MethodGet, "/admin/tenants/1/api-keys", created.Key, ``).Code)
+
+	rec = srv.call(http.MethodGet, "/admin/tenants/1/api-keys/1", admin, ``)
+	require.Contains(t, rec.Body.String(), `"revoked_at"`)
+}
+
+func TestAPIKeys_CreateValidation(t *testing.T) {
+	srv := newTestServer(t, &config.Config{BaseURL: "https://crm.example.com"})
+	admin := signToken(t, srv.cfg, srv.keys, 1, false, auth.RoleAdmin)
+
+	cases := []struct {
+		body   string
+		status int
+	}{
+		{`{"scopes":["idp:read"]}`, http.StatusBadRequest},
+		{`{"name":"x"}`, http.StatusBadRequest},
+		{`{"name":"x","scopes":["everything"]}`, http.StatusBadRequest},
+		{`{"name":"x","scopes":["idp:read"],"expires_at":"2001-01-01T00:00:00Z"}`, http.StatusBadRequest},
+		// admins can not grant what their roles do not
+		{`{"name":"x","scopes":["roles:write"]}`, http.StatusForbidden},
+		{`{"name":"x","scopes":["idp:read","idp:write"],"expires_at":"2999-01-01T00:00:00Z"}`, http.StatusCreated},
+	}
+
+	for _, tc := range cases {
+		rec := srv.call(http.MethodPost, "/admin/tenants/1/api-keys", admin, tc.body)
+		require.Equal(t, tc.status, rec.Code, tc.body)
+	}
+
+	require.Len(t, srv.apiKeys.keys, 1)
+
+	readOnly := signToken(t, srv.cfg, srv.keys, 1, false, auth.RoleReadOnly)
+	require.Equal(t, http.StatusOK, srv.call(http.MethodGet, "/admin/tenants/1/api-keys", readOnly, ``).Code)
+	require.Equal(t, http.StatusForbidden, srv.call(http.MethodDelete, "/admin/tenants/1/api-keys/1", readOnly, ``).Code)
+	require.Equal(t, http.StatusNotFound, srv.call(http.MethodDelete, "/admin/tenants/1/api-keys/9", admin, ``).Code)
+}
@@ -1,43 +1,54 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/jeanmolossi/verbose-adventure/internal/auth"
 	"github.com/jeanmolossi/verbose-adventure/internal/config"
 	"github.com/labstack/echo/v4"
 )
 
+const loginStateCookie = "crm_login_state"
+
 type AuthHandler struct {
-	Providers []auth.IdentityProvider
+	Providers auth.ProviderRegistry
 	Config    *config.Config
+	States    *auth.StateManager
+	Sessions  *auth.Sessions
+	OAuth     *auth.AuthorizationServer
 }
 
-func NewAuthHandler(providers []auth.IdentityProvider, cfg *config.Config) *AuthHandler {
-	return &AuthHandler{Providers: providers, Config: cfg}
+func NewAuthHandler(providers auth.ProviderRegistry, sessions *auth.Sessions, oauth *auth.AuthorizationServer, replay auth.ReplayStore, cfg *config.Config) *AuthHandler {
+	return &AuthHandler{
+		Providers: providers,
+		Sessions:  sessions,
+		OAuth:     oauth,
+		Config:    cfg,
+		States:    auth.NewStateManager(cfg.JWTSecret, cfg.LoginStateTTL, replay),
+	}
 }
 
 func (h *AuthHandler) getIdentityProvider(c echo.Context) (auth.IdentityProvider, error) {
-	tenantID, err := strconv.ParseInt(c.Param("tenantID"), 10, 64)
+	tenantID, err := parseTenant(c)
 	if err != nil {
-		return nil, c.JSON(http.StatusBadRequest, echo.Map{"message": "invalid tenantID"})
+		return nil, echo.NewHTTPError(http.StatusNotFound, "tenant not found")
 	}
 
-	typeID := c.Param("idpType")
+	p, err := h.Providers.Get(c.Request().Context(), tenantID, c.Param("idp"))
+	if errors.Is(err, auth.ErrProviderNotFound) {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "provider not found")
+	}
 
-	var p auth.IdentityProvider
-	for _, pr := range h.Providers {
-		if pr.TenantID() == tenantID && pr.Type() == typeID {
-			p = pr
-			break
-		}
+	if errors.Is(err, auth.ErrProviderUnavailable) {
+		return nil, echo.NewHTTPError(http.StatusServiceUnavailable, err.Error())
 	}
 
-	if p == nil {
-		return nil, c.JSON(http.StatusNotFound, echo.Map{"message": "provider not found"})
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusServiceUnavailable, "provider unavailable")
 	}
 
 	return p, nil
@@ -49,8 +60,23 @@ func (h *AuthHandler) Login(c echo.Context) error {
 		return err
 	}
 
-	state := strconv.FormatInt(time.Now().UnixNano(), 10)
-	url := p.AuthURL(state)
+	ls, err := h.States.New(p.TenantID(), p.Slug())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to create login state"})
+	}
+
+	value, err := h.States.Encode(ls)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to create login state"})
+	}
+
+	c.SetCookie(h.stateCookie(c, p, value, h.States.TTL()))
+
+	url := p.AuthURL(ls.Params())
+	if url == "" {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to build authorization request"})
+	}
+
 	return c.Redirect(http.StatusFound, url)
 }
 
@@ -60,29 +86,194 @@ func (h *AuthHandler) Callback(c echo.Context) error {
 		return err
 	}
 
-	authRes, err := p.Callback(c.Request().Context(), c.Request())
+	var cookieValue string
+	if ck, err := c.Cookie(stateCookieName(p)); err == nil {
+		cookieValue = ck.Value
+	}
+
+	// the state is single use, drop the cookie whatever the outcome
+	c.SetCookie(h.stateCookie(c, p, "", -1))
+
+	// OIDC devolve "state" na query, SAML devolve "RelayState" no POST
+	state := c.QueryParam("state")
+	if state == "" {
+		state = c.FormValue("RelayState")
+	}
+
+	ls, err := h.States.Verify(c.Request().Context(), cookieValue, state, p.TenantID(), p.Slug())
 	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, auth.ErrStateReplayed):
+			status = http.StatusConflict
+		case errors.Is(err, auth.ErrReplayUnavailable):
+			status = http.StatusServiceUnavailable
+		}
+		return c.JSON(status, echo.Map{"message": err.Error()})
+	}
+
+	authRes, err := p.Callback(c.Request().Context(), c.Request(), ls.Params())
+	if err != nil {
+		// o IdP autenticou o usuário, mas o login não cumpre a política do IdP
+		if errors.Is(err, auth.ErrLoginPolicy) {
+			return c.JSON(http.StatusForbidden, echo.Map{"error": "login_policy", "message": err.Error()})
+		}
 		return c.JSON(http.StatusUnauthorized, err.Error())
 	}
 
-	// Gera token JWT do CRM
-	claims := jwt.MapClaims{
-		"tenant_id": authRes.TenantID,
-		"user_id":   authRes.UserID,
-		"email":     authRes.Email,
-		"iat":       time.Now().Unix(),
-		"exp":       time.Now().Add(24 * time.Hour).Unix(),
+	// login iniciado por um cliente OAuth: devolve o código ao cliente
+	if req := h.authorizationRequest(c, authRes.TenantID); req != nil {
+		return h.authorize(c, req, authRes)
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signed, err := token.SignedString([]byte(h.Config.JWTSecret))
+	// Abre a sessão e gera os tokens do CRM
+	pair, err := h.Sessions.Start(c.Request().Context(), authRes)
+	if errors.Is(err, auth.ErrUserInactive) {
+		// desprovisionado pelo cliente SCIM do tenant
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "user_inactive", "message": err.Error()})
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{
 			"message": "failed to generate token",
 		})
 	}
 
-	return c.JSON(http.StatusOK, echo.Map{
-		"token": signed,
-	})
+	return c.JSON(http.StatusOK, pair)
+}
+
+// authorizationRequest retorna o pedido de autorização pendente do tenant,
+// consumindo o cookie que o guarda
+func (h *AuthHandler) authorizationRequest(c echo.Context, tenantID int64) *auth.AuthorizationRequest {
+	if h.OAuth == nil {
+		return nil
+	}
+
+	ck, err := c.Cookie(oauthAuthorizeCookie)
+	if err != nil {
+		return nil
+	}
+
+	c.SetCookie(authorizeCookie(h.Config, "", -1))
+
+	req := h.OAuth.DecodeRequest(ck.Value)
+	if req == nil || req.TenantID != tenantID {
+		return nil
+	}
+	return req
+}
+
+// authorize provisiona o usuário e redireciona ao cliente com o código
+func (h *AuthHandler) authorize(c echo.Context, req *auth.AuthorizationRequest, res *auth.AuthResult) error {
+	ctx := c.Request().Context()
+
+	userID, err := h.Sessions.Provision(ctx, res)
+	if err != nil {
+		return c.Redirect(http.StatusFound, h.OAuth.ErrorRedirect(req, err))
+	}
+
+	location, err := h.OAuth.IssueCode(ctx, req, userID)
+	if err != nil {
+		return c.Redirect(http.StatusFound, h.OAuth.ErrorRedirect(req, err))
+	}
+
+	return c.Redirect(http.StatusFound, location)
+}
+
+type refreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" form:"refresh_token" validate:"required"`
+}
+
+// Refresh troca um refresh token por um novo par de tokens
+func (h *AuthHandler) Refresh(c echo.Context) error {
+	var req refreshTokenRequest
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+
+	pair, err := h.Sessions.Refresh(c.Request().Context(), req.RefreshToken)
+	if err != nil {
+		return sessionError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, pair)
+}
+
+// Logout revoga a sessão do refresh token informado
+func (h *AuthHandler) Logout(c echo.Context) error {
+	var req refreshTokenRequest
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+
+	if err := h.Sessions.Logout(c.Request().Context(), req.RefreshToken); err != nil {
+		return sessionError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// sessionError traduz os erros de sessão para o status HTTP
+func sessionError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, auth.ErrRefreshTokenInvalid),
+		errors.Is(err, auth.ErrRefreshTokenExpired),
+		errors.Is(err, auth.ErrRefreshTokenReused),
+		errors.Is(err, auth.ErrSessionRevoked):
+		return c.JSON(http.StatusUnauthorized, echo.Map{"message": err.Error()})
+	default:
+		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to process session"})
+	}
+}
+
+// Metadata publica o XML de metadata do SP (apenas SAML)
+func (h *AuthHandler) Metadata(c echo.Context) error {
+	p, err := h.getIdentityProvider(c)
+	if err != nil {
+		return err
+	}
+
+	mp, ok := p.(auth.MetadataProvider)
+	if !ok {
+		return c.JSON(http.StatusNotFound, echo.Map{"message": "provider has no metadata"})
+	}
+
+	body, err := mp.Metadata()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to build metadata"})
+	}
+
+	return c.Blob(http.StatusOK, "application/samlmetadata+xml", body)
+}
+
+// stateCookieName identifica o cookie de login do provider. O nome, e não o
+// path, isola os logins: o login pode usar o slug ou o subdomínio e o
+// callback a URL com o ID numérico.
+func stateCookieName(p auth.IdentityProvider) string {
+	return loginStateCookie + "_" + strconv.FormatInt(p.TenantID(), 10) + "_" + p.Slug()
+}
+
+// stateCookie monta o cookie de login do provider
+func (h *AuthHandler) stateCookie(c echo.Context, p auth.IdentityProvider, value string, maxAge time.Duration) *http.Cookie {
+	ck := &http.Cookie{
+		Name:     stateCookieName(p),
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(h.Config.BaseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	// o ACS do SAML recebe um POST cross-site, que não carrega cookies Lax
+	if p.Type() == "saml" {
+		ck.SameSite = http.SameSiteNoneMode
+		ck.Secure = true
+	}
+
+	if maxAge < 0 {
+		ck.MaxAge = -1
+	} else {
+		ck.MaxAge = int(maxAge.Seconds())
+	}
+
+	return ck
 }
@@ -1,11 +1,20 @@
 package handlers_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/ed25519"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sort"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
@@ -14,36 +23,257 @@ import (
 	"github.com/jeanmolossi/verbose-adventure/internal/auth"
 	"github.com/jeanmolossi/verbose-adventure/internal/config"
 	"github.com/jeanmolossi/verbose-adventure/internal/http/handlers"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
 )
 
 type fakeOIDC struct {
 	tenant int64
+	// slug and idp default to "oidc" and 1
+	slug string
+	idp  int64
 }
 
-func (f *fakeOIDC) TenantID() int64             { return f.tenant }
-func (f *fakeOIDC) Type() string                { return "oidc" }
-func (f *fakeOIDC) AuthURL(state string) string { return "https://fakeidp.com/auth?state=" + state }
-func (f *fakeOIDC) Callback(ctx context.Context, req *http.Request) (*auth.AuthResult, error) {
+func (f *fakeOIDC) TenantID() int64 { return f.tenant }
+func (f *fakeOIDC) Type() string    { return "oidc" }
+func (f *fakeOIDC) Slug() string {
+	if f.slug == "" {
+		return "oidc"
+	}
+	return f.slug
+}
+func (f *fakeOIDC) AuthURL(p auth.AuthParams) string {
+	return "https://fakeidp.com/auth?state=" + url.QueryEscape(p.State)
+}
+func (f *fakeOIDC) Callback(ctx context.Context, req *http.Request, p auth.AuthParams) (*auth.AuthResult, error) {
 	// Ignore code, return fixed AuthResult
+	idp := f.idp
+	if idp == 0 {
+		idp = 1
+	}
 	return &auth.AuthResult{
 		TenantID: f.tenant,
+		IdPID:    idp,
 		UserID:   "user123",
 		Email:    "user@example.com",
+		Name:     "User",
+		Groups:   []string{"sales"},
+		Roles:    []string{auth.RoleMember},
 	}, nil
 }
 
-func setupServer(t *testing.T, providers []auth.IdentityProvider, cfg *config.Config) *echo.Echo {
-	e := echo.New()
-	h := handlers.NewAuthHandler(providers, cfg)
-	h.Register(e)
-	return e
+// staticRegistry serves a fixed set of providers
+type staticRegistry struct {
+	providers   []auth.IdentityProvider
+	health      []auth.ProviderHealth
+	invalidated []int64
+}
+
+func (s *staticRegistry) Get(ctx context.Context, tenantID int64, slug string) (auth.IdentityProvider, error) {
+	for _, p := range s.providers {
+		if p.TenantID() == tenantID && p.Slug() == slug {
+			return p, nil
+		}
+	}
+	return nil, auth.ErrProviderNotFound
+}
+
+func (s *staticRegistry) Invalidate(tenantID int64) {
+	s.invalidated = append(s.invalidated, tenantID)
+}
+
+func (s *staticRegistry) Health(tenantID int64) []auth.ProviderHealth {
+	var out []auth.ProviderHealth
+	for _, h := range s.health {
+		if h.TenantID == tenantID {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// memSessions is an in-memory repo.SessionRepository
+type memSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*repo.SessionRecord
+	tokens   []*repo.RefreshTokenRecord
+}
+
+func newMemSessions() *memSessions {
+	return &memSessions{sessions: map[string]*repo.SessionRecord{}}
+}
+
+func (m *memSessions) CreateSession(ctx context.Context, rec *repo.SessionRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cp := *rec
+	m.sessions[rec.ID] = &cp
+	return nil
+}
+
+func (m *memSessions) GetSession(ctx context.Context, id string) (*repo.SessionRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.sessions[id]; ok {
+		cp := *s
+		return &cp, nil
+	}
+	return nil, nil
+}
+
+func (m *memSessions) RevokeSession(ctx context.Context, id, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.sessions[id]; ok && s.RevokedAt == nil {
+		now := time.Now()
+		s.RevokedAt = &now
+		s.RevokedReason = reason
+	}
+	return nil
+}
+
+func (m *memSessions) ListActiveSessions(ctx context.Context, tenantID, userID int64) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ids []string
+	for id, s := range m.sessions {
+		if s.TenantID == tenantID && s.UserID == userID && s.RevokedAt == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (m *memSessions) CreateRefreshToken(ctx context.Context, rec *repo.RefreshTokenRecord) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cp := *rec
+	cp.ID = int64(len(m.tokens) + 1)
+	m.tokens = append(m.tokens, &cp)
+	return cp.ID, nil
+}
+
+func (m *memSessions) GetRefreshTokenByHash(ctx context.Context, hash []byte) (*repo.RefreshTokenRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.tokens {
+		if bytes.Equal(t.TokenHash, hash) {
+			cp := *t
+			return &cp, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memSessions) MarkRefreshTokenUsed(ctx context.Context, id int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.tokens[id-1]
+	if t.UsedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	t.UsedAt = &now
+	return true, nil
+}
+
+// memUsers is an in-memory repo.UserRepository
+type memUsers struct {
+	mu    sync.Mutex
+	users []*repo.UserRecord
+}
+
+func newMemUsers() *memUsers {
+	return &memUsers{}
+}
+
+func (m *memUsers) UpsertLogin(ctx context.Context, rec *repo.UserRecord) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for _, u := range m.users {
+		if u.TenantID == rec.TenantID && u.IdPID == rec.IdPID && u.Subject == rec.Subject {
+			u.Email, u.Name, u.Groups, u.LastLoginAt = rec.Email, rec.Name, rec.Groups, &now
+			return u.ID, nil
+		}
+	}
+	cp := *rec
+	cp.ID = int64(len(m.users) + 1)
+	cp.Active = true
+	cp.CreatedAt = now
+	cp.LastLoginAt = &now
+	m.users = append(m.users, &cp)
+	return cp.ID, nil
+}
+
+func (m *memUsers) GetByID(ctx context.Context, tenantID, id int64) (*repo.UserRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range m.users {
+		if u.TenantID == tenantID && u.ID == id {
+			cp := *u
+			return &cp, nil
+		}
+	}
+	return nil, nil
+}
+
+// memRoles is an in-memory repo.UserRoleRepository
+type memRoles struct {
+	mu    sync.Mutex
+	roles map[string][]string
+}
+
+func newMemRoles() *memRoles {
+	return &memRoles{roles: map[string][]string{}}
+}
+
+func roleKey(tenantID, userID int64, source string) string {
+	return fmt.Sprintf("%d/%d/%s", tenantID, userID, source)
+}
+
+func (m *memRoles) ListRoles(ctx context.Context, tenantID, userID int64) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := []string{}
+	for _, source := range []string{repo.RoleSourceIdP, repo.RoleSourceManual, repo.RoleSourceSCIM} {
+		for _, r := range m.roles[roleKey(tenantID, userID, source)] {
+			if !slices.Contains(out, r) {
+				out = append(out, r)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func (m *memRoles) ListRolesBySource(ctx context.Context, tenantID, userID int64, source string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string{}, m.roles[roleKey(tenantID, userID, source)]...), nil
+}
+
+func (m *memRoles) ReplaceRoles(ctx context.Context, tenantID, userID int64, source string, roles []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.roles[roleKey(tenantID, userID, source)] = roles
+	return nil
+}
+
+// setupAuthServer is a test server whose registry serves providers
+func setupAuthServer(t *testing.T, providers []auth.IdentityProvider, cfg *config.Config) *testServer {
+	t.Helper()
+
+	srv := newTestServer(t, cfg)
+	srv.registry.providers = providers
+	return srv
 }
 
 func TestAuthEndToEnd_LoginAndCallback(t *testing.T) {
 	// Configuration
-	cfg := &config.Config{JWTSecret: "test-secret"}
+	cfg := &config.Config{JWTSecret: "test-secret", BaseURL: "https://crm.example.com"}
 	provider := &fakeOIDC{tenant: 99}
-	e := setupServer(t, []auth.IdentityProvider{provider}, cfg)
+	srv := setupAuthServer(t, []auth.IdentityProvider{provider}, cfg)
+	e := srv.Echo
 
 	// Test Login
 	req := httptest.NewRequest(http.MethodGet, "/99/oidc/login", nil)
@@ -57,8 +287,15 @@ func TestAuthEndToEnd_LoginAndCallback(t *testing.T) {
 	require.NoError(t, err)
 	require.Contains(t, loc.String(), "https://fakeidp.com/auth?state=")
 
+	state := loc.Query().Get("state")
+	require.NotEmpty(t, state)
+
+	cookies := reqed.Cookies()
+	require.Len(t, cookies, 1)
+
 	// Simulate callback
-	callbackReq := httptest.NewRequest(http.MethodGet, "/99/oidc/callback?code=irrelevant", nil)
+	callbackReq := httptest.NewRequest(http.MethodGet, "/99/oidc/callback?code=irrelevant&state="+url.QueryEscape(state), nil)
+	callbackReq.AddCookie(cookies[0])
 	callbackRec := httptest.NewRecorder()
 	e.ServeHTTP(callbackRec, callbackReq)
 	res := callbackRec.Result()
@@ -66,18 +303,30 @@ func TestAuthEndToEnd_LoginAndCallback(t *testing.T) {
 
 	require.Equal(t, http.StatusOK, res.StatusCode)
 
-	var body map[string]string
+	var body auth.TokenPair
 	err = json.NewDecoder(res.Body).Decode(&body)
 	require.NoError(t, err)
-	tokenString, ok := body["token"]
-	require.True(t, ok, "response should contain token field")
+	tokenString := body.AccessToken
+	require.NotEmpty(t, tokenString, "response should contain token field")
+	require.NotEmpty(t, body.RefreshToken)
+	require.Equal(t, int64(15*60), body.ExpiresIn)
+
+	// Validate JWT against the published keys only
+	jwksRec := httptest.NewRecorder()
+	e.ServeHTTP(jwksRec, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	require.Equal(t, http.StatusOK, jwksRec.Code)
+
+	var jwks auth.JSONWebKeySet
+	require.NoError(t, json.Unmarshal(jwksRec.Body.Bytes(), &jwks))
+	require.Len(t, jwks.Keys, 1)
 
-	// Validate JWT
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if token.Method != jwt.SigningMethodHS256 {
-			t.Fatalf("unexpected signing method: %v", token.Method)
-		}
-		return []byte(cfg.JWTSecret), nil
+		require.Equal(t, jwt.SigningMethodEdDSA, token.Method)
+		require.Equal(t, jwks.Keys[0].KeyID, token.Header["kid"])
+
+		x, err := base64.RawURLEncoding.DecodeString(jwks.Keys[0].X)
+		require.NoError(t, err)
+		return ed25519.PublicKey(x), nil
 	})
 	require.NoError(t, err)
 	require.True(t, token.Valid)
@@ -85,6 +334,222 @@ func TestAuthEndToEnd_LoginAndCallback(t *testing.T) {
 	claims, ok := token.Claims.(jwt.MapClaims)
 	require.True(t, ok)
 	require.Equal(t, float64(99), claims["tenant_id"])
-	require.Equal(t, "user123", claims["user_id"])
+	// the internal id of the provisioned user, not the IdP subject
+	require.Equal(t, float64(1), claims["user_id"])
+	require.Equal(t, "1", claims["sub"])
+	require.Equal(t, "user123", srv.users.users[0].Subject)
 	require.Equal(t, "user@example.com", claims["email"])
+	require.NotEmpty(t, claims["sid"])
+	require.Equal(t, "https://crm.example.com", claims["iss"])
+	require.Equal(t, []any{"crm-api"}, claims["aud"])
+	// a member only gets the scope to read its own profile
+	require.Equal(t, auth.ScopeProfile, claims["scope"])
+	require.Equal(t, auth.Scope{auth.ScopeProfile}, body.Scope)
+}
+
+func login(t *testing.T, e *echo.Echo, path string) (string, *http.Cookie) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	res := rec.Result()
+	res.Body.Close()
+
+	require.Equal(t, http.StatusFound, res.StatusCode)
+	loc, err := res.Location()
+	require.NoError(t, err)
+	require.Len(t, res.Cookies(), 1)
+
+	return loc.Query().Get("state"), res.Cookies()[0]
+}
+
+func TestAuthCallback_MissingState(t *testing.T) {
+	cfg := &config.Config{JWTSecret: "test-secret"}
+	e := setupAuthServer(t, []auth.IdentityProvider{&fakeOIDC{tenant: 99}}, cfg).Echo
+
+	req := httptest.NewRequest(http.MethodGet, "/99/oidc/callback?code=irrelevant", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, rec.Body.String(), auth.ErrStateMissing.Error())
+}
+
+func TestAuthCallback_StateMismatch(t *testing.T) {
+	cfg := &config.Config{JWTSecret: "test-secret"}
+	e := setupAuthServer(t, []auth.IdentityProvider{&fakeOIDC{tenant: 99}}, cfg).Echo
+
+	_, cookie := login(t, e, "/99/oidc/login")
+
+	req := httptest.NewRequest(http.MethodGet, "/99/oidc/callback?code=irrelevant&state=forged", nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, rec.Body.String(), auth.ErrStateMismatch.Error())
+}
+
+func TestAuthCallback_Replay(t *testing.T) {
+	cfg := &config.Config{JWTSecret: "test-secret"}
+	e := setupAuthServer(t, []auth.IdentityProvider{&fakeOIDC{tenant: 99}}, cfg).Echo
+
+	state, cookie := login(t, e, "/99/oidc/login")
+	path := "/99/oidc/callback?code=irrelevant&state=" + url.QueryEscape(state)
+
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	req = httptest.NewRequest(http.MethodGet, path, nil)
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusConflict, rec.Code)
+	require.Contains(t, rec.Body.String(), auth.ErrStateReplayed.Error())
+}
+
+// signIn runs the whole login flow and returns the issued tokens
+func signIn(t *testing.T, e *echo.Echo) auth.TokenPair {
+	t.Helper()
+
+	state, cookie := login(t, e, "/99/oidc/login")
+
+	req := httptest.NewRequest(http.MethodGet, "/99/oidc/callback?code=irrelevant&state="+url.QueryEscape(state), nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var pair auth.TokenPair
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &pair))
+	return pair
+}
+
+func postRefreshToken(e *echo.Echo, path, token string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]string{"refresh_token": token})
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAuthRefresh_RotatesAndDetectsReuse(t *testing.T) {
+	cfg := &config.Config{JWTSecret: "test-secret"}
+	e := setupAuthServer(t, []auth.IdentityProvider{&fakeOIDC{tenant: 99}}, cfg).Echo
+
+	first := signIn(t, e)
+
+	rec := postRefreshToken(e, "/auth/refresh", first.RefreshToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var second auth.TokenPair
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &second))
+	require.NotEmpty(t, second.AccessToken)
+	require.NotEqual(t, first.RefreshToken, second.RefreshToken)
+
+	// replaying the rotated token revokes the whole session
+	rec = postRefreshToken(e, "/auth/refresh", first.RefreshToken)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.Contains(t, rec.Body.String(), auth.ErrRefreshTokenReused.Error())
+
+	rec = postRefreshToken(e, "/auth/refresh", second.RefreshToken)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.Contains(t, rec.Body.String(), auth.ErrSessionRevoked.Error())
+}
+
+func TestAuthLogout_RevokesAccessToken(t *testing.T) {
+	cfg := &config.Config{JWTSecret: "test-secret"}
+	srv := setupAuthServer(t, []auth.IdentityProvider{&fakeOIDC{tenant: 99}}, cfg)
+	e := srv.Echo
+
+	pair := signIn(t, e)
+
+	me := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/me", nil)
+		req.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := me()
+	require.Equal(t, http.StatusOK, rec.Code)
+	var profile handlers.MeResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &profile))
+	require.Equal(t, int64(99), profile.TenantID)
+	require.Equal(t, int64(1), profile.UserID)
+	require.Equal(t, "user123", profile.Subject)
+	require.Equal(t, "user@example.com", profile.Email)
+	require.Equal(t, "User", profile.Name)
+	require.Equal(t, []string{"sales"}, profile.Groups)
+	require.Equal(t, []string{auth.RoleMember}, profile.Roles)
+	require.NotNil(t, profile.LastLoginAt)
+
+	rec = postRefreshToken(e, "/auth/logout", pair.RefreshToken)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	require.Equal(t, http.StatusUnauthorized, me().Code)
+
+	rec = postRefreshToken(e, "/auth/refresh", pair.RefreshToken)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestAuthRefresh_UnknownToken(t *testing.T) {
+	cfg := &config.Config{JWTSecret: "test-secret"}
+	e := setupAuthServer(t, []auth.IdentityProvider{&fakeOIDC{tenant: 99}}, cfg).Echo
+
+	rec := postRefreshToken(e, "/auth/refresh", "forged")
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.Contains(t, rec.Body.String(), auth.ErrRefreshTokenInvalid.Error())
+}
+
+func TestAuthEndToEnd_TwoProvidersOfSameType(t *testing.T) {
+	cfg := &config.Config{JWTSecret: "test-secret", BaseURL: "https://crm.example.com"}
+	srv := setupAuthServer(t, []auth.IdentityProvider{
+		&fakeOIDC{tenant: 99, slug: "azure", idp: 1},
+		&fakeOIDC{tenant: 99, slug: "okta", idp: 2},
+	}, cfg)
+
+	login := func(slug string) (string, *http.Cookie) {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/99/"+slug+"/login", nil))
+		require.Equal(t, http.StatusFound, rec.Code, slug)
+
+		loc, err := url.Parse(rec.Header().Get(echo.HeaderLocation))
+		require.NoError(t, err)
+		cookies := rec.Result().Cookies()
+		require.Len(t, cookies, 1)
+		return loc.Query().Get("state"), cookies[0]
+	}
+
+	azureState, azureCookie := login("azure")
+	oktaState, oktaCookie := login("okta")
+	require.NotEqual(t, azureCookie.Name, oktaCookie.Name)
+
+	callback := func(slug, state string, cookie *http.Cookie) int {
+		req := httptest.NewRequest(http.MethodGet, "/99/"+slug+"/callback?code=c&state="+url.QueryEscape(state), nil)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// the state of one provider is not accepted by the other
+	require.Equal(t, http.StatusBadRequest, callback("okta", azureState, &http.Cookie{Name: oktaCookie.Name, Value: azureCookie.Value}))
+
+	require.Equal(t, http.StatusOK, callback("okta", oktaState, oktaCookie))
+	require.Equal(t, http.StatusOK, callback("azure", azureState, azureCookie))
+
+	// the same subject on two providers is two users
+	require.Len(t, srv.users.users, 2)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/99/oidc/login", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code)
 }
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/http/validation"
+	"github.com/labstack/echo/v4"
+)
+
+// ValidationResponse é o corpo de um payload recusado, igual em todas as
+// rotas: cada campo recusado, com a regra que falhou
+type ValidationResponse struct {
+	Message string            `json:"message"`
+	Errors  validation.Errors `json:"errors"`
+}
+
+// ctxValidator é o validator que recebe o escopo do tenant para as regras
+// de unicidade
+type ctxValidator interface {
+	ValidateCtx(ctx context.Context, i any) error
+}
+
+// bindRequest lê o payload em req e o valida com o validator do echo;
+// defaults preenchem os valores padrão antes da validação. As regras de
+// unicidade comparam com os registros do tenant do request, fora o do :id
+// da rota.
+func bindRequest(c echo.Context, req any, defaults ...func()) error {
+	if err := c.Bind(req); err != nil {
+		return invalidRequest(validation.BindErrors(err))
+	}
+
+	for _, d := range defaults {
+		d()
+	}
+
+	var scope validation.Scope
+	scope.TenantID, _ = parseTenant(c)
+	scope.ExceptID, _ = parseID(c)
+
+	var err error
+	if v, ok := c.Echo().Validator.(ctxValidator); ok {
+		err = v.ValidateCtx(validation.WithScope(c.Request().Context(), scope), req)
+	} else {
+		err = c.Validate(req)
+	}
+
+	var fields validation.Errors
+	switch {
+	case errors.As(err, &fields):
+		return invalidRequest(fields)
+	case err != nil:
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return nil
+}
+
+// invalidRequest responde a lista de campos recusados: 409 quando todos
+// colidem com outro registro, 400 nos demais casos
+func invalidRequest(fields validation.Errors) error {
+	status := http.StatusBadRequest
+	if fields.Conflict() {
+		status = http.StatusConflict
+	}
+
+	return echo.NewHTTPError(status, ValidationResponse{Message: "invalid request", Errors: fields})
+}
+
+// invalidField recusa um campo por uma checagem que não cabe em tag
+func invalidField(field, rule, message string) error {
+	return invalidRequest(validation.Field(field, rule, message))
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+	"github.com/jeanmolossi/verbose-adventure/internal/tenant"
+	"github.com/labstack/echo/v4"
+)
+
+// HomeRealmHandler descobre o tenant e os IdPs a partir do domínio do e-mail,
+// para que o usuário não precise conhecer o ID numérico do tenant, e oferece
+// a escolha do IdP quando o tenant tem vários.
+type HomeRealmHandler struct {
+	domains repo.TenantDomainRepository
+	idps    repo.IdentityProviderRepository
+	tenants *tenant.Resolver
+	cfg     *config.Config
+}
+
+// NewHomeRealmHandler cria um novo handler, injetando os repos
+func NewHomeRealmHandler(domains repo.TenantDomainRepository, idps repo.IdentityProviderRepository, tenants *tenant.Resolver, cfg *config.Config) *HomeRealmHandler {
+	return &HomeRealmHandler{domains: domains, idps: idps, tenants: tenants, cfg: cfg}
+}
+
+// DiscoveredProvider é uma opção de login do tenant
+type DiscoveredProvider struct {
+	ID       int64  `json:"id"`
+	Type     string `json:"type"`
+	Slug     string `json:"slug"`
+	LoginURL string `json:"login_url"`
+}
+
+// DiscoveryResponse lista as opções de login do tenant
+type DiscoveryResponse struct {
+	TenantID int64 `json:"tenant_id"`
+	// Tenant é o slug que as URLs de login usam no lugar do ID
+	Tenant    string               `json:"tenant"`
+	Domain    string               `json:"domain,omitempty"`
+	Providers []DiscoveredProvider `json:"providers"`
+}
+
+// Register associa as rotas de descoberta e de escolha do IdP
+func (h *HomeRealmHandler) Register(e *echo.Echo) {
+	e.GET("/auth/discover", h.Discover)
+	e.GET("/:tenantID/sso", h.Pick)
+	e.GET("/sso", h.Pick)
+}
+
+// Discover recebe ?email= ou ?domain=. Com um único IdP habilitado redireciona
+// para o login dele; com vários devolve as opções. Em Discover e Pick,
+// ?redirect=false sempre devolve as opções, para clientes que montam o
+// próprio seletor.
+func (h *HomeRealmHandler) Discover(c echo.Context) error {
+	input := c.QueryParam("email")
+	if input == "" {
+		input = c.QueryParam("domain")
+	}
+
+	domain, err := auth.NormalizeDomain(input)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "a valid email or domain is required"})
+	}
+
+	ctx := c.Request().Context()
+	tenantID, err := h.domains.FindTenant(ctx, domain)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to discover tenant"})
+	}
+
+	if tenantID == 0 {
+		return c.JSON(http.StatusNotFound, echo.Map{"message": "no tenant for this domain"})
+	}
+
+	t, err := h.tenants.FromID(ctx, tenantID)
+	if err != nil {
+		return tenantLookupError(c, err)
+	}
+
+	return h.choose(c, DiscoveryResponse{TenantID: t.ID, Tenant: t.Slug, Domain: domain})
+}
+
+// Pick é o seletor de IdP do tenant do path ou do host
+func (h *HomeRealmHandler) Pick(c echo.Context) error {
+	t, ok := tenant.FromContext(c)
+	if !ok {
+		id, err := parseTenant(c)
+		if err != nil {
+			return c.JSON(http.StatusNotFound, echo.Map{"message": "tenant not found"})
+		}
+
+		if t, err = h.tenants.FromID(c.Request().Context(), id); err != nil {
+			return tenantLookupError(c, err)
+		}
+	}
+
+	return h.choose(c, DiscoveryResponse{TenantID: t.ID, Tenant: t.Slug})
+}
+
+func tenantLookupError(c echo.Context, err error) error {
+	if errors.Is(err, tenant.ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"message": "tenant not found"})
+	}
+	return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to resolve tenant"})
+}
+
+// choose redireciona para o único IdP habilitado do tenant ou lista as opções
+func (h *HomeRealmHandler) choose(c echo.Context, resp DiscoveryResponse) error {
+	recs, err := h.idps.ListByTenant(c.Request().Context(), resp.TenantID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "failed to list identity providers"})
+	}
+
+	resp.Providers = []DiscoveredProvider{}
+	for _, r := range recs {
+		if !r.Enabled {
+			continue
+		}
+
+		resp.Providers = append(resp.Providers, DiscoveredProvider{
+			ID:       r.ID,
+			Type:     r.ProviderType,
+			Slug:     r.Slug,
+			LoginURL: h.loginURL(resp.Tenant, r.Slug),
+		})
+	}
+
+	if len(resp.Providers) == 0 {
+		return c.JSON(http.StatusNotFound, echo.Map{"message": "tenant has no enabled identity provider"})
+	}
+
+	if len(resp.Providers) == 1 && c.QueryParam("redirect") != "false" {
+		return c.Redirect(http.StatusFound, resp.Providers[0].LoginURL)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// loginURL monta a URL de login do provider a partir dos slugs, sem expor
+// o ID numérico do tenant
+func (h *HomeRealmHandler) loginURL(tenantSlug, idpSlug string) string {
+	return fmt.Sprintf("%s/%s/%s/login", strings.TrimSuffix(h.cfg.BaseURL, "/"), tenantSlug, idpSlug)
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+	"github.com/labstack/echo/v4"
+)
+
+// DomainHandler gerencia os domínios verificados de um tenant
+type DomainHandler struct {
+	repo     repo.TenantDomainRepository
+	verifier *auth.DomainVerifier
+}
+
+// NewDomainHandler cria um novo handler, injetando o repo e o verificador
+func NewDomainHandler(r repo.TenantDomainRepository, verifier *auth.DomainVerifier) *DomainHandler {
+	return &DomainHandler{repo: r, verifier: verifier}
+}
+
+// domainRequest representa o payload de cadastro de domínio
+type domainRequest struct {
+	Domain string `json:"domain" validate:"notblank"`
+}
+
+// DNSRecord é o registro que o tenant publica para provar a posse do domínio
+type DNSRecord struct {
+	Type  string `json:"type"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// DomainResponse representa um domínio do tenant
+type DomainResponse struct {
+	ID         int64      `json:"id"`
+	TenantID   int64      `json:"tenant_id"`
+	Domain     string     `json:"domain"`
+	Verified   bool       `json:"verified"`
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+
+	// Record só é exibido enquanto o domínio está pendente
+	Record *DNSRecord `json:"record,omitempty"`
+}
+
+// Register associa as rotas de administração de domínios
+func (h *DomainHandler) Register(e *echo.Echo) {
+	g := e.Group("/admin/tenants/:tenantID/domains")
+	g.GET("", h.List)
+	g.POST("", h.Create)
+	g.GET("/:id", h.Get)
+	g.POST("/:id/verify", h.Verify)
+	g.DELETE("/:id", h.Delete)
+}
+
+// List retorna os domínios do tenant
+func (h *DomainHandler) List(c echo.Context) error {
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
+	recs, err := h.repo.ListByTenant(c.Request().Context(), tenant)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	out := []DomainResponse{}
+	for _, r := range recs {
+		out = append(out, newDomainResponse(r))
+	}
+
+	return c.JSON(http.StatusOK, out)
+}
+
+// Get retorna um domínio do tenant
+func (h *DomainHandler) Get(c echo.Context) error {
+	rec, err := h.find(c)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, newDomainResponse(rec))
+}
+
+// Create cadastra um domínio pendente e devolve o registro TXT a publicar
+func (h *DomainHandler) Create(c echo.Context) error {
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
+	var req domainRequest
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+
+	domain, err := auth.NormalizeDomain(req.Domain)
+	if err != nil {
+		return invalidField("domain", "domain", err.Error())
+	}
+
+	ctx := c.Request().Context()
+	if err := h.checkOwner(c, tenant, domain); err != nil {
+		return err
+	}
+
+	recs, err := h.repo.ListByTenant(ctx, tenant)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	for _, r := range recs {
+		if r.Domain == domain {
+			return echo.NewHTTPError(http.StatusConflict, "domain already registered")
+		}
+	}
+
+	token, err := auth.NewDomainVerificationToken()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	rec := &repo.TenantDomainRecord{TenantID: tenant, Domain: domain, VerificationToken: token}
+	id, err := h.repo.Create(ctx, rec)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	rec.ID = id
+	rec.CreatedAt = time.Now()
+	return c.JSON(http.StatusCreated, newDomainResponse(rec))
+}
+
+// Verify consulta o registro TXT e marca o domínio como verificado
+func (h *DomainHandler) Verify(c echo.Context) error {
+	rec, err := h.find(c)
+	if err != nil {
+		return err
+	}
+
+	if rec.VerifiedAt != nil {
+		return c.JSON(http.StatusOK, newDomainResponse(rec))
+	}
+
+	if err := h.checkOwner(c, rec.TenantID, rec.Domain); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	if err := h.verifier.Verify(ctx, rec.Domain, rec.VerificationToken); err != nil {
+		switch {
+		case errors.Is(err, auth.ErrDomainNotVerified):
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		case errors.Is(err, auth.ErrDomainLookupFailed):
+			return echo.NewHTTPError(http.StatusServiceUnavailable, err.Error())
+		default:
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	}
+
+	if err := h.repo.MarkVerified(ctx, rec.TenantID, rec.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	now := time.Now()
+	rec.VerifiedAt = &now
+	return c.JSON(http.StatusOK, newDomainResponse(rec))
+}
+
+// Delete remove um domínio do tenant
+func (h *DomainHandler) Delete(c echo.Context) error {
+	id, err := parseID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+	}
+
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
+	if err := h.repo.Delete(c.Request().Context(), tenant, id); err != nil {
+		if err == sql.ErrNoRows {
+			return c.NoContent(http.StatusNotFound)
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// find carrega o domínio do path, restrito ao tenant
+func (h *DomainHandler) find(c echo.Context) (*repo.TenantDomainRecord, error) {
+	id, err := parseID(c)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+	}
+
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
+	rec, err := h.repo.GetByID(c.Request().Context(), tenant, id)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if rec == nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "domain not found")
+	}
+
+	return rec, nil
+}
+
+// checkOwner recusa domínios que já pertencem a outro tenant
+func (h *DomainHandler) checkOwner(c echo.Context, tenantID int64, domain string) error {
+	owner, err := h.repo.FindTenant(c.Request().Context(), domain)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if owner != 0 && owner != tenantID {
+		return echo.NewHTTPError(http.StatusConflict, "domain belongs to another tenant")
+	}
+
+	return nil
+}
+
+// newDomainResponse monta a resposta a partir do registro
+func newDomainResponse(rec *repo.TenantDomainRecord) DomainResponse {
+	resp := DomainResponse{
+		ID:         rec.ID,
+		TenantID:   rec.TenantID,
+		Domain:     rec.Domain,
+		Verified:   rec.VerifiedAt != nil,
+		VerifiedAt: rec.VerifiedAt,
+		CreatedAt:  rec.CreatedAt,
+	}
+
+	if rec.VerifiedAt == nil {
+		name, value := auth.DomainVerificationRecord(rec.Domain, rec.VerificationToken)
+		resp.Record = &DNSRecord{Type: "TXT", Name: name, Value: value}
+	}
+
+	return resp
+}
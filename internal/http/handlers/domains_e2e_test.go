@@ -0,0 +1,265 @@
+package handlers_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+	h "github.com/jeanmolossi/verbose-adventure/internal/http/handlers"
+	"github.com/jeanmolossi/verbose-adventure/internal/http/validation"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+	"github.com/jeanmolossi/verbose-adventure/internal/tenant"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// memDomains is an in-memory repo.TenantDomainRepository
+type memDomains struct {
+	mu      sync.Mutex
+	domains []*repo.TenantDomainRecord
+	// primary mirrors tenants.domain
+	primary map[string]int64
+}
+
+func newMemDomains() *memDomains {
+	return &memDomains{primary: map[string]int64{}}
+}
+
+func (m *memDomains) ListByTenant(ctx context.Context, tenantID int64) ([]*repo.TenantDomainRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []*repo.TenantDomainRecord
+	for _, d := range m.domains {
+		if d.TenantID == tenantID {
+			cp := *d
+			out = append(out, &cp)
+		}
+	}
+	return out, nil
+}
+
+func (m *memDomains) GetByID(ctx context.Context, tenantID, id int64) (*repo.TenantDomainRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, d := range m.domains {
+		if d.ID == id && d.TenantID == tenantID {
+			cp := *d
+			return &cp, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memDomains) Create(ctx context.Context, rec *repo.TenantDomainRecord) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cp := *rec
+	cp.ID = int64(len(m.domains) + 1)
+	m.domains = append(m.domains, &cp)
+	return cp.ID, nil
+}
+
+func (m *memDomains) MarkVerified(ctx context.Context, tenantID, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, d := range m.domains {
+		if d.ID == id && d.TenantID == tenantID {
+			now := time.Now()
+			d.VerifiedAt = &now
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *memDomains) Delete(ctx context.Context, tenantID, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, d := range m.domains {
+		if d.ID == id && d.TenantID == tenantID {
+			m.domains = append(m.domains[:i], m.domains[i+1:]...)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *memDomains) FindTenant(ctx context.Context, domain string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if id, ok := m.primary[domain]; ok {
+		return id, nil
+	}
+	for _, d := range m.domains {
+		if d.Domain == domain && d.VerifiedAt != nil {
+			return d.TenantID, nil
+		}
+	}
+	return 0, nil
+}
+
+// txtRecords is a stubbed auth.TXTResolver
+type txtRecords map[string][]string
+
+func (t txtRecords) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if r, ok := t[name]; ok {
+		return r, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func doJSON(e *echo.Echo, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestDomains_VerifyWithTXTRecord(t *testing.T) {
+	e := echo.New()
+	e.Validator = validation.New(validation.Params{})
+	domains, dns := newMemDomains(), txtRecords{}
+	h.NewDomainHandler(domains, auth.NewDomainVerifier(dns)).Register(e)
+
+	rec := doJSON(e, http.MethodPost, "/admin/tenants/5/domains", `{"domain":"Contoso.com"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	var created h.DomainResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	require.Equal(t, "contoso.com", created.Domain)
+	require.False(t, created.Verified)
+	require.NotNil(t, created.Record)
+	require.Equal(t, "_crm-verification.contoso.com", created.Record.Name)
+
+	// the same domain can not be registered twice by a tenant
+	rec = doJSON(e, http.MethodPost, "/admin/tenants/5/domains", `{"domain":"contoso.com"}`)
+	require.Equal(t, http.StatusConflict, rec.Code)
+
+	// another tenant may claim it while it is pending
+	rec = doJSON(e, http.MethodPost, "/admin/tenants/6/domains", `{"domain":"contoso.com"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = doJSON(e, http.MethodPost, "/admin/tenants/5/domains/1/verify", ``)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	dns[created.Record.Name] = []string{created.Record.Value}
+	rec = doJSON(e, http.MethodPost, "/admin/tenants/5/domains/1/verify", ``)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var verified h.DomainResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &verified))
+	require.True(t, verified.Verified)
+	require.Nil(t, verified.Record)
+
+	// once verified, the other claims are rejected
+	rec = doJSON(e, http.MethodPost, "/admin/tenants/6/domains/2/verify", ``)
+	require.Equal(t, http.StatusConflict, rec.Code)
+
+	// tenants can not reach the domains of each other
+	rec = doJSON(e, http.MethodGet, "/admin/tenants/6/domains/1", ``)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	rec = doJSON(e, http.MethodDelete, "/admin/tenants/6/domains/1", ``)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestDomains_RejectsInvalidDomain(t *testing.T) {
+	e := echo.New()
+	e.Validator = validation.New(validation.Params{})
+	h.NewDomainHandler(newMemDomains(), auth.NewDomainVerifier(txtRecords{})).Register(e)
+
+	rec := doJSON(e, http.MethodPost, "/admin/tenants/5/domains", `{"domain":"localhost"}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func setupDiscovery(idps ...*repo.IdentityProviderRecord) (*echo.Echo, *memDomains) {
+	e := echo.New()
+	domains := newMemDomains()
+	cfg := &config.Config{BaseURL: "https://crm.example.com/"}
+	tenants := &memTenants{tenants: []*repo.TenantRecord{{ID: 5, Slug: "contoso", Name: "Contoso"}}}
+	h.NewHomeRealmHandler(domains, &fakeRepo{list: idps}, tenant.NewResolver(cfg, tenants), cfg).Register(e)
+	return e, domains
+}
+
+func TestDiscover_RedirectsToSingleProvider(t *testing.T) {
+	e, domains := setupDiscovery(
+		&repo.IdentityProviderRecord{ID: 1, TenantID: 5, ProviderType: "oidc", Slug: "oidc", Enabled: true},
+		&repo.IdentityProviderRecord{ID: 2, TenantID: 5, ProviderType: "saml", Slug: "saml", Enabled: false},
+	)
+	domains.primary["contoso.com"] = 5
+
+	rec := doJSON(e, http.MethodGet, "/auth/discover?email=jane@CONTOSO.com", ``)
+	require.Equal(t, http.StatusFound, rec.Code)
+	require.Equal(t, "https://crm.example.com/contoso/oidc/login", rec.Header().Get(echo.HeaderLocation))
+
+	rec = doJSON(e, http.MethodGet, "/auth/discover?domain=contoso.com&redirect=false", ``)
+	require.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestDiscover_ReturnsChoices(t *testing.T) {
+	e, domains := setupDiscovery(
+		&repo.IdentityProviderRecord{ID: 1, TenantID: 5, ProviderType: "oidc", Slug: "oidc", Enabled: true},
+		&repo.IdentityProviderRecord{ID: 2, TenantID: 5, ProviderType: "saml", Slug: "saml", Enabled: true},
+	)
+	now := time.Now()
+	domains.domains = append(domains.domains,
+		&repo.TenantDomainRecord{ID: 1, TenantID: 5, Domain: "contoso.com", VerifiedAt: &now},
+		&repo.TenantDomainRecord{ID: 2, TenantID: 6, Domain: "fabrikam.com"},
+	)
+
+	rec := doJSON(e, http.MethodGet, "/auth/discover?email=jane@contoso.com", ``)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var out h.DiscoveryResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &out))
+	require.Equal(t, int64(5), out.TenantID)
+	require.Len(t, out.Providers, 2)
+	require.Equal(t, "https://crm.example.com/contoso/saml/login", out.Providers[1].LoginURL)
+
+	// pending domains are not used for discovery
+	rec = doJSON(e, http.MethodGet, "/auth/discover?email=john@fabrikam.com", ``)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = doJSON(e, http.MethodGet, "/auth/discover?email=not-an-email", ``)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestPick_ListsProvidersBySlug(t *testing.T) {
+	e, _ := setupDiscovery(
+		&repo.IdentityProviderRecord{ID: 1, TenantID: 5, ProviderType: "oidc", Slug: "azure", Enabled: true},
+		&repo.IdentityProviderRecord{ID: 2, TenantID: 5, ProviderType: "oidc", Slug: "okta", Enabled: true},
+	)
+
+	rec := doJSON(e, http.MethodGet, "/5/sso", ``)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var out h.DiscoveryResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &out))
+	require.Equal(t, int64(5), out.TenantID)
+	require.Len(t, out.Providers, 2)
+	require.Equal(t, "azure", out.Providers[0].Slug)
+	require.Equal(t, "https://crm.example.com/contoso/okta/login", out.Providers[1].LoginURL)
+
+	rec = doJSON(e, http.MethodGet, "/acme/sso", ``)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestPick_RedirectsToSingleProvider(t *testing.T) {
+	e, _ := setupDiscovery(
+		&repo.IdentityProviderRecord{ID: 1, TenantID: 5, ProviderType: "oidc", Slug: "azure", Enabled: true},
+		&repo.IdentityProviderRecord{ID: 2, TenantID: 5, ProviderType: "oidc", Slug: "okta", Enabled: false},
+	)
+
+	rec := doJSON(e, http.MethodGet, "/5/sso", ``)
+	require.Equal(t, http.StatusFound, rec.Code)
+	require.Equal(t, "https://crm.example.com/contoso/azure/login", rec.Header().Get(echo.HeaderLocation))
+}
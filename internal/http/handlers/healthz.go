@@ -3,10 +3,15 @@ package handlers
 import (
 	"database/sql"
 
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
 	"github.com/labstack/echo/v4"
 )
 
-func Healthz(mysqlDB *sql.DB) echo.HandlerFunc {
+// Healthz reports dependencies status. Unhealthy identity providers only
+// degrade the report, they never fail it: one broken tenant IdP must not
+// take the whole API out of rotation. The route is public, so only their
+// count is shown; the details are on the admin IdP API of each tenant.
+func Healthz(mysqlDB *sql.DB, providers auth.HealthReporter) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		if err := mysqlDB.Ping(); err != nil {
 			return c.JSON(500, echo.Map{
@@ -14,10 +19,20 @@ func Healthz(mysqlDB *sql.DB) echo.HandlerFunc {
 			})
 		}
 
+		unhealthy := providers.Unhealthy()
+		idpStatus := "up"
+		if len(unhealthy) > 0 {
+			idpStatus = "degraded"
+		}
+
 		return c.JSON(200, echo.Map{
 			"mysql": map[string]any{
 				"status": "up",
 			},
+			"identity_providers": map[string]any{
+				"status":    idpStatus,
+				"unhealthy": len(unhealthy),
+			},
 		})
 	}
 }
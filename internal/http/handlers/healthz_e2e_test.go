@@ -0,0 +1,37 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
+	h "github.com/jeanmolossi/verbose-adventure/internal/http/handlers"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+type unhealthyProviders []auth.ProviderHealth
+
+func (u unhealthyProviders) Unhealthy() []auth.ProviderHealth { return u }
+
+func TestHealthz_HidesProviderDetails(t *testing.T) {
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	providers := unhealthyProviders{{
+		ID: 3, TenantID: 7, Type: "oidc", Slug: "okta",
+		LastError: "discovery https://okta.internal.example.com failed",
+	}}
+
+	e := echo.New()
+	e.GET("/healthz", h.Healthz(db, providers))
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"mysql":{"status":"up"},"identity_providers":{"status":"degraded","unhealthy":1}}`, rec.Body.String())
+}
@@ -1,19 +1,19 @@
 package handlers
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
 	"database/sql"
-	"encoding/base64"
 	"fmt"
-	"io"
+	"maps"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
 	"github.com/jeanmolossi/verbose-adventure/internal/config"
+	"github.com/jeanmolossi/verbose-adventure/internal/encryption"
 	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+	"github.com/jeanmolossi/verbose-adventure/internal/tenant"
 	"github.com/jeanmolossi/verbose-adventure/pkg/stripslash"
 	"github.com/labstack/echo/v4"
 	"go.uber.org/fx"
@@ -21,50 +21,180 @@ import (
 
 // IDPHandler gerencia CRUD de Identity Providers
 type IDPHandler struct {
-	repo repo.IdentityProviderRepository
-	cfg  *config.Config
+	repo        repo.IdentityProviderRepository
+	cfg         *config.Config
+	secrets     *encryption.Keyring
+	providers   auth.ProviderRegistry
+	diagnostics auth.ProviderDiagnostician
 }
 
 type IDPHandlerParams struct {
 	fx.In
-	Repo repo.IdentityProviderRepository
-	Cfg  *config.Config
+	Repo      repo.IdentityProviderRepository
+	Cfg       *config.Config
+	Secrets   *encryption.Keyring
+	Providers auth.ProviderRegistry
+	// Diagnostics testa o IdP; sem ele o teste de conexão fica indisponível
+	// e a descoberta não é gravada ao salvar
+	Diagnostics auth.ProviderDiagnostician `optional:"true"`
 }
 
 // NewIDPHandler cria um novo handler, injetando o repo
 func NewIDPHandler(p IDPHandlerParams) *IDPHandler {
-	return &IDPHandler{repo: p.Repo, cfg: p.Cfg}
+	return &IDPHandler{repo: p.Repo, cfg: p.Cfg, secrets: p.Secrets, providers: p.Providers, diagnostics: p.Diagnostics}
 }
 
 // idpRequest representa o payload de criação/atualização
 type idpRequest struct {
 	ProviderType string `json:"type" validate:"required,oneof=oidc saml"`
-	MetadataURL  string `json:"metadata_url" validate:"required,url"`
+	// Slug identifica o provider nas URLs de login; o padrão é o tipo
+	Slug         string `json:"slug" validate:"omitempty,slug,tenant_unique=idp_slug"`
+	MetadataURL  string `json:"metadata_url" validate:"required,url_scheme=https http"`
 	ClientID     string `json:"client_id" validate:"required"`
 	ClientSecret string `json:"client_secret" validate:"required"`
 	Enabled      bool   `json:"enabled"`
+
+	// Configurações opcionais por IdP
+	Scopes       []string          `json:"scopes"`
+	ClaimMapping repo.ClaimMapping `json:"claim_mapping"`
+	RoleMapping  map[string]string `json:"role_mapping" validate:"dive,role"`
+	AuthParams   map[string]string `json:"auth_params"`
+	LoginPolicy  repo.LoginPolicy  `json:"login_policy"`
+	RedirectURL  string            `json:"redirect_url" validate:"omitempty,url_scheme=https http"`
+}
+
+// idpPatchRequest representa o payload de atualização parcial: campos
+// ausentes mantêm o valor atual. Listas e mapas são substituídos por inteiro.
+type idpPatchRequest struct {
+	ProviderType *string `json:"type" validate:"omitnil,oneof=oidc saml"`
+	Slug         *string `json:"slug" validate:"omitnil,slug,tenant_unique=idp_slug"`
+	MetadataURL  *string `json:"metadata_url" validate:"omitnil,url_scheme=https http"`
+	ClientID     *string `json:"client_id" validate:"omitnil,min=1"`
+	// ClientSecret troca o segredo; ele nunca volta nas respostas
+	ClientSecret *string `json:"client_secret" validate:"omitnil,min=1"`
+	Enabled      *bool   `json:"enabled"`
+
+	Scopes       *[]string          `json:"scopes"`
+	ClaimMapping *repo.ClaimMapping `json:"claim_mapping"`
+	RoleMapping  *map[string]string `json:"role_mapping" validate:"omitnil,dive,role"`
+	AuthParams   *map[string]string `json:"auth_params"`
+	LoginPolicy  *repo.LoginPolicy  `json:"login_policy"`
+	RedirectURL  *string            `json:"redirect_url" validate:"omitnil,omitzero,url_scheme=https http"`
+}
+
+// defaultSlug usa o tipo como slug de um provider criado sem um
+func (r *idpRequest) defaultSlug() {
+	if r.Slug == "" {
+		r.Slug = r.ProviderType
+	}
+}
+
+// checkSettings valida as configurações do payload que dependem umas das
+// outras ou não cabem em tags, e normaliza os domínios da política de login
+func (r *idpRequest) checkSettings() error {
+	for k := range r.AuthParams {
+		for _, reserved := range auth.ReservedAuthParams {
+			if strings.EqualFold(k, reserved) {
+				return invalidField("auth_params."+k, "reserved", "can not override a parameter of the login flow")
+			}
+		}
+	}
+
+	for i, s := range r.Scopes {
+		if s == "" || strings.ContainsAny(s, " \t\n") {
+			return invalidField(fmt.Sprintf("scopes[%d]", i), "scope", "must be a non-empty scope without spaces")
+		}
+	}
+
+	return r.checkLoginPolicy()
 }
 
-// IdpResponse representa a resposta ao cliente
+// checkLoginPolicy valida a política de login; só IdPs OIDC a aplicam
+func (r *idpRequest) checkLoginPolicy() error {
+	p := &r.LoginPolicy
+	if p.IsZero() {
+		return nil
+	}
+
+	if r.ProviderType != "oidc" {
+		return invalidField("login_policy", "oidc_only", "is only supported by oidc providers")
+	}
+
+	for _, values := range []struct {
+		name   string
+		values []string
+	}{
+		{"acr_values", p.ACRValues},
+		{"amr_values", p.AMRValues},
+	} {
+		for i, v := range values.values {
+			if v == "" || strings.ContainsAny(v, " \t\n") {
+				return invalidField(fmt.Sprintf("login_policy.%s[%d]", values.name, i), "token", "must be a non-empty value without spaces")
+			}
+		}
+	}
+
+	for i, d := range p.AllowedEmailDomains {
+		domain, err := auth.NormalizeDomain(d)
+		if err != nil || strings.Contains(d, "@") {
+			return invalidField(fmt.Sprintf("login_policy.allowed_email_domains[%d]", i), "domain", "must be a valid domain")
+		}
+		p.AllowedEmailDomains[i] = domain
+	}
+
+	return nil
+}
+
+// IdpResponse representa a resposta ao cliente. O segredo do client nunca
+// é devolvido, nem cifrado: só uma dica mascarada e a data da última troca.
 type IdpResponse struct {
-	ID              int64  `json:"id"`
-	TenantID        int64  `json:"tenant_id"`
-	ProviderType    string `json:"type"`
-	MetadataURL     string `json:"metadata_url"`
-	ClientSecretEnc string `json:"client_secret_enc"`
-	Enabled         bool   `json:"enabled"`
-	CreatedAt       string `json:"created_at"`
-	UpdatedAt       string `json:"updated_at"`
+	ID               int64  `json:"id"`
+	TenantID         int64  `json:"tenant_id"`
+	ProviderType     string `json:"type"`
+	Slug             string `json:"slug"`
+	MetadataURL      string `json:"metadata_url"`
+	ClientID         string `json:"client_id"`
+	ClientSecretHint string `json:"client_secret_hint,omitempty"`
+	SecretUpdatedAt  string `json:"secret_updated_at,omitempty"`
+	Enabled          bool   `json:"enabled"`
+	CreatedAt        string `json:"created_at"`
+	UpdatedAt        string `json:"updated_at"`
+
+	Scopes       []string          `json:"scopes"`
+	ClaimMapping repo.ClaimMapping `json:"claim_mapping"`
+	RoleMapping  map[string]string `json:"role_mapping"`
+	AuthParams   map[string]string `json:"auth_params"`
+	LoginPolicy  repo.LoginPolicy  `json:"login_policy"`
+	RedirectURL  string            `json:"redirect_url,omitempty"`
+
+	// Discovery é o retrato do issuer e dos endpoints feito ao salvar
+	Discovery *repo.ProviderDiscovery `json:"discovery,omitempty"`
+
+	Health *auth.ProviderHealth `json:"health,omitempty"`
+}
+
+// idpTestRequest representa o payload opcional do teste de conexão
+type idpTestRequest struct {
+	// CheckCredentials autentica o client no token endpoint do IdP
+	CheckCredentials bool `json:"check_credentials"`
+}
+
+// idpDiscoveryError é a resposta de um save recusado pela descoberta
+type idpDiscoveryError struct {
+	Message   string          `json:"message"`
+	Diagnosis *auth.Diagnosis `json:"diagnosis"`
 }
 
 // Register associa as rotas de administração de IdP
 func (h *IDPHandler) Register(e *echo.Echo) {
 	g := e.Group("/admin/tenants/:tenantID/idps")
-	g.GET(":id", h.Get)
+	g.GET("/:id", h.Get)
 	g.GET("", h.List)
 	g.POST("", h.Create)
-	g.PUT(":id", h.Update)
-	g.DELETE(":id", h.Delete)
+	g.PUT("/:id", h.Update)
+	g.PATCH("/:id", h.Patch)
+	g.DELETE("/:id", h.Delete)
+	g.POST("/:id/test", h.Test)
 }
 
 // List retorna todos os providers de um tenant
@@ -79,18 +209,11 @@ func (h *IDPHandler) List(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
+	health := h.healthByID(tid)
+
 	var out []IdpResponse
 	for _, r := range recs {
-		out = append(out, IdpResponse{
-			ID:              r.ID,
-			TenantID:        r.TenantID,
-			ProviderType:    r.ProviderType,
-			MetadataURL:     r.MetadataURL,
-			ClientSecretEnc: base64.StdEncoding.EncodeToString(r.ClientSecretEnc),
-			Enabled:         r.Enabled,
-			CreatedAt:       r.CreatedAt.Format(time.RFC3339),
-			UpdatedAt:       r.UpdatedAt.Format(time.RFC3339),
-		})
+		out = append(out, newIdpResponse(r, health[r.ID]))
 	}
 
 	return c.JSON(http.StatusOK, out)
@@ -103,7 +226,12 @@ func (h *IDPHandler) Get(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, c.Param("id"))
 	}
 
-	rec, err := h.repo.GetByID(c.Request().Context(), id)
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
+	rec, err := h.repo.GetByID(c.Request().Context(), tenant, id)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
@@ -112,46 +240,55 @@ func (h *IDPHandler) Get(c echo.Context) error {
 		return c.NoContent(http.StatusNotFound)
 	}
 
-	resp := IdpResponse{
-		ID:              rec.ID,
-		TenantID:        rec.TenantID,
-		ProviderType:    rec.ProviderType,
-		MetadataURL:     rec.MetadataURL,
-		ClientSecretEnc: base64.StdEncoding.EncodeToString(rec.ClientSecretEnc),
-		Enabled:         rec.Enabled,
-		CreatedAt:       rec.CreatedAt.Format(time.RFC3339),
-		UpdatedAt:       rec.UpdatedAt.Format(time.RFC3339),
-	}
+	resp := newIdpResponse(rec, h.healthByID(rec.TenantID)[rec.ID])
 
 	return c.JSON(http.StatusOK, resp)
 }
 
 // Create adiciona um novo Identity Provider
 func (h *IDPHandler) Create(c echo.Context) error {
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
 	var req idpRequest
-	if err := c.Bind(&req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	if err := bindRequest(c, &req, req.defaultSlug); err != nil {
+		return err
 	}
 
-	// decodifica secret
-	rawSecret := []byte(req.ClientSecret)
-	secret, err := encryptSecret(rawSecret, h.cfg.EncryptionKey)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid client_secret_enc, must be base64")
+	if err := req.checkSettings(); err != nil {
+		return err
 	}
 
-	tenant, err := parseTenant(c)
+	if err := checkRoleMapping(c, nil, req.RoleMapping); err != nil {
+		return err
+	}
+
+	secret, err := h.secrets.Encrypt(c.Request().Context(), []byte(req.ClientSecret))
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to encrypt secret")
 	}
 
 	rec := &repo.IdentityProviderRecord{
-		TenantID:        tenant,
-		ProviderType:    req.ProviderType,
-		MetadataURL:     req.MetadataURL,
-		ClientID:        req.ClientID,
-		ClientSecretEnc: secret,
-		Enabled:         req.Enabled,
+		TenantID:         tenant,
+		ProviderType:     req.ProviderType,
+		Slug:             req.Slug,
+		MetadataURL:      req.MetadataURL,
+		ClientID:         req.ClientID,
+		ClientSecretEnc:  secret,
+		ClientSecretHint: secretHint(req.ClientSecret),
+		Scopes:           req.Scopes,
+		ClaimMapping:     req.ClaimMapping,
+		RoleMapping:      req.RoleMapping,
+		AuthParams:       req.AuthParams,
+		LoginPolicy:      req.LoginPolicy,
+		RedirectURL:      req.RedirectURL,
+		Enabled:          req.Enabled,
+	}
+
+	if err := h.discover(c, rec); err != nil {
+		return err
 	}
 
 	id, err := h.repo.Create(c.Request().Context(), rec)
@@ -160,6 +297,7 @@ func (h *IDPHandler) Create(c echo.Context) error {
 	}
 
 	rec.ID = id
+	h.providers.Invalidate(tenant)
 
 	return c.JSON(http.StatusCreated, map[string]int64{"id": id})
 }
@@ -171,38 +309,303 @@ func (h *IDPHandler) Update(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid id")
 	}
 
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
+	// sem slug no payload o provider mantém o atual, preservando suas URLs
 	var req idpRequest
-	if err := c.Bind(&req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	if err := bindRequest(c, &req); err != nil {
+		return err
 	}
 
-	rawSecret := []byte(req.ClientSecret)
-	secret, err := encryptSecret(rawSecret, h.cfg.EncryptionKey)
+	if err := req.checkSettings(); err != nil {
+		return err
+	}
+
+	current, err := h.repo.GetByID(c.Request().Context(), tenant, id)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to encrypt secret")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
-	tenant, err := parseTenant(c)
+	// sem o registro atual o repo responde 404 ao gravar
+	var mapping map[string]string
+	if current != nil {
+		mapping = current.RoleMapping
+	}
+
+	if err := checkRoleMapping(c, mapping, req.RoleMapping); err != nil {
+		return err
+	}
+
+	secret, err := h.secrets.Encrypt(c.Request().Context(), []byte(req.ClientSecret))
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to encrypt secret")
 	}
 
 	rec := &repo.IdentityProviderRecord{
-		ID:              id,
-		TenantID:        tenant,
-		ProviderType:    req.ProviderType,
-		MetadataURL:     req.MetadataURL,
-		ClientID:        req.ClientID,
-		ClientSecretEnc: secret,
-		Enabled:         req.Enabled,
+		ID:               id,
+		TenantID:         tenant,
+		ProviderType:     req.ProviderType,
+		Slug:             req.Slug,
+		MetadataURL:      req.MetadataURL,
+		ClientID:         req.ClientID,
+		ClientSecretEnc:  secret,
+		ClientSecretHint: secretHint(req.ClientSecret),
+		Scopes:           req.Scopes,
+		ClaimMapping:     req.ClaimMapping,
+		RoleMapping:      req.RoleMapping,
+		AuthParams:       req.AuthParams,
+		LoginPolicy:      req.LoginPolicy,
+		RedirectURL:      req.RedirectURL,
+		Enabled:          req.Enabled,
+	}
+
+	if err := h.discover(c, rec); err != nil {
+		return err
 	}
+
 	if err := h.repo.Update(c.Request().Context(), rec); err != nil {
+		if err == sql.ErrNoRows {
+			return c.NoContent(http.StatusNotFound)
+		}
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
+	h.providers.Invalidate(tenant)
+
 	return c.NoContent(http.StatusNoContent)
 }
 
+// Patch atualiza só os campos enviados de um Identity Provider. Um novo
+// client_secret é cifrado e gravado sem nunca ser devolvido. A descoberta é
+// refeita quando muda algo que a afeta: tipo, metadata_url, client_id ou
+// enabled. Responde com o provider atualizado.
+func (h *IDPHandler) Patch(c echo.Context) error {
+	id, err := parseID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+	}
+
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
+	var req idpPatchRequest
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	current, err := h.repo.GetByID(ctx, tenant, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if current == nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	rec, columns := req.apply(*current)
+
+	// as configurações são validadas já combinadas, pois umas dependem das
+	// outras, como a política de login e o tipo
+	merged := idpRequest{
+		ProviderType: rec.ProviderType,
+		Slug:         rec.Slug,
+		Scopes:       rec.Scopes,
+		RoleMapping:  rec.RoleMapping,
+		AuthParams:   rec.AuthParams,
+		LoginPolicy:  rec.LoginPolicy,
+		RedirectURL:  rec.RedirectURL,
+	}
+	if err := merged.checkSettings(); err != nil {
+		return err
+	}
+	rec.LoginPolicy = merged.LoginPolicy
+
+	if err := checkRoleMapping(c, current.RoleMapping, rec.RoleMapping); err != nil {
+		return err
+	}
+
+	if len(columns) == 0 && req.ClientSecret == nil {
+		return c.JSON(http.StatusOK, newIdpResponse(current, h.healthByID(tenant)[id]))
+	}
+
+	if req.ClientSecret != nil {
+		if rec.ClientSecretEnc, err = h.secrets.Encrypt(ctx, []byte(*req.ClientSecret)); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to encrypt secret")
+		}
+		rec.ClientSecretHint = secretHint(*req.ClientSecret)
+		columns = append(columns, "client_secret_enc", "client_secret_hint")
+	}
+
+	// a descoberta só depende do tipo, da URL de metadados e do client, e é
+	// refeita ao ativar o IdP, que só é ativado se ela passar. Desativá-lo
+	// mantém o retrato atual.
+	rediscover := rec.ProviderType != current.ProviderType || rec.MetadataURL != current.MetadataURL ||
+		rec.ClientID != current.ClientID || (rec.Enabled && !current.Enabled)
+	if h.diagnostics != nil && rediscover {
+		if err := h.discover(c, &rec); err != nil {
+			return err
+		}
+		columns = append(columns, "discovery")
+	}
+
+	if err := h.repo.UpdateFields(ctx, &rec, columns...); err != nil {
+		if err == sql.ErrNoRows {
+			return c.NoContent(http.StatusNotFound)
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	h.providers.Invalidate(tenant)
+
+	updated, err := h.repo.GetByID(ctx, tenant, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if updated == nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	return c.JSON(http.StatusOK, newIdpResponse(updated, h.healthByID(tenant)[id]))
+}
+
+// apply copia os campos enviados para rec e lista as colunas alteradas; o
+// segredo fica a cargo de Patch, que precisa cifrá-lo
+func (r *idpPatchRequest) apply(rec repo.IdentityProviderRecord) (repo.IdentityProviderRecord, []string) {
+	var columns []string
+	set := func(column string) { columns = append(columns, column) }
+
+	if r.ProviderType != nil {
+		rec.ProviderType = *r.ProviderType
+		set("type")
+	}
+	if r.Slug != nil {
+		rec.Slug = *r.Slug
+		set("slug")
+	}
+	if r.MetadataURL != nil {
+		rec.MetadataURL = *r.MetadataURL
+		set("metadata_url")
+	}
+	if r.ClientID != nil {
+		rec.ClientID = *r.ClientID
+		set("client_id")
+	}
+	if r.Enabled != nil {
+		rec.Enabled = *r.Enabled
+		set("enabled")
+	}
+	if r.Scopes != nil {
+		rec.Scopes = *r.Scopes
+		set("scopes")
+	}
+	if r.ClaimMapping != nil {
+		rec.ClaimMapping = *r.ClaimMapping
+		set("claim_mapping")
+	}
+	if r.RoleMapping != nil {
+		rec.RoleMapping = *r.RoleMapping
+		set("role_mapping")
+	}
+	if r.AuthParams != nil {
+		rec.AuthParams = *r.AuthParams
+		set("auth_params")
+	}
+	if r.LoginPolicy != nil {
+		rec.LoginPolicy = *r.LoginPolicy
+		set("login_policy")
+	}
+	if r.RedirectURL != nil {
+		rec.RedirectURL = *r.RedirectURL
+		set("redirect_url")
+	}
+
+	return rec, columns
+}
+
+// Test executa o diagnóstico de conexão de um Identity Provider salvo:
+// descoberta, issuer, chaves de assinatura e, se pedido, as credenciais do
+// client. O relatório é devolvido com 200 mesmo quando alguma etapa falha.
+func (h *IDPHandler) Test(c echo.Context) error {
+	if h.diagnostics == nil {
+		return echo.NewHTTPError(http.StatusNotImplemented, "connection tests are not available")
+	}
+
+	id, err := parseID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+	}
+
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
+	var req idpTestRequest
+	if c.Request().ContentLength != 0 {
+		if err := bindRequest(c, &req); err != nil {
+			return err
+		}
+	}
+
+	rec, err := h.repo.GetByID(c.Request().Context(), tenant, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if rec == nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	in := auth.DiagnosticRequest{
+		Type:             rec.ProviderType,
+		MetadataURL:      rec.MetadataURL,
+		ClientID:         rec.ClientID,
+		CheckCredentials: req.CheckCredentials,
+	}
+
+	if req.CheckCredentials {
+		secret, err := h.secrets.Decrypt(c.Request().Context(), rec.ClientSecretEnc)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to decrypt secret")
+		}
+		in.ClientSecret = string(secret)
+	}
+
+	return c.JSON(http.StatusOK, h.diagnostics.Diagnose(c.Request().Context(), in))
+}
+
+// discover grava no registro o retrato da descoberta do IdP. Um provider
+// habilitado que não passa na descoberta é recusado com o relatório, pois
+// nenhum login funcionaria; um desabilitado é salvo sem o retrato.
+func (h *IDPHandler) discover(c echo.Context, rec *repo.IdentityProviderRecord) error {
+	if h.diagnostics == nil {
+		return nil
+	}
+
+	d := h.diagnostics.Diagnose(c.Request().Context(), auth.DiagnosticRequest{
+		Type:        rec.ProviderType,
+		MetadataURL: rec.MetadataURL,
+		ClientID:    rec.ClientID,
+	})
+
+	if rec.Enabled && !d.OK {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, idpDiscoveryError{
+			Message:   "identity provider discovery failed",
+			Diagnosis: d,
+		})
+	}
+
+	rec.Discovery = d.Discovery
+	return nil
+}
+
 // Delete remove um Identity Provider
 func (h *IDPHandler) Delete(c echo.Context) error {
 	id, err := parseID(c)
@@ -210,43 +613,101 @@ func (h *IDPHandler) Delete(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid id")
 	}
 
-	if err := h.repo.Delete(c.Request().Context(), id); err != nil {
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
+	if err := h.repo.Delete(c.Request().Context(), tenant, id); err != nil {
 		if err == sql.ErrNoRows {
 			return c.NoContent(http.StatusNotFound)
 		}
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
+
+	h.providers.Invalidate(tenant)
 	return c.NoContent(http.StatusNoContent)
 }
 
-// parseTenant obtém tenantID do context
-func parseTenant(c echo.Context) (int64, error) {
-	tenant := stripslash.ParamWithoutAnySlashes(c, "tenantID")
-	return strconv.ParseInt(tenant, 10, 64)
+// newIdpResponse monta a resposta a partir do registro e do status do provider
+func newIdpResponse(rec *repo.IdentityProviderRecord, health *auth.ProviderHealth) IdpResponse {
+	resp := IdpResponse{
+		ID:               rec.ID,
+		TenantID:         rec.TenantID,
+		ProviderType:     rec.ProviderType,
+		Slug:             rec.Slug,
+		MetadataURL:      rec.MetadataURL,
+		ClientID:         rec.ClientID,
+		ClientSecretHint: rec.ClientSecretHint,
+		Enabled:          rec.Enabled,
+		CreatedAt:        rec.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:        rec.UpdatedAt.Format(time.RFC3339),
+		Scopes:           rec.Scopes,
+		ClaimMapping:     rec.ClaimMapping,
+		RoleMapping:      rec.RoleMapping,
+		AuthParams:       rec.AuthParams,
+		LoginPolicy:      rec.LoginPolicy,
+		RedirectURL:      rec.RedirectURL,
+		Discovery:        rec.Discovery,
+		Health:           health,
+	}
+
+	if rec.SecretUpdatedAt != nil {
+		resp.SecretUpdatedAt = rec.SecretUpdatedAt.Format(time.RFC3339)
+	}
+
+	return resp
 }
 
-func parseID(c echo.Context) (int64, error) {
-	pid := stripslash.ParamWithoutBackslash(c, "id")
-	return strconv.ParseInt(pid, 10, 64)
+// secretHint mascara o segredo para o admin reconhecê-lo. Os quatro últimos
+// caracteres só aparecem em segredos longos, em que não ajudam a adivinhá-lo.
+func secretHint(secret string) string {
+	const shown, minLen = 4, 16
+
+	r := []rune(secret)
+	if len(r) < minLen {
+		return "****"
+	}
+	return "****" + string(r[len(r)-shown:])
 }
 
-// encryptSecret aplica AES-GCM com chave base64 (32 bytes) e retorna nonce|ciphertext
-func encryptSecret(plaintext []byte, keyB64 string) ([]byte, error) {
-	key, err := base64.StdEncoding.DecodeString(keyB64)
-	if err != nil {
-		return nil, fmt.Errorf("can not decode encryption key: %w", err)
+// checkRoleMapping exige roles:write para criar ou mudar o role_mapping.
+// Ele concede papéis no próximo login do usuário, inclusive os sincronizados
+// pelo SCIM, e sem a checagem quem só tem idp:write se promoveria a owner.
+func checkRoleMapping(c echo.Context, current, next map[string]string) error {
+	if maps.Equal(current, next) {
+		return nil
 	}
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, err
+
+	if claims, ok := auth.FromContext(c); ok && !claims.Can(auth.PermRoleWrite) {
+		return echo.NewHTTPError(http.StatusForbidden, "changing role_mapping requires "+auth.PermRoleWrite)
 	}
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
+
+	return nil
+}
+
+// healthByID indexa o status dos providers carregados de um tenant
+func (h *IDPHandler) healthByID(tenantID int64) map[int64]*auth.ProviderHealth {
+	out := make(map[int64]*auth.ProviderHealth)
+	for _, ph := range h.providers.Health(tenantID) {
+		out[ph.ID] = &ph
 	}
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, err
+
+	return out
+}
+
+// parseTenant obtém o tenant resolvido pelo middleware, ou o ID numérico do
+// path quando a rota é montada sem o middleware
+func parseTenant(c echo.Context) (int64, error) {
+	if t, ok := tenant.FromContext(c); ok {
+		return t.ID, nil
 	}
-	return append(nonce, gcm.Seal(nil, nonce, plaintext, nil)...), nil
+
+	tid := stripslash.ParamWithoutAnySlashes(c, "tenantID")
+	return strconv.ParseInt(tid, 10, 64)
+}
+
+func parseID(c echo.Context) (int64, error) {
+	pid := stripslash.ParamWithoutBackslash(c, "id")
+	return strconv.ParseInt(pid, 10, 64)
 }
@@ -3,27 +3,41 @@ package handlers_test
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"database/sql"
 	"encoding/base64"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"testing"
 	"time"
 
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
 	"github.com/jeanmolossi/verbose-adventure/internal/config"
+	"github.com/jeanmolossi/verbose-adventure/internal/encryption"
 	h "github.com/jeanmolossi/verbose-adventure/internal/http/handlers"
+	"github.com/jeanmolossi/verbose-adventure/internal/http/middleware"
+	"github.com/jeanmolossi/verbose-adventure/internal/http/validation"
 	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+	"github.com/jeanmolossi/verbose-adventure/internal/tenant"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/require"
 )
 
 // fakeRepo implements IdentityProviderRepository for testing
 type fakeRepo struct {
-	list     []*repo.IdentityProviderRecord
-	create   *repo.IdentityProviderRecord
-	update   *repo.IdentityProviderRecord
+	list   []*repo.IdentityProviderRecord
+	create *repo.IdentityProviderRecord
+	update *repo.IdentityProviderRecord
+	// columns written by UpdateFields
+	columns  []string
 	deleteID int64
+	// tenant received by the tenant-scoped methods
+	tenantID int64
 
 	// errors to return
 	listErr   error
@@ -40,10 +54,14 @@ func (f *fakeRepo) ListByTenant(ctx context.Context, tenantID int64) ([]*repo.Id
 	return f.list, f.listErr
 }
 
-func (f *fakeRepo) GetByID(ctx context.Context, id int64) (*repo.IdentityProviderRecord, error) {
+func (f *fakeRepo) GetByID(ctx context.Context, tenantID, id int64) (*repo.IdentityProviderRecord, error) {
+	f.tenantID = tenantID
 	if f.getErr != nil {
 		return nil, f.getErr
 	}
+	if f.getRec == nil || f.getRec.TenantID != tenantID || f.getRec.ID != id {
+		return nil, nil
+	}
 	return f.getRec, nil
 }
 
@@ -57,24 +75,48 @@ func (f *fakeRepo) Update(ctx context.Context, rec *repo.IdentityProviderRecord)
 	return f.updateErr
 }
 
-func (f *fakeRepo) Delete(ctx context.Context, id int64) error {
+func (f *fakeRepo) UpdateFields(ctx context.Context, rec *repo.IdentityProviderRecord, columns ...string) error {
+	f.update = rec
+	f.columns = columns
+	if f.updateErr != nil {
+		return f.updateErr
+	}
+
+	updated := *rec
+	if slices.Contains(columns, "client_secret_enc") {
+		now := time.Now()
+		updated.SecretUpdatedAt = &now
+	}
+	f.getRec = &updated
+	return nil
+}
+
+func (f *fakeRepo) Delete(ctx context.Context, tenantID, id int64) error {
+	f.tenantID = tenantID
 	f.deleteID = id
 	return f.deleteErr
 }
 
 func setup() (*echo.Echo, *fakeRepo) {
+	e, repo, _ := setupWithRegistry()
+	return e, repo
+}
+
+func setupWithRegistry() (*echo.Echo, *fakeRepo, *staticRegistry) {
 	e := echo.New()
 
 	repo := &fakeRepo{}
+	e.Validator = validation.New(validation.Params{IdentityProviders: repo})
+	registry := &staticRegistry{}
 
 	cfg := &config.Config{
 		EncryptionKey: base64.StdEncoding.EncodeToString([]byte("01234567890123456789012345678901")),
 	}
 
-	handler := h.NewIDPHandler(h.IDPHandlerParams{Repo: repo, Cfg: cfg})
+	handler := h.NewIDPHandler(h.IDPHandlerParams{Repo: repo, Cfg: cfg, Secrets: testKeyring(cfg), Providers: registry})
 	handler.Register(e)
 
-	return e, repo
+	return e, repo, registry
 }
 
 func TestList_Success(t *testing.T) {
@@ -145,7 +187,7 @@ func TestCreate_Success(t *testing.T) {
 }
 
 func TestUpdate_Success(t *testing.T) {
-	e, repo := setup()
+	e, repo, registry := setupWithRegistry()
 	// build request payload
 	payload := map[string]interface{}{
 		"type": "saml", "metadata_url": "https://saml",
@@ -165,11 +207,13 @@ func TestUpdate_Success(t *testing.T) {
 	// Check repo.update was called
 	require.NotNil(t, repo.update)
 	require.Equal(t, int64(77), repo.update.ID)
+	require.Equal(t, int64(7), repo.update.TenantID)
 	require.Equal(t, "saml", repo.update.ProviderType)
+	require.Equal(t, []int64{7}, registry.invalidated)
 }
 
 func TestDelete_Success(t *testing.T) {
-	e, repo := setup()
+	e, repo, registry := setupWithRegistry()
 
 	req := httptest.NewRequest(http.MethodDelete, "/admin/tenants/9/idps/99", nil)
 	rec := httptest.NewRecorder()
@@ -179,4 +223,688 @@ func TestDelete_Success(t *testing.T) {
 	defer reqRes.Body.Close()
 	require.Equal(t, http.StatusNoContent, reqRes.StatusCode)
 	require.Equal(t, int64(99), repo.deleteID)
+	require.Equal(t, int64(9), repo.tenantID)
+	require.Equal(t, []int64{9}, registry.invalidated)
+}
+
+func TestList_IncludesHealth(t *testing.T) {
+	e, repoMock, registry := setupWithRegistry()
+	repoMock.list = []*repo.IdentityProviderRecord{
+		{ID: 1, TenantID: 99, ProviderType: "oidc"},
+		{ID: 2, TenantID: 99, ProviderType: "saml"},
+	}
+	registry.health = []auth.ProviderHealth{
+		{ID: 2, TenantID: 99, Type: "saml", Healthy: false, LastError: "fetch saml metadata: 404"},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/tenants/99/idps", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var out []h.IdpResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&out))
+	require.Len(t, out, 2)
+	require.Nil(t, out[0].Health)
+	require.NotNil(t, out[1].Health)
+	require.False(t, out[1].Health.Healthy)
+	require.Equal(t, "fetch saml metadata: 404", out[1].Health.LastError)
+}
+
+func TestCreate_WithSettings(t *testing.T) {
+	e, repo := setup()
+	payload := map[string]interface{}{
+		"type": "oidc", "metadata_url": "https://login.microsoftonline.com/tid/v2.0",
+		"client_id": "cid", "client_secret": "secret", "enabled": true,
+		"scopes":        []string{"offline_access"},
+		"claim_mapping": map[string]string{"user_id": "oid", "groups": "groups"},
+		"auth_params":   map[string]string{"domain_hint": "contoso.com"},
+		"redirect_url":  "https://crm.example.com/5/oidc/callback",
+	}
+	body, _ := json.Marshal(payload)
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/tenants/5/idps", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.Equal(t, []string{"offline_access"}, repo.create.Scopes)
+	require.Equal(t, "oid", repo.create.ClaimMapping.UserID)
+	require.Equal(t, "contoso.com", repo.create.AuthParams["domain_hint"])
+	require.Equal(t, "https://crm.example.com/5/oidc/callback", repo.create.RedirectURL)
+}
+
+func TestCreate_RejectsReservedAuthParam(t *testing.T) {
+	e, repo := setup()
+	payload := map[string]interface{}{
+		"type": "oidc", "metadata_url": "https://example.com",
+		"client_id": "cid", "client_secret": "secret",
+		"auth_params": map[string]string{"redirect_uri": "https://evil.example.com"},
+	}
+	body, _ := json.Marshal(payload)
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/tenants/5/idps", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Nil(t, repo.create)
+}
+
+func TestGet_OtherTenantIsNotFound(t *testing.T) {
+	e, repoMock := setup()
+	repoMock.getRec = &repo.IdentityProviderRecord{ID: 42, TenantID: 2, ProviderType: "oidc"}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/tenants/1/idps/42", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	require.Equal(t, int64(1), repoMock.tenantID)
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/tenants/2/idps/42", nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestUpdate_OtherTenantIsNotFound(t *testing.T) {
+	e, repoMock, registry := setupWithRegistry()
+	repoMock.updateErr = sql.ErrNoRows
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"type": "oidc", "metadata_url": "https://example.com",
+		"client_id": "cid", "client_secret": "secret",
+	})
+
+	req := httptest.NewRequest(http.MethodPut, "/admin/tenants/7/idps/77", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	require.Empty(t, registry.invalidated)
+}
+
+// signToken issues an access token for the admin route tests
+func signToken(t *testing.T, cfg *config.Config, keys *auth.Keyring, tenantID int64, superAdmin bool, roles ...string) string {
+	t.Helper()
+
+	now := time.Now()
+	signed, err := keys.Sign(&auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    cfg.Issuer(),
+			Audience:  jwt.ClaimStrings{"crm-api"},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+		TenantID:   tenantID,
+		UserID:     1,
+		SuperAdmin: superAdmin,
+		Roles:      roles,
+	})
+	require.NoError(t, err)
+	return signed
+}
+
+func TestAdmin_TenantIsolationAndPermissions(t *testing.T) {
+	cfg := &config.Config{
+		BaseURL:       "https://crm.example.com",
+		EncryptionKey: base64.StdEncoding.EncodeToString([]byte("01234567890123456789012345678901")),
+	}
+
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	keys, err := auth.NewKeyringFromSigners(priv)
+	require.NoError(t, err)
+
+	repoMock := &fakeRepo{}
+	handler := h.NewIDPHandler(h.IDPHandlerParams{Repo: repoMock, Cfg: cfg, Secrets: testKeyring(cfg), Providers: &staticRegistry{}})
+
+	e := echo.New()
+	e.Use(middleware.ResolveTenant(tenant.NewResolver(cfg, newMemTenants()), "tenantID"))
+	admin := e.Group("/admin/tenants/:tenantID/idps",
+		middleware.NewJWTMiddleware(middleware.JWTConfig{Tokens: auth.NewTokenValidator(cfg, keys)}),
+		middleware.RequireTenant(),
+	)
+	admin.GET("", handler.List, middleware.RequireScope(auth.PermIDPRead))
+	admin.DELETE("/:id", handler.Delete, middleware.RequireScope(auth.PermIDPWrite))
+
+	call := func(method, path, bearer string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// tenant isolation
+	require.Equal(t, http.StatusOK, call(http.MethodGet, "/admin/tenants/1/idps", signToken(t, cfg, keys, 1, false, auth.RoleAdmin)))
+	require.Equal(t, http.StatusForbidden, call(http.MethodGet, "/admin/tenants/2/idps", signToken(t, cfg, keys, 1, false, auth.RoleAdmin)))
+	require.Equal(t, http.StatusOK, call(http.MethodGet, "/admin/tenants/2/idps", signToken(t, cfg, keys, 1, true)))
+
+	// slugs and subdomains resolve to the same tenants
+	require.Equal(t, http.StatusOK, call(http.MethodGet, "/admin/tenants/acme/idps", signToken(t, cfg, keys, 1, false, auth.RoleAdmin)))
+	require.Equal(t, http.StatusForbidden, call(http.MethodGet, "/admin/tenants/globex/idps", signToken(t, cfg, keys, 1, false, auth.RoleAdmin)))
+	require.Equal(t, http.StatusNotFound, call(http.MethodGet, "/admin/tenants/initech/idps", signToken(t, cfg, keys, 1, true)))
+
+	// permissions
+	require.Equal(t, http.StatusForbidden, call(http.MethodGet, "/admin/tenants/1/idps", signToken(t, cfg, keys, 1, false, auth.RoleMember)))
+	require.Equal(t, http.StatusOK, call(http.MethodGet, "/admin/tenants/1/idps", signToken(t, cfg, keys, 1, false, auth.RoleReadOnly)))
+	require.Equal(t, http.StatusForbidden, call(http.MethodDelete, "/admin/tenants/1/idps/3", signToken(t, cfg, keys, 1, false, auth.RoleReadOnly)))
+	require.Equal(t, http.StatusNoContent, call(http.MethodDelete, "/admin/tenants/1/idps/3", signToken(t, cfg, keys, 1, false, auth.RoleAdmin)))
+}
+
+func TestAdmin_RoleMappingRequiresRoleWrite(t *testing.T) {
+	cfg := &config.Config{
+		BaseURL:       "https://crm.example.com",
+		EncryptionKey: base64.StdEncoding.EncodeToString([]byte("01234567890123456789012345678901")),
+	}
+
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	keys, err := auth.NewKeyringFromSigners(priv)
+	require.NoError(t, err)
+
+	repoMock := &fakeRepo{}
+	handler := h.NewIDPHandler(h.IDPHandlerParams{Repo: repoMock, Cfg: cfg, Secrets: testKeyring(cfg), Providers: &staticRegistry{}})
+
+	e := echo.New()
+	e.Validator = validation.New(validation.Params{IdentityProviders: repoMock})
+	e.Use(middleware.ResolveTenant(tenant.NewResolver(cfg, newMemTenants()), "tenantID"))
+	admin := e.Group("/admin/tenants/:tenantID/idps",
+		middleware.NewJWTMiddleware(middleware.JWTConfig{Tokens: auth.NewTokenValidator(cfg, keys)}),
+		middleware.RequireTenant(),
+		middleware.RequireScope(auth.PermIDPWrite),
+	)
+	admin.POST("", handler.Create)
+	admin.PATCH("/:id", handler.Patch)
+
+	call := func(method, path, bearer, body string) int {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	adminToken := signToken(t, cfg, keys, 1, false, auth.RoleAdmin)
+	ownerToken := signToken(t, cfg, keys, 1, false, auth.RoleOwner)
+
+	// an admin can not map its own group to owner
+	escalate := `{"type":"oidc","slug":"okta","metadata_url":"https://example.com","client_id":"cid","client_secret":"secret","role_mapping":{"crm-admins":"owner"}}`
+	require.Equal(t, http.StatusForbidden, call(http.MethodPost, "/admin/tenants/1/idps", adminToken, escalate))
+	require.Nil(t, repoMock.create)
+
+	require.Equal(t, http.StatusCreated, call(http.MethodPost, "/admin/tenants/1/idps", adminToken,
+		`{"type":"oidc","slug":"okta","metadata_url":"https://example.com","client_id":"cid","client_secret":"secret"}`))
+	require.Equal(t, http.StatusCreated, call(http.MethodPost, "/admin/tenants/1/idps", ownerToken, escalate))
+
+	repoMock.getRec = &repo.IdentityProviderRecord{
+		ID: 3, TenantID: 1, ProviderType: "oidc", Slug: "okta", MetadataURL: "https://example.com", ClientID: "cid",
+		RoleMapping: map[string]string{"crm-admins": "admin"},
+	}
+	repoMock.update = nil
+
+	require.Equal(t, http.StatusForbidden, call(http.MethodPatch, "/admin/tenants/1/idps/3", adminToken, `{"role_mapping":{"crm-admins":"owner"}}`))
+	require.Nil(t, repoMock.update)
+
+	// other settings stay editable, and so does the unchanged mapping
+	require.Equal(t, http.StatusOK, call(http.MethodPatch, "/admin/tenants/1/idps/3", adminToken, `{"enabled":true,"role_mapping":{"crm-admins":"admin"}}`))
+	require.Equal(t, http.StatusOK, call(http.MethodPatch, "/admin/tenants/1/idps/3", ownerToken, `{"role_mapping":{"crm-admins":"owner"}}`))
+}
+
+func TestAdmin_InsufficientScope(t *testing.T) {
+	cfg := &config.Config{BaseURL: "https://crm.example.com"}
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	keys, err := auth.NewKeyringFromSigners(priv)
+	require.NoError(t, err)
+
+	handler := h.NewIDPHandler(h.IDPHandlerParams{Repo: &fakeRepo{}, Cfg: cfg, Providers: &staticRegistry{}})
+
+	e := echo.New()
+	e.Use(middleware.ResolveTenant(tenant.NewResolver(cfg, newMemTenants()), "tenantID"))
+	admin := e.Group("/admin/tenants/:tenantID/idps",
+		middleware.NewJWTMiddleware(middleware.JWTConfig{Tokens: auth.NewTokenValidator(cfg, keys)}),
+		middleware.RequireTenant(),
+	)
+	admin.GET("", handler.List, middleware.RequireScope(auth.PermIDPRead))
+	admin.DELETE("/:id", handler.Delete, middleware.RequireScope(auth.PermIDPWrite))
+
+	// an owner whose token was narrowed to reading the IdPs
+	now := time.Now()
+	token, err := keys.Sign(&auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    cfg.Issuer(),
+			Audience:  jwt.ClaimStrings{"crm-api"},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+		TenantID: 1,
+		UserID:   1,
+		Roles:    []string{auth.RoleOwner},
+		Scope:    auth.Scope{auth.PermIDPRead},
+	})
+	require.NoError(t, err)
+
+	call := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	require.Equal(t, http.StatusOK, call(http.MethodGet, "/admin/tenants/1/idps").Code)
+
+	rec := call(http.MethodDelete, "/admin/tenants/1/idps/3")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Equal(t, `Bearer error="insufficient_scope", scope="idp:write"`, rec.Header().Get(echo.HeaderWWWAuthenticate))
+
+	var body middleware.InsufficientScope
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	require.Equal(t, "insufficient_scope", body.Error)
+	require.Equal(t, auth.PermIDPWrite, body.Scope)
+}
+
+func TestCreate_RejectsUnknownRoleMapping(t *testing.T) {
+	e, repo := setup()
+	body, _ := json.Marshal(map[string]interface{}{
+		"type": "oidc", "metadata_url": "https://example.com",
+		"client_id": "cid", "client_secret": "secret",
+		"role_mapping": map[string]string{"crm-admins": "root"},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/tenants/5/idps", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Nil(t, repo.create)
+}
+
+func TestCreate_LoginPolicy(t *testing.T) {
+	create := func(providerType string, policy map[string]interface{}) (*httptest.ResponseRecorder, *fakeRepo) {
+		e, repo := setup()
+		body, _ := json.Marshal(map[string]interface{}{
+			"type": providerType, "metadata_url": "https://example.com",
+			"client_id": "cid", "client_secret": "secret",
+			"login_policy": policy,
+		})
+
+		req := httptest.NewRequest(http.MethodPost, "/admin/tenants/5/idps", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec, repo
+	}
+
+	rec, repo := create("oidc", map[string]interface{}{
+		"acr_values":             []string{"urn:okta:loa:2fa:any"},
+		"amr_values":             []string{"mfa", "hwk"},
+		"require_email_verified": true,
+		"allowed_email_domains":  []string{"Contoso.COM."},
+	})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.Equal(t, []string{"mfa", "hwk"}, repo.create.LoginPolicy.AMRValues)
+	require.True(t, repo.create.LoginPolicy.RequireEmailVerified)
+	require.Equal(t, []string{"contoso.com"}, repo.create.LoginPolicy.AllowedEmailDomains)
+
+	for _, tc := range []struct {
+		providerType string
+		policy       map[string]interface{}
+	}{
+		{"saml", map[string]interface{}{"require_email_verified": true}},
+		{"oidc", map[string]interface{}{"allowed_email_domains": []string{"jane@contoso.com"}}},
+		{"oidc", map[string]interface{}{"acr_values": []string{"silver gold"}}},
+	} {
+		rec, repo := create(tc.providerType, tc.policy)
+		require.Equal(t, http.StatusBadRequest, rec.Code, tc.policy)
+		require.Nil(t, repo.create)
+	}
+}
+
+func TestCreate_SlugIsUniquePerTenant(t *testing.T) {
+	e, repoMock := setup()
+	repoMock.list = []*repo.IdentityProviderRecord{{ID: 1, TenantID: 5, ProviderType: "oidc", Slug: "oidc"}}
+
+	create := func(payload map[string]interface{}) int {
+		body, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, "/admin/tenants/5/idps", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	payload := map[string]interface{}{
+		"type": "oidc", "metadata_url": "https://example.com",
+		"client_id": "cid", "client_secret": "secret", "enabled": true,
+	}
+
+	// the slug defaults to the type, which is taken
+	require.Equal(t, http.StatusConflict, create(payload))
+
+	payload["slug"] = "Okta Contractors"
+	require.Equal(t, http.StatusBadRequest, create(payload))
+	require.Nil(t, repoMock.create)
+
+	payload["slug"] = "okta"
+	require.Equal(t, http.StatusCreated, create(payload))
+	require.Equal(t, "okta", repoMock.create.Slug)
+	require.Equal(t, "oidc", repoMock.create.ProviderType)
+}
+
+func TestUpdate_SlugConflict(t *testing.T) {
+	e, repoMock := setup()
+	repoMock.list = []*repo.IdentityProviderRecord{
+		{ID: 1, TenantID: 5, ProviderType: "oidc", Slug: "azure"},
+		{ID: 2, TenantID: 5, ProviderType: "oidc", Slug: "okta"},
+	}
+
+	update := func(id, slug string) int {
+		body, _ := json.Marshal(map[string]interface{}{
+			"type": "oidc", "slug": slug, "metadata_url": "https://example.com",
+			"client_id": "cid", "client_secret": "secret",
+		})
+		req := httptest.NewRequest(http.MethodPut, "/admin/tenants/5/idps/"+id, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	require.Equal(t, http.StatusConflict, update("2", "azure"))
+	require.Nil(t, repoMock.update)
+
+	// keeping its own slug is not a conflict
+	require.Equal(t, http.StatusNoContent, update("2", "okta"))
+	require.Equal(t, "okta", repoMock.update.Slug)
+}
+
+// newDiscoveryServer serves the discovery document and JWKS of an OIDC
+// provider whose issuer is the server URL
+func newDiscoveryServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 srv.URL,
+			"authorization_endpoint": srv.URL + "/authorize",
+			"token_endpoint":         srv.URL + "/token",
+			"jwks_uri":               srv.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"keys":[{"kty":"RSA","use":"sig","alg":"RS256"}]}`))
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if _, secret, _ := r.BasicAuth(); secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_token":"x","token_type":"Bearer"}`))
+	})
+
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func setupWithDiagnostics() (*echo.Echo, *fakeRepo, *encryption.Keyring) {
+	e := echo.New()
+	repo := &fakeRepo{}
+	e.Validator = validation.New(validation.Params{IdentityProviders: repo})
+	cfg := &config.Config{
+		EncryptionKey: base64.StdEncoding.EncodeToString([]byte("01234567890123456789012345678901")),
+	}
+
+	secrets := testKeyring(cfg)
+	handler := h.NewIDPHandler(h.IDPHandlerParams{Repo: repo, Cfg: cfg, Secrets: secrets, Providers: &staticRegistry{}, Diagnostics: auth.NewDiagnostics()})
+	handler.Register(e)
+
+	return e, repo, secrets
+}
+
+func TestCreate_StoresDiscovery(t *testing.T) {
+	idp := newDiscoveryServer(t)
+	e, repo, _ := setupWithDiagnostics()
+
+	create := func(metadataURL string, enabled bool) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]interface{}{
+			"type": "oidc", "metadata_url": metadataURL,
+			"client_id": "cid", "client_secret": "secret", "enabled": enabled,
+		})
+		req := httptest.NewRequest(http.MethodPost, "/admin/tenants/5/idps", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := create(idp.URL, true)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.NotNil(t, repo.create.Discovery)
+	require.Equal(t, idp.URL, repo.create.Discovery.Issuer)
+	require.Equal(t, idp.URL+"/token", repo.create.Discovery.TokenEndpoint)
+
+	// an enabled provider whose discovery fails is refused with the report
+	repo.create = nil
+	rec = create(idp.URL+"/", true)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	require.Nil(t, repo.create)
+
+	var out struct {
+		Message   string
+		Diagnosis auth.Diagnosis
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &out))
+	require.False(t, out.Diagnosis.OK)
+	check, _ := out.Diagnosis.Check(auth.CheckIssuer)
+	require.Equal(t, auth.CheckFailed, check.Status)
+
+	// a disabled one is saved, without a snapshot
+	rec = create(idp.URL+"/missing", false)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.Nil(t, repo.create.Discovery)
+}
+
+func TestTest_RunsDiagnostics(t *testing.T) {
+	idp := newDiscoveryServer(t)
+	e, repoMock, secrets := setupWithDiagnostics()
+
+	test := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, secret := range []string{"secret", "wrong"} {
+		enc, err := secrets.Encrypt(context.Background(), []byte(secret))
+		require.NoError(t, err)
+		repoMock.getRec = &repo.IdentityProviderRecord{
+			ID: 1, TenantID: 5, ProviderType: "oidc", MetadataURL: idp.URL, ClientID: "cid", ClientSecretEnc: enc,
+		}
+
+		rec := test("/admin/tenants/5/idps/1/test", `{"check_credentials":true}`)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var report auth.Diagnosis
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+		require.Equal(t, secret == "secret", report.OK, report.Checks)
+		require.Len(t, report.Checks, 4)
+
+		check, _ := report.Check(auth.CheckClientCredentials)
+		require.Equal(t, map[bool]string{true: auth.CheckOK, false: auth.CheckFailed}[secret == "secret"], check.Status)
+	}
+
+	// without a body the credentials are not tested
+	rec := test("/admin/tenants/5/idps/1/test", ``)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `"status":"skipped"`)
+
+	// a provider of another tenant is not found
+	require.Equal(t, http.StatusNotFound, test("/admin/tenants/6/idps/1/test", ``).Code)
+}
+
+func TestPatch_PartialUpdate(t *testing.T) {
+	idp := newDiscoveryServer(t)
+	e, repoMock, secrets := setupWithDiagnostics()
+
+	patch := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	enc, err := secrets.Encrypt(context.Background(), []byte("the-original-client-secret"))
+	require.NoError(t, err)
+	repoMock.getRec = &repo.IdentityProviderRecord{
+		ID: 1, TenantID: 5, ProviderType: "oidc", Slug: "oidc", MetadataURL: idp.URL, ClientID: "cid",
+		ClientSecretEnc: enc, ClientSecretHint: "****cret", Scopes: []string{"openid"}, Enabled: true,
+		Discovery: &repo.ProviderDiscovery{Issuer: idp.URL},
+	}
+
+	// toggling enabled keeps the secret and the other settings, and
+	// disabling does not rerun the discovery nor drop its snapshot
+	rec := patch("/admin/tenants/5/idps/1", `{"enabled":false}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, []string{"enabled"}, repoMock.columns)
+	require.Equal(t, enc, repoMock.update.ClientSecretEnc)
+	require.Equal(t, []string{"openid"}, repoMock.update.Scopes)
+	require.Equal(t, idp.URL, repoMock.update.Discovery.Issuer)
+
+	var out h.IdpResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &out))
+	require.False(t, out.Enabled)
+	require.Equal(t, "cid", out.ClientID)
+	require.Equal(t, "****cret", out.ClientSecretHint)
+	require.NotContains(t, rec.Body.String(), "client_secret_enc")
+
+	// enabling it again reruns the discovery, as does changing the client
+	rec = patch("/admin/tenants/5/idps/1", `{"enabled":true}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, []string{"enabled", "discovery"}, repoMock.columns)
+
+	rec = patch("/admin/tenants/5/idps/1", `{"client_id":"other"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, []string{"client_id", "discovery"}, repoMock.columns)
+
+	// the secret is write-only
+	rec = patch("/admin/tenants/5/idps/1", `{"client_secret":"a-brand-new-client-secret"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, []string{"client_secret_enc", "client_secret_hint"}, repoMock.columns)
+	require.NotContains(t, rec.Body.String(), "a-brand-new-client-secret")
+
+	secret, err := secrets.Decrypt(context.Background(), repoMock.update.ClientSecretEnc)
+	require.NoError(t, err)
+	require.Equal(t, "a-brand-new-client-secret", string(secret))
+
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &out))
+	require.Equal(t, "****cret", out.ClientSecretHint)
+	require.NotEmpty(t, out.SecretUpdatedAt)
+
+	// an empty redirect_url clears it
+	rec = patch("/admin/tenants/5/idps/1", `{"redirect_url":""}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, []string{"redirect_url"}, repoMock.columns)
+
+	// the settings are checked against the stored ones
+	for _, body := range []string{
+		`{"client_secret":""}`,
+		`{"metadata_url":"example.com"}`,
+		`{"redirect_url":"/callback"}`,
+		`{"type":"ldap"}`,
+		`{"slug":"Not A Slug"}`,
+		`{"type":"saml","login_policy":{"require_email_verified":true}}`,
+		`{"role_mapping":{"admins":"root"}}`,
+	} {
+		require.Equal(t, http.StatusBadRequest, patch("/admin/tenants/5/idps/1", body).Code, body)
+	}
+
+	require.Equal(t, http.StatusNotFound, patch("/admin/tenants/6/idps/1", `{"enabled":true}`).Code)
+}
+
+func TestCreate_FieldErrors(t *testing.T) {
+	e, repoMock := setup()
+	repoMock.list = []*repo.IdentityProviderRecord{{ID: 1, TenantID: 5, ProviderType: "oidc", Slug: "okta"}}
+
+	create := func(body string) (int, h.ValidationResponse) {
+		req := httptest.NewRequest(http.MethodPost, "/admin/tenants/5/idps", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		var out h.ValidationResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &out), rec.Body.String())
+		return rec.Code, out
+	}
+
+	rules := func(out h.ValidationResponse) map[string]string {
+		m := make(map[string]string)
+		for _, fe := range out.Errors {
+			m[fe.Field] = fe.Rule
+		}
+		return m
+	}
+
+	// every rejected field is listed, by its json name
+	code, out := create(`{"type":"ldap","metadata_url":"ftp://example.com","redirect_url":"/callback","role_mapping":{"admins":"root"}}`)
+	require.Equal(t, http.StatusBadRequest, code)
+	require.Equal(t, map[string]string{
+		"type":                 "oneof",
+		"metadata_url":         "url_scheme",
+		"client_id":            "required",
+		"client_secret":        "required",
+		"redirect_url":         "url_scheme",
+		"role_mapping[admins]": "role",
+	}, rules(out))
+
+	// a value of the wrong type names its field
+	code, out = create(`{"type":"oidc","enabled":"yes"}`)
+	require.Equal(t, http.StatusBadRequest, code)
+	require.Equal(t, map[string]string{"enabled": "type"}, rules(out))
+
+	code, out = create(`{"type":`)
+	require.Equal(t, http.StatusBadRequest, code)
+	require.Equal(t, map[string]string{"": "body"}, rules(out))
+
+	// a slug taken in the tenant is a conflict
+	code, out = create(`{"type":"oidc","slug":"okta","metadata_url":"https://example.com","client_id":"cid","client_secret":"secret"}`)
+	require.Equal(t, http.StatusConflict, code)
+	require.Equal(t, map[string]string{"slug": "tenant_unique"}, rules(out))
+	require.Nil(t, repoMock.create)
+}
+
+func TestSecretHint(t *testing.T) {
+	e, repoMock := setup()
+	repoMock.getRec = &repo.IdentityProviderRecord{ID: 1, TenantID: 5, ProviderType: "oidc", MetadataURL: "url", ClientID: "cid"}
+
+	for secret, hint := range map[string]string{"short": "****", "a-long-enough-secret": "****cret"} {
+		body, _ := json.Marshal(map[string]interface{}{"client_secret": secret})
+		req := httptest.NewRequest(http.MethodPatch, "/admin/tenants/5/idps/1", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, hint, repoMock.update.ClientSecretHint)
+	}
+}
+
+// testKeyring builds the keyring of cfg, as the app does
+func testKeyring(cfg *config.Config) *encryption.Keyring {
+	k, err := encryption.NewKeyring(cfg)
+	if err != nil {
+		panic(err)
+	}
+	return k
 }
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+	"github.com/jeanmolossi/verbose-adventure/pkg/stripslash"
+	"github.com/labstack/echo/v4"
+)
+
+// ImpersonationHandler emite os tokens de personificação dos super admins
+// e expõe a trilha de auditoria de cada tenant
+type ImpersonationHandler struct {
+	repo           repo.ImpersonationRepository
+	impersonations *auth.Impersonations
+}
+
+// NewImpersonationHandler cria um novo handler, injetando o repo e o emissor de tokens
+func NewImpersonationHandler(r repo.ImpersonationRepository, impersonations *auth.Impersonations) *ImpersonationHandler {
+	return &ImpersonationHandler{repo: r, impersonations: impersonations}
+}
+
+// impersonationRequest representa o payload de personificação
+type impersonationRequest struct {
+	Reason string `json:"reason"`
+	// ExpiresIn em segundos; limitado por IMPERSONATION_TTL
+	ExpiresIn int64 `json:"expires_in" validate:"gte=0"`
+}
+
+// ImpersonationResponse representa uma personificação da trilha de auditoria
+type ImpersonationResponse struct {
+	ID        int64       `json:"id"`
+	TenantID  int64       `json:"tenant_id"`
+	UserID    int64       `json:"user_id"`
+	Actor     *auth.Actor `json:"act"`
+	Reason    string      `json:"reason"`
+	ExpiresAt time.Time   `json:"expires_at"`
+	CreatedAt time.Time   `json:"created_at"`
+
+	// Requests só é exibida na consulta de uma personificação
+	Requests []ImpersonationRequestResponse `json:"requests,omitempty"`
+
+	// o token só é exibido na emissão
+	Token     string `json:"token,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresIn int64  `json:"expires_in,omitempty"`
+}
+
+// ImpersonationRequestResponse representa uma requisição feita com o token
+type ImpersonationRequestResponse struct {
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	RemoteIP  string    `json:"remote_ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Register associa as rotas de personificação
+func (h *ImpersonationHandler) Register(e *echo.Echo) {
+	e.POST("/admin/tenants/:tenantID/users/:userID/impersonate", h.Create)
+
+	g := e.Group("/admin/tenants/:tenantID/impersonations")
+	g.GET("", h.List)
+	g.GET("/:id", h.Get)
+	g.DELETE("/:id", h.End)
+}
+
+// Create emite um token para agir como o usuário. Apenas super admins
+// podem personificar, e o motivo fica registrado na trilha de auditoria.
+func (h *ImpersonationHandler) Create(c echo.Context) error {
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
+	userID, err := strconv.ParseInt(stripslash.ParamWithoutAnySlashes(c, "userID"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user id")
+	}
+
+	var req impersonationRequest
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+
+	claims, _ := auth.FromContext(c)
+	imp, err := h.impersonations.Start(c.Request().Context(), claims, tenant, userID, req.Reason, time.Duration(req.ExpiresIn)*time.Second)
+	switch {
+	case errors.Is(err, auth.ErrImpersonationForbidden):
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	case errors.Is(err, auth.ErrImpersonationReason):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, auth.ErrUserNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case err != nil:
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	resp := newImpersonationResponse(imp.Record)
+	resp.Token = imp.Token
+	resp.TokenType = "Bearer"
+	resp.ExpiresIn = imp.ExpiresIn
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusCreated, resp)
+}
+
+// List retorna as personificações de usuários do tenant
+func (h *ImpersonationHandler) List(c echo.Context) error {
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
+	recs, err := h.repo.ListByTenant(c.Request().Context(), tenant)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	out := []ImpersonationResponse{}
+	for _, r := range recs {
+		out = append(out, newImpersonationResponse(r))
+	}
+
+	return c.JSON(http.StatusOK, out)
+}
+
+// Get retorna uma personificação com as requisições feitas com o token
+func (h *ImpersonationHandler) Get(c echo.Context) error {
+	id, err := parseID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+	}
+
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
+	ctx := c.Request().Context()
+	rec, err := h.repo.GetByID(ctx, tenant, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if rec == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "impersonation not found")
+	}
+
+	reqs, err := h.repo.ListRequests(ctx, rec.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	resp := newImpersonationResponse(rec)
+	resp.Requests = []ImpersonationRequestResponse{}
+	for _, r := range reqs {
+		resp.Requests = append(resp.Requests, ImpersonationRequestResponse{
+			Method:    r.Method,
+			Path:      r.Path,
+			RemoteIP:  r.RemoteIP,
+			UserAgent: r.UserAgent,
+			CreatedAt: r.CreatedAt,
+		})
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// End revoga o token de uma personificação antes de ele expirar
+func (h *ImpersonationHandler) End(c echo.Context) error {
+	id, err := parseID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+	}
+
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
+	err = h.impersonations.End(c.Request().Context(), tenant, id)
+	switch {
+	case errors.Is(err, auth.ErrImpersonationUnknown):
+		return echo.NewHTTPError(http.StatusNotFound, "impersonation not found")
+	case err != nil:
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func newImpersonationResponse(r *repo.ImpersonationRecord) ImpersonationResponse {
+	return ImpersonationResponse{
+		ID:       r.ID,
+		TenantID: r.TenantID,
+		UserID:   r.UserID,
+		Actor: &auth.Actor{
+			Subject:  strconv.FormatInt(r.ActorUserID, 10),
+			TenantID: r.ActorTenantID,
+			UserID:   r.ActorUserID,
+			Email:    r.ActorEmail,
+		},
+		Reason:    r.Reason,
+		ExpiresAt: r.ExpiresAt,
+		CreatedAt: r.CreatedAt,
+	}
+}
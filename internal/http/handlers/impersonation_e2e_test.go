@@ -0,0 +1,253 @@
+package handlers_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+	h "github.com/jeanmolossi/verbose-adventure/internal/http/handlers"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+)
+
+// memImpersonations is an in-memory repo.ImpersonationRepository
+type memImpersonations struct {
+	mu       sync.Mutex
+	recs     []*repo.ImpersonationRecord
+	requests []*repo.ImpersonationRequestRecord
+}
+
+func (m *memImpersonations) Create(ctx context.Context, rec *repo.ImpersonationRecord) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cp := *rec
+	cp.ID = int64(len(m.recs) + 1)
+	m.recs = append(m.recs, &cp)
+	return cp.ID, nil
+}
+
+func (m *memImpersonations) ListByTenant(ctx context.Context, tenantID int64) ([]*repo.ImpersonationRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []*repo.ImpersonationRecord
+	for i := len(m.recs) - 1; i >= 0; i-- {
+		if m.recs[i].TenantID == tenantID {
+			cp := *m.recs[i]
+			out = append(out, &cp)
+		}
+	}
+	return out, nil
+}
+
+func (m *memImpersonations) GetByID(ctx context.Context, tenantID, id int64) (*repo.ImpersonationRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range m.recs {
+		if r.TenantID == tenantID && r.ID == id {
+			cp := *r
+			return &cp, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memImpersonations) RecordRequest(ctx context.Context, tokenID string, rec *repo.ImpersonationRequestRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range m.recs {
+		if r.TokenID == tokenID {
+			cp := *rec
+			cp.ID = int64(len(m.requests) + 1)
+			cp.ImpersonationID = r.ID
+			cp.CreatedAt = time.Now()
+			m.requests = append(m.requests, &cp)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *memImpersonations) ListRequests(ctx context.Context, impersonationID int64) ([]*repo.ImpersonationRequestRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []*repo.ImpersonationRequestRecord
+	for _, r := range m.requests {
+		if r.ImpersonationID == impersonationID {
+			cp := *r
+			out = append(out, &cp)
+		}
+	}
+	return out, nil
+}
+
+// setupImpersonation seeds jane, a read-only user of tenant 2, and makes
+// user 1 of tenant 1 the support super admin
+func setupImpersonation(t *testing.T) (*testServer, int64) {
+	t.Helper()
+
+	srv := newTestServer(t, &config.Config{BaseURL: "https://crm.example.com", SuperAdmins: []string{"1:1"}})
+
+	userID, err := srv.users.UpsertLogin(context.Background(), &repo.UserRecord{TenantID: 2, Subject: "u-42", Email: "jane@globex.com"})
+	require.NoError(t, err)
+	require.NoError(t, srv.roles.ReplaceRoles(context.Background(), 2, userID, repo.RoleSourceIdP, []string{auth.RoleReadOnly}))
+
+	return srv, userID
+}
+
+func TestImpersonation_ActsAsUserAndIsAudited(t *testing.T) {
+	srv, userID := setupImpersonation(t)
+	superAdmin := signToken(t, srv.cfg, srv.keys, 1, true)
+	path := fmt.Sprintf("/admin/tenants/2/users/%d/impersonate", userID)
+
+	rec := srv.call(http.MethodPost, path, superAdmin, `{"reason":"ticket #4521","expires_in":300}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	require.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+
+	var created h.ImpersonationResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	require.NotEmpty(t, created.Token)
+	require.Equal(t, int64(300), created.ExpiresIn)
+	require.Equal(t, int64(1), created.Actor.UserID)
+
+	// the token acts as the user, with the user scope and the actor in "act"
+	claims, err := auth.NewTokenValidator(srv.cfg, srv.keys).Validate(created.Token)
+	require.NoError(t, err)
+	require.Equal(t, userID, claims.UserID)
+	require.False(t, claims.SuperAdmin)
+	require.False(t, claims.Can(auth.PermIDPWrite))
+	require.Equal(t, int64(1), claims.Act.TenantID)
+
+	rec = srv.call(http.MethodGet, "/api/v1/me", created.Token, ``)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var me h.MeResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &me))
+	require.Equal(t, "jane@globex.com", me.Email)
+	require.NotNil(t, me.Act)
+	require.Equal(t, int64(1), me.Act.UserID)
+
+	// admin routes are off limits, even those the user roles allow
+	require.Equal(t, http.StatusForbidden, srv.call(http.MethodGet, "/admin/tenants/2/impersonations", created.Token, ``).Code)
+	require.Equal(t, http.StatusForbidden, srv.call(http.MethodPost, path, created.Token, `{"reason":"again"}`).Code)
+
+	// every request made with the token is in the audit trail
+	rec = srv.call(http.MethodGet, fmt.Sprintf("/admin/tenants/2/impersonations/%d", created.ID), superAdmin, ``)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var audited h.ImpersonationResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &audited))
+	require.Equal(t, "ticket #4521", audited.Reason)
+	require.Empty(t, audited.Token)
+	require.Len(t, audited.Requests, 3)
+	require.Equal(t, "/api/v1/me", audited.Requests[0].Path)
+	require.Equal(t, testUserAgent, audited.Requests[0].UserAgent)
+	require.Equal(t, http.MethodPost, audited.Requests[2].Method)
+
+	rec = srv.call(http.MethodGet, "/admin/tenants/2/impersonations", superAdmin, ``)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var listed []h.ImpersonationResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &listed))
+	require.Len(t, listed, 1)
+}
+
+func TestImpersonation_Validation(t *testing.T) {
+	srv, userID := setupImpersonation(t)
+	superAdmin := signToken(t, srv.cfg, srv.keys, 1, true)
+	path := fmt.Sprintf("/admin/tenants/2/users/%d/impersonate", userID)
+
+	// only super admins impersonate, tenant owners can not
+	owner := signToken(t, srv.cfg, srv.keys, 2, false, auth.RoleOwner)
+	require.Equal(t, http.StatusForbidden, srv.call(http.MethodPost, path, owner, `{"reason":"debug"}`).Code)
+
+	require.Equal(t, http.StatusBadRequest, srv.call(http.MethodPost, path, superAdmin, `{}`).Code)
+	require.Equal(t, http.StatusBadRequest, srv.call(http.MethodPost, path, superAdmin, `{"reason":"x","expires_in":-1}`).Code)
+	require.Equal(t, http.StatusNotFound, srv.call(http.MethodPost, "/admin/tenants/2/users/77/impersonate", superAdmin, `{"reason":"x"}`).Code)
+
+	// the lifetime is capped by IMPERSONATION_TTL
+	rec := srv.call(http.MethodPost, path, superAdmin, `{"reason":"x","expires_in":86400}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	var created h.ImpersonationResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	require.Equal(t, int64((15 * time.Minute).Seconds()), created.ExpiresIn)
+}
+
+func TestImpersonation_UnauditedTokenIsRefused(t *testing.T) {
+	srv, userID := setupImpersonation(t)
+
+	// a validly signed token with an "act" claim that was never issued
+	now := time.Now()
+	forged, err := srv.keys.Sign(&auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "not-in-the-trail",
+			Issuer:    srv.cfg.Issuer(),
+			Audience:  jwt.ClaimStrings{"crm-api"},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+		TenantID: 2,
+		UserID:   userID,
+		Scope:    auth.Scope{auth.ScopeProfile},
+		Act:      &auth.Actor{Subject: "1", TenantID: 1, UserID: 1},
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, http.StatusUnauthorized, srv.call(http.MethodGet, "/api/v1/me", forged, ``).Code)
+	require.Empty(t, srv.impersonations.requests)
+}
+
+func TestImpersonation_ScopeAndRevocation(t *testing.T) {
+	srv, userID := setupImpersonation(t)
+	superAdmin := signToken(t, srv.cfg, srv.keys, 1, true)
+	path := fmt.Sprintf("/admin/tenants/2/users/%d/impersonate", userID)
+
+	// a super admin token narrowed to reading the audit trail can not impersonate
+	now := time.Now()
+	auditor, err := srv.keys.Sign(&auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    srv.cfg.Issuer(),
+			Audience:  jwt.ClaimStrings{"crm-api"},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+		TenantID:   1,
+		UserID:     1,
+		SuperAdmin: true,
+		Scope:      auth.Scope{auth.PermAuditRead},
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, http.StatusForbidden, srv.call(http.MethodPost, path, auditor, `{"reason":"debug"}`).Code)
+	require.Equal(t, http.StatusOK, srv.call(http.MethodGet, "/admin/tenants/2/impersonations", auditor, ``).Code)
+
+	rec := srv.call(http.MethodPost, path, superAdmin, `{"reason":"ticket #4521"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	var created h.ImpersonationResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	require.Equal(t, http.StatusOK, srv.call(http.MethodGet, "/api/v1/me", created.Token, ``).Code)
+
+	// ending the impersonation revokes the session of its token
+	end := fmt.Sprintf("/admin/tenants/2/impersonations/%d", created.ID)
+	require.Equal(t, http.StatusForbidden, srv.call(http.MethodDelete, end, auditor, ``).Code)
+	require.Equal(t, http.StatusNoContent, srv.call(http.MethodDelete, end, superAdmin, ``).Code)
+	require.Equal(t, http.StatusUnauthorized, srv.call(http.MethodGet, "/api/v1/me", created.Token, ``).Code)
+	require.Equal(t, http.StatusNotFound, srv.call(http.MethodDelete, "/admin/tenants/2/impersonations/77", superAdmin, ``).Code)
+
+	// so does deprovisioning the user
+	rec = srv.call(http.MethodPost, path, superAdmin, `{"reason":"ticket #4522"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	require.NoError(t, srv.sessions.RevokeUser(context.Background(), 2, userID, auth.RevokedByDeprovision))
+	require.Equal(t, http.StatusUnauthorized, srv.call(http.MethodGet, "/api/v1/me", created.Token, ``).Code)
+}
@@ -2,18 +2,88 @@ package handlers
 
 import (
 	"net/http"
+	"time"
 
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
 	"github.com/labstack/echo/v4"
 )
 
-func Me(c echo.Context) error {
-	tenantID := c.Get("tenant_id")
-	userID := c.Get("user_id")
-	email := c.Get("email")
+// MeHandler expõe o perfil do usuário autenticado
+type MeHandler struct {
+	users repo.UserRepository
+	roles repo.UserRoleRepository
+}
+
+// NewMeHandler cria um novo handler, injetando os repos
+func NewMeHandler(users repo.UserRepository, roles repo.UserRoleRepository) *MeHandler {
+	return &MeHandler{users: users, roles: roles}
+}
+
+// MeResponse representa o perfil armazenado do usuário
+type MeResponse struct {
+	TenantID    int64      `json:"tenant_id"`
+	UserID      int64      `json:"user_id"`
+	IdPID       int64      `json:"idp_id"`
+	Subject     string     `json:"subject"`
+	Email       string     `json:"email"`
+	Name        string     `json:"name"`
+	Groups      []string   `json:"groups"`
+	Roles       []string   `json:"roles"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+
+	// Act é o super admin que age como o usuário, em uma personificação
+	Act *auth.Actor `json:"act,omitempty"`
+}
+
+// Get retorna o perfil, o último login e os papéis do usuário do token
+func (h *MeHandler) Get(c echo.Context) error {
+	claims, ok := auth.FromContext(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"message": "unauthenticated"})
+	}
+
+	if claims.IsAPIKey() {
+		return echo.NewHTTPError(http.StatusForbidden, "api keys have no user profile")
+	}
+
+	if claims.UserID == 0 {
+		return echo.NewHTTPError(http.StatusForbidden, "client tokens have no user profile")
+	}
+
+	ctx := c.Request().Context()
+	user, err := h.users.GetByID(ctx, claims.TenantID, claims.UserID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if user == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	}
+
+	// os papéis do banco, o token pode estar defasado até o próximo refresh
+	roles, err := h.roles.ListRoles(ctx, user.TenantID, user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	groups := user.Groups
+	if groups == nil {
+		groups = []string{}
+	}
 
-	return c.JSON(http.StatusOK, echo.Map{
-		"tenant_id": tenantID,
-		"user_id":   userID,
-		"email":     email,
+	return c.JSON(http.StatusOK, &MeResponse{
+		TenantID:    user.TenantID,
+		UserID:      user.ID,
+		IdPID:       user.IdPID,
+		Subject:     user.Subject,
+		Email:       user.Email,
+		Name:        user.Name,
+		Groups:      groups,
+		Roles:       roles,
+		LastLoginAt: user.LastLoginAt,
+		CreatedAt:   user.CreatedAt,
+		Act:         claims.Act,
 	})
 }
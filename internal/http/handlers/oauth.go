@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+	"github.com/jeanmolossi/verbose-adventure/internal/tenant"
+	"github.com/labstack/echo/v4"
+)
+
+// oauthAuthorizeCookie guarda o pedido de autorização durante o login SSO
+const oauthAuthorizeCookie = "crm_oauth_authorize"
+
+// OAuthHandler expõe o servidor de autorização OAuth 2.0 para clientes
+// de terceiros: authorize, token, introspecção e revogação.
+type OAuthHandler struct {
+	server  *auth.AuthorizationServer
+	tenants *tenant.Resolver
+	cfg     *config.Config
+}
+
+// NewOAuthHandler cria um novo handler, injetando o servidor de autorização
+func NewOAuthHandler(server *auth.AuthorizationServer, tenants *tenant.Resolver, cfg *config.Config) *OAuthHandler {
+	return &OAuthHandler{server: server, tenants: tenants, cfg: cfg}
+}
+
+// tokenRequest representa o formulário do token endpoint
+type tokenRequest struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	Scope        string `form:"scope"`
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+}
+
+// tokenActionRequest representa o formulário de introspecção e revogação
+type tokenActionRequest struct {
+	Token        string `form:"token"`
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+}
+
+// Register associa as rotas do servidor de autorização
+func (h *OAuthHandler) Register(e *echo.Echo) {
+	e.GET("/oauth/authorize", h.Authorize)
+	e.POST("/oauth/token", h.Token)
+	e.POST("/oauth/introspect", h.Introspect)
+	e.POST("/oauth/revoke", h.Revoke)
+}
+
+// Authorize valida o pedido do cliente e leva o usuário ao login SSO do
+// tenant do cliente. O callback do login emite o código e redireciona de
+// volta ao cliente; autenticar-se no SSO vale como consentimento.
+func (h *OAuthHandler) Authorize(c echo.Context) error {
+	req, err := h.server.Authorize(c.Request().Context(), auth.AuthorizeParams{
+		ResponseType:        c.QueryParam("response_type"),
+		ClientID:            c.QueryParam("client_id"),
+		RedirectURI:         c.QueryParam("redirect_uri"),
+		Scope:               c.QueryParam("scope"),
+		State:               c.QueryParam("state"),
+		CodeChallenge:       c.QueryParam("code_challenge"),
+		CodeChallengeMethod: c.QueryParam("code_challenge_method"),
+	})
+
+	// sem um redirect_uri confiável o erro é mostrado ao usuário
+	if req == nil {
+		return oauthErrorResponse(c, err)
+	}
+
+	if err != nil {
+		return c.Redirect(http.StatusFound, h.server.ErrorRedirect(req, err))
+	}
+
+	// num host de outro tenant o login SSO não seria o do cliente
+	t, ok := tenant.FromContext(c)
+	if ok && t.ID != req.TenantID {
+		return c.Redirect(http.StatusFound, h.server.ErrorRedirect(req, &auth.OAuthError{
+			Code:        "invalid_request",
+			Description: "the client does not belong to this tenant",
+		}))
+	}
+
+	if !ok {
+		if t, err = h.tenants.FromID(c.Request().Context(), req.TenantID); err != nil {
+			return c.Redirect(http.StatusFound, h.server.ErrorRedirect(req, err))
+		}
+	}
+
+	value, err := h.server.EncodeRequest(req)
+	if err != nil {
+		return c.Redirect(http.StatusFound, h.server.ErrorRedirect(req, err))
+	}
+
+	c.SetCookie(authorizeCookie(h.cfg, value, h.server.RequestTTL()))
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("%s/%s/sso", strings.TrimSuffix(h.cfg.BaseURL, "/"), t.Slug))
+}
+
+// Token troca um grant por tokens (RFC 6749, seção 3.2)
+func (h *OAuthHandler) Token(c echo.Context) error {
+	var req tokenRequest
+	if err := c.Bind(&req); err != nil {
+		return oauthErrorResponse(c, &auth.OAuthError{Code: "invalid_request", Description: "invalid payload"})
+	}
+
+	client, err := h.authenticateClient(c, req.ClientID, req.ClientSecret)
+	if err != nil {
+		return oauthErrorResponse(c, err)
+	}
+
+	resp, err := h.server.Token(c.Request().Context(), client, auth.TokenRequest{
+		GrantType:    req.GrantType,
+		Code:         req.Code,
+		RedirectURI:  req.RedirectURI,
+		CodeVerifier: req.CodeVerifier,
+		RefreshToken: req.RefreshToken,
+		Scope:        req.Scope,
+	})
+	if err != nil {
+		return oauthErrorResponse(c, err)
+	}
+
+	noStore(c)
+	return c.JSON(http.StatusOK, resp)
+}
+
+// Introspect descreve um token para um cliente confidencial (RFC 7662)
+func (h *OAuthHandler) Introspect(c echo.Context) error {
+	var req tokenActionRequest
+	if err := c.Bind(&req); err != nil {
+		return oauthErrorResponse(c, &auth.OAuthError{Code: "invalid_request", Description: "invalid payload"})
+	}
+
+	client, err := h.authenticateClient(c, req.ClientID, req.ClientSecret)
+	if err != nil {
+		return oauthErrorResponse(c, err)
+	}
+
+	if !client.Confidential() {
+		return oauthErrorResponse(c, auth.ErrInvalidClient)
+	}
+
+	out, err := h.server.Introspect(c.Request().Context(), client, req.Token)
+	if err != nil {
+		return oauthErrorResponse(c, err)
+	}
+
+	noStore(c)
+	return c.JSON(http.StatusOK, out)
+}
+
+// Revoke encerra a sessão de um token do cliente (RFC 7009). Tokens
+// desconhecidos também recebem 200, como a RFC exige.
+func (h *OAuthHandler) Revoke(c echo.Context) error {
+	var req tokenActionRequest
+	if err := c.Bind(&req); err != nil {
+		return oauthErrorResponse(c, &auth.OAuthError{Code: "invalid_request", Description: "invalid payload"})
+	}
+
+	client, err := h.authenticateClient(c, req.ClientID, req.ClientSecret)
+	if err != nil {
+		return oauthErrorResponse(c, err)
+	}
+
+	if err := h.server.Revoke(c.Request().Context(), client, req.Token); err != nil {
+		return oauthErrorResponse(c, err)
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// authenticateClient aceita as credenciais por HTTP Basic ou no formulário
+func (h *OAuthHandler) authenticateClient(c echo.Context, clientID, secret string) (*repo.OAuthClientRecord, error) {
+	if id, s, ok := c.Request().BasicAuth(); ok {
+		clientID, secret = id, s
+	}
+
+	return h.server.AuthenticateClient(c.Request().Context(), clientID, secret)
+}
+
+// oauthErrorResponse devolve o erro no formato da RFC 6749: 401 para falha
+// de autenticação do cliente e 400 para os demais
+func oauthErrorResponse(c echo.Context, err error) error {
+	var oe *auth.OAuthError
+	if !errors.As(err, &oe) {
+		return c.JSON(http.StatusInternalServerError, &auth.OAuthError{Code: "server_error"})
+	}
+
+	noStore(c)
+	if oe.Code == auth.ErrInvalidClient.Code {
+		c.Response().Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		return c.JSON(http.StatusUnauthorized, oe)
+	}
+	return c.JSON(http.StatusBadRequest, oe)
+}
+
+func noStore(c echo.Context) {
+	c.Response().Header().Set("Cache-Control", "no-store")
+	c.Response().Header().Set("Pragma", "no-cache")
+}
+
+// authorizeCookie monta o cookie do pedido de autorização. Ele precisa
+// chegar ao callback SAML, um POST cross-site, então é SameSite=None
+// quando o BASE_URL é https.
+func authorizeCookie(cfg *config.Config, value string, maxAge time.Duration) *http.Cookie {
+	ck := &http.Cookie{
+		Name:     oauthAuthorizeCookie,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	if strings.HasPrefix(cfg.BaseURL, "https://") {
+		ck.SameSite = http.SameSiteNoneMode
+		ck.Secure = true
+	}
+
+	if maxAge < 0 {
+		ck.MaxAge = -1
+	} else {
+		ck.MaxAge = int(maxAge.Seconds())
+	}
+
+	return ck
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+	"github.com/labstack/echo/v4"
+)
+
+// OAuthClientHandler gerencia os clientes OAuth de um tenant
+type OAuthClientHandler struct {
+	repo   repo.OAuthRepository
+	server *auth.AuthorizationServer
+}
+
+// NewOAuthClientHandler cria um novo handler, injetando o repo e o servidor de autorização
+func NewOAuthClientHandler(r repo.OAuthRepository, server *auth.AuthorizationServer) *OAuthClientHandler {
+	return &OAuthClientHandler{repo: r, server: server}
+}
+
+// oauthClientRequest representa o payload de registro de cliente
+type oauthClientRequest struct {
+	Name         string   `json:"name" validate:"notblank"`
+	RedirectURIs []string `json:"redirect_uris"`
+	GrantTypes   []string `json:"grant_types"`
+	Scopes       []string `json:"scopes" validate:"min=1"`
+	Confidential bool     `json:"confidential"`
+}
+
+// OAuthClientResponse representa um cliente OAuth, sem o segredo
+type OAuthClientResponse struct {
+	ID           int64     `json:"id"`
+	TenantID     int64     `json:"tenant_id"`
+	ClientID     string    `json:"client_id"`
+	Name         string    `json:"name"`
+	Confidential bool      `json:"confidential"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	GrantTypes   []string  `json:"grant_types"`
+	Scopes       []string  `json:"scopes"`
+	CreatedBy    *int64    `json:"created_by,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+
+	// ClientSecret só é exibido no registro
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// Register associa as rotas de administração de clientes OAuth
+func (h *OAuthClientHandler) Register(e *echo.Echo) {
+	g := e.Group("/admin/tenants/:tenantID/oauth-clients")
+	g.GET("", h.List)
+	g.POST("", h.Create)
+	g.GET("/:id", h.Get)
+	g.DELETE("/:id", h.Delete)
+}
+
+// List retorna os clientes do tenant
+func (h *OAuthClientHandler) List(c echo.Context) error {
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
+	recs, err := h.repo.ListClients(c.Request().Context(), tenant)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	out := []OAuthClientResponse{}
+	for _, r := range recs {
+		out = append(out, newOAuthClientResponse(r))
+	}
+
+	return c.JSON(http.StatusOK, out)
+}
+
+// Get retorna um cliente do tenant
+func (h *OAuthClientHandler) Get(c echo.Context) error {
+	id, err := parseID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+	}
+
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
+	rec, err := h.repo.GetClient(c.Request().Context(), tenant, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if rec == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "oauth client not found")
+	}
+
+	return c.JSON(http.StatusOK, newOAuthClientResponse(rec))
+}
+
+// Create registra um cliente. O client_secret dos clientes confidenciais é
+// devolvido uma única vez; apenas o hash é armazenado.
+func (h *OAuthClientHandler) Create(c echo.Context) error {
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
+	var req oauthClientRequest
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+
+	rec := &repo.OAuthClientRecord{
+		TenantID:     tenant,
+		Name:         req.Name,
+		RedirectURIs: req.RedirectURIs,
+		GrantTypes:   req.GrantTypes,
+		Scopes:       req.Scopes,
+	}
+
+	// um cliente não recebe permissões que quem o registra não tem
+	if claims, ok := auth.FromContext(c); ok {
+		for _, s := range req.Scopes {
+			if auth.IsPermission(s) && !claims.Can(s) {
+				return echo.NewHTTPError(http.StatusForbidden, "cannot grant scope "+s)
+			}
+		}
+
+		if claims.UserID > 0 {
+			rec.CreatedBy = &claims.UserID
+		}
+	}
+
+	secret, err := h.server.RegisterClient(c.Request().Context(), rec, req.Confidential)
+	if err != nil {
+		var oe *auth.OAuthError
+		if errors.As(err, &oe) {
+			return echo.NewHTTPError(http.StatusBadRequest, oe.Description)
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	resp := newOAuthClientResponse(rec)
+	resp.ClientSecret = secret
+	return c.JSON(http.StatusCreated, resp)
+}
+
+// Delete remove um cliente do tenant; as sessões abertas por ele acabam junto
+func (h *OAuthClientHandler) Delete(c echo.Context) error {
+	id, err := parseID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+	}
+
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
+	if err := h.repo.DeleteClient(c.Request().Context(), tenant, id); err != nil {
+		if err == sql.ErrNoRows {
+			return c.NoContent(http.StatusNotFound)
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// newOAuthClientResponse monta a resposta a partir do registro
+func newOAuthClientResponse(rec *repo.OAuthClientRecord) OAuthClientResponse {
+	return OAuthClientResponse{
+		ID:           rec.ID,
+		TenantID:     rec.TenantID,
+		ClientID:     rec.ClientID,
+		Name:         rec.Name,
+		Confidential: rec.Confidential(),
+		RedirectURIs: nonNil(rec.RedirectURIs),
+		GrantTypes:   nonNil(rec.GrantTypes),
+		Scopes:       nonNil(rec.Scopes),
+		CreatedBy:    rec.CreatedBy,
+		CreatedAt:    rec.CreatedAt,
+	}
+}
+
+// nonNil troca uma lista nula por vazia, para o JSON ter [] e não null
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
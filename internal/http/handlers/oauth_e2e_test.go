@@ -0,0 +1,516 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+	"github.com/jeanmolossi/verbose-adventure/internal/http/handlers"
+	"github.com/jeanmolossi/verbose-adventure/internal/http/middleware"
+	"github.com/jeanmolossi/verbose-adventure/internal/http/validation"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+	"github.com/jeanmolossi/verbose-adventure/internal/tenant"
+)
+
+// memOAuth is an in-memory repo.OAuthRepository
+type memOAuth struct {
+	mu      sync.Mutex
+	clients []*repo.OAuthClientRecord
+	codes   []*repo.AuthorizationCodeRecord
+}
+
+func newMemOAuth() *memOAuth {
+	return &memOAuth{}
+}
+
+func (m *memOAuth) ListClients(ctx context.Context, tenantID int64) ([]*repo.OAuthClientRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []*repo.OAuthClientRecord
+	for _, c := range m.clients {
+		if c.TenantID == tenantID {
+			cp := *c
+			out = append(out, &cp)
+		}
+	}
+	return out, nil
+}
+
+func (m *memOAuth) GetClient(ctx context.Context, tenantID, id int64) (*repo.OAuthClientRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range m.clients {
+		if c.TenantID == tenantID && c.ID == id {
+			cp := *c
+			return &cp, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memOAuth) GetClientByClientID(ctx context.Context, clientID string) (*repo.OAuthClientRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range m.clients {
+		if c.ClientID == clientID {
+			cp := *c
+			return &cp, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memOAuth) CreateClient(ctx context.Context, rec *repo.OAuthClientRecord) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cp := *rec
+	cp.ID = int64(len(m.clients) + 1)
+	cp.CreatedAt = time.Now()
+	m.clients = append(m.clients, &cp)
+	return cp.ID, nil
+}
+
+func (m *memOAuth) DeleteClient(ctx context.Context, tenantID, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, c := range m.clients {
+		if c.TenantID == tenantID && c.ID == id {
+			m.clients = append(m.clients[:i], m.clients[i+1:]...)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *memOAuth) CreateCode(ctx context.Context, rec *repo.AuthorizationCodeRecord) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cp := *rec
+	cp.ID = int64(len(m.codes) + 1)
+	m.codes = append(m.codes, &cp)
+	return cp.ID, nil
+}
+
+func (m *memOAuth) GetCodeByHash(ctx context.Context, hash []byte) (*repo.AuthorizationCodeRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range m.codes {
+		if bytes.Equal(c.CodeHash, hash) {
+			cp := *c
+			return &cp, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memOAuth) ConsumeCode(ctx context.Context, id int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := m.codes[id-1]
+	if c.UsedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	c.UsedAt = &now
+	return true, nil
+}
+
+func (m *memOAuth) AttachSession(ctx context.Context, id int64, sessionID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.codes[id-1].SessionID = sessionID
+	return nil
+}
+
+// postForm sends a form to an OAuth endpoint, with HTTP Basic client
+// credentials when clientID is given
+func postForm(e *echo.Echo, path string, form url.Values, clientID, secret string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	if clientID != "" {
+		req.SetBasicAuth(clientID, secret)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestOAuth_AuthorizationCodeFlowWithPKCE(t *testing.T) {
+	cfg := &config.Config{JWTSecret: "test-secret", BaseURL: "https://crm.example.com"}
+	srv := setupAuthServer(t, []auth.IdentityProvider{&fakeOIDC{tenant: 99}}, cfg)
+	e := srv.Echo
+
+	client := &repo.OAuthClientRecord{
+		TenantID:     99,
+		Name:         "Mobile app",
+		RedirectURIs: []string{"https://app.example.com/cb"},
+		Scopes:       []string{auth.PermIDPRead, auth.PermRoleRead},
+	}
+	_, err := srv.oauth.RegisterClient(context.Background(), client, false)
+	require.NoError(t, err)
+
+	// 1) the client sends the user to the authorization endpoint
+	verifier := oauth2.GenerateVerifier()
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {client.ClientID},
+		"redirect_uri":          {"https://app.example.com/cb"},
+		"scope":                 {auth.PermIDPRead},
+		"state":                 {"xyz"},
+		"code_challenge":        {oauth2.S256ChallengeFromVerifier(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+q.Encode(), nil))
+	require.Equal(t, http.StatusFound, rec.Code)
+	require.Equal(t, "https://crm.example.com/initrode/sso", rec.Header().Get(echo.HeaderLocation))
+
+	authorizeCookies := rec.Result().Cookies()
+	require.Len(t, authorizeCookies, 1)
+
+	// 2) the user signs in with the tenant SSO and is sent back with a code
+	state, loginCookie := login(t, e, "/99/oidc/login")
+	req := httptest.NewRequest(http.MethodGet, "/99/oidc/callback?code=irrelevant&state="+url.QueryEscape(state), nil)
+	req.AddCookie(loginCookie)
+	req.AddCookie(authorizeCookies[0])
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusFound, rec.Code)
+
+	loc, err := rec.Result().Location()
+	require.NoError(t, err)
+	require.Equal(t, "app.example.com", loc.Host)
+	require.Equal(t, "xyz", loc.Query().Get("state"))
+	require.Equal(t, "https://crm.example.com", loc.Query().Get("iss"))
+	code := loc.Query().Get("code")
+	require.NotEmpty(t, code)
+
+	// the client acts for the user, so it needs a user who can read the IdPs
+	require.NoError(t, srv.roles.ReplaceRoles(context.Background(), 99, 1, repo.RoleSourceManual, []string{auth.RoleAdmin}))
+
+	// 3) the client exchanges the code with the verifier
+	exchange := url.Values{
+		"grant_type":    {auth.GrantAuthorizationCode},
+		"code":          {code},
+		"redirect_uri":  {"https://app.example.com/cb"},
+		"code_verifier": {oauth2.GenerateVerifier()},
+		"client_id":     {client.ClientID},
+	}
+	rec = postForm(e, "/oauth/token", exchange, "", "")
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, rec.Body.String(), `"error":"invalid_grant"`)
+
+	exchange.Set("code_verifier", verifier)
+	rec = postForm(e, "/oauth/token", exchange, "", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+
+	var tokens auth.TokenResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &tokens))
+	require.NotEmpty(t, tokens.AccessToken)
+	require.NotEmpty(t, tokens.RefreshToken)
+	require.Equal(t, auth.PermIDPRead, tokens.Scope)
+
+	claims, err := auth.NewTokenValidator(cfg, srv.keys).Validate(tokens.AccessToken)
+	require.NoError(t, err)
+	require.Equal(t, client.ClientID, claims.ClientID)
+	require.Equal(t, int64(1), claims.UserID)
+	require.Equal(t, auth.Scope{auth.PermIDPRead}, claims.Scope)
+	require.True(t, claims.Can(auth.PermIDPRead))
+	require.False(t, claims.Can(auth.PermRoleRead), "the client only gets the granted scopes")
+
+	// 4) the refresh token rotates, and only for the client it was issued to
+	refresh := url.Values{"grant_type": {auth.GrantRefreshToken}, "refresh_token": {tokens.RefreshToken}, "client_id": {"other"}}
+	rec = postForm(e, "/oauth/token", refresh, "", "")
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	refresh.Set("client_id", client.ClientID)
+	rec = postForm(e, "/oauth/token", refresh, "", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var rotated auth.TokenResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &rotated))
+	require.NotEqual(t, tokens.RefreshToken, rotated.RefreshToken)
+	require.Equal(t, auth.PermIDPRead, rotated.Scope)
+
+	// 5) the client revokes the session, its refresh token stops working
+	rec = postForm(e, "/oauth/revoke", url.Values{"token": {rotated.RefreshToken}, "client_id": {client.ClientID}}, "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	refresh.Set("refresh_token", rotated.RefreshToken)
+	rec = postForm(e, "/oauth/token", refresh, "", "")
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, rec.Body.String(), `"error":"invalid_grant"`)
+}
+
+func TestOAuth_CodeIsSingleUse(t *testing.T) {
+	cfg := &config.Config{JWTSecret: "test-secret", BaseURL: "https://crm.example.com"}
+	srv := setupAuthServer(t, []auth.IdentityProvider{&fakeOIDC{tenant: 99}}, cfg)
+	ctx := context.Background()
+
+	client := &repo.OAuthClientRecord{TenantID: 99, RedirectURIs: []string{"https://app.example.com/cb"}, Scopes: []string{auth.PermIDPRead}}
+	_, err := srv.oauth.RegisterClient(ctx, client, false)
+	require.NoError(t, err)
+
+	verifier := oauth2.GenerateVerifier()
+	req, err := srv.oauth.Authorize(ctx, auth.AuthorizeParams{
+		ResponseType:        "code",
+		ClientID:            client.ClientID,
+		CodeChallenge:       oauth2.S256ChallengeFromVerifier(verifier),
+		CodeChallengeMethod: "S256",
+	})
+	require.NoError(t, err)
+
+	userID, err := srv.sessions.Provision(ctx, &auth.AuthResult{TenantID: 99, IdPID: 1, UserID: "user123", Email: "user@example.com"})
+	require.NoError(t, err)
+
+	location, err := srv.oauth.IssueCode(ctx, req, userID)
+	require.NoError(t, err)
+	loc, err := url.Parse(location)
+	require.NoError(t, err)
+
+	exchange := url.Values{
+		"grant_type":    {auth.GrantAuthorizationCode},
+		"code":          {loc.Query().Get("code")},
+		"redirect_uri":  {"https://app.example.com/cb"},
+		"code_verifier": {verifier},
+		"client_id":     {client.ClientID},
+	}
+	rec := postForm(srv.Echo, "/oauth/token", exchange, "", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var tokens auth.TokenResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &tokens))
+
+	// a replayed code is rejected and ends the session it opened
+	rec = postForm(srv.Echo, "/oauth/token", exchange, "", "")
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, rec.Body.String(), `"error":"invalid_grant"`)
+
+	rec = postForm(srv.Echo, "/oauth/token", url.Values{
+		"grant_type":    {auth.GrantRefreshToken},
+		"refresh_token": {tokens.RefreshToken},
+		"client_id":     {client.ClientID},
+	}, "", "")
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestOAuth_AuthorizeErrors(t *testing.T) {
+	cfg := &config.Config{JWTSecret: "test-secret", BaseURL: "https://crm.example.com"}
+	srv := setupAuthServer(t, []auth.IdentityProvider{&fakeOIDC{tenant: 99}}, cfg)
+
+	client := &repo.OAuthClientRecord{TenantID: 99, RedirectURIs: []string{"https://app.example.com/cb"}, Scopes: []string{auth.PermIDPRead}}
+	_, err := srv.oauth.RegisterClient(context.Background(), client, false)
+	require.NoError(t, err)
+
+	// an unregistered redirect uri is never followed
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/oauth/authorize?response_type=code&client_id="+client.ClientID+"&redirect_uri="+url.QueryEscape("https://evil.example.com/cb"), nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, rec.Body.String(), `"error":"invalid_request"`)
+
+	// other errors go back to the client
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/oauth/authorize?response_type=code&state=s1&client_id="+client.ClientID, nil))
+	require.Equal(t, http.StatusFound, rec.Code)
+
+	loc, err := rec.Result().Location()
+	require.NoError(t, err)
+	require.Equal(t, "app.example.com", loc.Host)
+	require.Equal(t, "invalid_request", loc.Query().Get("error"))
+	require.Equal(t, "s1", loc.Query().Get("state"))
+	require.Empty(t, rec.Result().Cookies())
+}
+
+func TestOAuth_ClientCredentialsAndIntrospection(t *testing.T) {
+	cfg := &config.Config{JWTSecret: "test-secret", BaseURL: "https://crm.example.com"}
+	srv := setupAuthServer(t, nil, cfg)
+	ctx := context.Background()
+
+	service := &repo.OAuthClientRecord{TenantID: 99, GrantTypes: []string{auth.GrantClientCredentials}, Scopes: []string{auth.PermIDPRead, auth.PermDomainRead}}
+	secret, err := srv.oauth.RegisterClient(ctx, service, true)
+	require.NoError(t, err)
+	require.NotEmpty(t, secret)
+
+	other := &repo.OAuthClientRecord{TenantID: 1, GrantTypes: []string{auth.GrantClientCredentials}, Scopes: []string{auth.PermIDPRead}}
+	otherSecret, err := srv.oauth.RegisterClient(ctx, other, true)
+	require.NoError(t, err)
+
+	grant := url.Values{"grant_type": {auth.GrantClientCredentials}, "scope": {auth.PermIDPRead}}
+
+	rec := postForm(srv.Echo, "/oauth/token", grant, service.ClientID, "wrong")
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.Contains(t, rec.Body.String(), `"error":"invalid_client"`)
+
+	rec = postForm(srv.Echo, "/oauth/token", url.Values{"grant_type": {auth.GrantClientCredentials}, "scope": {auth.PermIDPWrite}}, service.ClientID, secret)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, rec.Body.String(), `"error":"invalid_scope"`)
+
+	rec = postForm(srv.Echo, "/oauth/token", url.Values{"grant_type": {auth.GrantAuthorizationCode}}, service.ClientID, secret)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, rec.Body.String(), `"error":"unauthorized_client"`)
+
+	rec = postForm(srv.Echo, "/oauth/token", grant, service.ClientID, secret)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var tokens auth.TokenResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &tokens))
+	require.Empty(t, tokens.RefreshToken)
+	require.Equal(t, auth.PermIDPRead, tokens.Scope)
+
+	// introspection by a client of the same tenant
+	rec = postForm(srv.Echo, "/oauth/introspect", url.Values{"token": {tokens.AccessToken}}, service.ClientID, secret)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var info auth.Introspection
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &info))
+	require.True(t, info.Active)
+	require.Equal(t, service.ClientID, info.ClientID)
+	require.Equal(t, auth.PermIDPRead, info.Scope)
+	require.Equal(t, int64(99), info.TenantID)
+	require.Zero(t, info.UserID)
+
+	// tokens of another tenant, and garbage, are simply inactive
+	rec = postForm(srv.Echo, "/oauth/introspect", url.Values{"token": {tokens.AccessToken}}, other.ClientID, otherSecret)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"active":false}`, rec.Body.String())
+
+	rec = postForm(srv.Echo, "/oauth/introspect", url.Values{"token": {"garbage"}}, service.ClientID, secret)
+	require.JSONEq(t, `{"active":false}`, rec.Body.String())
+}
+
+func TestOAuthClients_AdminAPI(t *testing.T) {
+	cfg := &config.Config{BaseURL: "https://crm.example.com"}
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	keys, err := auth.NewKeyringFromSigners(priv)
+	require.NoError(t, err)
+
+	clients := newMemOAuth()
+	sessions := auth.NewSessions(cfg, newMemSessions(), newMemUsers(), newMemRoles(), keys)
+	server := auth.NewAuthorizationServer(cfg, clients, sessions, auth.NewTokenValidator(cfg, keys))
+	handler := handlers.NewOAuthClientHandler(clients, server)
+	jwtMw := middleware.NewJWTMiddleware(middleware.JWTConfig{Tokens: auth.NewTokenValidator(cfg, keys)})
+
+	e := echo.New()
+	e.Validator = validation.New(validation.Params{})
+	e.Use(middleware.ResolveTenant(tenant.NewResolver(cfg, newMemTenants()), "tenantID"))
+	g := e.Group("/admin/tenants/:tenantID/oauth-clients", jwtMw, middleware.RequireTenant())
+	g.GET("", handler.List, middleware.RequireScope(auth.PermOAuthClientRead))
+	g.POST("", handler.Create, middleware.RequireScope(auth.PermOAuthClientWrite))
+	g.GET("/:id", handler.Get, middleware.RequireScope(auth.PermOAuthClientRead))
+	g.DELETE("/:id", handler.Delete, middleware.RequireScope(auth.PermOAuthClientWrite))
+
+	call := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	admin := signToken(t, cfg, keys, 1, false, auth.RoleAdmin)
+	readOnly := signToken(t, cfg, keys, 1, false, auth.RoleReadOnly)
+
+	// admins can not hand out permissions they do not have
+	rec := call(http.MethodPost, "/admin/tenants/1/oauth-clients", admin, `{"name":"sync","confidential":true,"grant_types":["client_credentials"],"scopes":["roles:write"]}`)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = call(http.MethodPost, "/admin/tenants/1/oauth-clients", admin, `{"name":"sync","grant_types":["client_credentials"],"scopes":["idp:read"]}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = call(http.MethodPost, "/admin/tenants/1/oauth-clients", readOnly, `{"name":"sync","confidential":true,"grant_types":["client_credentials"],"scopes":["idp:read"]}`)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = call(http.MethodPost, "/admin/tenants/1/oauth-clients", admin, `{"name":"sync","confidential":true,"grant_types":["client_credentials"],"scopes":["idp:read"]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	var created handlers.OAuthClientResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	require.NotEmpty(t, created.ClientID)
+	require.NotEmpty(t, created.ClientSecret)
+	require.True(t, created.Confidential)
+	require.Equal(t, int64(1), *created.CreatedBy)
+
+	// the secret is shown once
+	rec = call(http.MethodGet, "/admin/tenants/1/oauth-clients", readOnly, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NotContains(t, rec.Body.String(), created.ClientSecret)
+	require.NotContains(t, rec.Body.String(), "client_secret")
+
+	// another tenant does not see it
+	globex := signToken(t, cfg, keys, 2, false, auth.RoleOwner)
+	rec = call(http.MethodGet, "/admin/tenants/2/oauth-clients/1", globex, "")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = call(http.MethodDelete, "/admin/tenants/1/oauth-clients/1", admin, "")
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = call(http.MethodDelete, "/admin/tenants/1/oauth-clients/1", admin, "")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestOAuth_SuperAdminClientTokenIsTenantBound(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Config{BaseURL: "https://crm.example.com", SuperAdmins: []string{"1:1"}}
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	keys, err := auth.NewKeyringFromSigners(priv)
+	require.NoError(t, err)
+
+	users, roles := newMemUsers(), newMemRoles()
+	userID, err := users.UpsertLogin(ctx, &repo.UserRecord{TenantID: 1, IdPID: 1, Subject: "root", Email: "root@acme.com"})
+	require.NoError(t, err)
+	require.NoError(t, roles.ReplaceRoles(ctx, 1, userID, repo.RoleSourceManual, []string{auth.RoleOwner}))
+
+	sessions := auth.NewSessions(cfg, newMemSessions(), users, roles, keys)
+	clients := newMemOAuth()
+	handler := handlers.NewOAuthClientHandler(clients, auth.NewAuthorizationServer(cfg, clients, sessions, auth.NewTokenValidator(cfg, keys)))
+
+	e := echo.New()
+	e.Use(middleware.ResolveTenant(tenant.NewResolver(cfg, newMemTenants()), "tenantID"))
+	g := e.Group("/admin/tenants/:tenantID/oauth-clients",
+		middleware.NewJWTMiddleware(middleware.JWTConfig{Tokens: auth.NewTokenValidator(cfg, keys)}),
+		middleware.RequireTenant(),
+	)
+	g.GET("", handler.List, middleware.RequireScope(auth.PermOAuthClientRead))
+
+	call := func(path, token string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// the super admin's own session reaches every tenant
+	own, err := sessions.Start(ctx, &auth.AuthResult{TenantID: 1, IdPID: 1, UserID: "root", Email: "root@acme.com"})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, call("/admin/tenants/2/oauth-clients", own.AccessToken))
+
+	// a client the super admin signs into does not
+	pair, err := sessions.StartClient(ctx, 1, userID, "third-party", []string{auth.PermOAuthClientRead})
+	require.NoError(t, err)
+
+	claims, err := auth.NewTokenValidator(cfg, keys).Validate(pair.AccessToken)
+	require.NoError(t, err)
+	require.False(t, claims.SuperAdmin)
+
+	require.Equal(t, http.StatusOK, call("/admin/tenants/1/oauth-clients", pair.AccessToken))
+	require.Equal(t, http.StatusForbidden, call("/admin/tenants/2/oauth-clients", pair.AccessToken))
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+	"github.com/jeanmolossi/verbose-adventure/pkg/stripslash"
+	"github.com/labstack/echo/v4"
+)
+
+// RoleHandler gerencia os papéis dos usuários de um tenant
+type RoleHandler struct {
+	repo  repo.UserRoleRepository
+	users repo.UserRepository
+}
+
+// NewRoleHandler cria um novo handler, injetando os repos
+func NewRoleHandler(r repo.UserRoleRepository, users repo.UserRepository) *RoleHandler {
+	return &RoleHandler{repo: r, users: users}
+}
+
+// rolesRequest representa o payload de atribuição manual de papéis
+type rolesRequest struct {
+	Roles []string `json:"roles" validate:"dive,role"`
+}
+
+// RolesResponse representa os papéis de um usuário por origem
+type RolesResponse struct {
+	UserID int64    `json:"user_id"`
+	Roles  []string `json:"roles"`
+	IdP    []string `json:"idp"`
+	Manual []string `json:"manual"`
+}
+
+// Register associa as rotas de administração de papéis
+func (h *RoleHandler) Register(e *echo.Echo) {
+	g := e.Group("/admin/tenants/:tenantID/users/:userID/roles")
+	g.GET("", h.Get)
+	g.PUT("", h.Put)
+}
+
+// Get retorna os papéis de um usuário
+func (h *RoleHandler) Get(c echo.Context) error {
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
+	userID, err := h.findUser(c, tenant)
+	if err != nil {
+		return err
+	}
+
+	resp, err := h.load(c, tenant, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// Put substitui os papéis atribuídos manualmente a um usuário. Os papéis
+// vindos do IdP são recalculados a cada login e não são alterados aqui.
+func (h *RoleHandler) Put(c echo.Context) error {
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
+	userID, err := h.findUser(c, tenant)
+	if err != nil {
+		return err
+	}
+
+	var req rolesRequest
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	if err := h.repo.ReplaceRoles(ctx, tenant, userID, repo.RoleSourceManual, req.Roles); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	resp, err := h.load(c, tenant, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// findUser valida o usuário do path, que deve pertencer ao tenant
+func (h *RoleHandler) findUser(c echo.Context, tenantID int64) (int64, error) {
+	userID, err := strconv.ParseInt(stripslash.ParamWithoutAnySlashes(c, "userID"), 10, 64)
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "invalid user id")
+	}
+
+	user, err := h.users.GetByID(c.Request().Context(), tenantID, userID)
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if user == nil {
+		return 0, echo.NewHTTPError(http.StatusNotFound, "user not found")
+	}
+
+	return user.ID, nil
+}
+
+// load monta a resposta com os papéis de cada origem
+func (h *RoleHandler) load(c echo.Context, tenantID, userID int64) (*RolesResponse, error) {
+	ctx := c.Request().Context()
+
+	roles, err := h.repo.ListRoles(ctx, tenantID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	idp, err := h.repo.ListRolesBySource(ctx, tenantID, userID, repo.RoleSourceIdP)
+	if err != nil {
+		return nil, err
+	}
+
+	manual, err := h.repo.ListRolesBySource(ctx, tenantID, userID, repo.RoleSourceManual)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RolesResponse{UserID: userID, Roles: roles, IdP: idp, Manual: manual}, nil
+}
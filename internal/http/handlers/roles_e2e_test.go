@@ -0,0 +1,68 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
+	h "github.com/jeanmolossi/verbose-adventure/internal/http/handlers"
+	"github.com/jeanmolossi/verbose-adventure/internal/http/validation"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func setupRoles() (*echo.Echo, *memRoles) {
+	e := echo.New()
+	e.Validator = validation.New(validation.Params{})
+	roles, users := newMemRoles(), newMemUsers()
+	_, _ = users.UpsertLogin(context.Background(), &repo.UserRecord{TenantID: 5, IdPID: 1, Subject: "u1"})
+	h.NewRoleHandler(roles, users).Register(e)
+	return e, roles
+}
+
+func TestRoles_PutManualRoles(t *testing.T) {
+	e, roles := setupRoles()
+	_ = roles.ReplaceRoles(context.Background(), 5, 1, repo.RoleSourceIdP, []string{auth.RoleMember})
+
+	req := httptest.NewRequest(http.MethodPut, "/admin/tenants/5/users/1/roles", strings.NewReader(`{"roles":["admin"]}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var out h.RolesResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &out))
+	require.Equal(t, []string{auth.RoleAdmin, auth.RoleMember}, out.Roles)
+	require.Equal(t, []string{auth.RoleMember}, out.IdP)
+	require.Equal(t, []string{auth.RoleAdmin}, out.Manual)
+
+	// roles of other tenants are untouched
+	other, _ := roles.ListRoles(context.Background(), 6, 1)
+	require.Empty(t, other)
+}
+
+func TestRoles_RejectsUnknownRole(t *testing.T) {
+	e, _ := setupRoles()
+
+	req := httptest.NewRequest(http.MethodPut, "/admin/tenants/5/users/1/roles", strings.NewReader(`{"roles":["root"]}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestRoles_UnknownUserIsNotFound(t *testing.T) {
+	e, _ := setupRoles()
+
+	// a user of another tenant, and an unknown user
+	for _, path := range []string{"/admin/tenants/6/users/1/roles", "/admin/tenants/5/users/2/roles"} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, http.StatusNotFound, rec.Code, path)
+	}
+}
@@ -0,0 +1,761 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+	"github.com/jeanmolossi/verbose-adventure/internal/scim"
+	"github.com/jeanmolossi/verbose-adventure/pkg/stripslash"
+	"github.com/labstack/echo/v4"
+)
+
+// scimMaxResults é o tamanho padrão e máximo de uma página
+const scimMaxResults = 200
+
+// SCIMHandler expõe os endpoints SCIM 2.0 de provisionamento. Cada token
+// SCIM provisiona os usuários e grupos de um IdP do tenant.
+type SCIMHandler struct {
+	repo         repo.SCIMRepository
+	provisioning *auth.Provisioning
+	cfg          *config.Config
+}
+
+// NewSCIMHandler cria um novo handler, injetando o repo e o serviço de provisionamento
+func NewSCIMHandler(r repo.SCIMRepository, provisioning *auth.Provisioning, cfg *config.Config) *SCIMHandler {
+	return &SCIMHandler{repo: r, provisioning: provisioning, cfg: cfg}
+}
+
+// Register associa as rotas SCIM
+func (h *SCIMHandler) Register(e *echo.Echo) {
+	g := e.Group("/scim/v2/:tenantID")
+	g.GET("/ServiceProviderConfig", h.ServiceProviderConfig)
+
+	g.GET("/Users", h.ListUsers)
+	g.POST("/Users", h.CreateUser)
+	g.GET("/Users/:id", h.GetUser)
+	g.PUT("/Users/:id", h.ReplaceUser)
+	g.PATCH("/Users/:id", h.PatchUser)
+	g.DELETE("/Users/:id", h.DeleteUser)
+
+	g.GET("/Groups", h.ListGroups)
+	g.POST("/Groups", h.CreateGroup)
+	g.GET("/Groups/:id", h.GetGroup)
+	g.PUT("/Groups/:id", h.ReplaceGroup)
+	g.PATCH("/Groups/:id", h.PatchGroup)
+	g.DELETE("/Groups/:id", h.DeleteGroup)
+}
+
+// ServiceProviderConfig descreve o que os endpoints suportam
+func (h *SCIMHandler) ServiceProviderConfig(c echo.Context) error {
+	return scim.Write(c, http.StatusOK, scim.NewServiceProviderConfig(scimMaxResults))
+}
+
+// ListUsers retorna uma página dos usuários do IdP, com filtro opcional
+func (h *SCIMHandler) ListUsers(c echo.Context) error {
+	tk, _ := scim.FromContext(c)
+
+	page, serr := scim.ParsePage(c, scimMaxResults)
+	if serr != nil {
+		return scim.WriteError(c, serr)
+	}
+
+	filter, ok, serr := userFilter(c.QueryParam("filter"))
+	if serr != nil {
+		return scim.WriteError(c, serr)
+	}
+
+	// um filtro que nenhum usuário pode atender, como um id não numérico
+	if !ok {
+		return scim.Write(c, http.StatusOK, scim.NewListResponse(0, page.StartIndex, nil))
+	}
+
+	recs, total, err := h.repo.ListUsers(c.Request().Context(), tk.TenantID, tk.IdPID, filter, page.Offset(), page.Count)
+	if err != nil {
+		return scimInternalError(c, err)
+	}
+
+	var out []any
+	for _, r := range recs {
+		out = append(out, scim.NewUser(r, h.location(c, "Users", r.ID), nil))
+	}
+
+	return scim.Write(c, http.StatusOK, scim.NewListResponse(total, page.StartIndex, out))
+}
+
+// GetUser retorna um usuário com seus grupos
+func (h *SCIMHandler) GetUser(c echo.Context) error {
+	rec, serr := h.loadUser(c)
+	if serr != nil {
+		return scim.WriteError(c, serr)
+	}
+
+	return h.writeUser(c, http.StatusOK, rec)
+}
+
+// CreateUser provisiona um usuário. O subject do login é o externalId, ou
+// o userName na falta dele; um usuário que já fez login com esse subject
+// passa a ser gerenciado pelo SCIM.
+func (h *SCIMHandler) CreateUser(c echo.Context) error {
+	tk, _ := scim.FromContext(c)
+	ctx := c.Request().Context()
+
+	var u scim.User
+	if serr := scim.Decode(c, &u); serr != nil {
+		return scim.WriteError(c, serr)
+	}
+
+	if serr := u.Check(); serr != nil {
+		return scim.WriteError(c, serr)
+	}
+
+	if serr := h.checkUserName(ctx, tk, 0, u.UserName); serr != nil {
+		return scim.WriteError(c, serr)
+	}
+
+	subject := u.ExternalID
+	if subject == "" {
+		subject = u.UserName
+	}
+
+	rec := &repo.UserRecord{
+		TenantID: tk.TenantID,
+		IdPID:    tk.IdPID,
+		Subject:  subject,
+	}
+	applySCIMUser(rec, &u)
+
+	id, err := h.repo.UpsertUser(ctx, rec)
+	if err != nil {
+		return scimInternalError(c, err)
+	}
+
+	// o usuário assumido pode ter sessões abertas
+	if !rec.Active {
+		if err := h.provisioning.Deprovision(ctx, tk.TenantID, id); err != nil {
+			return scimInternalError(c, err)
+		}
+	}
+
+	created, err := h.repo.GetUser(ctx, tk.TenantID, tk.IdPID, id)
+	if err != nil || created == nil {
+		return scimInternalError(c, fmt.Errorf("load created user: %v", err))
+	}
+
+	c.Response().Header().Set(echo.HeaderLocation, h.location(c, "Users", id))
+	return h.writeUser(c, http.StatusCreated, created)
+}
+
+// ReplaceUser substitui o perfil e o status de um usuário
+func (h *SCIMHandler) ReplaceUser(c echo.Context) error {
+	rec, serr := h.loadUser(c)
+	if serr != nil {
+		return scim.WriteError(c, serr)
+	}
+
+	var u scim.User
+	if serr := scim.Decode(c, &u); serr != nil {
+		return scim.WriteError(c, serr)
+	}
+
+	return h.saveUser(c, rec, &u)
+}
+
+// PatchUser aplica as operações PATCH ao usuário; desativá-lo encerra suas sessões
+func (h *SCIMHandler) PatchUser(c echo.Context) error {
+	rec, serr := h.loadUser(c)
+	if serr != nil {
+		return scim.WriteError(c, serr)
+	}
+
+	var req scim.PatchRequest
+	if serr := scim.Decode(c, &req); serr != nil {
+		return scim.WriteError(c, serr)
+	}
+
+	u := scim.NewUser(rec, "", nil)
+	if serr := u.Patch(req.Operations); serr != nil {
+		return scim.WriteError(c, serr)
+	}
+
+	return h.saveUser(c, rec, u)
+}
+
+// DeleteUser remove o usuário, encerrando suas sessões e seus papéis
+func (h *SCIMHandler) DeleteUser(c echo.Context) error {
+	tk, _ := scim.FromContext(c)
+	ctx := c.Request().Context()
+
+	rec, serr := h.loadUser(c)
+	if serr != nil {
+		return scim.WriteError(c, serr)
+	}
+
+	if err := h.provisioning.Deprovision(ctx, tk.TenantID, rec.ID); err != nil {
+		return scimInternalError(c, err)
+	}
+
+	if err := h.repo.DeleteUser(ctx, tk.TenantID, tk.IdPID, rec.ID); err != nil {
+		if err == sql.ErrNoRows {
+			return scim.WriteError(c, scimNotFound("User", rec.ID))
+		}
+		return scimInternalError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// saveUser grava o usuário alterado por PUT ou PATCH
+func (h *SCIMHandler) saveUser(c echo.Context, rec *repo.UserRecord, u *scim.User) error {
+	tk, _ := scim.FromContext(c)
+	ctx := c.Request().Context()
+
+	if serr := u.Check(); serr != nil {
+		return scim.WriteError(c, serr)
+	}
+
+	if !strings.EqualFold(u.UserName, rec.UserName) {
+		if serr := h.checkUserName(ctx, tk, rec.ID, u.UserName); serr != nil {
+			return scim.WriteError(c, serr)
+		}
+	}
+
+	wasActive := rec.Active
+	applySCIMUser(rec, u)
+
+	if err := h.repo.UpdateUser(ctx, rec); err != nil {
+		return scimInternalError(c, err)
+	}
+
+	if wasActive && !rec.Active {
+		if err := h.provisioning.Deprovision(ctx, tk.TenantID, rec.ID); err != nil {
+			return scimInternalError(c, err)
+		}
+	}
+
+	updated, err := h.repo.GetUser(ctx, tk.TenantID, tk.IdPID, rec.ID)
+	if err != nil || updated == nil {
+		return scimInternalError(c, fmt.Errorf("load updated user: %v", err))
+	}
+
+	return h.writeUser(c, http.StatusOK, updated)
+}
+
+// loadUser carrega o usuário do parâmetro :id
+func (h *SCIMHandler) loadUser(c echo.Context) (*repo.UserRecord, *scim.Error) {
+	tk, _ := scim.FromContext(c)
+
+	param := stripslash.ParamWithoutAnySlashes(c, "id")
+	id, err := strconv.ParseInt(param, 10, 64)
+	if err != nil {
+		return nil, scim.NewError(http.StatusNotFound, "", "User %s not found", param)
+	}
+
+	rec, err := h.repo.GetUser(c.Request().Context(), tk.TenantID, tk.IdPID, id)
+	if err != nil {
+		return nil, scim.NewError(http.StatusInternalServerError, "", "%s", err.Error())
+	}
+
+	if rec == nil {
+		return nil, scimNotFound("User", id)
+	}
+
+	return rec, nil
+}
+
+// checkUserName recusa um userName que já é de outro usuário do IdP
+func (h *SCIMHandler) checkUserName(ctx context.Context, tk *repo.SCIMTokenRecord, id int64, userName string) *scim.Error {
+	recs, _, err := h.repo.ListUsers(ctx, tk.TenantID, tk.IdPID, repo.SCIMUserFilter{UserName: userName}, 0, 1)
+	if err != nil {
+		return scim.NewError(http.StatusInternalServerError, "", "%s", err.Error())
+	}
+
+	if len(recs) > 0 && recs[0].ID != id {
+		return scim.NewError(http.StatusConflict, scim.ErrUniqueness, "userName %q is already taken", userName)
+	}
+
+	return nil
+}
+
+// writeUser responde com o usuário e seus grupos
+func (h *SCIMHandler) writeUser(c echo.Context, status int, rec *repo.UserRecord) error {
+	groups, err := h.repo.ListUserGroups(c.Request().Context(), rec.ID)
+	if err != nil {
+		return scimInternalError(c, err)
+	}
+
+	return scim.Write(c, status, scim.NewUser(rec, h.location(c, "Users", rec.ID), groups))
+}
+
+// applySCIMUser copia os atributos armazenados do recurso para o registro
+func applySCIMUser(rec *repo.UserRecord, u *scim.User) {
+	rec.UserName = u.UserName
+	rec.ExternalID = u.ExternalID
+	rec.Email = u.PrimaryEmail()
+	rec.Name = u.FullName()
+	rec.Active = u.IsActive()
+}
+
+// ListGroups retorna uma página dos grupos do IdP, com filtro opcional
+func (h *SCIMHandler) ListGroups(c echo.Context) error {
+	tk, _ := scim.FromContext(c)
+	ctx := c.Request().Context()
+
+	page, serr := scim.ParsePage(c, scimMaxResults)
+	if serr != nil {
+		return scim.WriteError(c, serr)
+	}
+
+	filter, ok, serr := groupFilter(c.QueryParam("filter"))
+	if serr != nil {
+		return scim.WriteError(c, serr)
+	}
+
+	if !ok {
+		return scim.Write(c, http.StatusOK, scim.NewListResponse(0, page.StartIndex, nil))
+	}
+
+	recs, total, err := h.repo.ListGroups(ctx, tk.TenantID, tk.IdPID, filter, page.Offset(), page.Count)
+	if err != nil {
+		return scimInternalError(c, err)
+	}
+
+	var out []any
+	for _, r := range recs {
+		g, err := h.newGroup(c, r)
+		if err != nil {
+			return scimInternalError(c, err)
+		}
+		out = append(out, g)
+	}
+
+	return scim.Write(c, http.StatusOK, scim.NewListResponse(total, page.StartIndex, out))
+}
+
+// GetGroup retorna um grupo com seus membros
+func (h *SCIMHandler) GetGroup(c echo.Context) error {
+	rec, serr := h.loadGroup(c)
+	if serr != nil {
+		return scim.WriteError(c, serr)
+	}
+
+	return h.writeGroup(c, http.StatusOK, rec)
+}
+
+// CreateGroup cria um grupo; seus membros recebem os papéis mapeados do nome
+func (h *SCIMHandler) CreateGroup(c echo.Context) error {
+	tk, _ := scim.FromContext(c)
+	ctx := c.Request().Context()
+
+	var g scim.Group
+	if serr := scim.Decode(c, &g); serr != nil {
+		return scim.WriteError(c, serr)
+	}
+
+	if serr := g.Check(); serr != nil {
+		return scim.WriteError(c, serr)
+	}
+
+	if serr := h.checkDisplayName(ctx, tk, 0, g.DisplayName); serr != nil {
+		return scim.WriteError(c, serr)
+	}
+
+	members, serr := h.memberIDs(ctx, tk, g.MemberIDs())
+	if serr != nil {
+		return scim.WriteError(c, serr)
+	}
+
+	rec := &repo.SCIMGroupRecord{
+		TenantID:    tk.TenantID,
+		IdPID:       tk.IdPID,
+		DisplayName: g.DisplayName,
+		ExternalID:  g.ExternalID,
+	}
+
+	id, err := h.repo.CreateGroup(ctx, rec)
+	if err != nil {
+		return scimInternalError(c, err)
+	}
+
+	if err := h.repo.AddMembers(ctx, id, members); err != nil {
+		return scimInternalError(c, err)
+	}
+
+	if err := h.provisioning.SyncRoles(ctx, tk.TenantID, tk.IdPID, members...); err != nil {
+		return scimInternalError(c, err)
+	}
+
+	created, err := h.repo.GetGroup(ctx, tk.TenantID, tk.IdPID, id)
+	if err != nil || created == nil {
+		return scimInternalError(c, fmt.Errorf("load created group: %v", err))
+	}
+
+	c.Response().Header().Set(echo.HeaderLocation, h.location(c, "Groups", id))
+	return h.writeGroup(c, http.StatusCreated, created)
+}
+
+// ReplaceGroup substitui o nome e os membros de um grupo
+func (h *SCIMHandler) ReplaceGroup(c echo.Context) error {
+	rec, serr := h.loadGroup(c)
+	if serr != nil {
+		return scim.WriteError(c, serr)
+	}
+
+	var g scim.Group
+	if serr := scim.Decode(c, &g); serr != nil {
+		return scim.WriteError(c, serr)
+	}
+
+	return h.saveGroup(c, rec, &scim.GroupPatch{
+		DisplayName:    &g.DisplayName,
+		ExternalID:     &g.ExternalID,
+		ReplaceMembers: true,
+		Members:        g.MemberIDs(),
+	})
+}
+
+// PatchGroup aplica as operações PATCH ao grupo, que em geral incluem ou
+// retiram membros
+func (h *SCIMHandler) PatchGroup(c echo.Context) error {
+	rec, serr := h.loadGroup(c)
+	if serr != nil {
+		return scim.WriteError(c, serr)
+	}
+
+	var req scim.PatchRequest
+	if serr := scim.Decode(c, &req); serr != nil {
+		return scim.WriteError(c, serr)
+	}
+
+	patch, serr := scim.ParseGroupPatch(req.Operations)
+	if serr != nil {
+		return scim.WriteError(c, serr)
+	}
+
+	return h.saveGroup(c, rec, patch)
+}
+
+// DeleteGroup remove o grupo; seus membros perdem os papéis mapeados dele
+func (h *SCIMHandler) DeleteGroup(c echo.Context) error {
+	tk, _ := scim.FromContext(c)
+	ctx := c.Request().Context()
+
+	rec, serr := h.loadGroup(c)
+	if serr != nil {
+		return scim.WriteError(c, serr)
+	}
+
+	members, err := h.repo.ListMembers(ctx, rec.ID)
+	if err != nil {
+		return scimInternalError(c, err)
+	}
+
+	if err := h.repo.DeleteGroup(ctx, tk.TenantID, tk.IdPID, rec.ID); err != nil {
+		if err == sql.ErrNoRows {
+			return scim.WriteError(c, scimNotFound("Group", rec.ID))
+		}
+		return scimInternalError(c, err)
+	}
+
+	if err := h.provisioning.SyncRoles(ctx, tk.TenantID, tk.IdPID, memberUserIDs(members)...); err != nil {
+		return scimInternalError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// saveGroup grava as mudanças de um PUT ou PATCH e remapeia os papéis dos
+// usuários afetados: os membros incluídos e retirados, ou todos quando o
+// grupo muda de nome
+func (h *SCIMHandler) saveGroup(c echo.Context, rec *repo.SCIMGroupRecord, patch *scim.GroupPatch) error {
+	tk, _ := scim.FromContext(c)
+	ctx := c.Request().Context()
+
+	renamed := false
+	if patch.DisplayName != nil || patch.ExternalID != nil {
+		g := scim.Group{DisplayName: rec.DisplayName, ExternalID: rec.ExternalID}
+		if patch.DisplayName != nil {
+			g.DisplayName = *patch.DisplayName
+		}
+		if patch.ExternalID != nil {
+			g.ExternalID = *patch.ExternalID
+		}
+
+		if serr := g.Check(); serr != nil {
+			return scim.WriteError(c, serr)
+		}
+
+		if g.DisplayName != rec.DisplayName {
+			if serr := h.checkDisplayName(ctx, tk, rec.ID, g.DisplayName); serr != nil {
+				return scim.WriteError(c, serr)
+			}
+			renamed = true
+		}
+
+		rec.DisplayName, rec.ExternalID = g.DisplayName, g.ExternalID
+		if err := h.repo.UpdateGroup(ctx, rec); err != nil {
+			return scimInternalError(c, err)
+		}
+	}
+
+	current, err := h.repo.ListMembers(ctx, rec.ID)
+	if err != nil {
+		return scimInternalError(c, err)
+	}
+	currentIDs := memberUserIDs(current)
+
+	add, serr := h.memberIDs(ctx, tk, patch.Add)
+	if serr != nil {
+		return scim.WriteError(c, serr)
+	}
+
+	// só os membros atuais são retirados, os demais não estão no grupo
+	var remove []int64
+	for _, v := range patch.Remove {
+		if id, err := strconv.ParseInt(v, 10, 64); err == nil && slices.Contains(currentIDs, id) {
+			remove = append(remove, id)
+		}
+	}
+
+	if patch.ReplaceMembers {
+		members, serr := h.memberIDs(ctx, tk, patch.Members)
+		if serr != nil {
+			return scim.WriteError(c, serr)
+		}
+
+		for _, id := range members {
+			if !slices.Contains(currentIDs, id) {
+				add = append(add, id)
+			}
+		}
+		for _, id := range currentIDs {
+			if !slices.Contains(members, id) {
+				remove = append(remove, id)
+			}
+		}
+	}
+
+	if err := h.repo.RemoveMembers(ctx, rec.ID, remove); err != nil {
+		return scimInternalError(c, err)
+	}
+
+	if err := h.repo.AddMembers(ctx, rec.ID, add); err != nil {
+		return scimInternalError(c, err)
+	}
+
+	affected := append(add, remove...)
+	if renamed {
+		affected = append(affected, currentIDs...)
+	}
+
+	slices.Sort(affected)
+	if err := h.provisioning.SyncRoles(ctx, tk.TenantID, tk.IdPID, slices.Compact(affected)...); err != nil {
+		return scimInternalError(c, err)
+	}
+
+	updated, err := h.repo.GetGroup(ctx, tk.TenantID, tk.IdPID, rec.ID)
+	if err != nil || updated == nil {
+		return scimInternalError(c, fmt.Errorf("load updated group: %v", err))
+	}
+
+	return h.writeGroup(c, http.StatusOK, updated)
+}
+
+// loadGroup carrega o grupo do parâmetro :id
+func (h *SCIMHandler) loadGroup(c echo.Context) (*repo.SCIMGroupRecord, *scim.Error) {
+	tk, _ := scim.FromContext(c)
+
+	param := stripslash.ParamWithoutAnySlashes(c, "id")
+	id, err := strconv.ParseInt(param, 10, 64)
+	if err != nil {
+		return nil, scim.NewError(http.StatusNotFound, "", "Group %s not found", param)
+	}
+
+	rec, err := h.repo.GetGroup(c.Request().Context(), tk.TenantID, tk.IdPID, id)
+	if err != nil {
+		return nil, scim.NewError(http.StatusInternalServerError, "", "%s", err.Error())
+	}
+
+	if rec == nil {
+		return nil, scimNotFound("Group", id)
+	}
+
+	return rec, nil
+}
+
+// checkDisplayName recusa um nome que já é de outro grupo do IdP
+func (h *SCIMHandler) checkDisplayName(ctx context.Context, tk *repo.SCIMTokenRecord, id int64, name string) *scim.Error {
+	recs, _, err := h.repo.ListGroups(ctx, tk.TenantID, tk.IdPID, repo.SCIMGroupFilter{DisplayName: name}, 0, 1)
+	if err != nil {
+		return scim.NewError(http.StatusInternalServerError, "", "%s", err.Error())
+	}
+
+	if len(recs) > 0 && recs[0].ID != id {
+		return scim.NewError(http.StatusConflict, scim.ErrUniqueness, "displayName %q is already taken", name)
+	}
+
+	return nil
+}
+
+// memberIDs converte os membros enviados em IDs de usuários do IdP
+func (h *SCIMHandler) memberIDs(ctx context.Context, tk *repo.SCIMTokenRecord, values []string) ([]int64, *scim.Error) {
+	var ids []int64
+	for _, v := range values {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "member %q not found", v)
+		}
+
+		if slices.Contains(ids, id) {
+			continue
+		}
+
+		user, err := h.repo.GetUser(ctx, tk.TenantID, tk.IdPID, id)
+		if err != nil {
+			return nil, scim.NewError(http.StatusInternalServerError, "", "%s", err.Error())
+		}
+
+		if user == nil {
+			return nil, scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "member %q not found", v)
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// writeGroup responde com o grupo
+func (h *SCIMHandler) writeGroup(c echo.Context, status int, rec *repo.SCIMGroupRecord) error {
+	g, err := h.newGroup(c, rec)
+	if err != nil {
+		return scimInternalError(c, err)
+	}
+
+	return scim.Write(c, status, g)
+}
+
+// newGroup monta o recurso do grupo; os membros são omitidos com
+// excludedAttributes=members, que o Azure AD usa para não ler grupos grandes
+func (h *SCIMHandler) newGroup(c echo.Context, rec *repo.SCIMGroupRecord) (*scim.Group, error) {
+	var members []*repo.SCIMMemberRecord
+	if !excludesMembers(c.QueryParam("excludedAttributes")) {
+		var err error
+		if members, err = h.repo.ListMembers(c.Request().Context(), rec.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return scim.NewGroup(rec, h.location(c, "Groups", rec.ID), members), nil
+}
+
+func excludesMembers(excluded string) bool {
+	for _, attr := range strings.Split(excluded, ",") {
+		if strings.EqualFold(strings.TrimSpace(attr), "members") {
+			return true
+		}
+	}
+	return false
+}
+
+// location é a URL do recurso
+func (h *SCIMHandler) location(c echo.Context, resource string, id int64) string {
+	return fmt.Sprintf("%s/scim/v2/%s/%s/%d",
+		strings.TrimSuffix(h.cfg.BaseURL, "/"), stripslash.ParamWithoutAnySlashes(c, "tenantID"), resource, id)
+}
+
+// userFilter traduz o filtro SCIM para o repo; ok é false quando nenhum
+// usuário pode atender ao filtro
+func userFilter(s string) (f repo.SCIMUserFilter, ok bool, serr *scim.Error) {
+	if s == "" {
+		return f, true, nil
+	}
+
+	conds, serr := scim.ParseFilter(s)
+	if serr != nil {
+		return f, false, serr
+	}
+
+	for _, cond := range conds {
+		switch cond.Attr {
+		case "id":
+			id, err := strconv.ParseInt(cond.Value, 10, 64)
+			if err != nil {
+				return f, false, nil
+			}
+			f.ID = id
+		case "username":
+			f.UserName = cond.Value
+		case "externalid":
+			f.ExternalID = cond.Value
+		case "emails", "emails.value":
+			f.Email = cond.Value
+		case "active":
+			active := cond.Value == "true"
+			f.Active = &active
+		default:
+			return f, false, scim.NewError(http.StatusBadRequest, scim.ErrInvalidFilter, "filtering by %s is not supported", cond.Attr)
+		}
+	}
+
+	return f, true, nil
+}
+
+// groupFilter traduz o filtro SCIM para o repo; ok é false quando nenhum
+// grupo pode atender ao filtro
+func groupFilter(s string) (f repo.SCIMGroupFilter, ok bool, serr *scim.Error) {
+	if s == "" {
+		return f, true, nil
+	}
+
+	conds, serr := scim.ParseFilter(s)
+	if serr != nil {
+		return f, false, serr
+	}
+
+	for _, cond := range conds {
+		switch cond.Attr {
+		case "id", "members", "members.value":
+			id, err := strconv.ParseInt(cond.Value, 10, 64)
+			if err != nil {
+				return f, false, nil
+			}
+			if cond.Attr == "id" {
+				f.ID = id
+			} else {
+				f.MemberID = id
+			}
+		case "displayname":
+			f.DisplayName = cond.Value
+		case "externalid":
+			f.ExternalID = cond.Value
+		default:
+			return f, false, scim.NewError(http.StatusBadRequest, scim.ErrInvalidFilter, "filtering by %s is not supported", cond.Attr)
+		}
+	}
+
+	return f, true, nil
+}
+
+func memberUserIDs(members []*repo.SCIMMemberRecord) []int64 {
+	ids := make([]int64, 0, len(members))
+	for _, m := range members {
+		ids = append(ids, m.UserID)
+	}
+	return ids
+}
+
+func scimNotFound(resource string, id int64) *scim.Error {
+	return scim.NewError(http.StatusNotFound, "", "%s %d not found", resource, id)
+}
+
+func scimInternalError(c echo.Context, err error) error {
+	return scim.WriteError(c, scim.NewError(http.StatusInternalServerError, "", "%s", err.Error()))
+}
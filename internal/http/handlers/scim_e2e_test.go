@@ -0,0 +1,575 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+	h "github.com/jeanmolossi/verbose-adventure/internal/http/handlers"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+	"github.com/jeanmolossi/verbose-adventure/internal/scim"
+)
+
+// memSCIMTokens is an in-memory repo.SCIMTokenRepository
+type memSCIMTokens struct {
+	mu     sync.Mutex
+	tokens []*repo.SCIMTokenRecord
+}
+
+func (m *memSCIMTokens) ListByTenant(ctx context.Context, tenantID int64) ([]*repo.SCIMTokenRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []*repo.SCIMTokenRecord
+	for _, t := range m.tokens {
+		if t.TenantID == tenantID {
+			cp := *t
+			out = append(out, &cp)
+		}
+	}
+	return out, nil
+}
+
+func (m *memSCIMTokens) GetByID(ctx context.Context, tenantID, id int64) (*repo.SCIMTokenRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.tokens {
+		if t.TenantID == tenantID && t.ID == id {
+			cp := *t
+			return &cp, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memSCIMTokens) GetByHash(ctx context.Context, hash []byte) (*repo.SCIMTokenRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.tokens {
+		if bytes.Equal(t.TokenHash, hash) {
+			cp := *t
+			return &cp, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memSCIMTokens) Create(ctx context.Context, rec *repo.SCIMTokenRecord) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cp := *rec
+	cp.ID = int64(len(m.tokens) + 1)
+	cp.CreatedAt = time.Now()
+	m.tokens = append(m.tokens, &cp)
+	return cp.ID, nil
+}
+
+func (m *memSCIMTokens) Revoke(ctx context.Context, tenantID, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.tokens {
+		if t.TenantID == tenantID && t.ID == id {
+			if t.RevokedAt == nil {
+				now := time.Now()
+				t.RevokedAt = &now
+			}
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *memSCIMTokens) TouchLastUsed(ctx context.Context, id int64, at time.Time) error {
+	return nil
+}
+
+// memSCIM is an in-memory repo.SCIMRepository sharing the users of memUsers
+type memSCIM struct {
+	users *memUsers
+
+	mu      sync.Mutex
+	groups  []*repo.SCIMGroupRecord
+	members map[int64][]int64
+}
+
+func newMemSCIM(users *memUsers) *memSCIM {
+	return &memSCIM{users: users, members: map[int64][]int64{}}
+}
+
+func page[T any](items []T, offset, limit int) []T {
+	if offset >= len(items) {
+		return nil
+	}
+	return items[offset:min(offset+limit, len(items))]
+}
+
+func (m *memSCIM) ListUsers(ctx context.Context, tenantID, idpID int64, f repo.SCIMUserFilter, offset, limit int) ([]*repo.UserRecord, int, error) {
+	m.users.mu.Lock()
+	defer m.users.mu.Unlock()
+	var out []*repo.UserRecord
+	for _, u := range m.users.users {
+		if u.TenantID != tenantID || u.IdPID != idpID ||
+			(f.ID != 0 && u.ID != f.ID) ||
+			(f.UserName != "" && !strings.EqualFold(u.UserName, f.UserName)) ||
+			(f.ExternalID != "" && u.ExternalID != f.ExternalID) ||
+			(f.Email != "" && !strings.EqualFold(u.Email, f.Email)) ||
+			(f.Active != nil && u.Active != *f.Active) {
+			continue
+		}
+		cp := *u
+		out = append(out, &cp)
+	}
+	return page(out, offset, limit), len(out), nil
+}
+
+func (m *memSCIM) GetUser(ctx context.Context, tenantID, idpID, id int64) (*repo.UserRecord, error) {
+	recs, _, _ := m.ListUsers(ctx, tenantID, idpID, repo.SCIMUserFilter{ID: id}, 0, 1)
+	if len(recs) == 0 {
+		return nil, nil
+	}
+	return recs[0], nil
+}
+
+func (m *memSCIM) UpsertUser(ctx context.Context, rec *repo.UserRecord) (int64, error) {
+	m.users.mu.Lock()
+	defer m.users.mu.Unlock()
+	for _, u := range m.users.users {
+		if u.TenantID == rec.TenantID && u.IdPID == rec.IdPID && u.Subject == rec.Subject {
+			u.UserName, u.ExternalID, u.Email, u.Name, u.Active = rec.UserName, rec.ExternalID, rec.Email, rec.Name, rec.Active
+			return u.ID, nil
+		}
+	}
+	cp := *rec
+	cp.ID = int64(len(m.users.users) + 1)
+	cp.CreatedAt = time.Now()
+	m.users.users = append(m.users.users, &cp)
+	return cp.ID, nil
+}
+
+func (m *memSCIM) UpdateUser(ctx context.Context, rec *repo.UserRecord) error {
+	m.users.mu.Lock()
+	defer m.users.mu.Unlock()
+	for _, u := range m.users.users {
+		if u.TenantID == rec.TenantID && u.IdPID == rec.IdPID && u.ID == rec.ID {
+			u.UserName, u.ExternalID, u.Email, u.Name, u.Active = rec.UserName, rec.ExternalID, rec.Email, rec.Name, rec.Active
+		}
+	}
+	return nil
+}
+
+func (m *memSCIM) DeleteUser(ctx context.Context, tenantID, idpID, id int64) error {
+	m.users.mu.Lock()
+	defer m.users.mu.Unlock()
+	n := len(m.users.users)
+	m.users.users = slices.DeleteFunc(m.users.users, func(u *repo.UserRecord) bool {
+		return u.TenantID == tenantID && u.IdPID == idpID && u.ID == id
+	})
+	if len(m.users.users) == n {
+		return sql.ErrNoRows
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for g, ids := range m.members {
+		m.members[g] = slices.DeleteFunc(ids, func(u int64) bool { return u == id })
+	}
+	return nil
+}
+
+func (m *memSCIM) ListGroups(ctx context.Context, tenantID, idpID int64, f repo.SCIMGroupFilter, offset, limit int) ([]*repo.SCIMGroupRecord, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []*repo.SCIMGroupRecord
+	for _, g := range m.groups {
+		if g.TenantID != tenantID || g.IdPID != idpID ||
+			(f.ID != 0 && g.ID != f.ID) ||
+			(f.DisplayName != "" && !strings.EqualFold(g.DisplayName, f.DisplayName)) ||
+			(f.ExternalID != "" && g.ExternalID != f.ExternalID) ||
+			(f.MemberID != 0 && !slices.Contains(m.members[g.ID], f.MemberID)) {
+			continue
+		}
+		cp := *g
+		out = append(out, &cp)
+	}
+	return page(out, offset, limit), len(out), nil
+}
+
+func (m *memSCIM) GetGroup(ctx context.Context, tenantID, idpID, id int64) (*repo.SCIMGroupRecord, error) {
+	recs, _, _ := m.ListGroups(ctx, tenantID, idpID, repo.SCIMGroupFilter{ID: id}, 0, 1)
+	if len(recs) == 0 {
+		return nil, nil
+	}
+	return recs[0], nil
+}
+
+func (m *memSCIM) CreateGroup(ctx context.Context, rec *repo.SCIMGroupRecord) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cp := *rec
+	cp.ID = int64(len(m.groups) + 1)
+	cp.CreatedAt = time.Now()
+	m.groups = append(m.groups, &cp)
+	return cp.ID, nil
+}
+
+func (m *memSCIM) UpdateGroup(ctx context.Context, rec *repo.SCIMGroupRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, g := range m.groups {
+		if g.ID == rec.ID && g.TenantID == rec.TenantID {
+			g.DisplayName, g.ExternalID = rec.DisplayName, rec.ExternalID
+		}
+	}
+	return nil
+}
+
+func (m *memSCIM) DeleteGroup(ctx context.Context, tenantID, idpID, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := len(m.groups)
+	m.groups = slices.DeleteFunc(m.groups, func(g *repo.SCIMGroupRecord) bool {
+		return g.TenantID == tenantID && g.IdPID == idpID && g.ID == id
+	})
+	if len(m.groups) == n {
+		return sql.ErrNoRows
+	}
+	delete(m.members, id)
+	return nil
+}
+
+func (m *memSCIM) ListMembers(ctx context.Context, groupID int64) ([]*repo.SCIMMemberRecord, error) {
+	m.mu.Lock()
+	ids := slices.Clone(m.members[groupID])
+	m.mu.Unlock()
+
+	m.users.mu.Lock()
+	defer m.users.mu.Unlock()
+	var out []*repo.SCIMMemberRecord
+	for _, u := range m.users.users {
+		if slices.Contains(ids, u.ID) {
+			out = append(out, &repo.SCIMMemberRecord{UserID: u.ID, UserName: u.UserName})
+		}
+	}
+	return out, nil
+}
+
+func (m *memSCIM) AddMembers(ctx context.Context, groupID int64, userIDs []int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range userIDs {
+		if !slices.Contains(m.members[groupID], id) {
+			m.members[groupID] = append(m.members[groupID], id)
+		}
+	}
+	return nil
+}
+
+func (m *memSCIM) RemoveMembers(ctx context.Context, groupID int64, userIDs []int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.members[groupID] = slices.DeleteFunc(m.members[groupID], func(id int64) bool { return slices.Contains(userIDs, id) })
+	return nil
+}
+
+func (m *memSCIM) ListUserGroups(ctx context.Context, userID int64) ([]*repo.SCIMGroupRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []*repo.SCIMGroupRecord
+	for _, g := range m.groups {
+		if slices.Contains(m.members[g.ID], userID) {
+			cp := *g
+			out = append(out, &cp)
+		}
+	}
+	return out, nil
+}
+
+// setupSCIM serves tenant 2 (globex) and returns a SCIM token of its
+// identity provider 5, which maps "crm-admins" to the admin role
+func setupSCIM(t *testing.T) (*testServer, string) {
+	t.Helper()
+
+	srv := newTestServer(t, &config.Config{BaseURL: "https://crm.example.com"})
+	srv.idps.getRec = &repo.IdentityProviderRecord{
+		ID: 5, TenantID: 2, RoleMapping: map[string]string{"crm-admins": auth.RoleAdmin},
+	}
+
+	token, err := srv.scimAuth.Issue(context.Background(), &repo.SCIMTokenRecord{TenantID: 2, IdPID: 5, Name: "okta"})
+	require.NoError(t, err)
+
+	return srv, token
+}
+
+// callSCIM calls a /scim/v2 route, which takes application/scim+json
+func (s *testServer) callSCIM(method, path, token, body string) *httptest.ResponseRecorder {
+	req := s.request(method, path, token, body)
+	req.Header.Set(echo.HeaderContentType, scim.MediaType)
+	return s.serve(req)
+}
+
+func createSCIMUser(t *testing.T, srv *testServer, token, userName, externalID string) *scim.User {
+	t.Helper()
+
+	body := fmt.Sprintf(`{"schemas":[%q],"userName":%q,"externalId":%q,"name":{"givenName":"Jane","familyName":"Doe"},"emails":[{"value":%q,"type":"work","primary":true}]}`,
+		scim.UserSchema, userName, externalID, userName)
+	rec := srv.callSCIM(http.MethodPost, "/scim/v2/globex/Users", token, body)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	var u scim.User
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &u))
+	return &u
+}
+
+func TestSCIM_Users(t *testing.T) {
+	srv, token := setupSCIM(t)
+
+	jane := createSCIMUser(t, srv, token, "jane@globex.com", "00u1")
+	require.Equal(t, "Jane Doe", jane.DisplayName)
+	require.True(t, *jane.Active)
+	require.Equal(t, "https://crm.example.com/scim/v2/globex/Users/"+jane.ID, jane.Meta.Location)
+
+	// userName is unique, whatever its case
+	rec := srv.callSCIM(http.MethodPost, "/scim/v2/globex/Users", token, `{"userName":"JANE@globex.com"}`)
+	require.Equal(t, http.StatusConflict, rec.Code)
+	require.Equal(t, scim.MediaType, rec.Header().Get(echo.HeaderContentType))
+	require.Contains(t, rec.Body.String(), `"scimType":"uniqueness"`)
+
+	require.Equal(t, http.StatusBadRequest, srv.callSCIM(http.MethodPost, "/scim/v2/globex/Users", token, `{"userName":" "}`).Code)
+
+	for i := range 3 {
+		createSCIMUser(t, srv, token, fmt.Sprintf("user%d@globex.com", i), "")
+	}
+
+	// filtering, as Okta and Azure AD look users up before creating them
+	rec = srv.callSCIM(http.MethodGet, `/scim/v2/globex/Users?filter=userName%20eq%20%22jane@globex.com%22`, token, ``)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var list struct {
+		TotalResults int
+		StartIndex   int
+		ItemsPerPage int
+		Resources    []scim.User
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Equal(t, 1, list.TotalResults)
+	require.Equal(t, jane.ID, list.Resources[0].ID)
+
+	rec = srv.callSCIM(http.MethodGet, `/scim/v2/globex/Users?filter=title%20co%20%22x%22`, token, ``)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, rec.Body.String(), `"scimType":"invalidFilter"`)
+
+	// pagination
+	rec = srv.callSCIM(http.MethodGet, `/scim/v2/globex/Users?startIndex=2&count=2`, token, ``)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Equal(t, 4, list.TotalResults)
+	require.Equal(t, 2, list.StartIndex)
+	require.Equal(t, 2, list.ItemsPerPage)
+	require.Equal(t, "user0@globex.com", list.Resources[0].UserName)
+
+	// PUT replaces the profile
+	rec = srv.callSCIM(http.MethodPut, "/scim/v2/globex/Users/"+jane.ID, token,
+		`{"userName":"jane@globex.com","displayName":"Jane Roe","emails":[{"value":"jane.roe@globex.com"}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var updated scim.User
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &updated))
+	require.Equal(t, "Jane Roe", updated.DisplayName)
+	require.Equal(t, "jane.roe@globex.com", updated.PrimaryEmail())
+
+	// PATCH by path and without path, the way Azure AD sends it
+	rec = srv.callSCIM(http.MethodPatch, "/scim/v2/globex/Users/"+jane.ID, token, `{"schemas":["`+scim.PatchOpSchema+`"],"Operations":[
+		{"op":"Replace","path":"emails[type eq \"work\"].value","value":"jroe@globex.com"},
+		{"op":"Replace","value":{"displayName":"J. Roe","urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department":"Sales"}}
+	]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &updated))
+	require.Equal(t, "J. Roe", updated.DisplayName)
+	require.Equal(t, "jroe@globex.com", updated.PrimaryEmail())
+
+	require.Equal(t, http.StatusBadRequest, srv.callSCIM(http.MethodPatch, "/scim/v2/globex/Users/"+jane.ID, token, `{"Operations":[{"op":"move","path":"active"}]}`).Code)
+
+	rec = srv.callSCIM(http.MethodDelete, "/scim/v2/globex/Users/"+jane.ID, token, ``)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, http.StatusNotFound, srv.callSCIM(http.MethodGet, "/scim/v2/globex/Users/"+jane.ID, token, ``).Code)
+}
+
+func TestSCIM_DeprovisioningRevokesSessions(t *testing.T) {
+	srv, token := setupSCIM(t)
+	ctx := context.Background()
+
+	// jane logged in before her IdP started pushing users
+	pair, err := srv.sessions.Start(ctx, &auth.AuthResult{TenantID: 2, IdPID: 5, UserID: "00u1", Email: "jane@globex.com"})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, srv.call(http.MethodGet, "/api/v1/me", pair.AccessToken, ``).Code)
+
+	// the SCIM user claims her account through the external id
+	jane := createSCIMUser(t, srv, token, "jane@globex.com", "00u1")
+
+	claims, err := auth.NewTokenValidator(srv.cfg, srv.keys).Validate(pair.AccessToken)
+	require.NoError(t, err)
+	require.Equal(t, jane.ID, fmt.Sprint(claims.UserID))
+
+	rec := srv.callSCIM(http.MethodPatch, "/scim/v2/globex/Users/"+jane.ID, token,
+		`{"Operations":[{"op":"replace","value":{"active":"False"}}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var u scim.User
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &u))
+	require.False(t, *u.Active)
+
+	// the access token is refused right away, and so is the refresh token
+	require.Equal(t, http.StatusUnauthorized, srv.call(http.MethodGet, "/api/v1/me", pair.AccessToken, ``).Code)
+	_, err = srv.sessions.Refresh(ctx, pair.RefreshToken)
+	require.ErrorIs(t, err, auth.ErrSessionRevoked)
+
+	// a deactivated user can not log in again
+	_, err = srv.sessions.Start(ctx, &auth.AuthResult{TenantID: 2, IdPID: 5, UserID: "00u1", Email: "jane@globex.com"})
+	require.ErrorIs(t, err, auth.ErrUserInactive)
+
+	rec = srv.callSCIM(http.MethodGet, `/scim/v2/globex/Users?filter=active%20eq%20false`, token, ``)
+	require.Contains(t, rec.Body.String(), `"totalResults":1`)
+}
+
+func TestSCIM_GroupsMapRoles(t *testing.T) {
+	srv, token := setupSCIM(t)
+	ctx := context.Background()
+
+	jane := createSCIMUser(t, srv, token, "jane@globex.com", "")
+	john := createSCIMUser(t, srv, token, "john@globex.com", "")
+	janeID, johnID := int64(1), int64(2)
+
+	rec := srv.callSCIM(http.MethodPost, "/scim/v2/globex/Groups", token,
+		`{"displayName":"crm-admins","members":[{"value":"`+jane.ID+`"}]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	var group scim.Group
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &group))
+	require.Len(t, group.Members, 1)
+
+	roles, _ := srv.roles.ListRoles(ctx, 2, janeID)
+	require.Equal(t, []string{auth.RoleAdmin}, roles)
+
+	require.Equal(t, http.StatusConflict, srv.callSCIM(http.MethodPost, "/scim/v2/globex/Groups", token, `{"displayName":"CRM-admins"}`).Code)
+	require.Equal(t, http.StatusBadRequest, srv.callSCIM(http.MethodPost, "/scim/v2/globex/Groups", token, `{"displayName":"x","members":[{"value":"404"}]}`).Code)
+
+	// membership changes as Azure AD sends them
+	rec = srv.callSCIM(http.MethodPatch, "/scim/v2/globex/Groups/"+group.ID, token, `{"Operations":[
+		{"op":"Add","path":"members","value":[{"value":"`+john.ID+`"}]},
+		{"op":"Remove","path":"members[value eq \"`+jane.ID+`\"]"}
+	]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	roles, _ = srv.roles.ListRoles(ctx, 2, janeID)
+	require.Empty(t, roles)
+	roles, _ = srv.roles.ListRoles(ctx, 2, johnID)
+	require.Equal(t, []string{auth.RoleAdmin}, roles)
+
+	rec = srv.callSCIM(http.MethodGet, `/scim/v2/globex/Groups?filter=displayName%20eq%20%22crm-admins%22&excludedAttributes=members`, token, ``)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `"totalResults":1`)
+	require.NotContains(t, rec.Body.String(), `"members"`)
+
+	rec = srv.callSCIM(http.MethodGet, "/scim/v2/globex/Users/"+john.ID, token, ``)
+	require.Contains(t, rec.Body.String(), `"display":"crm-admins"`)
+
+	// renaming the group to an unmapped name drops the role
+	rec = srv.callSCIM(http.MethodPatch, "/scim/v2/globex/Groups/"+group.ID, token,
+		`{"Operations":[{"op":"replace","path":"displayName","value":"crm-users"}]}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	roles, _ = srv.roles.ListRoles(ctx, 2, johnID)
+	require.Empty(t, roles)
+
+	// Okta replaces the whole group
+	rec = srv.callSCIM(http.MethodPut, "/scim/v2/globex/Groups/"+group.ID, token,
+		`{"displayName":"crm-admins","members":[{"value":"`+jane.ID+`"}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	roles, _ = srv.roles.ListRoles(ctx, 2, janeID)
+	require.Equal(t, []string{auth.RoleAdmin}, roles)
+	roles, _ = srv.roles.ListRoles(ctx, 2, johnID)
+	require.Empty(t, roles)
+
+	require.Equal(t, http.StatusNoContent, srv.callSCIM(http.MethodDelete, "/scim/v2/globex/Groups/"+group.ID, token, ``).Code)
+}
+
+func TestSCIM_Tokens(t *testing.T) {
+	srv, token := setupSCIM(t)
+
+	// the token is bound to its tenant
+	require.Equal(t, http.StatusOK, srv.callSCIM(http.MethodGet, "/scim/v2/globex/ServiceProviderConfig", token, ``).Code)
+	rec := srv.callSCIM(http.MethodGet, "/scim/v2/acme/Users", token, ``)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.Contains(t, rec.Body.String(), scim.ErrorSchema)
+
+	require.Equal(t, http.StatusUnauthorized, srv.callSCIM(http.MethodGet, "/scim/v2/globex/Users", "scim_unknown", ``).Code)
+
+	// CRM tokens do not work on the SCIM endpoints, nor SCIM tokens on the API
+	owner := signToken(t, srv.cfg, srv.keys, 2, false, auth.RoleOwner)
+	require.Equal(t, http.StatusUnauthorized, srv.callSCIM(http.MethodGet, "/scim/v2/globex/Users", owner, ``).Code)
+	require.Equal(t, http.StatusUnauthorized, srv.call(http.MethodGet, "/admin/tenants/globex/scim-tokens", token, ``).Code)
+
+	readOnly := signToken(t, srv.cfg, srv.keys, 2, false, auth.RoleReadOnly)
+	require.Equal(t, http.StatusForbidden, srv.call(http.MethodPost, "/admin/tenants/globex/scim-tokens", readOnly, `{"name":"azure","idp_id":5}`).Code)
+
+	require.Equal(t, http.StatusBadRequest, srv.call(http.MethodPost, "/admin/tenants/globex/scim-tokens", owner, `{"name":"azure","idp_id":6}`).Code)
+
+	rec = srv.call(http.MethodPost, "/admin/tenants/globex/scim-tokens", owner, `{"name":"azure","idp_id":5}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	var created h.SCIMTokenResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	require.True(t, strings.HasPrefix(created.Token, auth.SCIMTokenPrefix))
+	require.Equal(t, created.Token[:len(created.Prefix)], created.Prefix)
+
+	require.Equal(t, http.StatusOK, srv.callSCIM(http.MethodGet, "/scim/v2/globex/Users", created.Token, ``).Code)
+
+	rec = srv.call(http.MethodDelete, fmt.Sprintf("/admin/tenants/globex/scim-tokens/%d", created.ID), owner, ``)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, http.StatusUnauthorized, srv.callSCIM(http.MethodGet, "/scim/v2/globex/Users", created.Token, ``).Code)
+
+	rec = srv.call(http.MethodGet, "/admin/tenants/globex/scim-tokens", readOnly, ``)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NotContains(t, rec.Body.String(), `"token"`)
+}
+
+func TestSCIM_AdminCanNotEscalateToOwner(t *testing.T) {
+	srv, token := setupSCIM(t)
+	ctx := context.Background()
+
+	// an admin may mint a SCIM token for the IdP
+	admin := signToken(t, srv.cfg, srv.keys, 2, false, auth.RoleAdmin)
+	rec := srv.call(http.MethodPost, "/admin/tenants/globex/scim-tokens", admin, `{"name":"rogue","idp_id":5}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	var minted h.SCIMTokenResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &minted))
+
+	// but not map a group it controls to owner
+	rec = srv.call(http.MethodPatch, "/admin/tenants/globex/idps/5", admin,
+		`{"role_mapping":{"crm-admins":"admin","crm-owners":"owner"}}`)
+	require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+	require.Equal(t, map[string]string{"crm-admins": auth.RoleAdmin}, srv.idps.getRec.RoleMapping)
+
+	// so pushing itself into that group grants nothing
+	jane := createSCIMUser(t, srv, token, "jane@globex.com", "")
+	rec = srv.callSCIM(http.MethodPost, "/scim/v2/globex/Groups", minted.Token,
+		`{"displayName":"crm-owners","members":[{"value":"`+jane.ID+`"}]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	roles, _ := srv.roles.ListRoles(ctx, 2, 1)
+	require.Empty(t, roles)
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+	"github.com/labstack/echo/v4"
+)
+
+// SCIMTokenHandler gerencia os tokens dos clientes SCIM de um tenant
+type SCIMTokenHandler struct {
+	repo   repo.SCIMTokenRepository
+	tokens *auth.SCIMTokens
+}
+
+// NewSCIMTokenHandler cria um novo handler, injetando o repo e o emissor de tokens
+func NewSCIMTokenHandler(r repo.SCIMTokenRepository, tokens *auth.SCIMTokens) *SCIMTokenHandler {
+	return &SCIMTokenHandler{repo: r, tokens: tokens}
+}
+
+// scimTokenRequest representa o payload de criação de token
+type scimTokenRequest struct {
+	Name  string `json:"name" validate:"notblank"`
+	IdPID int64  `json:"idp_id" validate:"gt=0"`
+}
+
+// SCIMTokenResponse representa um token SCIM, sem o segredo
+type SCIMTokenResponse struct {
+	ID         int64      `json:"id"`
+	TenantID   int64      `json:"tenant_id"`
+	IdPID      int64      `json:"idp_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	CreatedBy  *int64     `json:"created_by,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+
+	// Token só é exibido na criação
+	Token string `json:"token,omitempty"`
+}
+
+// Register associa as rotas de administração de tokens SCIM
+func (h *SCIMTokenHandler) Register(e *echo.Echo) {
+	g := e.Group("/admin/tenants/:tenantID/scim-tokens")
+	g.GET("", h.List)
+	g.POST("", h.Create)
+	g.GET("/:id", h.Get)
+	g.DELETE("/:id", h.Revoke)
+}
+
+// List retorna os tokens do tenant
+func (h *SCIMTokenHandler) List(c echo.Context) error {
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
+	recs, err := h.repo.ListByTenant(c.Request().Context(), tenant)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	out := []SCIMTokenResponse{}
+	for _, r := range recs {
+		out = append(out, newSCIMTokenResponse(r))
+	}
+
+	return c.JSON(http.StatusOK, out)
+}
+
+// Get retorna um token do tenant
+func (h *SCIMTokenHandler) Get(c echo.Context) error {
+	id, err := parseID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+	}
+
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
+	rec, err := h.repo.GetByID(c.Request().Context(), tenant, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if rec == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "scim token not found")
+	}
+
+	return c.JSON(http.StatusOK, newSCIMTokenResponse(rec))
+}
+
+// Create emite um token para provisionar os usuários de um IdP do tenant.
+// O segredo é devolvido uma única vez; apenas o hash é armazenado.
+func (h *SCIMTokenHandler) Create(c echo.Context) error {
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
+	var req scimTokenRequest
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+
+	rec := &repo.SCIMTokenRecord{
+		TenantID: tenant,
+		IdPID:    req.IdPID,
+		Name:     req.Name,
+	}
+
+	if claims, ok := auth.FromContext(c); ok && claims.UserID > 0 {
+		rec.CreatedBy = &claims.UserID
+	}
+
+	token, err := h.tokens.Issue(c.Request().Context(), rec)
+	if err != nil {
+		if errors.Is(err, auth.ErrSCIMUnknownIdP) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	resp := newSCIMTokenResponse(rec)
+	resp.Token = token
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusCreated, resp)
+}
+
+// Revoke revoga um token do tenant; o token continua listado
+func (h *SCIMTokenHandler) Revoke(c echo.Context) error {
+	id, err := parseID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+	}
+
+	tenant, err := parseTenant(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid tenant id")
+	}
+
+	if err := h.repo.Revoke(c.Request().Context(), tenant, id); err != nil {
+		if err == sql.ErrNoRows {
+			return c.NoContent(http.StatusNotFound)
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// newSCIMTokenResponse monta a resposta a partir do registro
+func newSCIMTokenResponse(rec *repo.SCIMTokenRecord) SCIMTokenResponse {
+	return SCIMTokenResponse{
+		ID:         rec.ID,
+		TenantID:   rec.TenantID,
+		IdPID:      rec.IdPID,
+		Name:       rec.Name,
+		Prefix:     rec.Prefix,
+		CreatedBy:  rec.CreatedBy,
+		LastUsedAt: rec.LastUsedAt,
+		RevokedAt:  rec.RevokedAt,
+		CreatedAt:  rec.CreatedAt,
+	}
+}
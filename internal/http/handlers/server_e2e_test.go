@@ -0,0 +1,139 @@
+package handlers_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/app"
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+	h "github.com/jeanmolossi/verbose-adventure/internal/http/handlers"
+	"github.com/jeanmolossi/verbose-adventure/internal/http/middleware"
+	"github.com/jeanmolossi/verbose-adventure/internal/http/validation"
+	"github.com/jeanmolossi/verbose-adventure/internal/tenant"
+)
+
+// testUserAgent is sent by every request of the test server
+const testUserAgent = "crm-e2e"
+
+// testServer serves the routes of app.MountRoutes, scopes and middlewares
+// included, over in-memory repositories
+type testServer struct {
+	*echo.Echo
+	cfg  *config.Config
+	keys *auth.Keyring
+
+	registry       *staticRegistry
+	idps           *fakeRepo
+	users          *memUsers
+	roles          *memRoles
+	domains        *memDomains
+	apiKeys        *memAPIKeys
+	clients        *memOAuth
+	impersonations *memImpersonations
+	scim           *memSCIM
+	scimTokens     *memSCIMTokens
+
+	sessions *auth.Sessions
+	oauth    *auth.AuthorizationServer
+	scimAuth *auth.SCIMTokens
+}
+
+// newTestServer wires the app the way app.New does. Tenants are the ones
+// of newMemTenants and the registry serves no provider until the test
+// sets srv.registry.providers.
+func newTestServer(t *testing.T, cfg *config.Config) *testServer {
+	t.Helper()
+
+	if cfg.EncryptionKey == "" {
+		cfg.EncryptionKey = base64.StdEncoding.EncodeToString([]byte("01234567890123456789012345678901"))
+	}
+
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	keys, err := auth.NewKeyringFromSigners(priv)
+	require.NoError(t, err)
+
+	s := &testServer{
+		Echo:           echo.New(),
+		cfg:            cfg,
+		keys:           keys,
+		registry:       &staticRegistry{},
+		idps:           &fakeRepo{},
+		users:          newMemUsers(),
+		roles:          newMemRoles(),
+		domains:        newMemDomains(),
+		apiKeys:        &memAPIKeys{},
+		clients:        newMemOAuth(),
+		impersonations: &memImpersonations{},
+		scimTokens:     &memSCIMTokens{},
+	}
+	s.scim = newMemSCIM(s.users)
+
+	tenants := tenant.NewResolver(cfg, newMemTenants())
+	tokens := auth.NewTokenValidator(cfg, keys)
+	apiKeys := auth.NewAPIKeys(s.apiKeys)
+	s.sessions = auth.NewSessions(cfg, newMemSessions(), s.users, s.roles, keys)
+	impersonations := auth.NewImpersonations(cfg, s.impersonations, s.users, s.roles, s.sessions, keys)
+	s.oauth = auth.NewAuthorizationServer(cfg, s.clients, s.sessions, tokens)
+	s.scimAuth = auth.NewSCIMTokens(s.scimTokens, s.idps)
+
+	s.Validator = validation.New(validation.Params{IdentityProviders: s.idps})
+	s.Use(middleware.ResolveTenant(tenants, "tenantID"))
+
+	app.MountRoutes(app.Routes{
+		Echo: s.Echo,
+		JWT: middleware.NewJWTMiddleware(middleware.JWTConfig{
+			Tokens:         tokens,
+			Revocations:    s.sessions,
+			APIKeys:        apiKeys,
+			Impersonations: impersonations,
+		}),
+		Auth:           h.NewAuthHandler(s.registry, s.sessions, s.oauth, auth.NewMemoryReplayStore(), cfg),
+		IDPs:           h.NewIDPHandler(h.IDPHandlerParams{Repo: s.idps, Cfg: cfg, Secrets: testKeyring(cfg), Providers: s.registry}),
+		Roles:          h.NewRoleHandler(s.roles, s.users),
+		Me:             h.NewMeHandler(s.users, s.roles),
+		Domains:        h.NewDomainHandler(s.domains, auth.NewDomainVerifier(txtRecords{})),
+		HomeRealm:      h.NewHomeRealmHandler(s.domains, s.idps, tenants, cfg),
+		APIKeys:        h.NewAPIKeyHandler(s.apiKeys, apiKeys),
+		OAuth:          h.NewOAuthHandler(s.oauth, tenants, cfg),
+		OAuthClients:   h.NewOAuthClientHandler(s.clients, s.oauth),
+		Impersonations: h.NewImpersonationHandler(s.impersonations, impersonations),
+		SCIM:           h.NewSCIMHandler(s.scim, auth.NewProvisioning(s.scim, s.idps, s.roles, s.sessions), cfg),
+		SCIMTokens:     h.NewSCIMTokenHandler(s.scimTokens, s.scimAuth),
+		SCIMAuth:       s.scimAuth,
+		Health:         unhealthyProviders{},
+		Config:         cfg,
+		Keys:           keys,
+	})
+
+	return s
+}
+
+// request builds a JSON request, authenticated by credential when given
+func (s *testServer) request(method, path, credential, body string) *http.Request {
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("User-Agent", testUserAgent)
+	if credential != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+credential)
+	}
+	return req
+}
+
+func (s *testServer) serve(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func (s *testServer) call(method, path, credential, body string) *httptest.ResponseRecorder {
+	return s.serve(s.request(method, path, credential, body))
+}
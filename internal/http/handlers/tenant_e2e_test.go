@@ -0,0 +1,164 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+	"github.com/jeanmolossi/verbose-adventure/internal/http/middleware"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+	"github.com/jeanmolossi/verbose-adventure/internal/tenant"
+)
+
+// memTenants is an in-memory repo.TenantRepository
+type memTenants struct {
+	tenants []*repo.TenantRecord
+}
+
+func newMemTenants() *memTenants {
+	return &memTenants{tenants: []*repo.TenantRecord{
+		{ID: 1, Slug: "acme", Name: "Acme", CustomHost: "login.acme.io"},
+		{ID: 2, Slug: "globex", Name: "Globex"},
+		{ID: 99, Slug: "initrode", Name: "Initrode"},
+	}}
+}
+
+func (m *memTenants) find(match func(*repo.TenantRecord) bool) (*repo.TenantRecord, error) {
+	for _, t := range m.tenants {
+		if match(t) {
+			cp := *t
+			return &cp, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memTenants) GetByID(ctx context.Context, id int64) (*repo.TenantRecord, error) {
+	return m.find(func(t *repo.TenantRecord) bool { return t.ID == id })
+}
+
+func (m *memTenants) GetBySlug(ctx context.Context, slug string) (*repo.TenantRecord, error) {
+	return m.find(func(t *repo.TenantRecord) bool { return t.Slug == slug })
+}
+
+func (m *memTenants) GetByCustomHost(ctx context.Context, host string) (*repo.TenantRecord, error) {
+	return m.find(func(t *repo.TenantRecord) bool { return t.CustomHost != "" && t.CustomHost == host })
+}
+
+// downTenants is a tenant store that can not be reached
+type downTenants struct{}
+
+func (downTenants) GetByID(context.Context, int64) (*repo.TenantRecord, error) {
+	return nil, errors.New("mysql down")
+}
+
+func (downTenants) GetBySlug(context.Context, string) (*repo.TenantRecord, error) {
+	return nil, errors.New("mysql down")
+}
+
+func (downTenants) GetByCustomHost(context.Context, string) (*repo.TenantRecord, error) {
+	return nil, errors.New("mysql down")
+}
+
+func TestResolveTenant_SkipsTenantlessRoutes(t *testing.T) {
+	cfg := &config.Config{BaseURL: "https://crm.example.com"}
+	e := echo.New()
+	e.Use(middleware.ResolveTenant(tenant.NewResolver(cfg, downTenants{}), "tenantID"))
+
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	e.GET("/healthz", ok)
+	e.GET("/.well-known/jwks.json", ok)
+	e.GET("/:idpType/login", ok)
+
+	call := func(path string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = "10.0.0.7:8081"
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// probes to a pod IP answer while the tenant store is down
+	require.Equal(t, http.StatusOK, call("/healthz"))
+	require.Equal(t, http.StatusOK, call("/.well-known/jwks.json"))
+	require.Equal(t, http.StatusInternalServerError, call("/oidc/login"))
+}
+
+func TestResolveTenant_SlugSubdomainAndCustomHost(t *testing.T) {
+	cfg := &config.Config{BaseURL: "https://crm.example.com:8443"}
+	e := echo.New()
+	e.Use(middleware.ResolveTenant(tenant.NewResolver(cfg, newMemTenants()), "tenantID"))
+
+	handler := func(c echo.Context) error {
+		if t, ok := tenant.FromContext(c); ok {
+			return c.String(http.StatusOK, t.Slug)
+		}
+		return c.String(http.StatusOK, "-")
+	}
+	e.GET("/:tenantID/:idpType/login", handler)
+	e.GET("/:idpType/login", handler)
+	e.GET("/healthz", handler)
+
+	cases := []struct {
+		host, path string
+		status     int
+		slug       string
+	}{
+		{"crm.example.com", "/1/oidc/login", http.StatusOK, "acme"},
+		{"crm.example.com", "/globex/oidc/login", http.StatusOK, "globex"},
+		{"crm.example.com", "/GLOBEX/oidc/login", http.StatusOK, "globex"},
+		{"crm.example.com:8443", "/healthz", http.StatusOK, "-"},
+		{"globex.crm.example.com", "/oidc/login", http.StatusOK, "globex"},
+		{"login.acme.io", "/oidc/login", http.StatusOK, "acme"},
+		{"10.0.0.7:8081", "/healthz", http.StatusOK, "-"},
+		// a host and a path naming different tenants
+		{"globex.crm.example.com", "/acme/oidc/login", http.StatusNotFound, ""},
+		{"crm.example.com", "/42/oidc/login", http.StatusNotFound, ""},
+		{"unknown.crm.example.com", "/oidc/login", http.StatusNotFound, ""},
+		{"a.b.crm.example.com", "/oidc/login", http.StatusNotFound, ""},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		req.Host = tc.host
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		require.Equal(t, tc.status, rec.Code, "%s%s", tc.host, tc.path)
+		if tc.status == http.StatusOK {
+			require.Equal(t, tc.slug, rec.Body.String(), "%s%s", tc.host, tc.path)
+		}
+	}
+}
+
+func TestLogin_BySlugCallsBackByID(t *testing.T) {
+	cfg := &config.Config{JWTSecret: "test-secret", BaseURL: "https://crm.example.com"}
+	srv := setupAuthServer(t, []auth.IdentityProvider{&fakeOIDC{tenant: 1}}, cfg)
+
+	// login on the custom host, callback on the canonical URL with the id
+	req := httptest.NewRequest(http.MethodGet, "/oidc/login", nil)
+	req.Host = "login.acme.io"
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusFound, rec.Code)
+
+	loc, err := url.Parse(rec.Header().Get(echo.HeaderLocation))
+	require.NoError(t, err)
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	require.Equal(t, "/", cookies[0].Path)
+
+	req = httptest.NewRequest(http.MethodGet, "/1/oidc/callback?code=c&state="+url.QueryEscape(loc.Query().Get("state")), nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+}
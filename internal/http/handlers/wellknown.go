@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+	"github.com/labstack/echo/v4"
+)
+
+// wellKnownCacheControl lets verifiers cache the keys without missing a
+// rotation: a new key is published well before it starts signing.
+const wellKnownCacheControl = "public, max-age=300"
+
+// JWKS publishes the public keys that verify CRM access tokens.
+func JWKS(keys *auth.Keyring) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Response().Header().Set("Cache-Control", wellKnownCacheControl)
+		return c.JSON(http.StatusOK, keys.JWKS())
+	}
+}
+
+// Discovery publishes an OpenID-style discovery document, enough for
+// downstream services to verify CRM access tokens offline. It doubles as
+// the OAuth authorization server metadata (RFC 8414).
+func Discovery(cfg *config.Config, keys *auth.Keyring) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		issuer := cfg.Issuer()
+
+		c.Response().Header().Set("Cache-Control", wellKnownCacheControl)
+		return c.JSON(http.StatusOK, echo.Map{
+			"issuer":                                issuer,
+			"jwks_uri":                              issuer + "/.well-known/jwks.json",
+			"id_token_signing_alg_values_supported": keys.Algorithms(),
+			"subject_types_supported":               []string{"public"},
+			"claims_supported":                      []string{"iss", "sub", "aud", "iat", "nbf", "exp", "jti", "sid", "tenant_id", "user_id", "email", "client_id", "scope", "act"},
+
+			"authorization_endpoint":                         issuer + "/oauth/authorize",
+			"token_endpoint":                                 issuer + "/oauth/token",
+			"introspection_endpoint":                         issuer + "/oauth/introspect",
+			"revocation_endpoint":                            issuer + "/oauth/revoke",
+			"response_types_supported":                       []string{"code"},
+			"grant_types_supported":                          auth.GrantTypes,
+			"code_challenge_methods_supported":               []string{"S256"},
+			"scopes_supported":                               auth.Permissions(),
+			"token_endpoint_auth_methods_supported":          []string{"client_secret_basic", "client_secret_post", "none"},
+			"authorization_response_iss_parameter_supported": true,
+		})
+	}
+}
@@ -1,54 +1,140 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
 	"github.com/labstack/echo/v4"
 )
 
+// APIKeyHeader carries an API key for clients that can not set a bearer token
+const APIKeyHeader = "X-API-Key"
+
+// Sizes of the impersonation_requests columns
+const (
+	maxAuditedPath      = 2048
+	maxAuditedUserAgent = 512
+)
+
 type JWTConfig struct {
-	JWTSecret string
+	// Tokens verifies the token signature and claims
+	Tokens *auth.TokenValidator
+	// Revocations rejects tokens whose session was revoked (optional)
+	Revocations auth.RevocationChecker
+	// APIKeys authenticates API keys; without it only JWTs are accepted
+	APIKeys auth.APIKeyAuthenticator
+	// Impersonations audits the requests made with impersonation tokens;
+	// without it those tokens are refused
+	Impersonations auth.ImpersonationAuditor
 }
 
+// NewJWTMiddleware authenticates the request with a bearer JWT or an API
+// key, given as a bearer token or in the X-API-Key header. Either way the
+// claims of the principal are put on the context.
 func NewJWTMiddleware(cfg JWTConfig) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
+			if key := c.Request().Header.Get(APIKeyHeader); key != "" {
+				return authenticateAPIKey(c, cfg, key, next)
+			}
+
 			// Extract auth token
-			auth := c.Request().Header.Get("Authorization")
-			if auth == "" {
+			header := c.Request().Header.Get("Authorization")
+			if header == "" {
 				return echo.NewHTTPError(http.StatusUnauthorized, "missing authorization header")
 			}
 
-			parts := strings.SplitN(auth, " ", 2)
+			parts := strings.SplitN(header, " ", 2)
 			if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
 				return echo.NewHTTPError(http.StatusUnauthorized, "invalid authorization header format. Did you forget 'Bearer '?")
 			}
 
 			tokenString := parts[1]
+			if auth.IsAPIKey(tokenString) {
+				return authenticateAPIKey(c, cfg, tokenString, next)
+			}
 
 			// Parse and validate token
-			token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-				if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-					return nil, echo.NewHTTPError(http.StatusUnauthorized, "unexpected signing method")
-				}
+			claims, err := cfg.Tokens.Validate(tokenString)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired token")
+			}
 
-				return []byte(cfg.JWTSecret), nil
-			})
+			if claims.SessionID != "" && cfg.Revocations != nil {
+				revoked, err := cfg.Revocations.IsRevoked(c.Request().Context(), claims.SessionID)
+				if err != nil {
+					return echo.NewHTTPError(http.StatusServiceUnavailable, "unable to verify session")
+				}
 
-			if err != nil || !token.Valid {
-				return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired token")
+				if revoked {
+					return echo.NewHTTPError(http.StatusUnauthorized, "session revoked")
+				}
 			}
 
-			if claims, ok := token.Claims.(jwt.MapClaims); ok {
-				c.Set("tenant_id", claims["tenant_id"])
-				c.Set("user_id", claims["user_id"])
-				dynamicEmail, _ := claims["email"].(string)
-				c.Set("email", dynamicEmail)
+			if claims.IsImpersonation() {
+				if err := auditImpersonation(c, cfg, claims); err != nil {
+					return err
+				}
 			}
 
+			auth.NewContext(c, claims)
+
 			return next(c)
 		}
 	}
 }
+
+// auditImpersonation records the request before it is served, a request
+// that can not be audited is not served at all.
+func auditImpersonation(c echo.Context, cfg JWTConfig, claims *auth.Claims) error {
+	if cfg.Impersonations == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "impersonation tokens are not accepted")
+	}
+
+	req := c.Request()
+	err := cfg.Impersonations.Record(req.Context(), claims, &repo.ImpersonationRequestRecord{
+		Method:    req.Method,
+		Path:      truncate(req.URL.RequestURI(), maxAuditedPath),
+		RemoteIP:  c.RealIP(),
+		UserAgent: truncate(req.UserAgent(), maxAuditedUserAgent),
+	})
+	switch {
+	case errors.Is(err, auth.ErrImpersonationUnknown):
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired token")
+	case err != nil:
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "unable to audit request")
+	}
+
+	return nil
+}
+
+// truncate cuts s to at most n bytes, the size of its audit column
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+func authenticateAPIKey(c echo.Context, cfg JWTConfig, key string, next echo.HandlerFunc) error {
+	if cfg.APIKeys == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "api keys are not accepted")
+	}
+
+	claims, err := cfg.APIKeys.Authenticate(c.Request().Context(), key)
+	switch {
+	case errors.Is(err, auth.ErrAPIKeyInvalid),
+		errors.Is(err, auth.ErrAPIKeyExpired),
+		errors.Is(err, auth.ErrAPIKeyRevoked):
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid, expired or revoked api key")
+	case err != nil:
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "unable to verify api key")
+	}
+
+	auth.NewContext(c, claims)
+
+	return next(c)
+}
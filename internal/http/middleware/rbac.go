@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
+	"github.com/labstack/echo/v4"
+)
+
+// InsufficientScope is the body of a 403 insufficient_scope response
+type InsufficientScope struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+	Scope       string `json:"scope"`
+}
+
+// RouteScopes are the scopes a route group requires: Read on the routes
+// that only read, Write on the ones that change something.
+type RouteScopes struct {
+	Read  string
+	Write string
+}
+
+// Reads requires the read scope of the group
+func (s RouteScopes) Reads() echo.MiddlewareFunc {
+	return RequireScope(s.Read)
+}
+
+// Writes requires the write scope of the group
+func (s RouteScopes) Writes() echo.MiddlewareFunc {
+	return RequireScope(s.Write)
+}
+
+// RequireScope restricts a route to tokens whose scope includes every one
+// of the given scopes. Other tokens get a 403 insufficient_scope error
+// (RFC 6750, section 3.1) naming the scopes the route requires. It must
+// run after the JWT middleware.
+func RequireScope(scopes ...string) echo.MiddlewareFunc {
+	required := strings.Join(scopes, " ")
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims, ok := auth.FromContext(c)
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "unauthenticated")
+			}
+
+			var missing []string
+			for _, s := range scopes {
+				if !claims.Can(s) {
+					missing = append(missing, s)
+				}
+			}
+
+			if len(missing) > 0 {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate,
+					`Bearer error="insufficient_scope", scope="`+required+`"`)
+				return c.JSON(http.StatusForbidden, &InsufficientScope{
+					Error:       "insufficient_scope",
+					Description: "the token lacks the scope " + strings.Join(missing, " "),
+					Scope:       required,
+				})
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// DenyImpersonation keeps impersonation tokens off a route. Support staff
+// acting as a user see what the user sees, but may not change the tenant
+// configuration on its behalf. It must run after the JWT middleware.
+func DenyImpersonation() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims, ok := auth.FromContext(c)
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "unauthenticated")
+			}
+
+			if claims.IsImpersonation() {
+				return echo.NewHTTPError(http.StatusForbidden, "not allowed while impersonating a user")
+			}
+
+			return next(c)
+		}
+	}
+}
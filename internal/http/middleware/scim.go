@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
+	"github.com/jeanmolossi/verbose-adventure/internal/scim"
+	"github.com/jeanmolossi/verbose-adventure/internal/tenant"
+	"github.com/labstack/echo/v4"
+)
+
+// SCIMAuth authenticates SCIM clients with their bearer token and puts it
+// on the context. The token must belong to the tenant of the request.
+// Failures are reported as SCIM errors, which is what the clients parse.
+func SCIMAuth(tokens auth.SCIMAuthenticator) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			parts := strings.SplitN(c.Request().Header.Get("Authorization"), " ", 2)
+			if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+				return scim.WriteError(c, scim.NewError(http.StatusUnauthorized, "", "missing bearer token"))
+			}
+
+			tk, err := tokens.Authenticate(c.Request().Context(), parts[1])
+			switch {
+			case errors.Is(err, auth.ErrSCIMTokenInvalid), errors.Is(err, auth.ErrSCIMTokenRevoked):
+				return scim.WriteError(c, scim.NewError(http.StatusUnauthorized, "", "%s", err.Error()))
+			case err != nil:
+				return scim.WriteError(c, scim.NewError(http.StatusServiceUnavailable, "", "unable to verify token"))
+			}
+
+			// a token of another tenant is as good as an unknown one
+			t, ok := tenant.FromContext(c)
+			if !ok || t.ID != tk.TenantID {
+				return scim.WriteError(c, scim.NewError(http.StatusUnauthorized, "", "%s", auth.ErrSCIMTokenInvalid.Error()))
+			}
+
+			scim.NewContext(c, tk)
+
+			return next(c)
+		}
+	}
+}
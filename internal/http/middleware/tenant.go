@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
+	"github.com/jeanmolossi/verbose-adventure/internal/tenant"
+	"github.com/jeanmolossi/verbose-adventure/pkg/stripslash"
+	"github.com/labstack/echo/v4"
+)
+
+// tenantlessRoutes never belong to a tenant: probes and the documents
+// every replica serves alike. They are not looked up, so they keep
+// answering when the tenant store is down or the Host is a pod IP.
+var tenantlessRoutes = []string{"/healthz", "/.well-known/"}
+
+// ResolveTenant puts the tenant of the request on the context. The tenant
+// comes from a custom host or a subdomain of BASE_URL, and from the given
+// path param (an id or a slug) on routes that have it; when both are
+// present they must agree. Requests that name no tenant pass through.
+func ResolveTenant(r *tenant.Resolver, param string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if isTenantless(c.Path()) {
+				return next(c)
+			}
+
+			ctx := c.Request().Context()
+
+			t, err := r.FromHost(ctx, c.Request().Host)
+			if err != nil {
+				return tenantError(err)
+			}
+
+			if value := stripslash.ParamWithoutAnySlashes(c, param); value != "" {
+				fromPath, err := r.FromPath(ctx, value)
+				if err != nil {
+					return tenantError(err)
+				}
+
+				if t != nil && t.ID != fromPath.ID {
+					return tenantError(tenant.ErrNotFound)
+				}
+				t = fromPath
+			}
+
+			if t != nil {
+				tenant.NewContext(c, t)
+			}
+
+			return next(c)
+		}
+	}
+}
+
+func isTenantless(route string) bool {
+	for _, prefix := range tenantlessRoutes {
+		if route == prefix || (strings.HasSuffix(prefix, "/") && strings.HasPrefix(route, prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+func tenantError(err error) error {
+	if errors.Is(err, tenant.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, "failed to resolve tenant")
+}
+
+// RequireTenant restricts a route to tokens issued for the tenant of the
+// request. Super admins may act on any tenant. It must run after the JWT
+// middleware and ResolveTenant.
+func RequireTenant() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims, ok := auth.FromContext(c)
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "unauthenticated")
+			}
+
+			t, ok := tenant.FromContext(c)
+			if !ok {
+				return echo.NewHTTPError(http.StatusNotFound, tenant.ErrNotFound.Error())
+			}
+
+			if claims.TenantID != t.ID && !claims.SuperAdmin {
+				return echo.NewHTTPError(http.StatusForbidden, "token does not grant access to this tenant")
+			}
+
+			return next(c)
+		}
+	}
+}
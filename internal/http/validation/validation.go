@@ -0,0 +1,306 @@
+package validation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"go.uber.org/fx"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/auth"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+)
+
+// FieldError describes one rejected field of a request payload
+type FieldError struct {
+	// Field is the payload path, as the client sent it, such as
+	// "login_policy.acr_values[0]". It is empty when the body itself is
+	// malformed.
+	Field   string `json:"field,omitempty"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// Errors is the list of rejected fields of a payload
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, fe := range e {
+		if fe.Field == "" {
+			msgs = append(msgs, fe.Message)
+			continue
+		}
+		msgs = append(msgs, fe.Field+" "+fe.Message)
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// Conflict reports whether every failure is a uniqueness one, which the
+// client fixes by picking another value rather than fixing its format
+func (e Errors) Conflict() bool {
+	for _, fe := range e {
+		if fe.Rule != "tenant_unique" {
+			return false
+		}
+	}
+
+	return len(e) > 0
+}
+
+// Field builds the errors of a single rejected field, for checks that do
+// not fit in a struct tag
+func Field(field, rule, message string) Errors {
+	return Errors{{Field: field, Rule: rule, Message: message}}
+}
+
+// UniqueFunc reports whether value is already taken by another record of
+// the tenant than exceptID
+type UniqueFunc func(ctx context.Context, tenantID, exceptID int64, value string) (bool, error)
+
+// Scope is what the tenant_unique rule compares against: the records of
+// TenantID other than ExceptID, the one being updated
+type Scope struct {
+	TenantID int64
+	ExceptID int64
+}
+
+type scopeKey struct{}
+
+// WithScope sets the scope of the tenant_unique rule for ValidateCtx
+func WithScope(ctx context.Context, s Scope) context.Context {
+	return context.WithValue(ctx, scopeKey{}, s)
+}
+
+// slugPattern restricts a slug to a readable URL segment
+var slugPattern = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]{0,62}[a-z0-9])?$`)
+
+// Validator implements echo.Validator on top of the `validate` struct tags.
+// Besides the standard rules it knows:
+//
+//   - notblank: a string with something other than spaces
+//   - slug: up to 64 lowercase letters, digits and inner hyphens
+//   - role: a known tenant role
+//   - url_scheme=https http: an absolute URL with one of the schemes
+//   - tenant_unique=<name>: a value no other record of the tenant uses,
+//     checked by the UniqueFunc registered under name
+type Validator struct {
+	validate *validator.Validate
+	unique   map[string]UniqueFunc
+}
+
+type Params struct {
+	fx.In
+	// IdentityProviders backs tenant_unique=idp_slug
+	IdentityProviders repo.IdentityProviderRepository `optional:"true"`
+}
+
+func New(p Params) *Validator {
+	v := &Validator{validate: validator.New(validator.WithRequiredStructEnabled()), unique: make(map[string]UniqueFunc)}
+
+	v.validate.RegisterTagNameFunc(fieldName)
+	v.validate.RegisterValidation("notblank", func(fl validator.FieldLevel) bool {
+		return strings.TrimSpace(fl.Field().String()) != ""
+	})
+	v.validate.RegisterValidation("slug", func(fl validator.FieldLevel) bool {
+		return slugPattern.MatchString(fl.Field().String())
+	})
+	v.validate.RegisterValidation("role", func(fl validator.FieldLevel) bool {
+		return auth.IsRole(fl.Field().String())
+	})
+	v.validate.RegisterValidation("url_scheme", validURLScheme)
+	v.validate.RegisterValidationCtx("tenant_unique", v.validUnique)
+
+	if p.IdentityProviders != nil {
+		v.RegisterUnique("idp_slug", identityProviderSlugs(p.IdentityProviders))
+	}
+
+	return v
+}
+
+// RegisterUnique makes fn available as tenant_unique=name
+func (v *Validator) RegisterUnique(name string, fn UniqueFunc) {
+	v.unique[name] = fn
+}
+
+// Validate checks i without a tenant scope; tenant_unique rules fail
+func (v *Validator) Validate(i any) error {
+	return v.ValidateCtx(context.Background(), i)
+}
+
+// ValidateCtx checks i, taking the tenant_unique scope from ctx. Rejected
+// fields come back as Errors.
+func (v *Validator) ValidateCtx(ctx context.Context, i any) error {
+	err := v.validate.StructCtx(ctx, i)
+
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return err
+	}
+
+	out := make(Errors, 0, len(verrs))
+	for _, fe := range verrs {
+		out = append(out, FieldError{
+			Field:   fieldPath(fe.Namespace()),
+			Rule:    fe.Tag(),
+			Param:   fe.Param(),
+			Message: message(fe.Tag(), fe.Param(), fe.Kind()),
+		})
+	}
+
+	return out
+}
+
+// validUnique fails when the scope is missing or the lookup errors, so a
+// duplicate never slips through
+func (v *Validator) validUnique(ctx context.Context, fl validator.FieldLevel) bool {
+	fn, ok := v.unique[fl.Param()]
+	if !ok {
+		return false
+	}
+
+	scope, ok := ctx.Value(scopeKey{}).(Scope)
+	if !ok {
+		return false
+	}
+
+	taken, err := fn(ctx, scope.TenantID, scope.ExceptID, fl.Field().String())
+	return err == nil && !taken
+}
+
+func validURLScheme(fl validator.FieldLevel) bool {
+	u, err := url.Parse(fl.Field().String())
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	return slices.Contains(strings.Fields(fl.Param()), u.Scheme)
+}
+
+// identityProviderSlugs looks the slug up among the tenant's providers
+func identityProviderSlugs(r repo.IdentityProviderRepository) UniqueFunc {
+	return func(ctx context.Context, tenantID, exceptID int64, value string) (bool, error) {
+		recs, err := r.ListByTenant(ctx, tenantID)
+		if err != nil {
+			return false, err
+		}
+
+		for _, rec := range recs {
+			if rec.Slug == value && rec.ID != exceptID {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	}
+}
+
+// BindErrors turns an echo bind error into field errors: a value of the
+// wrong type names its field, anything else is a malformed body
+func BindErrors(err error) Errors {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return Errors{{
+			Field:   typeErr.Field,
+			Rule:    "type",
+			Param:   typeErr.Type.String(),
+			Message: fmt.Sprintf("must be a %s, got %s", typeName(typeErr.Type), typeErr.Value),
+		}}
+	}
+
+	return Errors{{Rule: "body", Message: "malformed request body"}}
+}
+
+// fieldName names struct fields by their json, or form, tag
+func fieldName(f reflect.StructField) string {
+	for _, key := range []string{"json", "form"} {
+		name, _, _ := strings.Cut(f.Tag.Get(key), ",")
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+	}
+
+	return f.Name
+}
+
+// fieldPath drops the struct name that leads a validator namespace
+func fieldPath(ns string) string {
+	_, path, found := strings.Cut(ns, ".")
+	if !found {
+		return ns
+	}
+
+	return path
+}
+
+func message(tag, param string, kind reflect.Kind) string {
+	switch tag {
+	case "required":
+		return "is required"
+	case "notblank":
+		return "can not be blank"
+	case "oneof":
+		return "must be one of: " + strings.Join(strings.Fields(param), ", ")
+	case "url":
+		return "must be a valid url"
+	case "url_scheme":
+		return "must be an absolute " + strings.Join(strings.Fields(param), " or ") + " url"
+	case "slug":
+		return "must have up to 64 lowercase letters, digits and inner hyphens"
+	case "role":
+		return "is not a known role"
+	case "tenant_unique":
+		return "is already used in this tenant"
+	case "min":
+		if lengthKind(kind) {
+			return "must have at least " + param + " item(s) or character(s)"
+		}
+		return "must be at least " + param
+	case "max":
+		if lengthKind(kind) {
+			return "must have at most " + param + " item(s) or character(s)"
+		}
+		return "must be at most " + param
+	case "gt":
+		return "must be greater than " + param
+	case "gte":
+		return "must be greater than or equal to " + param
+	default:
+		return "failed the " + tag + " rule"
+	}
+}
+
+func lengthKind(k reflect.Kind) bool {
+	return k == reflect.String || k == reflect.Slice || k == reflect.Map || k == reflect.Array
+}
+
+func typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "list"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	default:
+		return t.String()
+	}
+}
@@ -0,0 +1,197 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// APIKeyRecord representa a linha da tabela api_keys.
+// Apenas o hash SHA-256 da chave é persistido; Prefix identifica a chave
+// para o administrador sem revelá-la.
+type APIKeyRecord struct {
+	ID         int64      `db:"id"`
+	TenantID   int64      `db:"tenant_id"`
+	Name       string     `db:"name"`
+	Prefix     string     `db:"prefix"`
+	KeyHash    []byte     `db:"key_hash"`
+	Scopes     []string   `db:"scopes"`
+	CreatedBy  *int64     `db:"created_by"`
+	ExpiresAt  *time.Time `db:"expires_at"`
+	LastUsedAt *time.Time `db:"last_used_at"`
+	RevokedAt  *time.Time `db:"revoked_at"`
+	CreatedAt  time.Time  `db:"created_at"`
+}
+
+// APIKeyRepository define os métodos para acesso às chaves de API dos tenants.
+type APIKeyRepository interface {
+	// ListByTenant retorna as chaves de um tenant, inclusive as revogadas
+	ListByTenant(ctx context.Context, tenantID int64) ([]*APIKeyRecord, error)
+	// GetByID retorna uma chave do tenant, ou nil se não existir
+	GetByID(ctx context.Context, tenantID, id int64) (*APIKeyRecord, error)
+	// GetByHash retorna uma chave pelo hash, ou nil se não existir
+	GetByHash(ctx context.Context, hash []byte) (*APIKeyRecord, error)
+	// Create insere uma chave e retorna o ID gerado
+	Create(ctx context.Context, rec *APIKeyRecord) (int64, error)
+	// Revoke revoga uma chave do tenant, mantendo a primeira revogação
+	Revoke(ctx context.Context, tenantID, id int64) error
+	// TouchLastUsed registra o último uso da chave
+	TouchLastUsed(ctx context.Context, id int64, at time.Time) error
+}
+
+// apiKeyRepo é a implementação concreta
+type apiKeyRepo struct {
+	db *sql.DB
+}
+
+// NewAPIKeyRepository instancia um APIKeyRepository
+func NewAPIKeyRepository(db *sql.DB) APIKeyRepository {
+	return &apiKeyRepo{db: db}
+}
+
+const apiKeyColumns = `id, tenant_id, name, prefix, key_hash, scopes, created_by, expires_at, last_used_at, revoked_at, created_at`
+
+func scanAPIKey(row rowScanner) (*APIKeyRecord, error) {
+	rec := &APIKeyRecord{}
+	var (
+		scopes                         []byte
+		createdBy                      sql.NullInt64
+		expiresAt, lastUsed, revokedAt sql.NullTime
+	)
+
+	if err := row.Scan(
+		&rec.ID,
+		&rec.TenantID,
+		&rec.Name,
+		&rec.Prefix,
+		&rec.KeyHash,
+		&scopes,
+		&createdBy,
+		&expiresAt,
+		&lastUsed,
+		&revokedAt,
+		&rec.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := unmarshalJSONColumn(scopes, &rec.Scopes); err != nil {
+		return nil, err
+	}
+	if createdBy.Valid {
+		rec.CreatedBy = &createdBy.Int64
+	}
+	if expiresAt.Valid {
+		rec.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsed.Valid {
+		rec.LastUsedAt = &lastUsed.Time
+	}
+	if revokedAt.Valid {
+		rec.RevokedAt = &revokedAt.Time
+	}
+	return rec, nil
+}
+
+func (r *apiKeyRepo) ListByTenant(ctx context.Context, tenantID int64) ([]*APIKeyRecord, error) {
+	query := `
+        SELECT ` + apiKeyColumns + `
+	    FROM api_keys
+	    WHERE tenant_id = ?
+	    ORDER BY id
+    `
+	rows, err := r.db.QueryContext(ctx, query, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*APIKeyRecord
+	for rows.Next() {
+		rec, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+func (r *apiKeyRepo) GetByID(ctx context.Context, tenantID, id int64) (*APIKeyRecord, error) {
+	query := `
+        SELECT ` + apiKeyColumns + `
+	    FROM api_keys
+	    WHERE id = ? AND tenant_id = ?
+    `
+	rec, err := scanAPIKey(r.db.QueryRowContext(ctx, query, id, tenantID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return rec, err
+}
+
+func (r *apiKeyRepo) GetByHash(ctx context.Context, hash []byte) (*APIKeyRecord, error) {
+	query := `
+        SELECT ` + apiKeyColumns + `
+	    FROM api_keys
+	    WHERE key_hash = ?
+    `
+	rec, err := scanAPIKey(r.db.QueryRowContext(ctx, query, hash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return rec, err
+}
+
+func (r *apiKeyRepo) Create(ctx context.Context, rec *APIKeyRecord) (int64, error) {
+	scopes, err := marshalJSONColumn(rec.Scopes)
+	if err != nil {
+		return 0, err
+	}
+
+	var createdBy sql.NullInt64
+	if rec.CreatedBy != nil {
+		createdBy = sql.NullInt64{Int64: *rec.CreatedBy, Valid: true}
+	}
+
+	var expiresAt sql.NullTime
+	if rec.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: *rec.ExpiresAt, Valid: true}
+	}
+
+	query := `
+        INSERT INTO api_keys (tenant_id, name, prefix, key_hash, scopes, created_by, expires_at)
+	    VALUES (?, ?, ?, ?, ?, ?, ?)
+    `
+	res, err := r.db.ExecContext(ctx, query,
+		rec.TenantID,
+		rec.Name,
+		rec.Prefix,
+		rec.KeyHash,
+		scopes,
+		createdBy,
+		expiresAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (r *apiKeyRepo) Revoke(ctx context.Context, tenantID, id int64) error {
+	query := `
+        UPDATE api_keys
+	    SET revoked_at = COALESCE(revoked_at, CURRENT_TIMESTAMP)
+	    WHERE id = ? AND tenant_id = ?
+    `
+	res, err := r.db.ExecContext(ctx, query, id, tenantID)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+func (r *apiKeyRepo) TouchLastUsed(ctx context.Context, id int64, at time.Time) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE api_keys SET last_used_at = ? WHERE id = ?`, at, id)
+	return err
+}
@@ -0,0 +1,112 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// EncryptedColumn identifica uma coluna cifrada com o keyring da aplicação
+type EncryptedColumn struct {
+	Table  string
+	Column string
+}
+
+func (c EncryptedColumn) String() string {
+	return c.Table + "." + c.Column
+}
+
+// EncryptedColumns lista as colunas cifradas; uma nova coluna cifrada entra
+// aqui para ser recifrada na rotação de chaves. As tabelas têm id numérico.
+var EncryptedColumns = []EncryptedColumn{
+	{Table: "identity_providers", Column: "client_secret_enc"},
+}
+
+// EncryptedValueRecord é o valor cifrado de uma linha
+type EncryptedValueRecord struct {
+	ID    int64
+	Value []byte
+}
+
+// EncryptedColumnRepository lê e regrava colunas cifradas, linha a linha
+type EncryptedColumnRepository interface {
+	// List retorna até limit valores não nulos com id maior que afterID, em
+	// ordem de id
+	List(ctx context.Context, col EncryptedColumn, afterID int64, limit int) ([]*EncryptedValueRecord, error)
+	// Replace troca o valor de uma linha se ele ainda for old; retorna false
+	// quando a linha mudou ou sumiu desde a leitura
+	Replace(ctx context.Context, col EncryptedColumn, id int64, old, value []byte) (bool, error)
+}
+
+// encryptedColumnRepo é a implementação concreta
+type encryptedColumnRepo struct {
+	db *sql.DB
+}
+
+// NewEncryptedColumnRepository instancia um EncryptedColumnRepository
+func NewEncryptedColumnRepository(db *sql.DB) EncryptedColumnRepository {
+	return &encryptedColumnRepo{db: db}
+}
+
+// checkColumn recusa colunas fora de EncryptedColumns, pois os nomes entram
+// na query sem placeholders
+func checkColumn(col EncryptedColumn) error {
+	for _, c := range EncryptedColumns {
+		if c == col {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not an encrypted column", col)
+}
+
+func (r *encryptedColumnRepo) List(ctx context.Context, col EncryptedColumn, afterID int64, limit int) ([]*EncryptedValueRecord, error) {
+	if err := checkColumn(col); err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+        SELECT id, %[2]s
+	    FROM %[1]s
+	    WHERE id > ? AND %[2]s IS NOT NULL
+	    ORDER BY id
+	    LIMIT ?
+    `, col.Table, col.Column)
+
+	rows, err := r.db.QueryContext(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []*EncryptedValueRecord
+	for rows.Next() {
+		rec := &EncryptedValueRecord{}
+		if err := rows.Scan(&rec.ID, &rec.Value); err != nil {
+			return nil, err
+		}
+		list = append(list, rec)
+	}
+	return list, rows.Err()
+}
+
+func (r *encryptedColumnRepo) Replace(ctx context.Context, col EncryptedColumn, id int64, old, value []byte) (bool, error) {
+	if err := checkColumn(col); err != nil {
+		return false, err
+	}
+
+	// comparar com o valor lido evita sobrescrever um segredo trocado pela
+	// API durante a rotação
+	query := fmt.Sprintf(`UPDATE %[1]s SET %[2]s = ? WHERE id = ? AND %[2]s = ?`, col.Table, col.Column)
+	res, err := r.db.ExecContext(ctx, query, value, id, old)
+	if err != nil {
+		return false, err
+	}
+
+	if err := expectAffected(res); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
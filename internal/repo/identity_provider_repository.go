@@ -3,34 +3,93 @@ package repo
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 )
 
+// ClaimMapping indica quais claims (ou atributos SAML) alimentam o perfil do
+// usuário. Campos vazios usam os nomes padrão do OIDC.
+type ClaimMapping struct {
+	UserID string `json:"user_id,omitempty"`
+	Email  string `json:"email,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Groups string `json:"groups,omitempty"`
+}
+
+// LoginPolicy define os requisitos que um login pelo IdP precisa cumprir,
+// além de um ID token válido. O valor zero não exige nada.
+type LoginPolicy struct {
+	// ACRValues são os "acr" aceitos, também pedidos ao IdP em acr_values
+	ACRValues []string `json:"acr_values,omitempty"`
+	// AMRValues são os métodos de autenticação aceitos, ex. "mfa" ou "hwk":
+	// o claim "amr" precisa incluir ao menos um deles
+	AMRValues []string `json:"amr_values,omitempty"`
+	// RequireEmailVerified recusa emails que o IdP não verificou
+	RequireEmailVerified bool `json:"require_email_verified,omitempty"`
+	// AllowedEmailDomains restringe o email a estes domínios
+	AllowedEmailDomains []string `json:"allowed_email_domains,omitempty"`
+}
+
+// IsZero indica se a política não exige nada
+func (p LoginPolicy) IsZero() bool {
+	return len(p.ACRValues) == 0 && len(p.AMRValues) == 0 && !p.RequireEmailVerified && len(p.AllowedEmailDomains) == 0
+}
+
+// ProviderDiscovery é o retrato da descoberta do IdP feito quando ele foi
+// salvo: o issuer e os endpoints OIDC, ou a entidade e o SSO do SAML.
+type ProviderDiscovery struct {
+	Issuer                string    `json:"issuer,omitempty"`
+	AuthorizationEndpoint string    `json:"authorization_endpoint,omitempty"`
+	TokenEndpoint         string    `json:"token_endpoint,omitempty"`
+	UserinfoEndpoint      string    `json:"userinfo_endpoint,omitempty"`
+	EndSessionEndpoint    string    `json:"end_session_endpoint,omitempty"`
+	JWKSURI               string    `json:"jwks_uri,omitempty"`
+	SSOURL                string    `json:"sso_url,omitempty"`
+	DiscoveredAt          time.Time `json:"discovered_at"`
+}
+
 // IdentityProviderRecord representa a linha da tabela identity_providers.
 type IdentityProviderRecord struct {
-	ID              int64     `db:"id"`
-	TenantID        int64     `db:"tenant_id"`
-	ProviderType    string    `db:"type"`
-	MetadataURL     string    `db:"metadata_url"`
-	ClientID        string    `db:"client_id"`
-	ClientSecretEnc []byte    `db:"client_secret_enc"`
-	Enabled         bool      `db:"enabled"`
-	CreatedAt       time.Time `db:"created_at"`
-	UpdatedAt       time.Time `db:"updated_at"`
+	ID              int64  `db:"id"`
+	TenantID        int64  `db:"tenant_id"`
+	ProviderType    string `db:"type"`
+	Slug            string `db:"slug"`
+	MetadataURL     string `db:"metadata_url"`
+	ClientID        string `db:"client_id"`
+	ClientSecretEnc []byte `db:"client_secret_enc"`
+	// ClientSecretHint mascara o segredo, mostrando no máximo seu final
+	ClientSecretHint string             `db:"client_secret_hint"`
+	SecretUpdatedAt  *time.Time         `db:"secret_updated_at"`
+	Scopes           []string           `db:"scopes"`
+	ClaimMapping     ClaimMapping       `db:"claim_mapping"`
+	RoleMapping      map[string]string  `db:"role_mapping"`
+	AuthParams       map[string]string  `db:"auth_params"`
+	LoginPolicy      LoginPolicy        `db:"login_policy"`
+	Discovery        *ProviderDiscovery `db:"discovery"`
+	RedirectURL      string             `db:"redirect_url"`
+	Enabled          bool               `db:"enabled"`
+	CreatedAt        time.Time          `db:"created_at"`
+	UpdatedAt        time.Time          `db:"updated_at"`
 }
 
 // IdentityProviderRepository define os métodos para acesso e manipulação de identity providers.
 type IdentityProviderRepository interface {
 	// ListByTenant retorna todos os providers de um tenant
 	ListByTenant(ctx context.Context, tenantID int64) ([]*IdentityProviderRecord, error)
-	// GetByID retorna um provider específico
-	GetByID(ctx context.Context, id int64) (*IdentityProviderRecord, error)
+	// GetByID retorna um provider específico do tenant
+	GetByID(ctx context.Context, tenantID, id int64) (*IdentityProviderRecord, error)
 	// Create insere um novo provider e retorna o ID gerado
 	Create(ctx context.Context, rec *IdentityProviderRecord) (int64, error)
-	// Update modifica um provider existente
+	// Update modifica um provider existente do tenant (rec.TenantID); um Slug
+	// vazio mantém o atual
 	Update(ctx context.Context, rec *IdentityProviderRecord) error
-	// Delete remove um provider pelo ID
-	Delete(ctx context.Context, id int64) error
+	// UpdateFields grava só as colunas listadas de rec, mantendo as demais;
+	// trocar client_secret_enc atualiza também secret_updated_at
+	UpdateFields(ctx context.Context, rec *IdentityProviderRecord, columns ...string) error
+	// Delete remove um provider do tenant pelo ID
+	Delete(ctx context.Context, tenantID, id int64) error
 }
 
 // identityProviderRepo é a implementação concreta
@@ -43,9 +102,83 @@ func NewIdentityProviderRepository(db *sql.DB) IdentityProviderRepository {
 	return &identityProviderRepo{db: db}
 }
 
+const identityProviderColumns = `id, tenant_id, type, slug, metadata_url, client_id, client_secret_enc, client_secret_hint, secret_updated_at,
+        scopes, claim_mapping, role_mapping, auth_params, login_policy, discovery, redirect_url, enabled, created_at, updated_at`
+
+// rowScanner é satisfeito por *sql.Row e *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanIdentityProvider lê uma linha no formato de identityProviderColumns
+func scanIdentityProvider(row rowScanner) (*IdentityProviderRecord, error) {
+	rec := &IdentityProviderRecord{}
+
+	var (
+		scopes, claimMapping, roleMapping, authParams, loginPolicy, discovery []byte
+		redirectURL, secretHint                                               sql.NullString
+		secretUpdatedAt                                                       sql.NullTime
+	)
+
+	if err := row.Scan(
+		&rec.ID,
+		&rec.TenantID,
+		&rec.ProviderType,
+		&rec.Slug,
+		&rec.MetadataURL,
+		&rec.ClientID,
+		&rec.ClientSecretEnc,
+		&secretHint,
+		&secretUpdatedAt,
+		&scopes,
+		&claimMapping,
+		&roleMapping,
+		&authParams,
+		&loginPolicy,
+		&discovery,
+		&redirectURL,
+		&rec.Enabled,
+		&rec.CreatedAt,
+		&rec.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := unmarshalJSONColumn(scopes, &rec.Scopes); err != nil {
+		return nil, err
+	}
+
+	if err := unmarshalJSONColumn(claimMapping, &rec.ClaimMapping); err != nil {
+		return nil, err
+	}
+
+	if err := unmarshalJSONColumn(roleMapping, &rec.RoleMapping); err != nil {
+		return nil, err
+	}
+
+	if err := unmarshalJSONColumn(authParams, &rec.AuthParams); err != nil {
+		return nil, err
+	}
+
+	if err := unmarshalJSONColumn(loginPolicy, &rec.LoginPolicy); err != nil {
+		return nil, err
+	}
+
+	if err := unmarshalJSONColumn(discovery, &rec.Discovery); err != nil {
+		return nil, err
+	}
+
+	rec.RedirectURL = redirectURL.String
+	rec.ClientSecretHint = secretHint.String
+	if secretUpdatedAt.Valid {
+		rec.SecretUpdatedAt = &secretUpdatedAt.Time
+	}
+	return rec, nil
+}
+
 func (r *identityProviderRepo) ListByTenant(ctx context.Context, tenantID int64) ([]*IdentityProviderRecord, error) {
 	query := `
-        SELECT id, tenant_id, type, metadata_url, client_id, client_secret_enc, enabled, created_at, updated_at
+        SELECT ` + identityProviderColumns + `
 	    FROM identity_providers
 	    WHERE tenant_id = ?
     `
@@ -57,18 +190,8 @@ func (r *identityProviderRepo) ListByTenant(ctx context.Context, tenantID int64)
 
 	var list []*IdentityProviderRecord
 	for rows.Next() {
-		rec := new(IdentityProviderRecord)
-		if err := rows.Scan(
-			&rec.ID,
-			&rec.TenantID,
-			&rec.ProviderType,
-			&rec.MetadataURL,
-			&rec.ClientID,
-			&rec.ClientSecretEnc,
-			&rec.Enabled,
-			&rec.CreatedAt,
-			&rec.UpdatedAt,
-		); err != nil {
+		rec, err := scanIdentityProvider(rows)
+		if err != nil {
 			return nil, err
 		}
 		list = append(list, rec)
@@ -76,25 +199,14 @@ func (r *identityProviderRepo) ListByTenant(ctx context.Context, tenantID int64)
 	return list, rows.Err()
 }
 
-func (r *identityProviderRepo) GetByID(ctx context.Context, id int64) (*IdentityProviderRecord, error) {
+func (r *identityProviderRepo) GetByID(ctx context.Context, tenantID, id int64) (*IdentityProviderRecord, error) {
 	query := `
-        SELECT id, tenant_id, type, metadata_url, client_id, client_secret_enc, enabled, created_at, updated_at
+        SELECT ` + identityProviderColumns + `
 	    FROM identity_providers
-	    WHERE id = ?
+	    WHERE id = ? AND tenant_id = ?
     `
-	row := r.db.QueryRowContext(ctx, query, id)
-	rec := &IdentityProviderRecord{}
-	if err := row.Scan(
-		&rec.ID,
-		&rec.TenantID,
-		&rec.ProviderType,
-		&rec.MetadataURL,
-		&rec.ClientID,
-		&rec.ClientSecretEnc,
-		&rec.Enabled,
-		&rec.CreatedAt,
-		&rec.UpdatedAt,
-	); err != nil {
+	rec, err := scanIdentityProvider(r.db.QueryRowContext(ctx, query, id, tenantID))
+	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -104,17 +216,32 @@ func (r *identityProviderRepo) GetByID(ctx context.Context, id int64) (*Identity
 }
 
 func (r *identityProviderRepo) Create(ctx context.Context, rec *IdentityProviderRecord) (int64, error) {
+	settings, err := marshalSettings(rec)
+	if err != nil {
+		return 0, err
+	}
+
 	query := `
         INSERT INTO identity_providers
-	        (tenant_id, type, metadata_url, client_id, client_secret_enc, enabled)
-	    VALUES (?, ?, ?, ?, ?, ?)
+	        (tenant_id, type, slug, metadata_url, client_id, client_secret_enc, client_secret_hint, secret_updated_at,
+	         scopes, claim_mapping, role_mapping, auth_params, login_policy, discovery, redirect_url, enabled)
+	    VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?, ?)
     `
 	res, err := r.db.ExecContext(ctx, query,
 		rec.TenantID,
 		rec.ProviderType,
+		rec.Slug,
 		rec.MetadataURL,
 		rec.ClientID,
 		rec.ClientSecretEnc,
+		settings.secretHint,
+		settings.scopes,
+		settings.claimMapping,
+		settings.roleMapping,
+		settings.authParams,
+		settings.loginPolicy,
+		settings.discovery,
+		settings.redirectURL,
 		rec.Enabled,
 	)
 	if err != nil {
@@ -128,29 +255,104 @@ func (r *identityProviderRepo) Create(ctx context.Context, rec *IdentityProvider
 }
 
 func (r *identityProviderRepo) Update(ctx context.Context, rec *IdentityProviderRecord) error {
+	settings, err := marshalSettings(rec)
+	if err != nil {
+		return err
+	}
+
+	// o tenant só entra no filtro: um provider nunca muda de tenant
 	query := `
         UPDATE identity_providers
-	    SET tenant_id = ?, type = ?, metadata_url = ?, client_id = ?, client_secret_enc = ?, enabled = ?, updated_at = CURRENT_TIMESTAMP
-	    WHERE id = ?
+	    SET type = ?, slug = COALESCE(NULLIF(?, ''), slug), metadata_url = ?, client_id = ?,
+	        client_secret_enc = ?, client_secret_hint = ?, secret_updated_at = CURRENT_TIMESTAMP,
+	        scopes = ?, claim_mapping = ?, role_mapping = ?, auth_params = ?, login_policy = ?, discovery = ?, redirect_url = ?, enabled = ?, revision = revision + 1, updated_at = CURRENT_TIMESTAMP
+	    WHERE id = ? AND tenant_id = ?
     `
-	_, err := r.db.ExecContext(ctx, query,
-		rec.TenantID,
+	res, err := r.db.ExecContext(ctx, query,
 		rec.ProviderType,
+		rec.Slug,
 		rec.MetadataURL,
 		rec.ClientID,
 		rec.ClientSecretEnc,
+		settings.secretHint,
+		settings.scopes,
+		settings.claimMapping,
+		settings.roleMapping,
+		settings.authParams,
+		settings.loginPolicy,
+		settings.discovery,
+		settings.redirectURL,
 		rec.Enabled,
 		rec.ID,
+		rec.TenantID,
+	)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+func (r *identityProviderRepo) UpdateFields(ctx context.Context, rec *IdentityProviderRecord, columns ...string) error {
+	settings, err := marshalSettings(rec)
+	if err != nil {
+		return err
+	}
+
+	// os nomes entram na query sem placeholders, então só colunas conhecidas
+	values := map[string]any{
+		"type":               rec.ProviderType,
+		"slug":               rec.Slug,
+		"metadata_url":       rec.MetadataURL,
+		"client_id":          rec.ClientID,
+		"client_secret_enc":  rec.ClientSecretEnc,
+		"client_secret_hint": settings.secretHint,
+		"scopes":             settings.scopes,
+		"claim_mapping":      settings.claimMapping,
+		"role_mapping":       settings.roleMapping,
+		"auth_params":        settings.authParams,
+		"login_policy":       settings.loginPolicy,
+		"discovery":          settings.discovery,
+		"redirect_url":       settings.redirectURL,
+		"enabled":            rec.Enabled,
+	}
+
+	var (
+		sets []string
+		args []any
 	)
-	return err
+	for _, col := range columns {
+		v, ok := values[col]
+		if !ok {
+			return fmt.Errorf("identity provider column %q can not be updated", col)
+		}
+		sets = append(sets, col+" = ?")
+		args = append(args, v)
+
+		if col == "client_secret_enc" {
+			sets = append(sets, "secret_updated_at = CURRENT_TIMESTAMP")
+		}
+	}
+	sets = append(sets, "revision = revision + 1", "updated_at = CURRENT_TIMESTAMP")
+
+	query := `UPDATE identity_providers SET ` + strings.Join(sets, ", ") + ` WHERE id = ? AND tenant_id = ?`
+	res, err := r.db.ExecContext(ctx, query, append(args, rec.ID, rec.TenantID)...)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
 }
 
-func (r *identityProviderRepo) Delete(ctx context.Context, id int64) error {
-	query := `DELETE FROM identity_providers WHERE id = ?`
-	res, err := r.db.ExecContext(ctx, query, id)
+func (r *identityProviderRepo) Delete(ctx context.Context, tenantID, id int64) error {
+	query := `DELETE FROM identity_providers WHERE id = ? AND tenant_id = ?`
+	res, err := r.db.ExecContext(ctx, query, id, tenantID)
 	if err != nil {
 		return err
 	}
+	return expectAffected(res)
+}
+
+// expectAffected retorna sql.ErrNoRows quando nenhuma linha foi encontrada
+func expectAffected(res sql.Result) error {
 	count, err := res.RowsAffected()
 	if err != nil {
 		return err
@@ -160,3 +362,75 @@ func (r *identityProviderRepo) Delete(ctx context.Context, id int64) error {
 	}
 	return nil
 }
+
+// providerSettings são as colunas opcionais já serializadas para o banco
+type providerSettings struct {
+	scopes       any
+	claimMapping any
+	roleMapping  any
+	authParams   any
+	loginPolicy  any
+	discovery    any
+	redirectURL  sql.NullString
+	secretHint   sql.NullString
+}
+
+func marshalSettings(rec *IdentityProviderRecord) (providerSettings, error) {
+	var (
+		s   providerSettings
+		err error
+	)
+
+	if len(rec.Scopes) > 0 {
+		if s.scopes, err = marshalJSONColumn(rec.Scopes); err != nil {
+			return s, err
+		}
+	}
+
+	if rec.ClaimMapping != (ClaimMapping{}) {
+		if s.claimMapping, err = marshalJSONColumn(rec.ClaimMapping); err != nil {
+			return s, err
+		}
+	}
+
+	if len(rec.RoleMapping) > 0 {
+		if s.roleMapping, err = marshalJSONColumn(rec.RoleMapping); err != nil {
+			return s, err
+		}
+	}
+
+	if len(rec.AuthParams) > 0 {
+		if s.authParams, err = marshalJSONColumn(rec.AuthParams); err != nil {
+			return s, err
+		}
+	}
+
+	if !rec.LoginPolicy.IsZero() {
+		if s.loginPolicy, err = marshalJSONColumn(rec.LoginPolicy); err != nil {
+			return s, err
+		}
+	}
+
+	if rec.Discovery != nil {
+		if s.discovery, err = marshalJSONColumn(rec.Discovery); err != nil {
+			return s, err
+		}
+	}
+
+	s.redirectURL = sql.NullString{String: rec.RedirectURL, Valid: rec.RedirectURL != ""}
+	s.secretHint = sql.NullString{String: rec.ClientSecretHint, Valid: rec.ClientSecretHint != ""}
+	return s, nil
+}
+
+func marshalJSONColumn(v any) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// unmarshalJSONColumn ignora colunas NULL
+func unmarshalJSONColumn(data []byte, v any) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
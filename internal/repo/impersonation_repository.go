@@ -0,0 +1,192 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// ImpersonationRecord representa a linha da tabela impersonations: um
+// token de "agir como usuário" emitido para um super admin.
+type ImpersonationRecord struct {
+	ID            int64     `db:"id"`
+	TokenID       string    `db:"token_id"`
+	TenantID      int64     `db:"tenant_id"`
+	UserID        int64     `db:"user_id"`
+	ActorTenantID int64     `db:"actor_tenant_id"`
+	ActorUserID   int64     `db:"actor_user_id"`
+	ActorEmail    string    `db:"actor_email"`
+	Reason        string    `db:"reason"`
+	ExpiresAt     time.Time `db:"expires_at"`
+	CreatedAt     time.Time `db:"created_at"`
+}
+
+// ImpersonationRequestRecord representa a linha da tabela
+// impersonation_requests: uma requisição feita com o token.
+type ImpersonationRequestRecord struct {
+	ID              int64     `db:"id"`
+	ImpersonationID int64     `db:"impersonation_id"`
+	Method          string    `db:"method"`
+	Path            string    `db:"path"`
+	RemoteIP        string    `db:"remote_ip"`
+	UserAgent       string    `db:"user_agent"`
+	CreatedAt       time.Time `db:"created_at"`
+}
+
+// ImpersonationRepository define os métodos da trilha de auditoria das
+// personificações.
+type ImpersonationRepository interface {
+	// Create registra a emissão de um token e retorna o ID gerado
+	Create(ctx context.Context, rec *ImpersonationRecord) (int64, error)
+	// ListByTenant retorna as personificações de usuários do tenant, das mais recentes às mais antigas
+	ListByTenant(ctx context.Context, tenantID int64) ([]*ImpersonationRecord, error)
+	// GetByID retorna uma personificação do tenant, ou nil se não existir
+	GetByID(ctx context.Context, tenantID, id int64) (*ImpersonationRecord, error)
+	// RecordRequest registra uma requisição do token; sql.ErrNoRows se o token não foi emitido
+	RecordRequest(ctx context.Context, tokenID string, rec *ImpersonationRequestRecord) error
+	// ListRequests retorna as requisições de uma personificação, em ordem
+	ListRequests(ctx context.Context, impersonationID int64) ([]*ImpersonationRequestRecord, error)
+}
+
+// impersonationRepo é a implementação concreta
+type impersonationRepo struct {
+	db *sql.DB
+}
+
+// NewImpersonationRepository instancia um ImpersonationRepository
+func NewImpersonationRepository(db *sql.DB) ImpersonationRepository {
+	return &impersonationRepo{db: db}
+}
+
+const impersonationColumns = `id, token_id, tenant_id, user_id, actor_tenant_id, actor_user_id, actor_email, reason, expires_at, created_at`
+
+func scanImpersonation(row rowScanner) (*ImpersonationRecord, error) {
+	rec := &ImpersonationRecord{}
+	if err := row.Scan(
+		&rec.ID,
+		&rec.TokenID,
+		&rec.TenantID,
+		&rec.UserID,
+		&rec.ActorTenantID,
+		&rec.ActorUserID,
+		&rec.ActorEmail,
+		&rec.Reason,
+		&rec.ExpiresAt,
+		&rec.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+func (r *impersonationRepo) Create(ctx context.Context, rec *ImpersonationRecord) (int64, error) {
+	query := `
+        INSERT INTO impersonations
+	        (token_id, tenant_id, user_id, actor_tenant_id, actor_user_id, actor_email, reason, expires_at)
+	    VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+    `
+	res, err := r.db.ExecContext(ctx, query,
+		rec.TokenID,
+		rec.TenantID,
+		rec.UserID,
+		rec.ActorTenantID,
+		rec.ActorUserID,
+		rec.ActorEmail,
+		rec.Reason,
+		rec.ExpiresAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (r *impersonationRepo) ListByTenant(ctx context.Context, tenantID int64) ([]*ImpersonationRecord, error) {
+	query := `
+        SELECT ` + impersonationColumns + `
+	    FROM impersonations
+	    WHERE tenant_id = ?
+	    ORDER BY created_at DESC, id DESC
+    `
+	rows, err := r.db.QueryContext(ctx, query, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*ImpersonationRecord
+	for rows.Next() {
+		rec, err := scanImpersonation(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+func (r *impersonationRepo) GetByID(ctx context.Context, tenantID, id int64) (*ImpersonationRecord, error) {
+	query := `
+        SELECT ` + impersonationColumns + `
+	    FROM impersonations
+	    WHERE id = ? AND tenant_id = ?
+    `
+	rec, err := scanImpersonation(r.db.QueryRowContext(ctx, query, id, tenantID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return rec, err
+}
+
+func (r *impersonationRepo) RecordRequest(ctx context.Context, tokenID string, rec *ImpersonationRequestRecord) error {
+	// o SELECT só encontra tokens registrados em Create
+	query := `
+        INSERT INTO impersonation_requests (impersonation_id, method, path, remote_ip, user_agent)
+	    SELECT id, ?, ?, ?, ?
+	    FROM impersonations
+	    WHERE token_id = ?
+    `
+	res, err := r.db.ExecContext(ctx, query,
+		rec.Method,
+		rec.Path,
+		rec.RemoteIP,
+		rec.UserAgent,
+		tokenID,
+	)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+func (r *impersonationRepo) ListRequests(ctx context.Context, impersonationID int64) ([]*ImpersonationRequestRecord, error) {
+	query := `
+        SELECT id, impersonation_id, method, path, remote_ip, user_agent, created_at
+	    FROM impersonation_requests
+	    WHERE impersonation_id = ?
+	    ORDER BY id
+    `
+	rows, err := r.db.QueryContext(ctx, query, impersonationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*ImpersonationRequestRecord
+	for rows.Next() {
+		rec := &ImpersonationRequestRecord{}
+		if err := rows.Scan(
+			&rec.ID,
+			&rec.ImpersonationID,
+			&rec.Method,
+			&rec.Path,
+			&rec.RemoteIP,
+			&rec.UserAgent,
+			&rec.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,318 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// OAuthClientRecord representa a linha da tabela oauth_clients.
+// Clientes públicos não têm segredo (SecretHash nil).
+type OAuthClientRecord struct {
+	ID           int64     `db:"id"`
+	TenantID     int64     `db:"tenant_id"`
+	ClientID     string    `db:"client_id"`
+	SecretHash   []byte    `db:"secret_hash"`
+	Name         string    `db:"name"`
+	RedirectURIs []string  `db:"redirect_uris"`
+	GrantTypes   []string  `db:"grant_types"`
+	Scopes       []string  `db:"scopes"`
+	CreatedBy    *int64    `db:"created_by"`
+	CreatedAt    time.Time `db:"created_at"`
+	UpdatedAt    time.Time `db:"updated_at"`
+}
+
+// Confidential indica se o cliente se autentica com segredo
+func (c *OAuthClientRecord) Confidential() bool {
+	return len(c.SecretHash) > 0
+}
+
+// AuthorizationCodeRecord representa a linha da tabela oauth_authorization_codes.
+// Apenas o hash SHA-256 do código é persistido.
+type AuthorizationCodeRecord struct {
+	ID            int64      `db:"id"`
+	CodeHash      []byte     `db:"code_hash"`
+	ClientID      string     `db:"client_id"`
+	TenantID      int64      `db:"tenant_id"`
+	UserID        int64      `db:"user_id"`
+	RedirectURI   string     `db:"redirect_uri"`
+	Scopes        []string   `db:"scopes"`
+	CodeChallenge string     `db:"code_challenge"`
+	SessionID     string     `db:"session_id"`
+	ExpiresAt     time.Time  `db:"expires_at"`
+	UsedAt        *time.Time `db:"used_at"`
+	CreatedAt     time.Time  `db:"created_at"`
+}
+
+// OAuthRepository define os métodos para acesso aos clientes OAuth e aos
+// códigos de autorização emitidos para eles.
+type OAuthRepository interface {
+	// ListClients retorna os clientes de um tenant
+	ListClients(ctx context.Context, tenantID int64) ([]*OAuthClientRecord, error)
+	// GetClient retorna um cliente do tenant, ou nil se não existir
+	GetClient(ctx context.Context, tenantID, id int64) (*OAuthClientRecord, error)
+	// GetClientByClientID retorna um cliente pelo client_id público, ou nil se não existir
+	GetClientByClientID(ctx context.Context, clientID string) (*OAuthClientRecord, error)
+	// CreateClient insere um cliente e retorna o ID gerado
+	CreateClient(ctx context.Context, rec *OAuthClientRecord) (int64, error)
+	// DeleteClient remove um cliente do tenant, encerrando suas sessões
+	DeleteClient(ctx context.Context, tenantID, id int64) error
+
+	// CreateCode insere um código de autorização
+	CreateCode(ctx context.Context, rec *AuthorizationCodeRecord) (int64, error)
+	// GetCodeByHash retorna um código pelo hash, ou nil se não existir
+	GetCodeByHash(ctx context.Context, hash []byte) (*AuthorizationCodeRecord, error)
+	// ConsumeCode marca o código como usado; retorna false se ele já tinha sido usado
+	ConsumeCode(ctx context.Context, id int64) (bool, error)
+	// AttachSession registra a sessão aberta com o código
+	AttachSession(ctx context.Context, id int64, sessionID string) error
+}
+
+// oauthRepo é a implementação concreta
+type oauthRepo struct {
+	db *sql.DB
+}
+
+// NewOAuthRepository instancia um OAuthRepository
+func NewOAuthRepository(db *sql.DB) OAuthRepository {
+	return &oauthRepo{db: db}
+}
+
+const oauthClientColumns = `id, tenant_id, client_id, secret_hash, name, redirect_uris, grant_types, scopes, created_by, created_at, updated_at`
+
+func scanOAuthClient(row rowScanner) (*OAuthClientRecord, error) {
+	rec := &OAuthClientRecord{}
+	var (
+		redirectURIs, grantTypes, scopes []byte
+		createdBy                        sql.NullInt64
+	)
+
+	if err := row.Scan(
+		&rec.ID,
+		&rec.TenantID,
+		&rec.ClientID,
+		&rec.SecretHash,
+		&rec.Name,
+		&redirectURIs,
+		&grantTypes,
+		&scopes,
+		&createdBy,
+		&rec.CreatedAt,
+		&rec.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := unmarshalJSONColumn(redirectURIs, &rec.RedirectURIs); err != nil {
+		return nil, err
+	}
+	if err := unmarshalJSONColumn(grantTypes, &rec.GrantTypes); err != nil {
+		return nil, err
+	}
+	if err := unmarshalJSONColumn(scopes, &rec.Scopes); err != nil {
+		return nil, err
+	}
+	if createdBy.Valid {
+		rec.CreatedBy = &createdBy.Int64
+	}
+	return rec, nil
+}
+
+func (r *oauthRepo) ListClients(ctx context.Context, tenantID int64) ([]*OAuthClientRecord, error) {
+	query := `
+        SELECT ` + oauthClientColumns + `
+	    FROM oauth_clients
+	    WHERE tenant_id = ?
+	    ORDER BY id
+    `
+	rows, err := r.db.QueryContext(ctx, query, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*OAuthClientRecord
+	for rows.Next() {
+		rec, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+func (r *oauthRepo) GetClient(ctx context.Context, tenantID, id int64) (*OAuthClientRecord, error) {
+	query := `
+        SELECT ` + oauthClientColumns + `
+	    FROM oauth_clients
+	    WHERE id = ? AND tenant_id = ?
+    `
+	rec, err := scanOAuthClient(r.db.QueryRowContext(ctx, query, id, tenantID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return rec, err
+}
+
+func (r *oauthRepo) GetClientByClientID(ctx context.Context, clientID string) (*OAuthClientRecord, error) {
+	query := `
+        SELECT ` + oauthClientColumns + `
+	    FROM oauth_clients
+	    WHERE client_id = ?
+    `
+	rec, err := scanOAuthClient(r.db.QueryRowContext(ctx, query, clientID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return rec, err
+}
+
+func (r *oauthRepo) CreateClient(ctx context.Context, rec *OAuthClientRecord) (int64, error) {
+	redirectURIs, err := marshalJSONColumn(rec.RedirectURIs)
+	if err != nil {
+		return 0, err
+	}
+	grantTypes, err := marshalJSONColumn(rec.GrantTypes)
+	if err != nil {
+		return 0, err
+	}
+	scopes, err := marshalJSONColumn(rec.Scopes)
+	if err != nil {
+		return 0, err
+	}
+
+	var createdBy sql.NullInt64
+	if rec.CreatedBy != nil {
+		createdBy = sql.NullInt64{Int64: *rec.CreatedBy, Valid: true}
+	}
+
+	var secretHash any
+	if rec.Confidential() {
+		secretHash = rec.SecretHash
+	}
+
+	query := `
+        INSERT INTO oauth_clients (tenant_id, client_id, secret_hash, name, redirect_uris, grant_types, scopes, created_by)
+	    VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+    `
+	res, err := r.db.ExecContext(ctx, query,
+		rec.TenantID,
+		rec.ClientID,
+		secretHash,
+		rec.Name,
+		redirectURIs,
+		grantTypes,
+		scopes,
+		createdBy,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (r *oauthRepo) DeleteClient(ctx context.Context, tenantID, id int64) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM oauth_clients WHERE id = ? AND tenant_id = ?`, id, tenantID)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+func (r *oauthRepo) CreateCode(ctx context.Context, rec *AuthorizationCodeRecord) (int64, error) {
+	scopes, err := marshalJSONColumn(rec.Scopes)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `
+        INSERT INTO oauth_authorization_codes
+	        (code_hash, client_id, tenant_id, user_id, redirect_uri, scopes, code_challenge, expires_at)
+	    VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+    `
+	res, err := r.db.ExecContext(ctx, query,
+		rec.CodeHash,
+		rec.ClientID,
+		rec.TenantID,
+		rec.UserID,
+		rec.RedirectURI,
+		scopes,
+		rec.CodeChallenge,
+		rec.ExpiresAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (r *oauthRepo) GetCodeByHash(ctx context.Context, hash []byte) (*AuthorizationCodeRecord, error) {
+	query := `
+        SELECT id, code_hash, client_id, tenant_id, user_id, redirect_uri, scopes,
+	           code_challenge, session_id, expires_at, used_at, created_at
+	    FROM oauth_authorization_codes
+	    WHERE code_hash = ?
+    `
+
+	rec := &AuthorizationCodeRecord{}
+	var (
+		scopes    []byte
+		sessionID sql.NullString
+		usedAt    sql.NullTime
+	)
+
+	err := r.db.QueryRowContext(ctx, query, hash).Scan(
+		&rec.ID,
+		&rec.CodeHash,
+		&rec.ClientID,
+		&rec.TenantID,
+		&rec.UserID,
+		&rec.RedirectURI,
+		&scopes,
+		&rec.CodeChallenge,
+		&sessionID,
+		&rec.ExpiresAt,
+		&usedAt,
+		&rec.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if err := unmarshalJSONColumn(scopes, &rec.Scopes); err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		rec.UsedAt = &usedAt.Time
+	}
+	rec.SessionID = sessionID.String
+	return rec, nil
+}
+
+func (r *oauthRepo) ConsumeCode(ctx context.Context, id int64) (bool, error) {
+	// a condição em used_at torna o consumo atômico entre requisições concorrentes
+	query := `
+        UPDATE oauth_authorization_codes
+	    SET used_at = CURRENT_TIMESTAMP
+	    WHERE id = ? AND used_at IS NULL
+    `
+	res, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return count == 1, nil
+}
+
+func (r *oauthRepo) AttachSession(ctx context.Context, id int64, sessionID string) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE oauth_authorization_codes SET session_id = ? WHERE id = ?`, sessionID, id)
+	return err
+}
@@ -0,0 +1,426 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// SCIMGroupRecord representa a linha da tabela scim_groups. Os grupos são
+// de um IdP do tenant, e seus nomes são mapeados em papéis como os grupos
+// recebidos no login.
+type SCIMGroupRecord struct {
+	ID          int64     `db:"id"`
+	TenantID    int64     `db:"tenant_id"`
+	IdPID       int64     `db:"idp_id"`
+	DisplayName string    `db:"display_name"`
+	ExternalID  string    `db:"external_id"`
+	CreatedAt   time.Time `db:"created_at"`
+	UpdatedAt   time.Time `db:"updated_at"`
+}
+
+// SCIMMemberRecord representa um membro de um grupo
+type SCIMMemberRecord struct {
+	UserID   int64  `db:"user_id"`
+	UserName string `db:"user_name"`
+}
+
+// SCIMUserFilter restringe a listagem de usuários; campos vazios não filtram
+type SCIMUserFilter struct {
+	ID         int64
+	UserName   string
+	ExternalID string
+	Email      string
+	Active     *bool
+}
+
+// SCIMGroupFilter restringe a listagem de grupos; campos vazios não filtram
+type SCIMGroupFilter struct {
+	ID          int64
+	DisplayName string
+	ExternalID  string
+	MemberID    int64
+}
+
+// SCIMRepository define os métodos de acesso aos usuários e grupos
+// provisionados por SCIM. Tudo é restrito a um IdP do tenant.
+type SCIMRepository interface {
+	// ListUsers retorna uma página dos usuários e o total que atende ao filtro
+	ListUsers(ctx context.Context, tenantID, idpID int64, filter SCIMUserFilter, offset, limit int) ([]*UserRecord, int, error)
+	// GetUser retorna um usuário do IdP, ou nil se não existir
+	GetUser(ctx context.Context, tenantID, idpID, id int64) (*UserRecord, error)
+	// UpsertUser cria o usuário e retorna seu ID. Um usuário que já fez login
+	// com o mesmo subject é assumido pelo SCIM em vez de duplicado.
+	UpsertUser(ctx context.Context, rec *UserRecord) (int64, error)
+	// UpdateUser atualiza o perfil e o status de um usuário
+	UpdateUser(ctx context.Context, rec *UserRecord) error
+	// DeleteUser remove um usuário do IdP
+	DeleteUser(ctx context.Context, tenantID, idpID, id int64) error
+
+	// ListGroups retorna uma página dos grupos e o total que atende ao filtro
+	ListGroups(ctx context.Context, tenantID, idpID int64, filter SCIMGroupFilter, offset, limit int) ([]*SCIMGroupRecord, int, error)
+	// GetGroup retorna um grupo do IdP, ou nil se não existir
+	GetGroup(ctx context.Context, tenantID, idpID, id int64) (*SCIMGroupRecord, error)
+	// CreateGroup insere um grupo e retorna o ID gerado
+	CreateGroup(ctx context.Context, rec *SCIMGroupRecord) (int64, error)
+	// UpdateGroup atualiza o nome e o external id de um grupo
+	UpdateGroup(ctx context.Context, rec *SCIMGroupRecord) error
+	// DeleteGroup remove um grupo do IdP e seus vínculos
+	DeleteGroup(ctx context.Context, tenantID, idpID, id int64) error
+
+	// ListMembers retorna os membros de um grupo
+	ListMembers(ctx context.Context, groupID int64) ([]*SCIMMemberRecord, error)
+	// AddMembers inclui usuários no grupo; usuários de outro IdP são ignorados
+	AddMembers(ctx context.Context, groupID int64, userIDs []int64) error
+	// RemoveMembers retira usuários do grupo
+	RemoveMembers(ctx context.Context, groupID int64, userIDs []int64) error
+	// ListUserGroups retorna os grupos de um usuário
+	ListUserGroups(ctx context.Context, userID int64) ([]*SCIMGroupRecord, error)
+}
+
+// scimRepo é a implementação concreta
+type scimRepo struct {
+	db *sql.DB
+}
+
+// NewSCIMRepository instancia um SCIMRepository
+func NewSCIMRepository(db *sql.DB) SCIMRepository {
+	return &scimRepo{db: db}
+}
+
+func (r *scimRepo) ListUsers(ctx context.Context, tenantID, idpID int64, filter SCIMUserFilter, offset, limit int) ([]*UserRecord, int, error) {
+	where := []string{"tenant_id = ?", "idp_id = ?"}
+	args := []any{tenantID, idpID}
+
+	if filter.ID != 0 {
+		where, args = append(where, "id = ?"), append(args, filter.ID)
+	}
+	if filter.UserName != "" {
+		where, args = append(where, "user_name = ?"), append(args, filter.UserName)
+	}
+	if filter.ExternalID != "" {
+		where, args = append(where, "external_id = ?"), append(args, filter.ExternalID)
+	}
+	if filter.Email != "" {
+		where, args = append(where, "email = ?"), append(args, filter.Email)
+	}
+	if filter.Active != nil {
+		where, args = append(where, "active = ?"), append(args, *filter.Active)
+	}
+
+	cond := strings.Join(where, " AND ")
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE `+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+        SELECT ` + userColumns + `
+	    FROM users
+	    WHERE ` + cond + `
+	    ORDER BY id
+	    LIMIT ? OFFSET ?
+    `
+	rows, err := r.db.QueryContext(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var out []*UserRecord
+	for rows.Next() {
+		rec, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		out = append(out, rec)
+	}
+	return out, total, rows.Err()
+}
+
+func (r *scimRepo) GetUser(ctx context.Context, tenantID, idpID, id int64) (*UserRecord, error) {
+	query := `
+        SELECT ` + userColumns + `
+	    FROM users
+	    WHERE id = ? AND tenant_id = ? AND idp_id = ?
+    `
+	rec, err := scanUser(r.db.QueryRowContext(ctx, query, id, tenantID, idpID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return rec, err
+}
+
+func (r *scimRepo) UpsertUser(ctx context.Context, rec *UserRecord) (int64, error) {
+	// LAST_INSERT_ID(id) faz o LastInsertId devolver o ID da linha atualizada
+	query := `
+        INSERT INTO users (tenant_id, idp_id, subject, user_name, external_id, email, name, active)
+	    VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	    ON DUPLICATE KEY UPDATE
+	        id = LAST_INSERT_ID(id),
+	        user_name = VALUES(user_name),
+	        external_id = VALUES(external_id),
+	        email = VALUES(email),
+	        name = VALUES(name),
+	        active = VALUES(active)
+    `
+	res, err := r.db.ExecContext(ctx, query,
+		rec.TenantID,
+		rec.IdPID,
+		rec.Subject,
+		nullString(rec.UserName),
+		nullString(rec.ExternalID),
+		rec.Email,
+		rec.Name,
+		rec.Active,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (r *scimRepo) UpdateUser(ctx context.Context, rec *UserRecord) error {
+	// sem expectAffected: um PUT sem mudanças não altera nenhuma linha
+	query := `
+        UPDATE users
+	    SET user_name = ?, external_id = ?, email = ?, name = ?, active = ?
+	    WHERE id = ? AND tenant_id = ? AND idp_id = ?
+    `
+	_, err := r.db.ExecContext(ctx, query,
+		nullString(rec.UserName),
+		nullString(rec.ExternalID),
+		rec.Email,
+		rec.Name,
+		rec.Active,
+		rec.ID,
+		rec.TenantID,
+		rec.IdPID,
+	)
+	return err
+}
+
+func (r *scimRepo) DeleteUser(ctx context.Context, tenantID, idpID, id int64) error {
+	query := `DELETE FROM users WHERE id = ? AND tenant_id = ? AND idp_id = ?`
+	res, err := r.db.ExecContext(ctx, query, id, tenantID, idpID)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+const scimGroupColumns = `g.id, g.tenant_id, g.idp_id, g.display_name, g.external_id, g.created_at, g.updated_at`
+
+func scanSCIMGroup(row rowScanner) (*SCIMGroupRecord, error) {
+	rec := &SCIMGroupRecord{}
+	var externalID sql.NullString
+
+	if err := row.Scan(
+		&rec.ID,
+		&rec.TenantID,
+		&rec.IdPID,
+		&rec.DisplayName,
+		&externalID,
+		&rec.CreatedAt,
+		&rec.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	rec.ExternalID = externalID.String
+	return rec, nil
+}
+
+func (r *scimRepo) ListGroups(ctx context.Context, tenantID, idpID int64, filter SCIMGroupFilter, offset, limit int) ([]*SCIMGroupRecord, int, error) {
+	where := []string{"g.tenant_id = ?", "g.idp_id = ?"}
+	args := []any{tenantID, idpID}
+
+	if filter.ID != 0 {
+		where, args = append(where, "g.id = ?"), append(args, filter.ID)
+	}
+	if filter.DisplayName != "" {
+		where, args = append(where, "g.display_name = ?"), append(args, filter.DisplayName)
+	}
+	if filter.ExternalID != "" {
+		where, args = append(where, "g.external_id = ?"), append(args, filter.ExternalID)
+	}
+	if filter.MemberID != 0 {
+		where = append(where, "EXISTS (SELECT 1 FROM scim_group_members m WHERE m.group_id = g.id AND m.user_id = ?)")
+		args = append(args, filter.MemberID)
+	}
+
+	cond := strings.Join(where, " AND ")
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM scim_groups g WHERE `+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+        SELECT ` + scimGroupColumns + `
+	    FROM scim_groups g
+	    WHERE ` + cond + `
+	    ORDER BY g.id
+	    LIMIT ? OFFSET ?
+    `
+	rows, err := r.db.QueryContext(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var out []*SCIMGroupRecord
+	for rows.Next() {
+		rec, err := scanSCIMGroup(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		out = append(out, rec)
+	}
+	return out, total, rows.Err()
+}
+
+func (r *scimRepo) GetGroup(ctx context.Context, tenantID, idpID, id int64) (*SCIMGroupRecord, error) {
+	query := `
+        SELECT ` + scimGroupColumns + `
+	    FROM scim_groups g
+	    WHERE g.id = ? AND g.tenant_id = ? AND g.idp_id = ?
+    `
+	rec, err := scanSCIMGroup(r.db.QueryRowContext(ctx, query, id, tenantID, idpID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return rec, err
+}
+
+func (r *scimRepo) CreateGroup(ctx context.Context, rec *SCIMGroupRecord) (int64, error) {
+	query := `
+        INSERT INTO scim_groups (tenant_id, idp_id, display_name, external_id)
+	    VALUES (?, ?, ?, ?)
+    `
+	res, err := r.db.ExecContext(ctx, query, rec.TenantID, rec.IdPID, rec.DisplayName, nullString(rec.ExternalID))
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (r *scimRepo) UpdateGroup(ctx context.Context, rec *SCIMGroupRecord) error {
+	query := `
+        UPDATE scim_groups
+	    SET display_name = ?, external_id = ?
+	    WHERE id = ? AND tenant_id = ? AND idp_id = ?
+    `
+	_, err := r.db.ExecContext(ctx, query, rec.DisplayName, nullString(rec.ExternalID), rec.ID, rec.TenantID, rec.IdPID)
+	return err
+}
+
+func (r *scimRepo) DeleteGroup(ctx context.Context, tenantID, idpID, id int64) error {
+	query := `DELETE FROM scim_groups WHERE id = ? AND tenant_id = ? AND idp_id = ?`
+	res, err := r.db.ExecContext(ctx, query, id, tenantID, idpID)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+func (r *scimRepo) ListMembers(ctx context.Context, groupID int64) ([]*SCIMMemberRecord, error) {
+	query := `
+        SELECT u.id, COALESCE(u.user_name, u.email)
+	    FROM scim_group_members m
+	    JOIN users u ON u.id = m.user_id
+	    WHERE m.group_id = ?
+	    ORDER BY u.id
+    `
+	rows, err := r.db.QueryContext(ctx, query, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*SCIMMemberRecord
+	for rows.Next() {
+		rec := &SCIMMemberRecord{}
+		if err := rows.Scan(&rec.UserID, &rec.UserName); err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+func (r *scimRepo) AddMembers(ctx context.Context, groupID int64, userIDs []int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// o join garante que o usuário é do mesmo IdP do grupo
+	query := `
+        INSERT IGNORE INTO scim_group_members (group_id, user_id)
+	    SELECT g.id, u.id
+	    FROM scim_groups g
+	    JOIN users u ON u.tenant_id = g.tenant_id AND u.idp_id = g.idp_id
+	    WHERE g.id = ? AND u.id = ?
+    `
+	for _, userID := range userIDs {
+		if _, err := tx.ExecContext(ctx, query, groupID, userID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *scimRepo) RemoveMembers(ctx context.Context, groupID int64, userIDs []int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, userID := range userIDs {
+		if _, err := tx.ExecContext(ctx,
+			`DELETE FROM scim_group_members WHERE group_id = ? AND user_id = ?`,
+			groupID, userID,
+		); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *scimRepo) ListUserGroups(ctx context.Context, userID int64) ([]*SCIMGroupRecord, error) {
+	query := `
+        SELECT ` + scimGroupColumns + `
+	    FROM scim_groups g
+	    JOIN scim_group_members m ON m.group_id = g.id
+	    WHERE m.user_id = ?
+	    ORDER BY g.display_name
+    `
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*SCIMGroupRecord
+	for rows.Next() {
+		rec, err := scanSCIMGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+// nullString grava strings vazias como NULL, o que mantém os índices
+// únicos livres para as linhas sem valor
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,177 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// SCIMTokenRecord representa a linha da tabela scim_tokens. Cada token
+// provisiona os usuários de um IdP do tenant; apenas o hash SHA-256 do
+// token é persistido.
+type SCIMTokenRecord struct {
+	ID         int64      `db:"id"`
+	TenantID   int64      `db:"tenant_id"`
+	IdPID      int64      `db:"idp_id"`
+	Name       string     `db:"name"`
+	Prefix     string     `db:"prefix"`
+	TokenHash  []byte     `db:"token_hash"`
+	CreatedBy  *int64     `db:"created_by"`
+	LastUsedAt *time.Time `db:"last_used_at"`
+	RevokedAt  *time.Time `db:"revoked_at"`
+	CreatedAt  time.Time  `db:"created_at"`
+}
+
+// SCIMTokenRepository define os métodos para acesso aos tokens dos clientes SCIM.
+type SCIMTokenRepository interface {
+	// ListByTenant retorna os tokens de um tenant, inclusive os revogados
+	ListByTenant(ctx context.Context, tenantID int64) ([]*SCIMTokenRecord, error)
+	// GetByID retorna um token do tenant, ou nil se não existir
+	GetByID(ctx context.Context, tenantID, id int64) (*SCIMTokenRecord, error)
+	// GetByHash retorna um token pelo hash, ou nil se não existir
+	GetByHash(ctx context.Context, hash []byte) (*SCIMTokenRecord, error)
+	// Create insere um token e retorna o ID gerado
+	Create(ctx context.Context, rec *SCIMTokenRecord) (int64, error)
+	// Revoke revoga um token do tenant, mantendo a primeira revogação
+	Revoke(ctx context.Context, tenantID, id int64) error
+	// TouchLastUsed registra o último uso do token
+	TouchLastUsed(ctx context.Context, id int64, at time.Time) error
+}
+
+// scimTokenRepo é a implementação concreta
+type scimTokenRepo struct {
+	db *sql.DB
+}
+
+// NewSCIMTokenRepository instancia um SCIMTokenRepository
+func NewSCIMTokenRepository(db *sql.DB) SCIMTokenRepository {
+	return &scimTokenRepo{db: db}
+}
+
+const scimTokenColumns = `id, tenant_id, idp_id, name, prefix, token_hash, created_by, last_used_at, revoked_at, created_at`
+
+func scanSCIMToken(row rowScanner) (*SCIMTokenRecord, error) {
+	rec := &SCIMTokenRecord{}
+	var (
+		createdBy           sql.NullInt64
+		lastUsed, revokedAt sql.NullTime
+	)
+
+	if err := row.Scan(
+		&rec.ID,
+		&rec.TenantID,
+		&rec.IdPID,
+		&rec.Name,
+		&rec.Prefix,
+		&rec.TokenHash,
+		&createdBy,
+		&lastUsed,
+		&revokedAt,
+		&rec.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if createdBy.Valid {
+		rec.CreatedBy = &createdBy.Int64
+	}
+	if lastUsed.Valid {
+		rec.LastUsedAt = &lastUsed.Time
+	}
+	if revokedAt.Valid {
+		rec.RevokedAt = &revokedAt.Time
+	}
+	return rec, nil
+}
+
+func (r *scimTokenRepo) ListByTenant(ctx context.Context, tenantID int64) ([]*SCIMTokenRecord, error) {
+	query := `
+        SELECT ` + scimTokenColumns + `
+	    FROM scim_tokens
+	    WHERE tenant_id = ?
+	    ORDER BY id
+    `
+	rows, err := r.db.QueryContext(ctx, query, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*SCIMTokenRecord
+	for rows.Next() {
+		rec, err := scanSCIMToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+func (r *scimTokenRepo) GetByID(ctx context.Context, tenantID, id int64) (*SCIMTokenRecord, error) {
+	query := `
+        SELECT ` + scimTokenColumns + `
+	    FROM scim_tokens
+	    WHERE id = ? AND tenant_id = ?
+    `
+	rec, err := scanSCIMToken(r.db.QueryRowContext(ctx, query, id, tenantID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return rec, err
+}
+
+func (r *scimTokenRepo) GetByHash(ctx context.Context, hash []byte) (*SCIMTokenRecord, error) {
+	query := `
+        SELECT ` + scimTokenColumns + `
+	    FROM scim_tokens
+	    WHERE token_hash = ?
+    `
+	rec, err := scanSCIMToken(r.db.QueryRowContext(ctx, query, hash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return rec, err
+}
+
+func (r *scimTokenRepo) Create(ctx context.Context, rec *SCIMTokenRecord) (int64, error) {
+	var createdBy sql.NullInt64
+	if rec.CreatedBy != nil {
+		createdBy = sql.NullInt64{Int64: *rec.CreatedBy, Valid: true}
+	}
+
+	query := `
+        INSERT INTO scim_tokens (tenant_id, idp_id, name, prefix, token_hash, created_by)
+	    VALUES (?, ?, ?, ?, ?, ?)
+    `
+	res, err := r.db.ExecContext(ctx, query,
+		rec.TenantID,
+		rec.IdPID,
+		rec.Name,
+		rec.Prefix,
+		rec.TokenHash,
+		createdBy,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (r *scimTokenRepo) Revoke(ctx context.Context, tenantID, id int64) error {
+	query := `
+        UPDATE scim_tokens
+	    SET revoked_at = COALESCE(revoked_at, CURRENT_TIMESTAMP)
+	    WHERE id = ? AND tenant_id = ?
+    `
+	res, err := r.db.ExecContext(ctx, query, id, tenantID)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+func (r *scimTokenRepo) TouchLastUsed(ctx context.Context, id int64, at time.Time) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE scim_tokens SET last_used_at = ? WHERE id = ?`, at, id)
+	return err
+}
@@ -0,0 +1,230 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// SessionRecord representa a linha da tabela auth_sessions.
+type SessionRecord struct {
+	ID       string `db:"id"`
+	TenantID int64  `db:"tenant_id"`
+	UserID   int64  `db:"user_id"`
+	Email    string `db:"email"`
+	// ClientID e Scopes só existem em sessões abertas para um cliente OAuth
+	ClientID      string     `db:"client_id"`
+	Scopes        []string   `db:"scopes"`
+	CreatedAt     time.Time  `db:"created_at"`
+	RevokedAt     *time.Time `db:"revoked_at"`
+	RevokedReason string     `db:"revoked_reason"`
+}
+
+// RefreshTokenRecord representa a linha da tabela refresh_tokens.
+// Apenas o hash SHA-256 do token é persistido.
+type RefreshTokenRecord struct {
+	ID        int64      `db:"id"`
+	SessionID string     `db:"session_id"`
+	TokenHash []byte     `db:"token_hash"`
+	ParentID  *int64     `db:"parent_id"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+	CreatedAt time.Time  `db:"created_at"`
+}
+
+// SessionRepository define os métodos para acesso às sessões e seus refresh tokens.
+type SessionRepository interface {
+	// CreateSession insere uma nova sessão
+	CreateSession(ctx context.Context, rec *SessionRecord) error
+	// GetSession retorna uma sessão pelo ID, ou nil se não existir
+	GetSession(ctx context.Context, id string) (*SessionRecord, error)
+	// RevokeSession marca a sessão como revogada, mantendo a primeira revogação
+	RevokeSession(ctx context.Context, id, reason string) error
+	// ListActiveSessions retorna os IDs das sessões não revogadas do usuário
+	ListActiveSessions(ctx context.Context, tenantID, userID int64) ([]string, error)
+	// CreateRefreshToken insere um refresh token e retorna o ID gerado
+	CreateRefreshToken(ctx context.Context, rec *RefreshTokenRecord) (int64, error)
+	// GetRefreshTokenByHash retorna um refresh token pelo hash, ou nil se não existir
+	GetRefreshTokenByHash(ctx context.Context, hash []byte) (*RefreshTokenRecord, error)
+	// MarkRefreshTokenUsed consome o token; retorna false se ele já tinha sido usado
+	MarkRefreshTokenUsed(ctx context.Context, id int64) (bool, error)
+}
+
+// sessionRepo é a implementação concreta
+type sessionRepo struct {
+	db *sql.DB
+}
+
+// NewSessionRepository instancia um SessionRepository
+func NewSessionRepository(db *sql.DB) SessionRepository {
+	return &sessionRepo{db: db}
+}
+
+func (r *sessionRepo) CreateSession(ctx context.Context, rec *SessionRecord) error {
+	var clientID, scopes any
+	if rec.ClientID != "" {
+		b, err := marshalJSONColumn(rec.Scopes)
+		if err != nil {
+			return err
+		}
+		clientID, scopes = rec.ClientID, b
+	}
+
+	query := `
+        INSERT INTO auth_sessions (id, tenant_id, user_id, email, client_id, scopes)
+	    VALUES (?, ?, ?, ?, ?, ?)
+    `
+	_, err := r.db.ExecContext(ctx, query, rec.ID, rec.TenantID, rec.UserID, rec.Email, clientID, scopes)
+	return err
+}
+
+func (r *sessionRepo) GetSession(ctx context.Context, id string) (*SessionRecord, error) {
+	query := `
+        SELECT id, tenant_id, user_id, email, client_id, scopes, created_at, revoked_at, revoked_reason
+	    FROM auth_sessions
+	    WHERE id = ?
+    `
+
+	rec := &SessionRecord{}
+	var (
+		clientID  sql.NullString
+		scopes    []byte
+		revokedAt sql.NullTime
+		reason    sql.NullString
+	)
+
+	err := r.db.QueryRowContext(ctx, query, id).Scan(
+		&rec.ID,
+		&rec.TenantID,
+		&rec.UserID,
+		&rec.Email,
+		&clientID,
+		&scopes,
+		&rec.CreatedAt,
+		&revokedAt,
+		&reason,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if err := unmarshalJSONColumn(scopes, &rec.Scopes); err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		rec.RevokedAt = &revokedAt.Time
+	}
+	rec.ClientID = clientID.String
+	rec.RevokedReason = reason.String
+	return rec, nil
+}
+
+func (r *sessionRepo) RevokeSession(ctx context.Context, id, reason string) error {
+	query := `
+        UPDATE auth_sessions
+	    SET revoked_at = CURRENT_TIMESTAMP, revoked_reason = ?
+	    WHERE id = ? AND revoked_at IS NULL
+    `
+	_, err := r.db.ExecContext(ctx, query, reason, id)
+	return err
+}
+
+func (r *sessionRepo) ListActiveSessions(ctx context.Context, tenantID, userID int64) ([]string, error) {
+	query := `
+        SELECT id
+	    FROM auth_sessions
+	    WHERE tenant_id = ? AND user_id = ? AND revoked_at IS NULL
+    `
+	rows, err := r.db.QueryContext(ctx, query, tenantID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (r *sessionRepo) CreateRefreshToken(ctx context.Context, rec *RefreshTokenRecord) (int64, error) {
+	var parentID sql.NullInt64
+	if rec.ParentID != nil {
+		parentID = sql.NullInt64{Int64: *rec.ParentID, Valid: true}
+	}
+
+	query := `
+        INSERT INTO refresh_tokens (session_id, token_hash, parent_id, expires_at)
+	    VALUES (?, ?, ?, ?)
+    `
+	res, err := r.db.ExecContext(ctx, query, rec.SessionID, rec.TokenHash, parentID, rec.ExpiresAt)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (r *sessionRepo) GetRefreshTokenByHash(ctx context.Context, hash []byte) (*RefreshTokenRecord, error) {
+	query := `
+        SELECT id, session_id, token_hash, parent_id, expires_at, used_at, created_at
+	    FROM refresh_tokens
+	    WHERE token_hash = ?
+    `
+
+	rec := &RefreshTokenRecord{}
+	var (
+		parentID sql.NullInt64
+		usedAt   sql.NullTime
+	)
+
+	err := r.db.QueryRowContext(ctx, query, hash).Scan(
+		&rec.ID,
+		&rec.SessionID,
+		&rec.TokenHash,
+		&parentID,
+		&rec.ExpiresAt,
+		&usedAt,
+		&rec.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if parentID.Valid {
+		rec.ParentID = &parentID.Int64
+	}
+	if usedAt.Valid {
+		rec.UsedAt = &usedAt.Time
+	}
+	return rec, nil
+}
+
+func (r *sessionRepo) MarkRefreshTokenUsed(ctx context.Context, id int64) (bool, error) {
+	// a condição em used_at torna o consumo atômico entre requisições concorrentes
+	query := `
+        UPDATE refresh_tokens
+	    SET used_at = CURRENT_TIMESTAMP
+	    WHERE id = ? AND used_at IS NULL
+    `
+	res, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return count == 1, nil
+}
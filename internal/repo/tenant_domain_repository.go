@@ -0,0 +1,160 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// TenantDomainRecord representa a linha da tabela tenant_domains.
+type TenantDomainRecord struct {
+	ID                int64      `db:"id"`
+	TenantID          int64      `db:"tenant_id"`
+	Domain            string     `db:"domain"`
+	VerificationToken string     `db:"verification_token"`
+	VerifiedAt        *time.Time `db:"verified_at"`
+	CreatedAt         time.Time  `db:"created_at"`
+}
+
+// TenantDomainRepository define os métodos para acesso aos domínios dos tenants.
+type TenantDomainRepository interface {
+	// ListByTenant retorna os domínios de um tenant, verificados ou não
+	ListByTenant(ctx context.Context, tenantID int64) ([]*TenantDomainRecord, error)
+	// GetByID retorna um domínio do tenant, ou nil se não existir
+	GetByID(ctx context.Context, tenantID, id int64) (*TenantDomainRecord, error)
+	// Create insere um domínio pendente de verificação e retorna o ID gerado
+	Create(ctx context.Context, rec *TenantDomainRecord) (int64, error)
+	// MarkVerified marca o domínio do tenant como verificado
+	MarkVerified(ctx context.Context, tenantID, id int64) error
+	// Delete remove um domínio do tenant pelo ID
+	Delete(ctx context.Context, tenantID, id int64) error
+	// FindTenant retorna o tenant dono do domínio, ou 0 se nenhum for.
+	// Vale o domínio principal do tenant (tenants.domain) ou um domínio verificado.
+	FindTenant(ctx context.Context, domain string) (int64, error)
+}
+
+// tenantDomainRepo é a implementação concreta
+type tenantDomainRepo struct {
+	db *sql.DB
+}
+
+// NewTenantDomainRepository instancia um TenantDomainRepository
+func NewTenantDomainRepository(db *sql.DB) TenantDomainRepository {
+	return &tenantDomainRepo{db: db}
+}
+
+const tenantDomainColumns = `id, tenant_id, domain, verification_token, verified_at, created_at`
+
+func scanTenantDomain(row rowScanner) (*TenantDomainRecord, error) {
+	rec := &TenantDomainRecord{}
+	var verifiedAt sql.NullTime
+
+	if err := row.Scan(
+		&rec.ID,
+		&rec.TenantID,
+		&rec.Domain,
+		&rec.VerificationToken,
+		&verifiedAt,
+		&rec.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if verifiedAt.Valid {
+		rec.VerifiedAt = &verifiedAt.Time
+	}
+	return rec, nil
+}
+
+func (r *tenantDomainRepo) ListByTenant(ctx context.Context, tenantID int64) ([]*TenantDomainRecord, error) {
+	query := `
+        SELECT ` + tenantDomainColumns + `
+	    FROM tenant_domains
+	    WHERE tenant_id = ?
+	    ORDER BY domain
+    `
+	rows, err := r.db.QueryContext(ctx, query, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*TenantDomainRecord
+	for rows.Next() {
+		rec, err := scanTenantDomain(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+func (r *tenantDomainRepo) GetByID(ctx context.Context, tenantID, id int64) (*TenantDomainRecord, error) {
+	query := `
+        SELECT ` + tenantDomainColumns + `
+	    FROM tenant_domains
+	    WHERE id = ? AND tenant_id = ?
+    `
+	rec, err := scanTenantDomain(r.db.QueryRowContext(ctx, query, id, tenantID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return rec, err
+}
+
+func (r *tenantDomainRepo) Create(ctx context.Context, rec *TenantDomainRecord) (int64, error) {
+	query := `
+        INSERT INTO tenant_domains (tenant_id, domain, verification_token)
+	    VALUES (?, ?, ?)
+    `
+	res, err := r.db.ExecContext(ctx, query, rec.TenantID, rec.Domain, rec.VerificationToken)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (r *tenantDomainRepo) MarkVerified(ctx context.Context, tenantID, id int64) error {
+	query := `
+        UPDATE tenant_domains
+	    SET verified_at = COALESCE(verified_at, CURRENT_TIMESTAMP)
+	    WHERE id = ? AND tenant_id = ?
+    `
+	res, err := r.db.ExecContext(ctx, query, id, tenantID)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+func (r *tenantDomainRepo) Delete(ctx context.Context, tenantID, id int64) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM tenant_domains WHERE id = ? AND tenant_id = ?`, id, tenantID)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+func (r *tenantDomainRepo) FindTenant(ctx context.Context, domain string) (int64, error) {
+	var tenantID int64
+
+	// o domínio principal é cadastrado pelo operador e tem precedência
+	err := r.db.QueryRowContext(ctx,
+		`SELECT id FROM tenants WHERE domain = ? ORDER BY id LIMIT 1`, domain,
+	).Scan(&tenantID)
+	if err == nil {
+		return tenantID, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	err = r.db.QueryRowContext(ctx,
+		`SELECT tenant_id FROM tenant_domains WHERE verified_domain = ?`, domain,
+	).Scan(&tenantID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return tenantID, err
+}
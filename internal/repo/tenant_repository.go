@@ -0,0 +1,85 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// TenantRecord representa a linha da tabela tenants.
+type TenantRecord struct {
+	ID         int64     `db:"id"`
+	Slug       string    `db:"slug"`
+	Name       string    `db:"name"`
+	Domain     string    `db:"domain"`
+	CustomHost string    `db:"custom_host"`
+	CreatedAt  time.Time `db:"created_at"`
+	UpdatedAt  time.Time `db:"updated_at"`
+}
+
+// TenantRepository define os métodos para localizar tenants.
+// Todos retornam nil quando o tenant não existe.
+type TenantRepository interface {
+	// GetByID retorna um tenant pelo ID
+	GetByID(ctx context.Context, id int64) (*TenantRecord, error)
+	// GetBySlug retorna um tenant pelo slug
+	GetBySlug(ctx context.Context, slug string) (*TenantRecord, error)
+	// GetByCustomHost retorna o tenant mapeado para o host
+	GetByCustomHost(ctx context.Context, host string) (*TenantRecord, error)
+}
+
+// tenantRepo é a implementação concreta
+type tenantRepo struct {
+	db *sql.DB
+}
+
+// NewTenantRepository instancia um TenantRepository
+func NewTenantRepository(db *sql.DB) TenantRepository {
+	return &tenantRepo{db: db}
+}
+
+const tenantColumns = `id, slug, name, domain, custom_host, created_at, updated_at`
+
+func (r *tenantRepo) GetByID(ctx context.Context, id int64) (*TenantRecord, error) {
+	return r.getBy(ctx, "id", id)
+}
+
+func (r *tenantRepo) GetBySlug(ctx context.Context, slug string) (*TenantRecord, error) {
+	return r.getBy(ctx, "slug", slug)
+}
+
+func (r *tenantRepo) GetByCustomHost(ctx context.Context, host string) (*TenantRecord, error) {
+	return r.getBy(ctx, "custom_host", host)
+}
+
+// getBy busca um tenant por uma coluna única; column nunca vem do usuário
+func (r *tenantRepo) getBy(ctx context.Context, column string, value any) (*TenantRecord, error) {
+	query := `
+        SELECT ` + tenantColumns + `
+	    FROM tenants
+	    WHERE ` + column + ` = ?
+    `
+
+	rec := &TenantRecord{}
+	var domain, customHost sql.NullString
+
+	err := r.db.QueryRowContext(ctx, query, value).Scan(
+		&rec.ID,
+		&rec.Slug,
+		&rec.Name,
+		&domain,
+		&customHost,
+		&rec.CreatedAt,
+		&rec.UpdatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	rec.Domain = domain.String
+	rec.CustomHost = customHost.String
+	return rec, nil
+}
@@ -0,0 +1,56 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// UsedTokenRepository guarda os valores de uso único já consumidos (states
+// de login e asserções SAML), para que todas as réplicas os recusem.
+type UsedTokenRepository interface {
+	// Consume marca o hash como usado até expiresAt; retorna false se ele
+	// já tinha sido usado e ainda não expirou
+	Consume(ctx context.Context, hash []byte, expiresAt, now time.Time) (bool, error)
+	// DeleteExpired apaga os hashes expirados até now
+	DeleteExpired(ctx context.Context, now time.Time) error
+}
+
+// usedTokenRepo é a implementação concreta
+type usedTokenRepo struct {
+	db *sql.DB
+}
+
+// NewUsedTokenRepository instancia um UsedTokenRepository
+func NewUsedTokenRepository(db *sql.DB) UsedTokenRepository {
+	return &usedTokenRepo{db: db}
+}
+
+func (r *usedTokenRepo) Consume(ctx context.Context, hash []byte, expiresAt, now time.Time) (bool, error) {
+	// o upsert torna o consumo atômico entre réplicas: insere (1 linha
+	// afetada), reaproveita um hash expirado (2) ou não muda nada (0)
+	query := `
+        INSERT INTO used_tokens (token_hash, expires_at)
+	    VALUES (?, ?)
+	    ON DUPLICATE KEY UPDATE expires_at = IF(expires_at <= ?, VALUES(expires_at), expires_at)
+    `
+	res, err := r.db.ExecContext(ctx, query, hash, expiresAt, now)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (r *usedTokenRepo) DeleteExpired(ctx context.Context, now time.Time) error {
+	query := `
+        DELETE FROM used_tokens
+	    WHERE expires_at <= ?
+    `
+	_, err := r.db.ExecContext(ctx, query, now)
+	return err
+}
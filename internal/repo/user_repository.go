@@ -0,0 +1,133 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// UserRecord representa a linha da tabela users. Um usuário é identificado
+// pelo subject que o IdP atribuiu a ele (tenant, idp, subject).
+type UserRecord struct {
+	ID       int64  `db:"id"`
+	TenantID int64  `db:"tenant_id"`
+	IdPID    int64  `db:"idp_id"`
+	Subject  string `db:"subject"`
+	// UserName e ExternalID só existem em usuários provisionados por SCIM
+	UserName    string     `db:"user_name"`
+	ExternalID  string     `db:"external_id"`
+	Email       string     `db:"email"`
+	Name        string     `db:"name"`
+	Groups      []string   `db:"groups"`
+	Active      bool       `db:"active"`
+	LastLoginAt *time.Time `db:"last_login_at"`
+	CreatedAt   time.Time  `db:"created_at"`
+	UpdatedAt   time.Time  `db:"updated_at"`
+}
+
+// UserRepository define os métodos para acesso aos usuários.
+type UserRepository interface {
+	// UpsertLogin cria ou atualiza o perfil do usuário no login e retorna seu ID
+	UpsertLogin(ctx context.Context, rec *UserRecord) (int64, error)
+	// GetByID retorna um usuário do tenant, ou nil se não existir
+	GetByID(ctx context.Context, tenantID, id int64) (*UserRecord, error)
+}
+
+// userRepo é a implementação concreta
+type userRepo struct {
+	db *sql.DB
+}
+
+// NewUserRepository instancia um UserRepository
+func NewUserRepository(db *sql.DB) UserRepository {
+	return &userRepo{db: db}
+}
+
+func (r *userRepo) UpsertLogin(ctx context.Context, rec *UserRecord) (int64, error) {
+	var groups any
+	if len(rec.Groups) > 0 {
+		b, err := marshalJSONColumn(rec.Groups)
+		if err != nil {
+			return 0, err
+		}
+		groups = b
+	}
+
+	// LAST_INSERT_ID(id) faz o LastInsertId devolver o ID da linha atualizada
+	query := `
+        INSERT INTO users (tenant_id, idp_id, subject, email, name, ` + "`groups`" + `, last_login_at)
+	    VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	    ON DUPLICATE KEY UPDATE
+	        id = LAST_INSERT_ID(id),
+	        email = VALUES(email),
+	        name = VALUES(name),
+	        ` + "`groups`" + ` = VALUES(` + "`groups`" + `),
+	        last_login_at = CURRENT_TIMESTAMP
+    `
+	res, err := r.db.ExecContext(ctx, query,
+		rec.TenantID,
+		rec.IdPID,
+		rec.Subject,
+		rec.Email,
+		rec.Name,
+		groups,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (r *userRepo) GetByID(ctx context.Context, tenantID, id int64) (*UserRecord, error) {
+	query := `
+        SELECT ` + userColumns + `
+	    FROM users
+	    WHERE id = ? AND tenant_id = ?
+    `
+	rec, err := scanUser(r.db.QueryRowContext(ctx, query, id, tenantID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return rec, err
+}
+
+const userColumns = `id, tenant_id, idp_id, subject, user_name, external_id, email, name, ` + "`groups`" + `, active, last_login_at, created_at, updated_at`
+
+// scanUser lê uma linha no formato de userColumns
+func scanUser(row rowScanner) (*UserRecord, error) {
+	rec := &UserRecord{}
+	var (
+		userName, externalID sql.NullString
+		groups               []byte
+		lastLogin            sql.NullTime
+	)
+
+	if err := row.Scan(
+		&rec.ID,
+		&rec.TenantID,
+		&rec.IdPID,
+		&rec.Subject,
+		&userName,
+		&externalID,
+		&rec.Email,
+		&rec.Name,
+		&groups,
+		&rec.Active,
+		&lastLogin,
+		&rec.CreatedAt,
+		&rec.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := unmarshalJSONColumn(groups, &rec.Groups); err != nil {
+		return nil, err
+	}
+
+	rec.UserName = userName.String
+	rec.ExternalID = externalID.String
+	if lastLogin.Valid {
+		rec.LastLoginAt = &lastLogin.Time
+	}
+	return rec, nil
+}
@@ -0,0 +1,100 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Origem dos papéis de um usuário
+const (
+	// RoleSourceIdP são papéis mapeados dos grupos do IdP a cada login
+	RoleSourceIdP = "idp"
+	// RoleSourceManual são papéis atribuídos pela API de administração
+	RoleSourceManual = "manual"
+	// RoleSourceSCIM são papéis mapeados dos grupos provisionados por SCIM
+	RoleSourceSCIM = "scim"
+)
+
+// UserRoleRepository define os métodos para acesso aos papéis dos usuários.
+type UserRoleRepository interface {
+	// ListRoles retorna os papéis distintos do usuário no tenant, de todas as origens
+	ListRoles(ctx context.Context, tenantID, userID int64) ([]string, error)
+	// ListRolesBySource retorna os papéis do usuário de uma origem
+	ListRolesBySource(ctx context.Context, tenantID, userID int64, source string) ([]string, error)
+	// ReplaceRoles substitui os papéis do usuário de uma origem
+	ReplaceRoles(ctx context.Context, tenantID, userID int64, source string, roles []string) error
+}
+
+// userRoleRepo é a implementação concreta
+type userRoleRepo struct {
+	db *sql.DB
+}
+
+// NewUserRoleRepository instancia um UserRoleRepository
+func NewUserRoleRepository(db *sql.DB) UserRoleRepository {
+	return &userRoleRepo{db: db}
+}
+
+func (r *userRoleRepo) ListRoles(ctx context.Context, tenantID, userID int64) ([]string, error) {
+	query := `
+        SELECT DISTINCT role
+	    FROM user_roles
+	    WHERE tenant_id = ? AND user_id = ?
+	    ORDER BY role
+    `
+	return r.queryRoles(ctx, query, tenantID, userID)
+}
+
+func (r *userRoleRepo) ListRolesBySource(ctx context.Context, tenantID, userID int64, source string) ([]string, error) {
+	query := `
+        SELECT role
+	    FROM user_roles
+	    WHERE tenant_id = ? AND user_id = ? AND source = ?
+	    ORDER BY role
+    `
+	return r.queryRoles(ctx, query, tenantID, userID, source)
+}
+
+func (r *userRoleRepo) ReplaceRoles(ctx context.Context, tenantID, userID int64, source string, roles []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM user_roles WHERE tenant_id = ? AND user_id = ? AND source = ?`,
+		tenantID, userID, source,
+	); err != nil {
+		return err
+	}
+
+	for _, role := range roles {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT IGNORE INTO user_roles (tenant_id, user_id, role, source) VALUES (?, ?, ?, ?)`,
+			tenantID, userID, role, source,
+		); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *userRoleRepo) queryRoles(ctx context.Context, query string, args ...any) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
@@ -0,0 +1,175 @@
+package scim
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Condition is an `attr eq "value"` comparison. Attr is lowercased and a
+// filter on a sub-attribute, `emails[value eq "x"]`, reads "emails.value".
+type Condition struct {
+	Attr  string
+	Value string
+}
+
+// ParseFilter parses the filters provisioning clients send: "eq"
+// comparisons joined by "and". Okta and Azure AD only look resources up by
+// userName, externalId, displayName or membership, so the other operators
+// are refused with invalidFilter.
+func ParseFilter(s string) ([]Condition, *Error) {
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{tokens: tokens}
+	var conds []Condition
+	for {
+		cond, err := p.condition()
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, cond)
+
+		if p.done() {
+			return conds, nil
+		}
+
+		op := p.next()
+		if op.kind != tokenWord || !strings.EqualFold(op.text, "and") {
+			return nil, badRequest(ErrInvalidFilter, "expected \"and\", got %q", op.text)
+		}
+	}
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenOpen
+	tokenClose
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// lex splits a filter into words, quoted strings and brackets
+func lex(s string) ([]token, *Error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		switch ch := s[i]; {
+		case ch == ' ' || ch == '\t':
+			i++
+		case ch == '[':
+			tokens = append(tokens, token{kind: tokenOpen, text: "["})
+			i++
+		case ch == ']':
+			tokens = append(tokens, token{kind: tokenClose, text: "]"})
+			i++
+		case ch == '"':
+			end := i + 1
+			for ; end < len(s) && s[end] != '"'; end++ {
+				if s[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(s) {
+				return nil, badRequest(ErrInvalidFilter, "unterminated string in filter")
+			}
+
+			var v string
+			if err := json.Unmarshal([]byte(s[i:end+1]), &v); err != nil {
+				return nil, badRequest(ErrInvalidFilter, "invalid string %s in filter", s[i:end+1])
+			}
+			tokens = append(tokens, token{kind: tokenString, text: v})
+			i = end + 1
+		default:
+			end := i
+			for end < len(s) && !strings.ContainsRune(" \t[]\"", rune(s[end])) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: s[i:end]})
+			i = end
+		}
+	}
+
+	if len(tokens) == 0 {
+		return nil, badRequest(ErrInvalidFilter, "empty filter")
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *filterParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *filterParser) next() token {
+	if p.done() {
+		return token{kind: tokenWord}
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	return t
+}
+
+// condition parses `attr eq value` or `attr[sub eq value]`
+func (p *filterParser) condition() (Condition, *Error) {
+	attr := p.next()
+	if attr.kind != tokenWord || attr.text == "" {
+		return Condition{}, badRequest(ErrInvalidFilter, "expected an attribute, got %q", attr.text)
+	}
+
+	name := normalizeAttr(attr.text)
+	if !p.done() && p.tokens[p.pos].kind == tokenOpen {
+		p.pos++
+		cond, err := p.condition()
+		if err != nil {
+			return Condition{}, err
+		}
+
+		if p.next().kind != tokenClose {
+			return Condition{}, badRequest(ErrInvalidFilter, "expected \"]\" after the %s filter", name)
+		}
+
+		cond.Attr = name + "." + cond.Attr
+		return cond, nil
+	}
+
+	op := p.next()
+	if op.kind != tokenWord || !strings.EqualFold(op.text, "eq") {
+		return Condition{}, badRequest(ErrInvalidFilter, "operator %q is not supported, only \"eq\" is", op.text)
+	}
+
+	value := p.next()
+	switch {
+	case value.kind == tokenString:
+	case value.kind == tokenWord && value.text != "" && !strings.EqualFold(value.text, "null"):
+		// true, false and numbers
+		value.text = strings.ToLower(value.text)
+	default:
+		return Condition{}, badRequest(ErrInvalidFilter, "expected a value after %s eq", name)
+	}
+
+	return Condition{Attr: name, Value: value.text}, nil
+}
+
+// normalizeAttr lowercases an attribute path and drops the URN of the core
+// schemas, e.g. "urn:ietf:params:scim:schemas:core:2.0:User:userName".
+// Attributes of extension schemas keep their URN.
+func normalizeAttr(s string) string {
+	lower := strings.ToLower(s)
+	for _, schema := range []string{UserSchema, GroupSchema} {
+		if prefix := strings.ToLower(schema) + ":"; strings.HasPrefix(lower, prefix) {
+			return lower[len(prefix):]
+		}
+	}
+	return lower
+}
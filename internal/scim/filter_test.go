@@ -0,0 +1,48 @@
+package scim
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	cases := map[string][]Condition{
+		`userName eq "jane@contoso.com"`: {{Attr: "username", Value: "jane@contoso.com"}},
+		`externalId EQ "00u1" and active eq true`: {
+			{Attr: "externalid", Value: "00u1"},
+			{Attr: "active", Value: "true"},
+		},
+		`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "a \"b\""`: {{Attr: "username", Value: `a "b"`}},
+		`members[value eq "5"]`: {{Attr: "members.value", Value: "5"}},
+		`emails[type eq "work"] and id eq "7"`: {
+			{Attr: "emails.type", Value: "work"},
+			{Attr: "id", Value: "7"},
+		},
+	}
+	for in, want := range cases {
+		got, err := ParseFilter(in)
+		if err != nil {
+			t.Errorf("ParseFilter(%q): %v", in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ParseFilter(%q) = %+v; want %+v", in, got, want)
+		}
+	}
+
+	for _, in := range []string{
+		``,
+		`userName`,
+		`userName co "jane"`,
+		`userName eq "jane" or id eq "1"`,
+		`userName eq null`,
+		`userName eq "jane`,
+		`members[value eq "5"`,
+		`not (userName eq "jane")`,
+	} {
+		_, err := ParseFilter(in)
+		if err == nil || err.ScimType != ErrInvalidFilter {
+			t.Errorf("ParseFilter(%q) should fail with invalidFilter, got %v", in, err)
+		}
+	}
+}
@@ -0,0 +1,491 @@
+package scim
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+)
+
+// PatchRequest is the body of a PATCH request (RFC 7644, section 3.5.2)
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// PatchOperation is one operation of a PATCH request. Azure AD capitalizes
+// the op ("Replace"), so it is matched case-insensitively.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Patch operations
+const (
+	opAdd     = "add"
+	opReplace = "replace"
+	opRemove  = "remove"
+)
+
+// Path is a parsed PATCH path: `attr`, `attr.sub`, `attr[filter]` or
+// `attr[filter].sub`, lowercased
+type Path struct {
+	Attr   string
+	Filter *Condition
+	Sub    string
+}
+
+// ParsePath parses the path of a PATCH operation
+func ParsePath(s string) (Path, *Error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Path{}, badRequest(ErrInvalidPath, "empty path")
+	}
+
+	var p Path
+	if open := strings.IndexByte(s, '['); open >= 0 {
+		end := strings.LastIndexByte(s, ']')
+		if end < open {
+			return Path{}, badRequest(ErrInvalidPath, "invalid path %q", s)
+		}
+
+		conds, err := ParseFilter(s[open+1 : end])
+		if err != nil || len(conds) != 1 {
+			return Path{}, badRequest(ErrInvalidPath, "invalid filter in path %q", s)
+		}
+
+		p.Attr = normalizeAttr(s[:open])
+		p.Filter = &conds[0]
+
+		rest := s[end+1:]
+		if rest != "" {
+			if !strings.HasPrefix(rest, ".") || len(rest) == 1 {
+				return Path{}, badRequest(ErrInvalidPath, "invalid path %q", s)
+			}
+			p.Sub = strings.ToLower(rest[1:])
+		}
+		return p, nil
+	}
+
+	p.Attr = normalizeAttr(s)
+	// the URN of extension schemas has dots in its version, only split
+	// the attributes of the core schemas
+	if !strings.HasPrefix(p.Attr, "urn:") {
+		if dot := strings.IndexByte(p.Attr, '.'); dot >= 0 {
+			p.Attr, p.Sub = p.Attr[:dot], p.Attr[dot+1:]
+		}
+	}
+	return p, nil
+}
+
+// checkOp validates and lowercases the op of an operation
+func checkOp(op *PatchOperation) *Error {
+	op.Op = strings.ToLower(op.Op)
+	switch op.Op {
+	case opAdd, opReplace, opRemove:
+		return nil
+	default:
+		return badRequest(ErrInvalidSyntax, "unsupported patch op %q", op.Op)
+	}
+}
+
+// valueObject returns the attributes of an operation without a path
+func valueObject(op PatchOperation) (map[string]json.RawMessage, *Error) {
+	if op.Op == opRemove {
+		return nil, badRequest(ErrNoTarget, "remove requires a path")
+	}
+
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(op.Value, &obj); err != nil {
+		return nil, badRequest(ErrInvalidValue, "an operation without path requires an object value")
+	}
+	return obj, nil
+}
+
+// Patch applies the operations to the user. Attributes the CRM does not
+// store, such as phone numbers or the enterprise extension, are ignored:
+// clients send whatever their mapping holds.
+func (u *User) Patch(ops []PatchOperation) *Error {
+	for _, op := range ops {
+		if err := checkOp(&op); err != nil {
+			return err
+		}
+
+		if op.Path == "" {
+			obj, err := valueObject(op)
+			if err != nil {
+				return err
+			}
+
+			for attr, value := range obj {
+				path, err := ParsePath(attr)
+				if err != nil {
+					return err
+				}
+				if err := u.set(op.Op, path, value); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		path, err := ParsePath(op.Path)
+		if err != nil {
+			return err
+		}
+
+		if op.Op == opRemove {
+			u.remove(path)
+			continue
+		}
+
+		if err := u.set(op.Op, path, op.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// set adds or replaces an attribute of the user
+func (u *User) set(op string, path Path, value json.RawMessage) *Error {
+	switch path.Attr {
+	case "username":
+		return decodeString(value, &u.UserName)
+	case "externalid":
+		return decodeString(value, &u.ExternalID)
+	case "displayname":
+		return decodeString(value, &u.DisplayName)
+	case "active":
+		active, err := decodeBool(value)
+		if err != nil {
+			return err
+		}
+		u.Active = &active
+		return nil
+	case "name":
+		return u.setName(path.Sub, value)
+	case "emails":
+		return u.setEmails(op, path, value)
+	}
+
+	return nil
+}
+
+func (u *User) setName(sub string, value json.RawMessage) *Error {
+	if u.Name == nil {
+		u.Name = &Name{}
+	}
+
+	switch sub {
+	case "":
+		var n Name
+		if err := json.Unmarshal(value, &n); err != nil {
+			return badRequest(ErrInvalidValue, "name must be an object")
+		}
+		*u.Name = n
+	case "formatted":
+		return decodeString(value, &u.Name.Formatted)
+	case "givenname":
+		return decodeString(value, &u.Name.GivenName)
+	case "familyname":
+		return decodeString(value, &u.Name.FamilyName)
+	}
+
+	return nil
+}
+
+func (u *User) setEmails(op string, path Path, value json.RawMessage) *Error {
+	// emails[type eq "work"].value or emails.value
+	if path.Sub != "" {
+		if path.Sub != "value" {
+			return nil
+		}
+
+		var v string
+		if err := decodeString(value, &v); err != nil {
+			return err
+		}
+
+		i := u.findEmail(path.Filter)
+		if i < 0 {
+			e := Email{Value: v, Primary: len(u.Emails) == 0}
+			if path.Filter != nil && path.Filter.Attr == "type" {
+				e.Type = path.Filter.Value
+			}
+			u.Emails = append(u.Emails, e)
+			return nil
+		}
+
+		u.Emails[i].Value = v
+		return nil
+	}
+
+	var emails []Email
+	if err := json.Unmarshal(value, &emails); err != nil {
+		var single Email
+		if err := json.Unmarshal(value, &single); err != nil {
+			return badRequest(ErrInvalidValue, "emails must be a list of emails")
+		}
+		emails = []Email{single}
+	}
+
+	if op == opReplace || path.Filter != nil {
+		u.removeEmails(path.Filter)
+	}
+
+	for _, e := range emails {
+		if i := slices.IndexFunc(u.Emails, func(cur Email) bool { return strings.EqualFold(cur.Value, e.Value) }); i >= 0 {
+			u.Emails[i] = e
+			continue
+		}
+		u.Emails = append(u.Emails, e)
+	}
+	return nil
+}
+
+// findEmail returns the index of the email the filter selects; without a
+// filter it is the primary email
+func (u *User) findEmail(f *Condition) int {
+	if f == nil {
+		for i, e := range u.Emails {
+			if e.Primary {
+				return i
+			}
+		}
+		if len(u.Emails) > 0 {
+			return 0
+		}
+		return -1
+	}
+
+	return slices.IndexFunc(u.Emails, func(e Email) bool { return emailMatches(e, f) })
+}
+
+func (u *User) removeEmails(f *Condition) {
+	if f == nil {
+		u.Emails = nil
+		return
+	}
+	u.Emails = slices.DeleteFunc(u.Emails, func(e Email) bool { return emailMatches(e, f) })
+}
+
+func emailMatches(e Email, f *Condition) bool {
+	switch f.Attr {
+	case "type":
+		return strings.EqualFold(e.Type, f.Value)
+	case "value":
+		return strings.EqualFold(e.Value, f.Value)
+	case "primary":
+		return e.Primary == (f.Value == "true")
+	}
+	return false
+}
+
+// remove clears an attribute of the user
+func (u *User) remove(path Path) {
+	switch path.Attr {
+	case "externalid":
+		u.ExternalID = ""
+	case "displayname":
+		u.DisplayName = ""
+	case "name":
+		if u.Name == nil {
+			return
+		}
+		switch path.Sub {
+		case "":
+			u.Name = nil
+		case "formatted":
+			u.Name.Formatted = ""
+		case "givenname":
+			u.Name.GivenName = ""
+		case "familyname":
+			u.Name.FamilyName = ""
+		}
+	case "emails":
+		u.removeEmails(path.Filter)
+	}
+}
+
+// GroupPatch is what a PATCH request changes in a group. Membership
+// changes are kept as a delta so large groups are not rewritten.
+type GroupPatch struct {
+	DisplayName *string
+	ExternalID  *string
+
+	// ReplaceMembers tells Members replaces the members as a whole;
+	// otherwise Add and Remove are applied to the current members
+	ReplaceMembers bool
+	Members        []string
+	Add            []string
+	Remove         []string
+}
+
+// ParseGroupPatch reads the operations of a group PATCH request
+func ParseGroupPatch(ops []PatchOperation) (*GroupPatch, *Error) {
+	gp := &GroupPatch{}
+	for _, op := range ops {
+		if err := checkOp(&op); err != nil {
+			return nil, err
+		}
+
+		if op.Path == "" {
+			obj, err := valueObject(op)
+			if err != nil {
+				return nil, err
+			}
+
+			for attr, value := range obj {
+				path, err := ParsePath(attr)
+				if err != nil {
+					return nil, err
+				}
+				if err := gp.apply(op.Op, path, value); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		path, err := ParsePath(op.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := gp.apply(op.Op, path, op.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	return gp, nil
+}
+
+func (gp *GroupPatch) apply(op string, path Path, value json.RawMessage) *Error {
+	switch path.Attr {
+	case "displayname":
+		if op == opRemove {
+			return badRequest(ErrMutability, "displayName is required")
+		}
+
+		var name string
+		if err := decodeString(value, &name); err != nil {
+			return err
+		}
+		gp.DisplayName = &name
+	case "externalid":
+		var id string
+		if op != opRemove {
+			if err := decodeString(value, &id); err != nil {
+				return err
+			}
+		}
+		gp.ExternalID = &id
+	case "members":
+		return gp.applyMembers(op, path, value)
+	}
+
+	return nil
+}
+
+func (gp *GroupPatch) applyMembers(op string, path Path, value json.RawMessage) *Error {
+	// members[value eq "id"] selects one member
+	if path.Filter != nil {
+		if path.Filter.Attr != "value" || op != opRemove {
+			return badRequest(ErrInvalidPath, "only members[value eq \"id\"] can be removed")
+		}
+		gp.remove([]string{path.Filter.Value})
+		return nil
+	}
+
+	var ids []string
+	if len(bytes.TrimSpace(value)) > 0 && !bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+		var members []Member
+		if err := json.Unmarshal(value, &members); err != nil {
+			return badRequest(ErrInvalidValue, "members must be a list of members")
+		}
+
+		for _, m := range members {
+			if m.Value == "" {
+				return badRequest(ErrInvalidValue, "a member requires a value")
+			}
+			ids = append(ids, m.Value)
+		}
+	}
+
+	switch op {
+	case opAdd:
+		gp.add(ids)
+	case opReplace:
+		gp.ReplaceMembers, gp.Members, gp.Add, gp.Remove = true, ids, nil, nil
+	case opRemove:
+		// without a value every member is removed
+		if ids == nil {
+			gp.ReplaceMembers, gp.Members, gp.Add, gp.Remove = true, nil, nil, nil
+			return nil
+		}
+		gp.remove(ids)
+	}
+
+	return nil
+}
+
+func (gp *GroupPatch) add(ids []string) {
+	for _, id := range ids {
+		if gp.ReplaceMembers {
+			if !slices.Contains(gp.Members, id) {
+				gp.Members = append(gp.Members, id)
+			}
+			continue
+		}
+
+		gp.Remove = slices.DeleteFunc(gp.Remove, func(r string) bool { return r == id })
+		if !slices.Contains(gp.Add, id) {
+			gp.Add = append(gp.Add, id)
+		}
+	}
+}
+
+func (gp *GroupPatch) remove(ids []string) {
+	for _, id := range ids {
+		if gp.ReplaceMembers {
+			gp.Members = slices.DeleteFunc(gp.Members, func(m string) bool { return m == id })
+			continue
+		}
+
+		gp.Add = slices.DeleteFunc(gp.Add, func(a string) bool { return a == id })
+		if !slices.Contains(gp.Remove, id) {
+			gp.Remove = append(gp.Remove, id)
+		}
+	}
+}
+
+// decodeString reads a string value
+func decodeString(value json.RawMessage, dst *string) *Error {
+	if err := json.Unmarshal(value, dst); err != nil {
+		return badRequest(ErrInvalidValue, "expected a string, got %s", value)
+	}
+	return nil
+}
+
+// decodeBool reads a boolean value. Azure AD sends "True" and "False"
+// as strings.
+func decodeBool(value json.RawMessage) (bool, *Error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		switch strings.ToLower(s) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+
+	return false, badRequest(ErrInvalidValue, "expected a boolean, got %s", value)
+}
@@ -0,0 +1,148 @@
+package scim
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func parseOps(t *testing.T, body string) []PatchOperation {
+	t.Helper()
+
+	var req PatchRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	return req.Operations
+}
+
+func TestParsePath(t *testing.T) {
+	cases := map[string]Path{
+		"userName":                     {Attr: "username"},
+		"name.givenName":               {Attr: "name", Sub: "givenname"},
+		`emails[type eq "work"].value`: {Attr: "emails", Filter: &Condition{Attr: "type", Value: "work"}, Sub: "value"},
+		`members[value eq "5"]`:        {Attr: "members", Filter: &Condition{Attr: "value", Value: "5"}},
+		"urn:ietf:params:scim:schemas:core:2.0:User:displayName": {Attr: "displayname"},
+	}
+	for in, want := range cases {
+		got, err := ParsePath(in)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("ParsePath(%q) = %+v, %v; want %+v", in, got, err, want)
+		}
+	}
+
+	for _, in := range []string{"", `emails[type eq "work"`, `emails[type co "w"]`} {
+		if _, err := ParsePath(in); err == nil || err.ScimType != ErrInvalidPath && err.ScimType != ErrInvalidFilter {
+			t.Errorf("ParsePath(%q) should fail, got %v", in, err)
+		}
+	}
+}
+
+func TestUserPatch(t *testing.T) {
+	active := true
+	u := &User{
+		UserName: "jane@contoso.com",
+		Name:     &Name{GivenName: "Jane", FamilyName: "Doe"},
+		Emails:   []Email{{Value: "jane@contoso.com", Type: "work", Primary: true}},
+		Active:   &active,
+	}
+
+	ops := parseOps(t, `{"Operations":[
+		{"op":"Replace","path":"emails[type eq \"work\"].value","value":"jane.roe@contoso.com"},
+		{"op":"replace","path":"name.familyName","value":"Roe"},
+		{"op":"add","path":"phoneNumbers","value":[{"value":"555"}]},
+		{"op":"Replace","value":{"active":"False","displayName":"Jane Roe","title":"CEO"}}
+	]}`)
+	if err := u.Patch(ops); err != nil {
+		t.Fatalf("patch: %v", err)
+	}
+
+	if u.IsActive() {
+		t.Error("user should be inactive")
+	}
+	if u.PrimaryEmail() != "jane.roe@contoso.com" || len(u.Emails) != 1 {
+		t.Errorf("emails = %+v", u.Emails)
+	}
+	if u.DisplayName != "Jane Roe" || u.Name.FamilyName != "Roe" || u.Name.GivenName != "Jane" {
+		t.Errorf("name = %q %+v", u.DisplayName, u.Name)
+	}
+
+	// a new typed email is appended
+	if err := u.Patch(parseOps(t, `{"Operations":[{"op":"add","path":"emails[type eq \"home\"].value","value":"jane@home.com"}]}`)); err != nil {
+		t.Fatalf("patch: %v", err)
+	}
+	if len(u.Emails) != 2 || u.Emails[1].Type != "home" || u.PrimaryEmail() != "jane.roe@contoso.com" {
+		t.Errorf("emails = %+v", u.Emails)
+	}
+
+	if err := u.Patch(parseOps(t, `{"Operations":[{"op":"remove","path":"emails[type eq \"home\"]"},{"op":"remove","path":"name"}]}`)); err != nil {
+		t.Fatalf("patch: %v", err)
+	}
+	if len(u.Emails) != 1 || u.Name != nil {
+		t.Errorf("user = %+v", u)
+	}
+
+	for _, body := range []string{
+		`{"Operations":[{"op":"move","path":"active"}]}`,
+		`{"Operations":[{"op":"replace","path":"active","value":"maybe"}]}`,
+		`{"Operations":[{"op":"replace","value":"x"}]}`,
+		`{"Operations":[{"op":"replace","path":"userName","value":5}]}`,
+	} {
+		if err := u.Patch(parseOps(t, body)); err == nil {
+			t.Errorf("patch %s should fail", body)
+		}
+	}
+}
+
+func TestParseGroupPatch(t *testing.T) {
+	name := "crm-admins"
+	cases := []struct {
+		body string
+		want GroupPatch
+	}{
+		{
+			`{"Operations":[{"op":"replace","value":{"id":"1","displayName":"crm-admins"}}]}`,
+			GroupPatch{DisplayName: &name},
+		},
+		{
+			`{"Operations":[
+				{"op":"Add","path":"members","value":[{"value":"1"},{"value":"2"}]},
+				{"op":"Remove","path":"members[value eq \"2\"]"},
+				{"op":"remove","path":"members","value":[{"value":"3"}]}
+			]}`,
+			GroupPatch{Add: []string{"1"}, Remove: []string{"2", "3"}},
+		},
+		{
+			`{"Operations":[
+				{"op":"add","path":"members","value":[{"value":"1"}]},
+				{"op":"replace","path":"members","value":[{"value":"2"}]},
+				{"op":"add","path":"members","value":[{"value":"3"}]}
+			]}`,
+			GroupPatch{ReplaceMembers: true, Members: []string{"2", "3"}},
+		},
+		{
+			`{"Operations":[{"op":"remove","path":"members"}]}`,
+			GroupPatch{ReplaceMembers: true},
+		},
+	}
+	for _, tc := range cases {
+		got, err := ParseGroupPatch(parseOps(t, tc.body))
+		if err != nil {
+			t.Errorf("ParseGroupPatch(%s): %v", tc.body, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, tc.want) {
+			t.Errorf("ParseGroupPatch(%s) = %+v; want %+v", tc.body, *got, tc.want)
+		}
+	}
+
+	for _, body := range []string{
+		`{"Operations":[{"op":"remove","path":"displayName"}]}`,
+		`{"Operations":[{"op":"add","path":"members[value eq \"1\"]"}]}`,
+		`{"Operations":[{"op":"add","path":"members","value":[{"display":"x"}]}]}`,
+	} {
+		if _, err := ParseGroupPatch(parseOps(t, body)); err == nil {
+			t.Errorf("ParseGroupPatch(%s) should fail", body)
+		}
+	}
+}
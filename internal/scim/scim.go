@@ -0,0 +1,397 @@
+// Package scim holds the SCIM 2.0 resources (RFC 7643) and the protocol
+// pieces (RFC 7644) the provisioning endpoints need: errors, list
+// responses, filters and PATCH operations.
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+	"github.com/labstack/echo/v4"
+)
+
+// MediaType is the content type of SCIM requests and responses
+const MediaType = "application/scim+json"
+
+// Schema URNs
+const (
+	UserSchema                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	GroupSchema                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ServiceProviderConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	ListResponseSchema          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	PatchOpSchema               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ErrorSchema                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// Error types of RFC 7644, section 3.12
+const (
+	ErrInvalidFilter = "invalidFilter"
+	ErrInvalidSyntax = "invalidSyntax"
+	ErrInvalidPath   = "invalidPath"
+	ErrInvalidValue  = "invalidValue"
+	ErrNoTarget      = "noTarget"
+	ErrUniqueness    = "uniqueness"
+	ErrMutability    = "mutability"
+)
+
+// Error is a SCIM error response
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+
+	status int
+}
+
+// NewError builds an error response; scimType may be empty
+func NewError(status int, scimType, format string, args ...any) *Error {
+	return &Error{
+		Schemas:  []string{ErrorSchema},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   fmt.Sprintf(format, args...),
+		status:   status,
+	}
+}
+
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+// badRequest is the 400 error of a malformed request
+func badRequest(scimType, format string, args ...any) *Error {
+	return NewError(http.StatusBadRequest, scimType, format, args...)
+}
+
+// Write sends v with the SCIM media type
+func Write(c echo.Context, status int, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Blob(status, MediaType, b)
+}
+
+// WriteError sends a SCIM error response
+func WriteError(c echo.Context, err *Error) error {
+	return Write(c, err.status, err)
+}
+
+// Decode reads a JSON request body. SCIM clients send application/scim+json,
+// which the echo binder does not accept.
+func Decode(c echo.Context, v any) *Error {
+	if err := json.NewDecoder(c.Request().Body).Decode(v); err != nil {
+		return badRequest(ErrInvalidSyntax, "invalid request body: %v", err)
+	}
+	return nil
+}
+
+// Meta is the resource metadata
+type Meta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Location     string     `json:"location,omitempty"`
+}
+
+// Name is the name of a user
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// Email is an email address of a user
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// GroupRef is a group a user belongs to
+type GroupRef struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+}
+
+// User is the core User resource. Only the attributes the CRM stores are
+// kept: the username, external id, name, one email and the status.
+type User struct {
+	Schemas     []string   `json:"schemas"`
+	ID          string     `json:"id,omitempty"`
+	ExternalID  string     `json:"externalId,omitempty"`
+	UserName    string     `json:"userName"`
+	Name        *Name      `json:"name,omitempty"`
+	DisplayName string     `json:"displayName,omitempty"`
+	Emails      []Email    `json:"emails,omitempty"`
+	Active      *bool      `json:"active,omitempty"`
+	Groups      []GroupRef `json:"groups,omitempty"`
+	Meta        *Meta      `json:"meta,omitempty"`
+}
+
+// PrimaryEmail returns the primary email, else the work one, else the first
+func (u *User) PrimaryEmail() string {
+	for _, e := range u.Emails {
+		if e.Primary {
+			return e.Value
+		}
+	}
+	for _, e := range u.Emails {
+		if strings.EqualFold(e.Type, "work") {
+			return e.Value
+		}
+	}
+	if len(u.Emails) > 0 {
+		return u.Emails[0].Value
+	}
+	return ""
+}
+
+// FullName returns the display name, else the formatted name, else the
+// given and family names
+func (u *User) FullName() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	if u.Name == nil {
+		return ""
+	}
+	if u.Name.Formatted != "" {
+		return u.Name.Formatted
+	}
+	return strings.TrimSpace(u.Name.GivenName + " " + u.Name.FamilyName)
+}
+
+// IsActive reports the status of the user; users are active by default
+func (u *User) IsActive() bool {
+	return u.Active == nil || *u.Active
+}
+
+// Check validates a User sent by the client
+func (u *User) Check() *Error {
+	u.UserName = strings.TrimSpace(u.UserName)
+	if u.UserName == "" {
+		return badRequest(ErrInvalidValue, "userName is required")
+	}
+	return nil
+}
+
+// Member is a member of a group
+type Member struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// Group is the core Group resource
+type Group struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []Member `json:"members,omitempty"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+// Check validates a Group sent by the client
+func (g *Group) Check() *Error {
+	g.DisplayName = strings.TrimSpace(g.DisplayName)
+	if g.DisplayName == "" {
+		return badRequest(ErrInvalidValue, "displayName is required")
+	}
+	return nil
+}
+
+// MemberIDs returns the values of the members
+func (g *Group) MemberIDs() []string {
+	ids := make([]string, 0, len(g.Members))
+	for _, m := range g.Members {
+		ids = append(ids, m.Value)
+	}
+	return ids
+}
+
+// NewUser builds the User resource of a stored user
+func NewUser(rec *repo.UserRecord, location string, groups []*repo.SCIMGroupRecord) *User {
+	active := rec.Active
+	u := &User{
+		Schemas:     []string{UserSchema},
+		ID:          strconv.FormatInt(rec.ID, 10),
+		ExternalID:  rec.ExternalID,
+		UserName:    rec.UserName,
+		DisplayName: rec.Name,
+		Active:      &active,
+		Meta: &Meta{
+			ResourceType: "User",
+			Created:      &rec.CreatedAt,
+			LastModified: &rec.UpdatedAt,
+			Location:     location,
+		},
+	}
+
+	if rec.Name != "" {
+		u.Name = &Name{Formatted: rec.Name}
+	}
+
+	if rec.Email != "" {
+		u.Emails = []Email{{Value: rec.Email, Type: "work", Primary: true}}
+	}
+
+	for _, g := range groups {
+		u.Groups = append(u.Groups, GroupRef{Value: strconv.FormatInt(g.ID, 10), Display: g.DisplayName})
+	}
+
+	return u
+}
+
+// NewGroup builds the Group resource of a stored group. members is nil
+// when they were excluded from the response.
+func NewGroup(rec *repo.SCIMGroupRecord, location string, members []*repo.SCIMMemberRecord) *Group {
+	g := &Group{
+		Schemas:     []string{GroupSchema},
+		ID:          strconv.FormatInt(rec.ID, 10),
+		ExternalID:  rec.ExternalID,
+		DisplayName: rec.DisplayName,
+		Meta: &Meta{
+			ResourceType: "Group",
+			Created:      &rec.CreatedAt,
+			LastModified: &rec.UpdatedAt,
+			Location:     location,
+		},
+	}
+
+	for _, m := range members {
+		g.Members = append(g.Members, Member{Value: strconv.FormatInt(m.UserID, 10), Display: m.UserName})
+	}
+
+	return g
+}
+
+// ListResponse is a page of resources
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []any    `json:"Resources"`
+}
+
+// NewListResponse builds a page starting at the 1-based startIndex
+func NewListResponse(total, startIndex int, resources []any) *ListResponse {
+	if resources == nil {
+		resources = []any{}
+	}
+	return &ListResponse{
+		Schemas:      []string{ListResponseSchema},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
+
+// Page is the pagination of a list request (RFC 7644, section 3.4.2.4)
+type Page struct {
+	// StartIndex is 1-based
+	StartIndex int
+	Count      int
+}
+
+// Offset is the 0-based index of the first resource
+func (p Page) Offset() int {
+	return p.StartIndex - 1
+}
+
+// ParsePage reads startIndex and count; count defaults to and is capped at
+// maxCount. Values under the minimum are raised to it, as the RFC asks.
+func ParsePage(c echo.Context, maxCount int) (Page, *Error) {
+	p := Page{StartIndex: 1, Count: maxCount}
+
+	if v := c.QueryParam("startIndex"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return p, badRequest(ErrInvalidValue, "startIndex must be an integer")
+		}
+		p.StartIndex = max(n, 1)
+	}
+
+	if v := c.QueryParam("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return p, badRequest(ErrInvalidValue, "count must be an integer")
+		}
+		p.Count = min(max(n, 0), maxCount)
+	}
+
+	return p, nil
+}
+
+// ServiceProviderConfig advertises the supported features
+type ServiceProviderConfig struct {
+	Schemas               []string               `json:"schemas"`
+	Patch                 supported              `json:"patch"`
+	Bulk                  bulk                   `json:"bulk"`
+	Filter                filter                 `json:"filter"`
+	ChangePassword        supported              `json:"changePassword"`
+	Sort                  supported              `json:"sort"`
+	ETag                  supported              `json:"etag"`
+	AuthenticationSchemes []authenticationScheme `json:"authenticationSchemes"`
+}
+
+type supported struct {
+	Supported bool `json:"supported"`
+}
+
+type bulk struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+type filter struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type authenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Primary     bool   `json:"primary"`
+}
+
+// NewServiceProviderConfig describes the endpoints: PATCH and "eq" filters
+// are supported, bulk, sorting, etags and passwords are not.
+func NewServiceProviderConfig(maxResults int) *ServiceProviderConfig {
+	return &ServiceProviderConfig{
+		Schemas: []string{ServiceProviderConfigSchema},
+		Patch:   supported{Supported: true},
+		Filter:  filter{Supported: true, MaxResults: maxResults},
+		AuthenticationSchemes: []authenticationScheme{{
+			Type:        "oauthbearertoken",
+			Name:        "Bearer token",
+			Description: "A SCIM token issued by the tenant administrators",
+			Primary:     true,
+		}},
+	}
+}
+
+// tokenContextKey holds the SCIM token of the request on the echo context
+const tokenContextKey = "scim.token"
+
+// NewContext stores the authenticated SCIM token on the context
+func NewContext(c echo.Context, token *repo.SCIMTokenRecord) {
+	c.Set(tokenContextKey, token)
+}
+
+// FromContext returns the token stored by the SCIM middleware
+func FromContext(c echo.Context) (*repo.SCIMTokenRecord, bool) {
+	t, ok := c.Get(tokenContextKey).(*repo.SCIMTokenRecord)
+	return t, ok && t != nil
+}
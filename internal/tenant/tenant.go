@@ -0,0 +1,135 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jeanmolossi/verbose-adventure/internal/config"
+	"github.com/jeanmolossi/verbose-adventure/internal/repo"
+	"github.com/labstack/echo/v4"
+)
+
+const contextKey = "tenant"
+
+var ErrNotFound = errors.New("tenant not found")
+
+// Tenant is the tenant a request was addressed to.
+type Tenant struct {
+	ID         int64  `json:"id"`
+	Slug       string `json:"slug"`
+	Name       string `json:"name"`
+	Domain     string `json:"domain,omitempty"`
+	CustomHost string `json:"custom_host,omitempty"`
+}
+
+// Resolver identifies tenants by host or by path segment, so URLs do not
+// need to carry internal ids.
+type Resolver struct {
+	repo     repo.TenantRepository
+	baseHost string
+}
+
+func NewResolver(cfg *config.Config, r repo.TenantRepository) *Resolver {
+	return &Resolver{repo: r, baseHost: baseHost(cfg.BaseURL)}
+}
+
+// FromHost resolves a mapped custom host or a "<slug>.<BASE_URL host>"
+// subdomain. It returns nil for the base host and for hosts that are not
+// mapped to any tenant, such as the address used by health checks.
+func (r *Resolver) FromHost(ctx context.Context, host string) (*Tenant, error) {
+	host = normalizeHost(host)
+	if host == "" || host == r.baseHost {
+		return nil, nil
+	}
+
+	if r.baseHost != "" {
+		if slug, ok := strings.CutSuffix(host, "."+r.baseHost); ok {
+			// only one label: deeper subdomains are not tenants
+			if strings.Contains(slug, ".") {
+				return nil, ErrNotFound
+			}
+			return r.found(r.repo.GetBySlug(ctx, slug))
+		}
+	}
+
+	rec, err := r.repo.GetByCustomHost(ctx, host)
+	if err != nil || rec == nil {
+		return nil, err
+	}
+	return newTenant(rec), nil
+}
+
+// FromID resolves a tenant by its internal id
+func (r *Resolver) FromID(ctx context.Context, id int64) (*Tenant, error) {
+	return r.found(r.repo.GetByID(ctx, id))
+}
+
+// FromPath resolves a path segment holding a tenant id or slug. Numeric
+// ids keep the URLs issued before slugs existed working.
+func (r *Resolver) FromPath(ctx context.Context, value string) (*Tenant, error) {
+	if id, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return r.found(r.repo.GetByID(ctx, id))
+	}
+
+	return r.found(r.repo.GetBySlug(ctx, strings.ToLower(value)))
+}
+
+func (r *Resolver) found(rec *repo.TenantRecord, err error) (*Tenant, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	if rec == nil {
+		return nil, ErrNotFound
+	}
+
+	return newTenant(rec), nil
+}
+
+// NewContext stores the tenant of the request
+func NewContext(c echo.Context, t *Tenant) {
+	c.Set(contextKey, t)
+}
+
+// FromContext returns the tenant stored by the tenant resolver middleware
+func FromContext(c echo.Context) (*Tenant, bool) {
+	t, ok := c.Get(contextKey).(*Tenant)
+	return t, ok && t != nil
+}
+
+func newTenant(rec *repo.TenantRecord) *Tenant {
+	return &Tenant{
+		ID:         rec.ID,
+		Slug:       rec.Slug,
+		Name:       rec.Name,
+		Domain:     rec.Domain,
+		CustomHost: rec.CustomHost,
+	}
+}
+
+// baseHost extracts the host of BASE_URL, which may omit the scheme
+func baseHost(baseURL string) string {
+	if !strings.Contains(baseURL, "://") {
+		baseURL = "//" + baseURL
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return ""
+	}
+
+	return normalizeHost(u.Host)
+}
+
+// normalizeHost lowercases the host and drops the port and the trailing dot
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}